@@ -2,31 +2,54 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 )
 
 // Commands to acquire and release sessions.
 type AcquireSessionCommands interface {
 	// Acquires a session. If the session has been offloaded and not acquired it
-	// returns the new session sessionLocation, otherwise nil. The options defines how
-	// the session is acquired.
+	// returns the new session sessionLocation, otherwise the id of the
+	// acquisition. The ids of the acquisitions of a session are positive and
+	// increasing, also across offloads, and are the fencing tokens of the
+	// acquisitions: the writes that carry the id of an acquisition that has been
+	// released, or whose lease expired, are rejected with ErrStaleFencingToken.
+	// The options defines how the session is acquired. Acquisitions with a lease
+	// are treated as released once the lease expires.
 	// errors:
 	// - ErrSessionNotFound: If no session with the given id is found.
 	// - ErrSessionIsOffloading: If the session is offloading and cannot be acquired.
+	// - ErrSessionIsAcquired: If the session is acquired in a mode that
+	// conflicts with the mode of the options.
 	AcquireSession(
 		ctx context.Context,
 		sessionId string,
 		opt AcquireSessionOptions,
-	) (*SessionLocation, error)
-	// Releases a previously acquired session. The options defines how the session
-	// is released.
+	) (acquisitionId uint64, offloadedTo *SessionLocation, err error)
+	// Releases a previously acquired session. If the session has been offloaded
+	// while acquired it returns the new session sessionLocation.
 	// errors:
 	// - ErrSessionNotFound: If no session with the given id is found.
-	// - ErrNoAcquisitionToRelease: If there is no acquisition to release.
+	// - ErrNoAcquisitionToRelease: If the acquisition has already been released
+	// or its lease expired.
 	ReleaseSession(
 		ctx context.Context,
 		sessionId string,
-		opt AcquireSessionOptions,
+		acquisitionId uint64,
 	) (*SessionLocation, error)
+	// Renews the lease of an acquisition, that expires after leaseDuration from
+	// now. The lease of an acquisition without lease is set.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrLeaseExpired: If the acquisition has been released or its lease
+	// expired.
+	RenewSessionLease(
+		ctx context.Context,
+		sessionId string,
+		acquisitionId uint64,
+		leaseDuration time.Duration,
+	) error
 	// Returns the offloadable sessions, the function returns the new cursor, the
 	// list of session ids and an error. The cursor is used to paginate the results.
 	// If the cursor is empty, the function returns the first page of results.
@@ -44,6 +67,15 @@ type AcquireSessionCommands interface {
 // possible to safely use the session key space with redis. The options defines
 // how the session is acquired.
 //
+// If the options wait while offloading, an offloading session is acquired once
+// the offload aborts, or its new location is returned once the offload
+// completes.
+//
+// The callback receives the fencing token of the acquisition, to pass to the
+// writes to the session so that they are rejected once the acquisition lapses.
+// If the options carry a lease with a heartbeat interval, the lease is renewed
+// while the callback runs.
+//
 // There are 3 possible outcomes:
 //  1. The session is acquired and the callback is run. In this case the return
 //     value is nil.
@@ -55,28 +87,115 @@ func (n *Node) AcquireSession(
 	ctx context.Context,
 	sessionToken SessionToken,
 	opt AcquireSessionOptions,
-	ifAcquired func() error,
+	ifAcquired func(fencingToken uint64) error,
 ) (*SessionToken, error) {
-	offloadedTo, err := n.Cmd.AcquireSession(ctx, sessionToken.SessionId, opt)
+	acquisitionId, offloadedTo, err := n.acquireSession(ctx, sessionToken.SessionId, opt)
 
 	// If there is an error, return it.
 	if err != nil {
 		return nil, err
 	}
 
-	// Defer the release of the session metadata.
-	defer func() {
-		n.Cmd.ReleaseSession(ctx, sessionToken.SessionId, opt)
-	}()
-
-	// If the session has been offloaded, return the sessionLocation of the session.
+	// If the session has been offloaded, return the sessionLocation of the
+	// session. The session has not been acquired, so there is nothing to release.
 	if offloadedTo != nil {
 		newToken := NewSessionTokenAfterOffloading(sessionToken, *offloadedTo)
 		return &newToken, nil
 	}
 
+	// Renew the lease while the callback runs.
+	stop := n.heartbeat(ctx, sessionToken.SessionId, acquisitionId, opt)
+
+	// Defer the release of the session metadata, even if the context is
+	// cancelled in the meantime.
+	defer func() {
+		stop()
+		n.Cmd.ReleaseSession(context.WithoutCancel(ctx), sessionToken.SessionId, acquisitionId)
+	}()
+
 	// Run the ifAcquired callback and return its return value.
-	return nil, ifAcquired()
+	return nil, ifAcquired(acquisitionId)
+}
+
+// Acquire a session. If the options require so, wait while the session is
+// offloading. If the context is done before the offload completes or aborts,
+// the error wraps both ErrSessionIsOffloading and the error of the context.
+func (n *Node) acquireSession(
+	ctx context.Context,
+	sessionId string,
+	opt AcquireSessionOptions,
+) (uint64, *SessionLocation, error) {
+	for {
+		acquisitionId, offloadedTo, err := n.Cmd.AcquireSession(ctx, sessionId, opt)
+		if !errors.Is(err, ErrSessionIsOffloading) || !opt.WaitWhileOffloading() {
+			return acquisitionId, offloadedTo, err
+		}
+
+		timer := time.NewTimer(opt.WaitInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, nil, fmt.Errorf("%w: %w", ErrSessionIsOffloading, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Renews the lease of an acquisition, that expires after leaseDuration from now.
+// Long-running callbacks that do not rely on the automatic renewal of the lease
+// must renew it before it expires.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrLeaseExpired: If the acquisition has been released or its lease expired.
+func (n *Node) RenewSessionLease(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+	leaseDuration time.Duration,
+) error {
+	return n.Cmd.RenewSessionLease(ctx, sessionId, acquisitionId, leaseDuration)
+}
+
+// Renew the lease of the acquisition every heartbeat interval, until the
+// returned function is called or the lease cannot be renewed. Returns a no-op if
+// the options do not carry a lease with a heartbeat interval.
+func (n *Node) heartbeat(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+	opt AcquireSessionOptions,
+) (stop func()) {
+	if opt.LeaseDuration() <= 0 || opt.HeartbeatInterval() <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(opt.HeartbeatInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// If the lease expired, the session may be acquired by others.
+				if err := n.Cmd.RenewSessionLease(ctx, sessionId, acquisitionId, opt.LeaseDuration()); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
 }
 
 func (n *Node) ScanOffloadableSessions(
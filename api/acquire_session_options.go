@@ -1,22 +1,94 @@
 package api
 
+import "time"
+
+// AcquisitionMode defines which acquisitions of the same session can coexist.
+type AcquisitionMode int
+
+const (
+	// The acquisition coexists with every other acquisition but the write ones,
+	// and blocks the offload unless it allows offloading. The default.
+	AcquisitionModeUnrestricted AcquisitionMode = iota
+	// A shared acquisition to read the session. It coexists with every acquisition
+	// but the write ones, never blocks the offload, and
+	// can be allowed while the session is offloading.
+	AcquisitionModeRead
+	// An exclusive acquisition to write the session. It coexists with no other
+	// acquisition, always blocks the offload, and is never allowed while the
+	// session is offloading.
+	AcquisitionModeWrite
+)
+
 // Options to acquire a session.
 type AcquireSessionOptions struct {
+	// The mode of the acquisition.
+	mode AcquisitionMode
 	// If true, the session will be eligible for offloading even if the session is
 	// acquired.
 	allowOffloading bool
 	// If true, the session will be acquired even if the session is offloading
 	allowWhileOffloading bool
+	// If true, Node.AcquireSession waits while the session is offloading instead
+	// of failing with ErrSessionIsOffloading.
+	waitWhileOffloading bool
+	// The interval between the attempts to acquire a session that is
+	// offloading.
+	waitInterval time.Duration
+	// The duration of the lease of the acquisition, 0 if the acquisition does
+	// not expire.
+	leaseDuration time.Duration
+	// The interval between the automatic renewals of the lease, 0 if the lease
+	// is not renewed automatically.
+	heartbeatInterval time.Duration
+}
+
+// Get the mode of the acquisition.
+func (o AcquireSessionOptions) Mode() AcquisitionMode {
+	return o.mode
 }
 
-// Get the value of allowOffloading.
+// Get the value of allowOffloading, always true for read acquisitions and
+// false for write ones.
 func (o AcquireSessionOptions) AllowOffloading() bool {
-	return o.allowOffloading
+	switch o.mode {
+	case AcquisitionModeRead:
+		return true
+	case AcquisitionModeWrite:
+		return false
+	default:
+		return o.allowOffloading
+	}
 }
 
-// Get the value of allowWhileOffloading.
+// Get the value of allowWhileOffloading, always false for write acquisitions.
 func (o AcquireSessionOptions) AllowWhileOffloading() bool {
-	return o.allowWhileOffloading
+	return o.mode != AcquisitionModeWrite && o.allowWhileOffloading
+}
+
+// Get the value of waitWhileOffloading.
+func (o AcquireSessionOptions) WaitWhileOffloading() bool {
+	return o.waitWhileOffloading
+}
+
+// Get the value of waitInterval.
+func (o AcquireSessionOptions) WaitInterval() time.Duration {
+	return o.waitInterval
+}
+
+// Return a copy of the options with the given mode.
+func (o AcquireSessionOptions) WithMode(mode AcquisitionMode) AcquireSessionOptions {
+	o.mode = mode
+	return o
+}
+
+// Get the value of leaseDuration.
+func (o AcquireSessionOptions) LeaseDuration() time.Duration {
+	return o.leaseDuration
+}
+
+// Get the value of heartbeatInterval.
+func (o AcquireSessionOptions) HeartbeatInterval() time.Duration {
+	return o.heartbeatInterval
 }
 
 // Builder for CreateSessionOptions.
@@ -43,6 +115,61 @@ func (builder *AcquireSessionOptionsBuilder) AllowWhileOffloading() *AcquireSess
 	return builder
 }
 
+// Acquire the session in read mode, shared with the other readers. The read
+// acquisitions do not block the offload, and can be allowed while the session
+// is offloading with AllowWhileOffloading.
+func (builder *AcquireSessionOptionsBuilder) Read() *AcquireSessionOptionsBuilder {
+	builder.options.mode = AcquisitionModeRead
+	return builder
+}
+
+// Acquire the session in write mode, exclusive of the readers and of the other
+// writers. The write acquisitions block the offload.
+func (builder *AcquireSessionOptionsBuilder) Write() *AcquireSessionOptionsBuilder {
+	builder.options.mode = AcquisitionModeWrite
+	return builder
+}
+
+// Set the mode of the acquisition.
+func (builder *AcquireSessionOptionsBuilder) Mode(mode AcquisitionMode) *AcquireSessionOptionsBuilder {
+	builder.options.mode = mode
+	return builder
+}
+
+// Wait while the session is offloading, retrying to acquire it every interval
+// until the offload completes or aborts, or the context is done. Once the
+// offload completes the new location of the session is returned, as for the
+// sessions that were already offloaded. If interval is not positive,
+// DefaultWaitInterval is used.
+func (builder *AcquireSessionOptionsBuilder) WaitWhileOffloading(interval time.Duration) *AcquireSessionOptionsBuilder {
+	if interval <= 0 {
+		interval = DefaultWaitInterval
+	}
+
+	builder.options.waitWhileOffloading = true
+	builder.options.waitInterval = interval
+	return builder
+}
+
+// Acquire the session with a lease of the given duration: if the acquisition is
+// neither released nor renewed before the lease expires, it is treated as
+// released, so that a crashed process does not prevent the session from being
+// offloaded or garbage collected. The lease is renewed automatically every
+// third of its duration while the session is acquired by Node.AcquireSession,
+// see HeartbeatInterval.
+func (builder *AcquireSessionOptionsBuilder) Lease(leaseDuration time.Duration) *AcquireSessionOptionsBuilder {
+	builder.options.leaseDuration = leaseDuration
+	builder.options.heartbeatInterval = leaseDuration / 3
+	return builder
+}
+
+// Set the interval between the automatic renewals of the lease, 0 to renew the
+// lease only explicitly with RenewSessionLease.
+func (builder *AcquireSessionOptionsBuilder) HeartbeatInterval(heartbeatInterval time.Duration) *AcquireSessionOptionsBuilder {
+	builder.options.heartbeatInterval = heartbeatInterval
+	return builder
+}
+
 // Build the AcquireSessionOptions.
 func (builder *AcquireSessionOptionsBuilder) Build() AcquireSessionOptions {
 	return builder.options
@@ -52,6 +179,13 @@ func (builder *AcquireSessionOptionsBuilder) Build() AcquireSessionOptions {
 // session.
 func DefaultAcquireSessionOptions() AcquireSessionOptions {
 	return AcquireSessionOptions{
+		mode:                 AcquisitionModeUnrestricted,
 		allowWhileOffloading: false,
+		waitWhileOffloading:  false,
+		waitInterval:         DefaultWaitInterval,
 	}
 }
+
+// DefaultWaitInterval is the default interval between the attempts to acquire
+// a session that is offloading.
+const DefaultWaitInterval = 50 * time.Millisecond
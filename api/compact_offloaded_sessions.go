@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+// The number of tombstones inspected by each scan of CompactOffloadedSessions.
+const compactOffloadedSessionsBatchSize = 128
+
+// Resolves a hop of the forwarding of a session: returns the location the
+// session at the given location has been offloaded to, or nil if the session
+// lives there.
+// errors:
+// - ErrSessionNotFound: If no session is found at the location.
+type SessionLocationResolver func(ctx context.Context, location SessionLocation) (*SessionLocation, error)
+
+// The outcome of the compaction of the tombstone of a session.
+type TombstoneCompaction struct {
+	SessionId string
+	// The current location of the session, that the tombstone points to unless
+	// collected.
+	Location SessionLocation
+	// True if the tombstone has been deleted.
+	Collected bool
+	// The error of the compaction, see CompactOffloadedSession.
+	Err error
+}
+
+// Returns the location an offloaded session points to, or nil if the session
+// lives on this node, including a session whose onload is pending until the
+// offloading node commits or aborts it.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (n *Node) ResolveSessionLocation(
+	ctx context.Context,
+	sessionId string,
+) (*SessionLocation, error) {
+	offloadedTo, _, err := n.Cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	if errors.Is(err, ErrSessionIsNotOffloaded) {
+		return nil, nil
+	}
+	if errors.Is(err, ErrSessionNotFound) {
+		status, statusErr := n.Cmd.GetSessionOnloadStatus(ctx, sessionId)
+		if statusErr != nil {
+			return nil, statusErr
+		}
+
+		if status == OnloadStatusPending {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &offloadedTo, nil
+}
+
+// Compacts the tombstone of an offloaded session: the chain of tombstones left
+// by the following offloads is followed, and the tombstone is updated to point
+// directly to the current location of the session, so that the clients with a
+// stale token are forwarded once. The hops on this node are resolved locally,
+// the others with resolve.
+//
+// A tombstone whose chain ends where the session no longer exists (e.g. it
+// expired) leads no client to the session, and it is deleted. A session whose
+// onload is pending at the end of the chain is live. The tombstone of an
+// offload still in the journal is left to RecoverOffloads. If a newer
+// location is notified while the chain is followed, it may be overwritten by
+// the resolved one, that then points to it.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrSessionIsNotOffloaded: If the session is not offloaded.
+// - ErrSessionForwardingLoop: If the chain leads back to a visited location.
+// - ErrTooManyForwardingHops: If the chain is longer than the maximum hops.
+// - ErrOffloadNotCommitted: If the offload of the session is in the journal.
+func (n *Node) CompactOffloadedSession(
+	ctx context.Context,
+	sessionId string,
+	opt CompactOffloadedSessionsOptions,
+	resolve SessionLocationResolver,
+) (location SessionLocation, collected bool, err error) {
+	journaled, err := n.journaledOffloads(ctx)
+	if err != nil {
+		return SessionLocation{}, false, err
+	}
+
+	return n.compactOffloadedSession(ctx, sessionId, opt, resolve, journaled)
+}
+
+// Compact the tombstone of an offloaded session, the sessions in journaled are
+// skipped.
+func (n *Node) compactOffloadedSession(
+	ctx context.Context,
+	sessionId string,
+	opt CompactOffloadedSessionsOptions,
+	resolve SessionLocationResolver,
+	journaled map[string]bool,
+) (location SessionLocation, collected bool, err error) {
+	offloadedTo, clientRedirected, err := n.Cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	if err != nil {
+		return SessionLocation{}, false, err
+	}
+
+	if journaled[sessionId] {
+		return offloadedTo, false, ErrOffloadNotCommitted
+	}
+
+	location, err = n.followSessionLocation(ctx, NewSessionLocation(n.Host, sessionId), offloadedTo, opt.MaxHops(), resolve)
+	// The session is not found at the end of the chain.
+	if errors.Is(err, ErrSessionNotFound) {
+		location, err = SessionLocation{}, nil
+		collected = true
+	}
+	if err != nil {
+		return SessionLocation{}, false, err
+	}
+
+	if collected || (clientRedirected && opt.CollectRedirected()) {
+		if err := n.Cmd.DeleteOffloadedSession(ctx, sessionId); err != nil {
+			return SessionLocation{}, false, err
+		}
+
+		return location, true, nil
+	}
+
+	if location != offloadedTo {
+		_, err = n.Cmd.UpdateOffloadedSessionLocation(ctx, sessionId, location)
+	}
+
+	return location, false, err
+}
+
+// Compacts the tombstones of all the offloaded sessions of the node, see
+// CompactOffloadedSession, and returns the outcome of each compaction.
+func (n *Node) CompactOffloadedSessions(
+	ctx context.Context,
+	opt CompactOffloadedSessionsOptions,
+	resolve SessionLocationResolver,
+) ([]TombstoneCompaction, error) {
+	journaled, err := n.journaledOffloads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	compactions := []TombstoneCompaction{}
+	cursor := uint64(0)
+	for {
+		ids, next, err := n.Cmd.ScanOffloadedSessions(ctx, cursor, compactOffloadedSessionsBatchSize)
+		if err != nil {
+			return compactions, err
+		}
+
+		for _, id := range ids {
+			compaction := TombstoneCompaction{SessionId: id}
+			compaction.Location, compaction.Collected, compaction.Err = n.compactOffloadedSession(ctx, id, opt, resolve, journaled)
+			compactions = append(compactions, compaction)
+		}
+
+		if next == 0 {
+			return compactions, nil
+		}
+
+		cursor = next
+	}
+}
+
+// Returns the ids of the sessions whose offload is in the journal.
+func (n *Node) journaledOffloads(ctx context.Context) (map[string]bool, error) {
+	entries, err := n.Cmd.ScanOffloadJournal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	journaled := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		journaled[entry.SessionId] = true
+	}
+
+	return journaled, nil
+}
+
+// Follows the chain of tombstones of a session from the first hop, and returns
+// the location the session lives at.
+func (n *Node) followSessionLocation(
+	ctx context.Context,
+	from SessionLocation,
+	location SessionLocation,
+	maxHops int,
+	resolve SessionLocationResolver,
+) (SessionLocation, error) {
+	visited := map[SessionLocation]bool{from: true}
+	for hops := 1; ; hops++ {
+		if visited[location] {
+			return SessionLocation{}, ErrSessionForwardingLoop
+		}
+		visited[location] = true
+
+		var next *SessionLocation
+		var err error
+		if location.Host == n.Host {
+			next, err = n.ResolveSessionLocation(ctx, location.SessionId)
+		} else {
+			next, err = resolve(ctx, location)
+		}
+
+		if err != nil {
+			return SessionLocation{}, err
+		}
+
+		if next == nil {
+			return location, nil
+		}
+
+		if maxHops > 0 && hops >= maxHops {
+			return SessionLocation{}, ErrTooManyForwardingHops
+		}
+
+		location = *next
+	}
+}
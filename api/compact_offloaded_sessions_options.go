@@ -0,0 +1,67 @@
+package api
+
+// The default maximum number of hops followed to reach a session.
+const DefaultMaxForwardingHops = 8
+
+// Options to compact the tombstones of the offloaded sessions.
+type CompactOffloadedSessionsOptions struct {
+	// The maximum number of hops followed to resolve the current location of a
+	// session, 0 if unbounded.
+	maxHops int
+	// Collect the tombstones a client has already been redirected from.
+	collectRedirected bool
+}
+
+// Get the maximum number of hops followed to resolve the current location of a
+// session, 0 if unbounded.
+func (o CompactOffloadedSessionsOptions) MaxHops() int {
+	return o.maxHops
+}
+
+// Get if the tombstones a client has already been redirected from are
+// collected.
+func (o CompactOffloadedSessionsOptions) CollectRedirected() bool {
+	return o.collectRedirected
+}
+
+// Builder for CompactOffloadedSessionsOptions.
+type CompactOffloadedSessionsOptionsBuilder struct {
+	options CompactOffloadedSessionsOptions
+}
+
+// Create a new CompactOffloadedSessionsOptionsBuilder.
+func NewCompactOffloadedSessionsOptionsBuilder() *CompactOffloadedSessionsOptionsBuilder {
+	return &CompactOffloadedSessionsOptionsBuilder{
+		options: DefaultCompactOffloadedSessionsOptions(),
+	}
+}
+
+// Set the maximum number of hops followed to resolve the current location of a
+// session, 0 if unbounded.
+func (builder *CompactOffloadedSessionsOptionsBuilder) MaxHops(maxHops int) *CompactOffloadedSessionsOptionsBuilder {
+	builder.options.maxHops = maxHops
+	return builder
+}
+
+// Collect the tombstones a client has already been redirected from, once their
+// chain is resolved. The client of the session holds the token of a newer
+// location, so only copies of the old token reach the tombstone: enable it
+// only if the clients do not share their tokens.
+func (builder *CompactOffloadedSessionsOptionsBuilder) CollectRedirected() *CompactOffloadedSessionsOptionsBuilder {
+	builder.options.collectRedirected = true
+	return builder
+}
+
+// Build the CompactOffloadedSessionsOptions.
+func (builder *CompactOffloadedSessionsOptionsBuilder) Build() CompactOffloadedSessionsOptions {
+	return builder.options
+}
+
+// DefaultCompactOffloadedSessionsOptions returns the default options to
+// compact the tombstones of the offloaded sessions.
+func DefaultCompactOffloadedSessionsOptions() CompactOffloadedSessionsOptions {
+	return CompactOffloadedSessionsOptions{
+		maxHops:           DefaultMaxForwardingHops,
+		collectRedirected: false,
+	}
+}
@@ -7,35 +7,42 @@ import (
 // Commands to create and acquire a session.
 type CreateAndAcquireSessionCommands interface {
 	AcquireSessionCommands
-	// Creates a new session and acquires it. Returns the id of the session.
+	// Creates a new session and acquires it. Returns the id of the session and
+	// the id of the acquisition.
 	CreateAndAcquireSession(
 		ctx context.Context,
 		options CreateAndAcquireSessionOptions,
-	) (string, error)
+	) (sessionId string, acquisitionId uint64, err error)
 }
 
 // Create a new session and acquire it, the run the ifCreatedAndAcquired
 // callback. Inside the callback is possible to safely use the session. The
-// options defines how the session is created and acquired.
+// options defines how the session is created and acquired. The callback
+// receives the fencing token of the acquisition.
 //
 // If the callback is run, a SessionToken is returned, otherwise an error is
 // returned.
 func (n *Node) CreateAndAcquireSession(
 	ctx context.Context,
 	opt CreateAndAcquireSessionOptions,
-	ifCreatedAndAcquired func(sessionToken SessionToken) error,
+	ifCreatedAndAcquired func(sessionToken SessionToken, fencingToken uint64) error,
 ) (SessionToken, error) {
 	// Create and acquire the session.
-	sessionId, err := n.Cmd.CreateAndAcquireSession(ctx, opt)
+	sessionId, acquisitionId, err := n.Cmd.CreateAndAcquireSession(ctx, opt)
 
 	// If there is an error, return it.
 	if err != nil {
 		return SessionToken{}, err
 	}
 
-	// Defer the release of the session.
+	// Renew the lease while the callback runs.
+	stop := n.heartbeat(ctx, sessionId, acquisitionId, opt.AcquireSessionOptions)
+
+	// Defer the release of the session, even if the context is cancelled in
+	// the meantime.
 	defer func() {
-		n.Cmd.ReleaseSession(ctx, sessionId, opt.AcquireSessionOptions)
+		stop()
+		n.Cmd.ReleaseSession(context.WithoutCancel(ctx), sessionId, acquisitionId)
 	}()
 
 	// Create a new session token.
@@ -43,7 +50,7 @@ func (n *Node) CreateAndAcquireSession(
 	sessionToken := NewSessionToken(sessionLocation)
 
 	// Run the ifCreatedAndAcquired callback and return its return value.
-	return sessionToken, ifCreatedAndAcquired(sessionToken)
+	return sessionToken, ifCreatedAndAcquired(sessionToken, acquisitionId)
 }
 
 // Create and acquire a session if there is no session token, otherwise acquire
@@ -63,7 +70,7 @@ func (n *Node) MaybeCreateAndAcquireSession(
 	ctx context.Context,
 	sessionToken *SessionToken,
 	opt CreateAndAcquireSessionOptions,
-	ifAcquired func(SessionToken) error,
+	ifAcquired func(sessionToken SessionToken, fencingToken uint64) error,
 ) (_ *SessionToken, err error) {
 	// If there is no session token, create and acquire a session.
 	if (*sessionToken == SessionToken{}) {
@@ -73,5 +80,7 @@ func (n *Node) MaybeCreateAndAcquireSession(
 	}
 
 	// Acquire the session.
-	return n.AcquireSession(ctx, *sessionToken, opt.AcquireSessionOptions, func() error { return ifAcquired(*sessionToken) })
+	return n.AcquireSession(ctx, *sessionToken, opt.AcquireSessionOptions, func(fencingToken uint64) error {
+		return ifAcquired(*sessionToken, fencingToken)
+	})
 }
@@ -18,6 +18,46 @@ var (
 	ErrSessionIdAlreadyExists = fmt.Errorf("%w: session id already exists", ErrErmes)
 	// ErrNoAcquisitionToRelease is returned when there is no acquisition to release.
 	ErrNoAcquisitionToRelease = fmt.Errorf("%w: no acquisition to release", ErrErmes)
+	// ErrSessionIsAcquired is returned when a session cannot be acquired because it is acquired in a conflicting mode.
+	ErrSessionIsAcquired = fmt.Errorf("%w: session is acquired in a conflicting mode", ErrErmes)
+	// ErrLeaseExpired is returned when the lease of an acquisition expired, or the acquisition has been released.
+	ErrLeaseExpired = fmt.Errorf("%w: lease expired", ErrErmes)
+	// ErrStaleFencingToken is returned when a write or an onload carries a fencing token that is no longer valid.
+	ErrStaleFencingToken = fmt.Errorf("%w: stale fencing token", ErrErmes)
+	// ErrAcquisitionIsReadOnly is returned when a write carries the fencing token of an acquisition in read mode.
+	ErrAcquisitionIsReadOnly = fmt.Errorf("%w: acquisition is read-only", ErrErmes)
 	// ErrUnableToOffloadAcquiredSession is returned when the session is unable to offload acquired session.
 	ErrUnableToOffloadAcquiredSession = fmt.Errorf("%w: unable to offload acquired session", ErrErmes)
+	// ErrSessionIsNotOffloading is returned when an action requires an offloading session.
+	ErrSessionIsNotOffloading = fmt.Errorf("%w: session is not offloading", ErrErmes)
+	// ErrSessionIsNotOnloading is returned when an action requires a session whose onload is pending.
+	ErrSessionIsNotOnloading = fmt.Errorf("%w: session is not onloading", ErrErmes)
+	// ErrOffloadNotCommitted is returned when the offload of a session has been committed by the source node but not by the target node.
+	ErrOffloadNotCommitted = fmt.Errorf("%w: offload not committed by the target node", ErrErmes)
+	// ErrOffloadIsConfirming is returned when an offload can no longer be cancelled since it is being confirmed.
+	ErrOffloadIsConfirming = fmt.Errorf("%w: offload is confirming", ErrErmes)
+	// ErrOffloadPlanNotForNode is returned when an offload plan is executed by a node other than the one that computed it.
+	ErrOffloadPlanNotForNode = fmt.Errorf("%w: offload plan computed by another node", ErrErmes)
+	// ErrNoTargetPlanned is returned when a planned offload has no target node.
+	ErrNoTargetPlanned = fmt.Errorf("%w: no target planned", ErrErmes)
+	// ErrSessionDataCorrupted is returned when the checksum of an onloaded session data stream does not match the one computed by the source node.
+	ErrSessionDataCorrupted = fmt.Errorf("%w: session data corrupted in transit", ErrErmes)
+	// ErrSessionIsNotOffloaded is returned when an action requires an offloaded session.
+	ErrSessionIsNotOffloaded = fmt.Errorf("%w: session is not offloaded", ErrErmes)
+	// ErrSessionForwardingLoop is returned when the forwarding of a session leads back to a node it already visited.
+	ErrSessionForwardingLoop = fmt.Errorf("%w: session forwarding loop", ErrErmes)
+	// ErrTooManyForwardingHops is returned when the forwarding of a session exceeds the maximum number of hops.
+	ErrTooManyForwardingHops = fmt.Errorf("%w: too many session forwarding hops", ErrErmes)
+	// ErrInvalidCursor is returned when a scan cursor is invalid.
+	ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrErmes)
+	// ErrInvalidCount is returned when a scan count is invalid.
+	ErrInvalidCount = fmt.Errorf("%w: invalid count", ErrErmes)
+	// ErrNodeNotFound is returned when a node is not found in the infrastructure.
+	ErrNodeNotFound = fmt.Errorf("%w: node not found", ErrErmes)
+	// ErrInvalidSessionTokenSignature is returned when the signature of a session token cannot be verified.
+	ErrInvalidSessionTokenSignature = fmt.Errorf("%w: invalid session token signature", ErrErmes)
+	// ErrSessionTokenDecryption is returned when a session token cannot be decrypted.
+	ErrSessionTokenDecryption = fmt.Errorf("%w: unable to decrypt session token", ErrErmes)
+	// ErrUnknownSessionTokenVersion is returned when no codec is registered for the version of a session token.
+	ErrUnknownSessionTokenVersion = fmt.Errorf("%w: unknown session token version", ErrErmes)
 )
@@ -29,7 +29,8 @@ func (n *Node) GarbageCollectSessions(
 	// Garbage collect sessions.
 	for {
 		// Garbage collect sessions.
-		cursor, err := n.Cmd.GarbageCollectSessions(ctx, opt, cursor)
+		var err error
+		cursor, err = n.Cmd.GarbageCollectSessions(ctx, opt, cursor)
 
 		// If there is an error, return it.
 		if err != nil {
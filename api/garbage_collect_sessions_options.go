@@ -9,6 +9,12 @@ type GarbageCollectSessionsOptions struct {
 	expiredUnreleasedOlderThan *int64
 }
 
+// Get the age (in seconds) after which expired but unreleased sessions are
+// collected, nil if the collection is disabled.
+func (o GarbageCollectSessionsOptions) ExpiredUnreleasedOlderThan() *int64 {
+	return o.expiredUnreleasedOlderThan
+}
+
 // Builder for GarbageCollectSessionsOptions.
 type GarbageCollectSessionsOptionsBuilder struct {
 	options GarbageCollectSessionsOptions
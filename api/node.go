@@ -5,12 +5,17 @@ import "github.com/ermes-labs/api-go/infrastructure"
 type Node struct {
 	Cmd Commands
 	infrastructure.Node
+	// The scheduler shared by the offloads of the node, nil to not limit them.
+	Scheduler *TransferScheduler
+	// The offloads in progress on the node.
+	offloads *offloadRegistry
 }
 
 func NewNode(node infrastructure.Node, cmd Commands) *Node {
 	return &Node{
-		Cmd:  cmd,
-		Node: node,
+		Cmd:      cmd,
+		Node:     node,
+		offloads: newOffloadRegistry(),
 	}
 }
 
@@ -20,8 +25,10 @@ type Commands interface {
 	CreateAndAcquireSessionCommands
 	CreateSessionCommands
 	GarbageCollectSessionsCommands
+	OffloadJournalCommands
 	OffloadSessionCommands
 	OnloadSessionCommands
 	ResourcesUsageCommands
 	SessionMetadataCommands
+	SessionSizeCommands
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// An offload recorded in the journal of the source node. The journal records
+// every offload from its start until the onload is committed on the target, so
+// that the offloads interrupted by a restart can be resolved.
+type OffloadJournalEntry struct {
+	// The id of the offloaded session.
+	SessionId string `json:"sessionId"`
+	// The location the session is offloaded to, nil if the target has not been
+	// contacted yet.
+	NewLocation *SessionLocation `json:"newLocation,omitempty"`
+	// True if the offload has been confirmed, and the onload must be committed
+	// on the target.
+	Confirmed bool `json:"confirmed"`
+}
+
+// The status of the onload of a session on the target node.
+type OnloadStatus int
+
+const (
+	// The status of the onload could not be determined.
+	OnloadStatusUnknown OnloadStatus = iota
+	// The onload is prepared and waits for a commit or an abort.
+	OnloadStatusPending
+	// The onload has been committed, the session is stored on the node.
+	OnloadStatusCommitted
+	// No onload of the session is pending or committed on the node, it has been
+	// aborted or never prepared.
+	OnloadStatusAborted
+	// The node holds the tombstone of the session: the onload has been
+	// committed and the session offloaded again, unless the tombstone predates
+	// an onload that has been aborted.
+	OnloadStatusMoved
+)
+
+// Commands to journal the offloads. The entries are created by OffloadSession
+// and deleted by AbortSessionOffload and CompleteSessionOffload.
+type OffloadJournalCommands interface {
+	// Records the location an offloading session is being offloaded to, before
+	// the target is contacted.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloading: If the offload of the session was not started.
+	RecordSessionOffloadTarget(
+		ctx context.Context,
+		id string,
+		newLocation SessionLocation,
+	) error
+	// Completes a confirmed offload, whose onload has been committed on the
+	// target, deleting it from the journal. Completing an offload twice is not
+	// an error.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloaded: If the session is not offloaded.
+	CompleteSessionOffload(
+		ctx context.Context,
+		id string,
+	) error
+	// Returns the entries of the journal.
+	ScanOffloadJournal(
+		ctx context.Context,
+	) ([]OffloadJournalEntry, error)
+	// Returns the status of the onload of a session on this node.
+	GetSessionOnloadStatus(
+		ctx context.Context,
+		sessionId string,
+	) (OnloadStatus, error)
+}
+
+// Returns the status of the onload of a session on the node.
+func (n *Node) GetSessionOnloadStatus(
+	ctx context.Context,
+	sessionId string,
+) (OnloadStatus, error) {
+	return n.Cmd.GetSessionOnloadStatus(ctx, sessionId)
+}
+
+// Resolves the offloads of the journal, interrupted by a restart of the node.
+// The function must run at startup, before the node offloads any session. Each
+// offload is resolved according to the status of the onload on its target,
+// returned by targets for the host of the new location:
+//   - a confirmed offload is committed on the target if still pending, and
+//     completed if the target committed it, even if the session moved on
+//     since. If the target aborted it, the session data is lost on both nodes,
+//     and the offload is left in the journal with an ErrOffloadNotCommitted
+//     error.
+//   - an offload that is not confirmed is aborted on both nodes, unless the
+//     target already committed it, in which case it is confirmed. The target
+//     cannot have committed an onload that was not confirmed, so a tombstone
+//     on it predates the onload.
+//   - an offload whose target has not been contacted is aborted.
+//
+// The offloads whose status is unknown are left in the journal, and their
+// errors are returned joined, so that the recovery can be retried.
+func (n *Node) RecoverOffloads(
+	ctx context.Context,
+	targets func(host string) OffloadTarget,
+) error {
+	entries, err := n.Cmd.ScanOffloadJournal(ctx)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, entry := range entries {
+		if err := n.recoverOffload(ctx, entry, targets); err != nil {
+			errs = append(errs, fmt.Errorf("recover offload of %s: %w", entry.SessionId, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Resolve an offload of the journal.
+func (n *Node) recoverOffload(
+	ctx context.Context,
+	entry OffloadJournalEntry,
+	targets func(host string) OffloadTarget,
+) error {
+	// The target never received the session.
+	if entry.NewLocation == nil {
+		return n.Cmd.AbortSessionOffload(ctx, entry.SessionId)
+	}
+
+	target := targets(entry.NewLocation.Host)
+	status, err := target.OnloadStatus(ctx, *entry.NewLocation)
+	if err == nil && status == OnloadStatusUnknown {
+		err = fmt.Errorf("%w: unknown onload status", ErrErmes)
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.Confirmed {
+		switch status {
+		case OnloadStatusPending:
+			if err := target.CommitOnload(ctx, *entry.NewLocation); err != nil {
+				return fmt.Errorf("%w: %w", ErrOffloadNotCommitted, err)
+			}
+		case OnloadStatusAborted:
+			// The session cannot be restored on either node, keep the entry so
+			// that the loss is not hidden.
+			return fmt.Errorf("%w: onload aborted by the target", ErrOffloadNotCommitted)
+		}
+
+		return n.Cmd.CompleteSessionOffload(ctx, entry.SessionId)
+	}
+
+	// A tombstone on the target is handled as an aborted onload.
+	switch status {
+	case OnloadStatusPending:
+		if err := target.AbortOnload(ctx, *entry.NewLocation); err != nil {
+			return err
+		}
+	case OnloadStatusCommitted:
+		// The target owns the session, that cannot be aborted anymore.
+		if err := n.Cmd.ConfirmSessionOffload(ctx, entry.SessionId, *entry.NewLocation, DefaultOffloadSessionOptions(), nil); err != nil {
+			return err
+		}
+
+		return n.Cmd.CompleteSessionOffload(ctx, entry.SessionId)
+	}
+
+	return n.Cmd.AbortSessionOffload(ctx, entry.SessionId)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// The planned offload of a session.
+type PlannedOffload struct {
+	SessionId string `json:"sessionId"`
+	// The hosts of the candidate target nodes, in order of preference.
+	Targets []string `json:"targets"`
+	// The estimated bytes of the session data stream.
+	EstimatedBytes int64 `json:"estimatedBytes"`
+	// The resources usage that moves with the session.
+	ResourcesUsage ResourcesUsage `json:"resourcesUsage"`
+}
+
+// A plan of the offloads of a node, computed without moving any session, that
+// can be reviewed and then executed with ExecuteOffloadPlan.
+type OffloadPlan struct {
+	// The host of the node whose sessions are offloaded.
+	Host string `json:"host"`
+	// The host of the lookup node that chose the targets.
+	LookupHost string `json:"lookupHost"`
+	// The time the plan has been computed, expressed as a Unix timestamp (UTC).
+	CreatedAt int64            `json:"createdAt"`
+	Offloads  []PlannedOffload `json:"offloads"`
+	// The estimated bytes of all the session data streams.
+	EstimatedBytes int64 `json:"estimatedBytes"`
+	// The change of the resources usage of each node, by host, if each session
+	// is offloaded to its preferred target.
+	ResourcesDeltas map[string]ResourcesUsage `json:"resourcesDeltas"`
+}
+
+// Returns the plan of the offload of the sessions to the targets, as chosen by
+// BestSessionsToOffload and BestOffloadTargetNodes. The sessions without a
+// target are not planned.
+func (n *Node) PlanOffload(
+	ctx context.Context,
+	sessions map[string]SessionInfoForOffloadDecision,
+	lookupNode infrastructure.Node,
+	targets [][2]string,
+) (OffloadPlan, error) {
+	plan := OffloadPlan{
+		Host:            n.Host,
+		LookupHost:      lookupNode.Host,
+		CreatedAt:       time.Now().Unix(),
+		Offloads:        []PlannedOffload{},
+		ResourcesDeltas: map[string]ResourcesUsage{},
+	}
+
+	// Group the targets by session, keeping their order.
+	offloads := map[string]*PlannedOffload{}
+	for _, target := range targets {
+		sessionId, host := target[0], target[1]
+		offload, ok := offloads[sessionId]
+		if !ok {
+			offload = &PlannedOffload{SessionId: sessionId, ResourcesUsage: sessions[sessionId].ResourcesUsage}
+			offloads[sessionId] = offload
+		}
+
+		offload.Targets = append(offload.Targets, host)
+	}
+
+	for _, offload := range offloads {
+		size, err := n.Cmd.GetSessionSize(ctx, offload.SessionId)
+		// The session may have been offloaded or deleted in the meantime.
+		if errors.Is(err, ErrSessionNotFound) {
+			continue
+		}
+		if err != nil {
+			return OffloadPlan{}, err
+		}
+
+		offload.EstimatedBytes = size
+		plan.EstimatedBytes += size
+		plan.Offloads = append(plan.Offloads, *offload)
+
+		// The resources move from this node to the preferred target.
+		for resource, usage := range offload.ResourcesUsage {
+			addResourceUsage(plan.ResourcesDeltas, n.Host, resource, -usage)
+			addResourceUsage(plan.ResourcesDeltas, offload.Targets[0], resource, usage)
+		}
+	}
+
+	sort.Slice(plan.Offloads, func(i, j int) bool {
+		return plan.Offloads[i].SessionId < plan.Offloads[j].SessionId
+	})
+
+	return plan, nil
+}
+
+// Add a usage of a resource to the deltas of a node.
+func addResourceUsage(deltas map[string]ResourcesUsage, host string, resource string, usage float64) {
+	if deltas[host] == nil {
+		deltas[host] = ResourcesUsage{}
+	}
+
+	deltas[host][resource] += usage
+}
+
+// Executes a plan computed by PlanOffload. Each session is offloaded as by
+// OffloadSession to the first of its targets that accepts it, and the function
+// returns the outcome of each planned offload, with the error of the last
+// target tried if none accepted the session, or ErrNoTargetPlanned.
+// errors:
+// - ErrOffloadPlanNotForNode: If the plan has been computed by another node.
+func (n *Node) ExecuteOffloadPlan(
+	ctx context.Context,
+	plan OffloadPlan,
+	opt OffloadSessionOptions,
+	targetFor func(host string) OffloadTarget,
+	notifyLastVisitedNode func(ctx context.Context, lastVisitedLocation SessionLocation, newLocation SessionLocation) (bool, error),
+) ([]OffloadResult, error) {
+	if plan.Host != n.Host {
+		return nil, ErrOffloadPlanNotForNode
+	}
+
+	results := make([]OffloadResult, len(plan.Offloads))
+	for i, offload := range plan.Offloads {
+		results[i] = OffloadResult{SessionId: offload.SessionId, Err: ErrNoTargetPlanned}
+		for _, host := range offload.Targets {
+			newLocation, err := n.OffloadSession(ctx, offload.SessionId, opt, targetFor(host), notifyLastVisitedNode)
+			results[i].NewLocation, results[i].Err = newLocation, err
+
+			// Once prepared on a target, the session is no longer offloaded to
+			// the other ones.
+			if err == nil || errors.Is(err, ErrOffloadNotCommitted) {
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+)
+
+// The phase of an offload.
+type OffloadPhase int
+
+const (
+	// The session is being frozen and its data read.
+	OffloadPhaseReading OffloadPhase = iota
+	// The session data is being onloaded on the target.
+	OffloadPhaseOnloading
+	// The offload is being confirmed on this node.
+	OffloadPhaseConfirming
+	// The last visited node is being notified of the new location.
+	OffloadPhaseNotifying
+	// The onload is being committed on the target.
+	OffloadPhaseCommitting
+)
+
+// Returns the name of the phase.
+func (p OffloadPhase) String() string {
+	switch p {
+	case OffloadPhaseReading:
+		return "reading"
+	case OffloadPhaseOnloading:
+		return "onloading"
+	case OffloadPhaseConfirming:
+		return "confirming"
+	case OffloadPhaseNotifying:
+		return "notifying"
+	case OffloadPhaseCommitting:
+		return "committing"
+	default:
+		return "unknown"
+	}
+}
+
+// The progress of an offload.
+type OffloadProgress struct {
+	SessionId string
+	// The host of the target node.
+	Target string
+	Phase  OffloadPhase
+	// The bytes of the session data read by the target.
+	BytesSent int64
+	// The bytes of the session data the target acknowledged to have received.
+	BytesAcknowledged int64
+}
+
+// Observes the progress of an offload. The calls are serialized and must not
+// block, since they happen while the session data is streamed.
+type OffloadObserver func(progress OffloadProgress)
+
+// An offload in progress.
+type activeOffload struct {
+	mu       sync.Mutex
+	progress OffloadProgress
+	observer OffloadObserver
+	// Cancels the offload, nil once the offload is confirming.
+	cancel context.CancelFunc
+}
+
+// Update the progress and notify the observer.
+func (a *activeOffload) update(update func(progress *OffloadProgress)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	update(&a.progress)
+	if a.observer != nil {
+		a.observer(a.progress)
+	}
+}
+
+// Set the phase of the offload.
+func (a *activeOffload) phase(phase OffloadPhase) {
+	a.update(func(progress *OffloadProgress) {
+		progress.Phase = phase
+	})
+}
+
+// Start the confirmation of the offload, after which it can no longer be
+// cancelled. Returns false if the offload has already been cancelled.
+func (a *activeOffload) confirm(ctx context.Context) bool {
+	a.mu.Lock()
+	a.cancel = nil
+	a.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	a.phase(OffloadPhaseConfirming)
+	return true
+}
+
+// Report the bytes of the session data acknowledged by the target of an
+// offload, for the transports whose target acknowledges the data while it is
+// received. The reader is the one passed to OffloadTarget.PrepareOnload, other
+// readers are ignored.
+func AcknowledgeOffloadedBytes(reader io.Reader, acknowledged int64) {
+	if o, ok := reader.(*offload); ok {
+		o.active.update(func(progress *OffloadProgress) {
+			progress.BytesAcknowledged = max(progress.BytesAcknowledged, acknowledged)
+		})
+	}
+}
+
+// The offloads in progress on a node, by session id.
+type offloadRegistry struct {
+	mu       sync.Mutex
+	offloads map[string]*activeOffload
+}
+
+func newOffloadRegistry() *offloadRegistry {
+	return &offloadRegistry{offloads: map[string]*activeOffload{}}
+}
+
+// Register the offload of a session.
+// errors:
+// - ErrSessionIsOffloading: If an offload of the session is in progress.
+func (r *offloadRegistry) register(sessionId string, host string, observer OffloadObserver, cancel context.CancelFunc) (*activeOffload, error) {
+	a := &activeOffload{
+		progress: OffloadProgress{SessionId: sessionId, Target: host, Phase: OffloadPhaseReading},
+		observer: observer,
+		cancel:   cancel,
+	}
+
+	// The offloads of nodes not created by NewNode are not listed.
+	if r == nil {
+		return a, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.offloads[sessionId]; ok {
+		return nil, ErrSessionIsOffloading
+	}
+
+	r.offloads[sessionId] = a
+	return a, nil
+}
+
+// Remove the offload of a session once it ends.
+func (r *offloadRegistry) unregister(sessionId string, a *activeOffload) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.offloads[sessionId] == a {
+		delete(r.offloads, sessionId)
+	}
+}
+
+// Returns the progress of the offloads in progress on the node, ordered by
+// session id.
+func (n *Node) ActiveOffloads() []OffloadProgress {
+	if n.offloads == nil {
+		return nil
+	}
+
+	n.offloads.mu.Lock()
+	active := make([]*activeOffload, 0, len(n.offloads.offloads))
+	for _, a := range n.offloads.offloads {
+		active = append(active, a)
+	}
+	n.offloads.mu.Unlock()
+
+	progresses := make([]OffloadProgress, 0, len(active))
+	for _, a := range active {
+		a.mu.Lock()
+		progresses = append(progresses, a.progress)
+		a.mu.Unlock()
+	}
+
+	sort.Slice(progresses, func(i, j int) bool {
+		return progresses[i].SessionId < progresses[j].SessionId
+	})
+
+	return progresses
+}
+
+// Cancels the offload of a session in progress on the node. The offload fails
+// with context.Canceled and is rolled back as any other failure, the session
+// returns active on this node. Cancelling a session of a batch cancels the
+// whole batch.
+// errors:
+// - ErrSessionIsNotOffloading: If no offload of the session is in progress.
+// - ErrOffloadIsConfirming: If the offload is already being confirmed.
+func (n *Node) CancelOffload(sessionId string) error {
+	if n.offloads == nil {
+		return ErrSessionIsNotOffloading
+	}
+
+	n.offloads.mu.Lock()
+	a, ok := n.offloads.offloads[sessionId]
+	n.offloads.mu.Unlock()
+
+	if !ok {
+		return ErrSessionIsNotOffloading
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cancel == nil {
+		return ErrOffloadIsConfirming
+	}
+
+	a.cancel()
+	return nil
+}
@@ -2,7 +2,10 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
 )
 
 type OffloadSessionCommands interface {
@@ -26,6 +29,7 @@ type OffloadSessionCommands interface {
 	// Confirms the offload of a session.
 	// errors:
 	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloading: If the offload of the session was not started.
 	ConfirmSessionOffload(
 		ctx context.Context,
 		id string,
@@ -34,6 +38,16 @@ type OffloadSessionCommands interface {
 		// TODO: extract into another API.
 		notifyLastVisitedNode func(ctx context.Context, oldLocation SessionLocation) (clientRedirected bool, err error),
 	) (err error)
+	// Aborts the offload of a session, that returns active on this node. Once
+	// the offload is confirmed it can no longer be aborted.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloading: If the offload of the session was not started
+	// or has already been confirmed.
+	AbortSessionOffload(
+		ctx context.Context,
+		id string,
+	) (err error)
 	// Updates the location of an offloaded session, the function returns true if
 	// the client has already been redirected to the new location, while the update
 	// is in progress. If true, this node is no more the last visited one, otherwise
@@ -46,6 +60,26 @@ type OffloadSessionCommands interface {
 		id string,
 		newLocation SessionLocation,
 	) (clientRedirected bool, err error)
+	// Returns the location an offloaded session points to, and true if a
+	// client has been redirected to it.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloaded: If the session is not offloaded.
+	GetOffloadedSessionLocation(
+		ctx context.Context,
+		id string,
+	) (offloadedTo SessionLocation, clientRedirected bool, err error)
+	// Deletes the tombstone of an offloaded session, the clients that still
+	// hold a token for this node no longer find the session. The tombstone of
+	// an offload still in the journal is kept for RecoverOffloads.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOffloaded: If the session is not offloaded.
+	// - ErrOffloadNotCommitted: If the offload of the session is in the journal.
+	DeleteOffloadedSession(
+		ctx context.Context,
+		id string,
+	) error
 	// Returns the offloaded sessions, the function returns the new cursor, the
 	// list of session ids and an error. The cursor is used to paginate the results.
 	// If the cursor is empty, the function returns the first page of results.
@@ -59,80 +93,291 @@ type OffloadSessionCommands interface {
 	) (ids []string, newCursor uint64, err error)
 }
 
+// The node a session is offloaded to. The offload is a two-phase protocol:
+// the session is prepared on the target, that keeps it pending, and then either
+// committed or aborted, so that every offload ends with exactly one owner.
+type OffloadTarget interface {
+	// The host of the target node, that keeps the id of the prepared sessions.
+	Host() string
+	// Onloads the session on the target in a pending state, and returns its
+	// new location.
+	PrepareOnload(
+		ctx context.Context,
+		metadata SessionMetadata,
+		reader io.Reader,
+		onloadedFrom SessionLocation,
+	) (SessionLocation, error)
+	// Commits the pending onload at the given location.
+	CommitOnload(ctx context.Context, location SessionLocation) error
+	// Aborts the pending onload at the given location, deleting its data.
+	AbortOnload(ctx context.Context, location SessionLocation) error
+	// Returns the status of the onload at the given location.
+	OnloadStatus(ctx context.Context, location SessionLocation) (OnloadStatus, error)
+}
+
+// An offload target that onloads the sessions on a node of the same process.
+type LocalOffloadTarget struct {
+	Node *Node
+}
+
+// The host of the node.
+func (t LocalOffloadTarget) Host() string {
+	return t.Node.Host
+}
+
+// Onload the session on the node in a pending state.
+func (t LocalOffloadTarget) PrepareOnload(
+	ctx context.Context,
+	metadata SessionMetadata,
+	reader io.Reader,
+	onloadedFrom SessionLocation,
+) (SessionLocation, error) {
+	return t.Node.OnloadSession(ctx, metadata, reader, NewOnloadSessionOptionsBuilder().
+		OnloadedFrom(onloadedFrom).
+		Pending().
+		Build())
+}
+
+// Commit the pending onload of the session on the node.
+func (t LocalOffloadTarget) CommitOnload(ctx context.Context, location SessionLocation) error {
+	return t.Node.CommitSessionOnload(ctx, location.SessionId)
+}
+
+// Abort the pending onload of the session on the node.
+func (t LocalOffloadTarget) AbortOnload(ctx context.Context, location SessionLocation) error {
+	return t.Node.AbortSessionOnload(ctx, location.SessionId)
+}
+
+// Return the status of the onload of the session on the node.
+func (t LocalOffloadTarget) OnloadStatus(ctx context.Context, location SessionLocation) (OnloadStatus, error) {
+	return t.Node.GetSessionOnloadStatus(ctx, location.SessionId)
+}
+
 // Offloads a session to a new location. The function returns the new location of
-// the session.
+// the session. The session is prepared on the target and the offload is then
+// confirmed on this node, that is the point of no return: if anything fails
+// before it, both sides are aborted and the session stays on this node,
+// otherwise the onload is committed on the target. Each step is recorded in the
+// offload journal, see RecoverOffloads. The transfer waits for the scheduler
+// of the node, if any, before the session is frozen. The progress of the
+// offload is reported to the observer of the options, and the offload is
+// listed by ActiveOffloads until it ends.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrSessionIsOffloading: If the session is already offloading.
+// - ErrUnableToOffloadAcquiredSession: If the session is unable to offload because it is acquired.
+// - ErrOffloadNotCommitted: If the offload was confirmed but the target failed
+// to commit the onload, that must be retried with the target.
 func (n *Node) OffloadSession(
 	ctx context.Context,
 	sessionId string,
 	opt OffloadSessionOptions,
-	onload func(ctx context.Context, metadata SessionMetadata, reader io.Reader) (SessionLocation, error),
+	target OffloadTarget,
 	notifyLastVisitedNode func(ctx context.Context, lastVisitedLocation SessionLocation, newLocation SessionLocation) (bool, error),
 ) (SessionLocation, error) {
-	// Create a new context to cancel the loader if the context is canceled.
-	ctx, cancel := context.WithCancel(ctx)
+	// Wait for the scheduler before freezing the session.
+	release, err := n.Scheduler.Acquire(ctx, target.Host(), opt.Priority())
+	if err != nil {
+		return SessionLocation{}, err
+	}
+	defer release()
 
-	// Read the metadata of the session.
-	metadata, err := n.Cmd.GetSessionMetadata(ctx, sessionId)
-	// If there is an error, return it.
+	// The offload can be cancelled until it is confirmed, the rollback uses
+	// the parent context.
+	offloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	active, err := n.offloads.register(sessionId, target.Host(), opt.Observer(), cancel)
 	if err != nil {
-		cancel()
 		return SessionLocation{}, err
 	}
+	defer n.offloads.unregister(sessionId, active)
+	active.phase(OffloadPhaseReading)
 
 	// Start the offload of the session.
-	reader, loader, err := n.Cmd.OffloadSession(ctx, sessionId, opt)
-	// If there is an error, return it.
+	o, err := n.startOffload(offloadCtx, sessionId, opt, target.Host(), active)
 	if err != nil {
-		cancel()
 		return SessionLocation{}, err
 	}
 
-	// If the loader failed, default to false.
-	loaderFailed := false
-	// If there is a loader, run it concurrently in a go routine.
-	if loader != nil {
-		// Wrap the reader
-		reader = wrapReaderWithCheck(reader, func(err error) {
-			if err != io.EOF {
-				// If the loader failed, cancel the context.
-				loaderFailed = true
-				cancel()
-			}
+	// Prepare the session on the target.
+	oldLocation := NewSessionLocation(n.Host, sessionId)
+	active.phase(OffloadPhaseOnloading)
+	newLocation, err := target.PrepareOnload(offloadCtx, o.metadata, o, oldLocation)
+
+	return n.finishOffload(ctx, offloadCtx, o, opt, target, newLocation, err, notifyLastVisitedNode)
+}
+
+// An offload of a session waiting for the prepare of the onload on the target,
+// that reads the session data from it.
+type offload struct {
+	sessionId string
+	metadata  SessionMetadata
+	reader    io.ReadCloser
+	// The loader is started once the session data is first read.
+	loader func()
+	start  sync.Once
+	// The first error met while reading the session data, if any.
+	loaderErr chan error
+	// Stops the loader.
+	cancel context.CancelFunc
+	// The progress of the offload.
+	active *activeOffload
+}
+
+// Read the session data, starting the loader if needed.
+func (o *offload) Read(p []byte) (int, error) {
+	o.start.Do(func() {
+		// If there is a loader, run it concurrently in a go routine.
+		if o.loader != nil {
+			go o.loader()
+		}
+	})
+
+	n, err := o.reader.Read(p)
+	if n > 0 {
+		o.active.update(func(progress *OffloadProgress) {
+			progress.BytesSent += int64(n)
 		})
+	}
+
+	return n, err
+}
 
-		// Run the loader concurrently.
-		go loader()
+// Start the offload of a session to the target host, and record the target in
+// the offload journal.
+func (n *Node) startOffload(
+	ctx context.Context,
+	sessionId string,
+	opt OffloadSessionOptions,
+	host string,
+	active *activeOffload,
+) (*offload, error) {
+	// Read the metadata of the session.
+	metadata, err := n.Cmd.GetSessionMetadata(ctx, sessionId)
+	// If there is an error, return it.
+	if err != nil {
+		return nil, err
 	}
 
-	// Run the onload function.
-	newLocation, err := onload(ctx, metadata, reader)
-	// We could close them only in case of error, but we do it always to be sure.
-	cancel()
-	reader.Close()
+	// Create a new context to cancel the loader if the onload fails.
+	loaderCtx, cancel := context.WithCancel(ctx)
+
+	// Start the offload of the session.
+	reader, loader, err := n.Cmd.OffloadSession(loaderCtx, sessionId, opt)
 	// If there is an error, return it.
 	if err != nil {
-		return SessionLocation{}, err
+		cancel()
+		return nil, err
+	}
+
+	// Record the target before contacting it, the session keeps its id.
+	err = n.Cmd.RecordSessionOffloadTarget(ctx, sessionId, NewSessionLocation(host, sessionId))
+	if err != nil {
+		cancel()
+		reader.Close()
+		return nil, errors.Join(err, n.Cmd.AbortSessionOffload(ctx, sessionId))
 	}
 
-	// If there was an error during the streaming process but for some reason the
-	// onloading node confirmed the offload.
-	if loaderFailed {
-		// TODO: What to do here?
+	o := &offload{
+		sessionId: sessionId,
+		metadata:  metadata,
+		loader:    loader,
+		loaderErr: make(chan error, 1),
+		cancel:    cancel,
+		active:    active,
+	}
+
+	o.reader = wrapReaderWithCheck(n.Scheduler.Throttle(loaderCtx, reader, opt.Priority()), func(err error) {
+		select {
+		case o.loaderErr <- err:
+		default:
+		}
+	})
+
+	return o, nil
+}
+
+// Finish the offload of a session once the onload has been prepared on the
+// target, with the given outcome. If the prepare or the read of the session
+// data failed, or the offload context is done, both sides are aborted,
+// otherwise the offload is confirmed and the onload committed. The rollback and
+// the commit run even if ctx is cancelled, so that the offload always ends.
+func (n *Node) finishOffload(
+	ctx context.Context,
+	offloadCtx context.Context,
+	o *offload,
+	opt OffloadSessionOptions,
+	target OffloadTarget,
+	newLocation SessionLocation,
+	err error,
+	notifyLastVisitedNode func(ctx context.Context, lastVisitedLocation SessionLocation, newLocation SessionLocation) (bool, error),
+) (SessionLocation, error) {
+	ctx = context.WithoutCancel(ctx)
+	sessionId := o.sessionId
+	// Stop the loader and release the reader, whatever the outcome.
+	o.cancel()
+	o.reader.Close()
+
+	// A target may accept a truncated stream, so a read error aborts the offload
+	// even if the prepare succeeded.
+	if err == nil {
+		// The prepared onload has received all the session data sent.
+		o.active.update(func(progress *OffloadProgress) {
+			progress.BytesAcknowledged = progress.BytesSent
+		})
+
+		select {
+		case err = <-o.loaderErr:
+		default:
+		}
+
+		// The offload may have been cancelled after the prepare.
+		if err == nil && !o.active.confirm(offloadCtx) {
+			err = context.Cause(offloadCtx)
+		}
+
+		if err != nil {
+			err = errors.Join(err, target.AbortOnload(ctx, newLocation))
+		}
 	} else {
-		// TODO: DO we assume the loader finished?
+		// The target may have prepared the onload even if the prepare failed,
+		// e.g. if its response was lost. The session keeps its id, and aborting
+		// an onload that was never prepared is harmless, so the error is ignored.
+		_ = target.AbortOnload(ctx, NewSessionLocation(target.Host(), sessionId))
+	}
+
+	// If the prepare failed, the session returns active on this node.
+	if err != nil {
+		return SessionLocation{}, errors.Join(err, n.Cmd.AbortSessionOffload(ctx, sessionId))
 	}
 
 	// Confirm the offload of the session.
 	err = n.Cmd.ConfirmSessionOffload(ctx, sessionId, newLocation, opt, func(ctx context.Context, lastVisitedLocation SessionLocation) (bool, error) {
+		o.active.phase(OffloadPhaseNotifying)
 		return notifyLastVisitedNode(ctx, lastVisitedLocation, newLocation)
 	})
-	// If there is an error, return it.
+	// The confirmation may fail after the session has been offloaded, e.g. when
+	// notifying the last visited node, so the abort decides which node owns the
+	// session.
 	if err != nil {
-		// TODO: What to do here?
+		abortErr := n.Cmd.AbortSessionOffload(ctx, sessionId)
+		switch {
+		case abortErr == nil:
+			return SessionLocation{}, errors.Join(err, target.AbortOnload(ctx, newLocation))
+		case !errors.Is(abortErr, ErrSessionIsNotOffloading):
+			return SessionLocation{}, errors.Join(err, abortErr)
+		}
+	}
+
+	// The session is offloaded, commit the onload on the target.
+	o.active.phase(OffloadPhaseCommitting)
+	if commitErr := target.CommitOnload(ctx, newLocation); commitErr != nil {
+		return newLocation, fmt.Errorf("%w: %w", ErrOffloadNotCommitted, commitErr)
 	}
 
-	// Return the metadata and the reader.
-	return newLocation, nil
+	// Return the new location and the error of the confirmation, if any.
+	return newLocation, errors.Join(err, n.Cmd.CompleteSessionOffload(ctx, sessionId))
 }
 
 func (n *Node) UpdateOffloadedSessionLocation(
@@ -6,6 +6,38 @@ type OffloadSessionOptions struct {
 	sessionId string
 	// The sessionLocation to offload the session to.
 	toLocation SessionLocation
+	// The content encodings of the session stream, in order of preference.
+	encodings []string
+	// The priority of the transfer of the session.
+	priority TransferPriority
+	// The observer of the progress of the offload.
+	observer OffloadObserver
+}
+
+// Get the id of the session to offload.
+func (o OffloadSessionOptions) SessionId() string {
+	return o.sessionId
+}
+
+// Get the sessionLocation to offload the session to.
+func (o OffloadSessionOptions) ToLocation() SessionLocation {
+	return o.toLocation
+}
+
+// Get the content encodings of the session stream, in order of preference. If
+// nil, the transport uses its own.
+func (o OffloadSessionOptions) Encodings() []string {
+	return o.encodings
+}
+
+// Get the priority of the transfer of the session.
+func (o OffloadSessionOptions) Priority() TransferPriority {
+	return o.priority
+}
+
+// Get the observer of the progress of the offload, nil if not set.
+func (o OffloadSessionOptions) Observer() OffloadObserver {
+	return o.observer
 }
 
 // Builder for OffloadSessionOptions.
@@ -32,6 +64,26 @@ func (builder *OffloadSessionOptionsBuilder) ToLocation(toLocation SessionLocati
 	return builder
 }
 
+// Set the content encodings of the session stream (e.g. "zstd", "gzip"), in
+// order of preference. The first one accepted by the target node is used, the
+// stream is not encoded if none is.
+func (builder *OffloadSessionOptionsBuilder) Encodings(encodings ...string) *OffloadSessionOptionsBuilder {
+	builder.options.encodings = encodings
+	return builder
+}
+
+// Set the priority of the transfer of the session.
+func (builder *OffloadSessionOptionsBuilder) Priority(priority TransferPriority) *OffloadSessionOptionsBuilder {
+	builder.options.priority = priority
+	return builder
+}
+
+// Set the observer of the progress of the offload.
+func (builder *OffloadSessionOptionsBuilder) Observer(observer OffloadObserver) *OffloadSessionOptionsBuilder {
+	builder.options.observer = observer
+	return builder
+}
+
 // Build the OffloadSessionOptions.
 func (builder *OffloadSessionOptionsBuilder) Build() OffloadSessionOptions {
 	return builder.options
@@ -39,5 +91,11 @@ func (builder *OffloadSessionOptionsBuilder) Build() OffloadSessionOptions {
 
 // DefaultOffloadSessionOptions returns the default options for the offloadSession.
 func DefaultOffloadSessionOptions() OffloadSessionOptions {
-	return OffloadSessionOptions{}
+	return OffloadSessionOptions{
+		sessionId:  "",
+		toLocation: SessionLocation{},
+		encodings:  nil,
+		priority:   TransferPriorityNormal,
+		observer:   nil,
+	}
 }
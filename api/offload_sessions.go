@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// A session to onload in a batch.
+type BatchOnload struct {
+	Metadata     SessionMetadata
+	Reader       io.Reader
+	OnloadedFrom SessionLocation
+}
+
+// The outcome of the prepare of an onload of a batch.
+type BatchOnloadResult struct {
+	Location SessionLocation
+	Err      error
+}
+
+// An offload target that prepares the onloads of several sessions at once.
+type BatchOffloadTarget interface {
+	OffloadTarget
+	// Onloads the sessions on the target in a pending state, reading their data
+	// in order. Returns the outcome of each onload, or an error if none of them
+	// has been prepared.
+	PrepareOnloads(ctx context.Context, onloads []BatchOnload) ([]BatchOnloadResult, error)
+}
+
+// Onload the sessions on the node in a pending state, one after the other.
+func (t LocalOffloadTarget) PrepareOnloads(ctx context.Context, onloads []BatchOnload) ([]BatchOnloadResult, error) {
+	results := make([]BatchOnloadResult, len(onloads))
+	for i, onload := range onloads {
+		results[i].Location, results[i].Err = t.PrepareOnload(ctx, onload.Metadata, onload.Reader, onload.OnloadedFrom)
+	}
+
+	return results, nil
+}
+
+// The outcome of the offload of a session of a batch.
+type OffloadResult struct {
+	SessionId   string
+	NewLocation SessionLocation
+	// The error of the offload of the session, see OffloadSession.
+	Err error
+}
+
+// Offloads several sessions to the same target with a single transfer. Each
+// session is then confirmed and committed on its own, as by OffloadSession,
+// and the function returns the outcome of each offload in the order of the
+// ids. If the transfer fails, the target may have prepared some of the
+// onloads without reporting them, so each session whose prepare failed is
+// aborted on the target at its id, even if ctx is cancelled. The batch counts
+// as one transfer for the scheduler of the node, and each session is listed by
+// ActiveOffloads.
+// errors:
+// - The error of the context if it is done before the transfer starts.
+func (n *Node) OffloadSessions(
+	ctx context.Context,
+	sessionIds []string,
+	opt OffloadSessionOptions,
+	target BatchOffloadTarget,
+	notifyLastVisitedNode func(ctx context.Context, lastVisitedLocation SessionLocation, newLocation SessionLocation) (bool, error),
+) ([]OffloadResult, error) {
+	// Wait for the scheduler before freezing the sessions.
+	release, err := n.Scheduler.Acquire(ctx, target.Host(), opt.Priority())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// The sessions of the batch are cancelled together.
+	offloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]OffloadResult, len(sessionIds))
+	// The offloads that have started, and the index of their result.
+	offloads := make([]*offload, 0, len(sessionIds))
+	indexes := make([]int, 0, len(sessionIds))
+	onloads := make([]BatchOnload, 0, len(sessionIds))
+
+	for i, sessionId := range sessionIds {
+		results[i].SessionId = sessionId
+
+		active, err := n.offloads.register(sessionId, target.Host(), opt.Observer(), cancel)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		defer n.offloads.unregister(sessionId, active)
+		active.phase(OffloadPhaseReading)
+
+		o, err := n.startOffload(offloadCtx, sessionId, opt, target.Host(), active)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		offloads = append(offloads, o)
+		indexes = append(indexes, i)
+		onloads = append(onloads, BatchOnload{
+			Metadata:     o.metadata,
+			Reader:       o,
+			OnloadedFrom: NewSessionLocation(n.Host, sessionId),
+		})
+	}
+
+	if len(offloads) == 0 {
+		return results, nil
+	}
+
+	// Prepare the sessions on the target.
+	for _, o := range offloads {
+		o.active.phase(OffloadPhaseOnloading)
+	}
+	prepared, err := target.PrepareOnloads(offloadCtx, onloads)
+
+	for j, o := range offloads {
+		var newLocation SessionLocation
+		prepareErr := err
+		if err == nil {
+			if j < len(prepared) {
+				newLocation, prepareErr = prepared[j].Location, prepared[j].Err
+			} else {
+				prepareErr = errors.New("the target did not prepare the onload")
+			}
+		}
+
+		result := &results[indexes[j]]
+		result.NewLocation, result.Err = n.finishOffload(ctx, offloadCtx, o, opt, target, newLocation, prepareErr, notifyLastVisitedNode)
+	}
+
+	return results, nil
+}
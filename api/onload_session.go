@@ -12,12 +12,33 @@ type OnloadSessionCommands interface {
 	// errors:
 	// - ErrSessionNotFound: If no session with the given id is found.
 	// - ErrSessionAlreadyOnloaded: If the session is already onloaded.
+	// - ErrStaleFencingToken: If the fencing token of the metadata is lower than
+	// the last one issued for the session by this node, i.e. the onloaded data
+	// is older than the data of a later offload.
 	OnloadSession(
 		ctx context.Context,
 		metadata SessionMetadata,
 		reader io.Reader,
 		opt OnloadSessionOptions,
 	) (string, error)
+	// Commits the pending onload of a session, that becomes visible.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOnloading: If the onload of the session is not pending.
+	CommitSessionOnload(
+		ctx context.Context,
+		sessionId string,
+	) error
+	// Aborts the pending onload of a session. The onloaded session is deleted,
+	// and the tombstone it replaced, if any, is restored pointing to the location
+	// the session was onloaded from, that still owns it.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrSessionIsNotOnloading: If the onload of the session is not pending.
+	AbortSessionOnload(
+		ctx context.Context,
+		sessionId string,
+	) error
 }
 
 // Setup the onload of a session. The function returns the location of the
@@ -25,6 +46,8 @@ type OnloadSessionCommands interface {
 // that deletes the session if the onload fails, and an error.
 // errors:
 // - ErrSessionAlreadyOnloaded: If the session is already onloaded.
+// - ErrStaleFencingToken: If the onloaded data is older than the data of a later
+// offload.
 func (n *Node) OnloadSession(
 	ctx context.Context,
 	metadata SessionMetadata,
@@ -42,3 +65,25 @@ func (n *Node) OnloadSession(
 	// Return the location of the session.
 	return NewSessionLocation(n.Host, sessionId), nil
 }
+
+// Commit the pending onload of a session, that becomes visible.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrSessionIsNotOnloading: If the onload of the session is not pending.
+func (n *Node) CommitSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	return n.Cmd.CommitSessionOnload(ctx, sessionId)
+}
+
+// Abort the pending onload of a session, deleting the onloaded data.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrSessionIsNotOnloading: If the onload of the session is not pending.
+func (n *Node) AbortSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	return n.Cmd.AbortSessionOnload(ctx, sessionId)
+}
@@ -1,7 +1,25 @@
 package api
 
 // Options to onload a session.
-type OnloadSessionOptions struct{}
+type OnloadSessionOptions struct {
+	// The location of the session before the onload, nil if unknown. When set,
+	// the session keeps its id and the location is used as the last visited
+	// location of the client.
+	onloadedFrom *SessionLocation
+	// If true, the session is onloaded in the pending state, and becomes visible
+	// only once the onload is committed.
+	pending bool
+}
+
+// Get the location of the session before the onload.
+func (o OnloadSessionOptions) OnloadedFrom() *SessionLocation {
+	return o.onloadedFrom
+}
+
+// Get the value of pending.
+func (o OnloadSessionOptions) Pending() bool {
+	return o.pending
+}
 
 // Builder for CreateSessionOptions.
 type OnloadSessionOptionsBuilder struct {
@@ -15,6 +33,21 @@ func NewOnloadSessionOptionsBuilder() *OnloadSessionOptionsBuilder {
 	}
 }
 
+// Set the location of the session before the onload.
+func (builder *OnloadSessionOptionsBuilder) OnloadedFrom(onloadedFrom SessionLocation) *OnloadSessionOptionsBuilder {
+	builder.options.onloadedFrom = &onloadedFrom
+	return builder
+}
+
+// Onload the session in the pending state, the first phase of a two-phase
+// offload: the session is neither visible nor acquirable until the onload is
+// committed with CommitSessionOnload, and it is deleted, restoring the replaced
+// tombstone if any, by AbortSessionOnload.
+func (builder *OnloadSessionOptionsBuilder) Pending() *OnloadSessionOptionsBuilder {
+	builder.options.pending = true
+	return builder
+}
+
 // Build the OnloadSessionOptions.
 func (builder *OnloadSessionOptionsBuilder) Build() OnloadSessionOptions {
 	return builder.options
@@ -23,5 +56,8 @@ func (builder *OnloadSessionOptionsBuilder) Build() OnloadSessionOptions {
 // DefaultOnloadSessionOptions returns the default options to onload a
 // session.
 func DefaultOnloadSessionOptions() OnloadSessionOptions {
-	return OnloadSessionOptions{}
+	return OnloadSessionOptions{
+		onloadedFrom: nil,
+		pending:      false,
+	}
 }
@@ -21,6 +21,10 @@ type SessionMetadata struct {
 	// The expiration time is expressed as a Unix timestamp (UTC). If the
 	// expiration time is nil, the session does not expire.
 	ExpiresAt *int64
+	// The last fencing token issued for the session. It travels with the
+	// offloads, so that the fencing tokens of a session keep increasing across
+	// nodes. It is ignored by SetSessionMetadata.
+	FencingToken uint64
 }
 
 // Commands to manage the metadata of a session.
@@ -35,6 +39,12 @@ type SessionMetadataCommands interface {
 	// SetClientCoordinates sets the coordinates of the client of a session.
 	// errors:
 	// - ErrSessionNotFound: If no session with the given id is found.
+	// - ErrStaleFencingToken: If the options carry a fencing token of an
+	// acquisition that has been released, or whose lease expired.
+	// - ErrSessionIsOffloading: If the options carry a fencing token and the
+	// session is offloading.
+	// - ErrAcquisitionIsReadOnly: If the options carry the fencing token of an
+	// acquisition in read mode.
 	SetSessionMetadata(
 		ctx context.Context,
 		sessionId string,
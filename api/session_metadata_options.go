@@ -19,6 +19,29 @@ type SessionMetadataOptions struct {
 	// If true, the session is considered expired. Default is false. If true, the
 	// "expiresAt" field is ignored.
 	expired bool
+	// The fencing token of the acquisition that sets the metadata, 0 if the
+	// write is not fenced. Default is 0.
+	fencingToken uint64
+}
+
+// Get the geographic coordinates associated with the client that owns the session.
+func (o SessionMetadataOptions) ClientGeoCoordinates() *infrastructure.GeoCoordinates {
+	return o.clientGeoCoordinates
+}
+
+// Get the expiration time.
+func (o SessionMetadataOptions) ExpiresAt() *int64 {
+	return o.expiresAt
+}
+
+// Get the value of expired.
+func (o SessionMetadataOptions) Expired() bool {
+	return o.expired
+}
+
+// Get the fencing token of the acquisition that sets the metadata.
+func (o SessionMetadataOptions) FencingToken() uint64 {
+	return o.fencingToken
 }
 
 // Builder for SessionMetadataOptions.
@@ -70,6 +93,14 @@ func (builder *SessionMetadataOptionsBuilder) MarkExpired() *SessionMetadataOpti
 	return builder
 }
 
+// Fence the write with the fencing token of the acquisition that performs it,
+// so that it is rejected if the acquisition has been released or its lease
+// expired.
+func (builder *SessionMetadataOptionsBuilder) FencingToken(fencingToken uint64) *SessionMetadataOptionsBuilder {
+	builder.options.fencingToken = fencingToken
+	return builder
+}
+
 // Build the SessionMetadataOptions.
 func (builder *SessionMetadataOptionsBuilder) Build() SessionMetadataOptions {
 	return builder.options
@@ -81,5 +112,6 @@ func DefaultSessionMetadataOptions() SessionMetadataOptions {
 		clientGeoCoordinates: nil,
 		expiresAt:            nil,
 		expired:              false,
+		fencingToken:         0,
 	}
 }
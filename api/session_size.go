@@ -0,0 +1,24 @@
+package api
+
+import "context"
+
+// Commands to estimate the size of the sessions.
+type SessionSizeCommands interface {
+	// Returns the number of bytes of the session data stream of an offload of
+	// the session, as it would be read now.
+	// errors:
+	// - ErrSessionNotFound: If no session with the given id is found.
+	GetSessionSize(
+		ctx context.Context,
+		sessionId string,
+	) (int64, error)
+}
+
+// Returns the number of bytes of the session data stream of an offload of the
+// session, as it would be read now.
+func (n *Node) GetSessionSize(
+	ctx context.Context,
+	sessionId string,
+) (int64, error) {
+	return n.Cmd.GetSessionSize(ctx, sessionId)
+}
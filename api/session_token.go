@@ -1,9 +1,5 @@
 package api
 
-import (
-	"encoding/json"
-)
-
 // SessionToken represents a session token.
 type SessionToken struct {
 	// The sessionLocation of the session.
@@ -21,29 +17,19 @@ func NewSessionTokenAfterOffloading(sessionToken SessionToken, newLocation Sessi
 	return sessionToken
 }
 
-// Unmarshall a session token.
-func UnmarshallSessionToken(sessionTokenBytes []byte) (*SessionToken, error) {
-	if len(sessionTokenBytes) == 0 {
-		return nil, nil
-	}
-
-	var sessionToken SessionToken
-	err := json.Unmarshal(sessionTokenBytes, &sessionToken)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &sessionToken, nil
+// Unmarshall a session token with the DefaultSessionTokenCodecs. If verifier
+// is not nil, the token must be signed and its signature is verified.
+// errors:
+// - ErrInvalidSessionTokenSignature: If the token is not signed or the
+// signature does not match.
+// - ErrUnknownSessionTokenVersion: If no codec is registered for the version of
+// the token.
+func UnmarshallSessionToken(sessionTokenBytes []byte, verifier TokenVerifier) (*SessionToken, error) {
+	return DefaultSessionTokenCodecs.Unmarshall(sessionTokenBytes, verifier)
 }
 
-// Marshall a session token.
-func MarshallSessionToken(sessionToken SessionToken) ([]byte, error) {
-	sessionTokenBytes, err := json.Marshal(sessionToken)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return sessionTokenBytes, nil
+// Marshall a session token with the default codec of the
+// DefaultSessionTokenCodecs. If signer is not nil, the token is signed.
+func MarshallSessionToken(sessionToken SessionToken, signer TokenSigner) ([]byte, error) {
+	return DefaultSessionTokenCodecs.Marshall(sessionToken, signer)
 }
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// TokenCipher encrypts session tokens, so that the clients only see an opaque
+// string that does not reveal the host or the id of the session.
+type TokenCipher interface {
+	// Encrypt the encoded session token.
+	Seal(sessionTokenBytes []byte) ([]byte, error)
+	// Decrypt a session token encrypted with Seal.
+	// errors:
+	// - ErrSessionTokenDecryption: If the key is unknown or the token has been
+	// tampered with.
+	Open(sealedSessionTokenBytes []byte) ([]byte, error)
+}
+
+// AEADKeyring encrypts session tokens with AES-GCM. A sealed token is the id
+// of the key followed by a dot and the base64url encoding of the nonce and the
+// ciphertext. The key id is authenticated as additional data. Tokens are
+// sealed with the current key and opened with any key of the keyring, so keys
+// can be rotated by adding a new version of the key to every node, switching
+// the current key, and removing the old version once the tokens sealed with it
+// expired.
+type AEADKeyring struct {
+	// The id of the key used to seal.
+	currentKeyId string
+	// The ciphers by key id.
+	aeads map[string]cipher.AEAD
+}
+
+// Assert that AEADKeyring implements TokenCipher.
+var _ TokenCipher = (*AEADKeyring)(nil)
+
+// NewAEADKeyring creates a new AEADKeyring that seals with the key
+// currentKeyId. Keys must be 16, 24 or 32 bytes long (AES-128, AES-192 or
+// AES-256), key ids must be non-empty and must not contain dots, e.g. "v1".
+func NewAEADKeyring(currentKeyId string, keys map[string][]byte) (*AEADKeyring, error) {
+	if _, ok := keys[currentKeyId]; !ok {
+		return nil, fmt.Errorf("%w: unknown current key %q", ErrErmes, currentKeyId)
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for keyId, key := range keys {
+		if keyId == "" || bytes.ContainsRune([]byte(keyId), '.') {
+			return nil, fmt.Errorf("%w: invalid key id %q", ErrErmes, keyId)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid key %q: %v", ErrErmes, keyId, err)
+		}
+
+		if aeads[keyId], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AEADKeyring{
+		currentKeyId: currentKeyId,
+		aeads:        aeads,
+	}, nil
+}
+
+// Seal the session token with the current key.
+func (k *AEADKeyring) Seal(sessionTokenBytes []byte) ([]byte, error) {
+	aead := k.aeads[k.currentKeyId]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(sessionTokenBytes)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nonce, nonce, sessionTokenBytes, []byte(k.currentKeyId))
+	return []byte(k.currentKeyId + "." + base64.RawURLEncoding.EncodeToString(sealed)), nil
+}
+
+// Open a session token sealed with any key of the keyring.
+func (k *AEADKeyring) Open(sealedSessionTokenBytes []byte) ([]byte, error) {
+	keyId, encoded, ok := bytes.Cut(sealedSessionTokenBytes, []byte("."))
+	if !ok {
+		return nil, fmt.Errorf("%w: missing key id", ErrSessionTokenDecryption)
+	}
+
+	aead, ok := k.aeads[string(keyId)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrSessionTokenDecryption, keyId)
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(string(encoded))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed ciphertext", ErrSessionTokenDecryption)
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	sessionTokenBytes, err := aead.Open(nil, nonce, ciphertext, keyId)
+	if err != nil {
+		return nil, ErrSessionTokenDecryption
+	}
+
+	return sessionTokenBytes, nil
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SignedSessionToken is a session token with its optional signature.
+type SignedSessionToken struct {
+	SessionToken
+	// The id of the key used to sign the token, empty if not signed.
+	KeyId string `json:"kid,omitempty"`
+	// The signature of the token, empty if not signed.
+	Signature []byte `json:"sig,omitempty"`
+}
+
+// SessionTokenCodec encodes session tokens in a given format.
+type SessionTokenCodec interface {
+	// Encode a session token with its signature.
+	Encode(signedSessionToken SignedSessionToken) ([]byte, error)
+	// Decode a session token encoded with Encode.
+	Decode(data []byte) (SignedSessionToken, error)
+	// Return the bytes covered by the signature of the session token.
+	Payload(sessionToken SessionToken) ([]byte, error)
+}
+
+// SessionTokenCodecRegistry marshalls session tokens with versioned codecs. A
+// token is the version of its codec, a dot, and the data encoded by the codec.
+// Tokens are marshalled with the default codec and unmarshalled with the codec
+// of their version, so new formats can be rolled out while the tokens issued
+// with the previous ones are still accepted. Tokens in the legacy JSON form,
+// which has no version, are unmarshalled with the JSONSessionTokenCodec.
+type SessionTokenCodecRegistry struct {
+	// The mutex that protects the codecs.
+	mu sync.RWMutex
+	// The codecs by version.
+	codecs map[string]SessionTokenCodec
+	// The version of the codec used to marshall.
+	defaultVersion string
+}
+
+// The version of the CompactSessionTokenCodec in the registries created by
+// NewSessionTokenCodecRegistry.
+const CompactSessionTokenVersion = "1"
+
+// DefaultSessionTokenCodecs is the registry used by MarshallSessionToken and
+// UnmarshallSessionToken.
+var DefaultSessionTokenCodecs = NewSessionTokenCodecRegistry()
+
+// NewSessionTokenCodecRegistry creates a new registry with the
+// CompactSessionTokenCodec registered as CompactSessionTokenVersion and used
+// as default.
+func NewSessionTokenCodecRegistry() *SessionTokenCodecRegistry {
+	return &SessionTokenCodecRegistry{
+		codecs:         map[string]SessionTokenCodec{CompactSessionTokenVersion: CompactSessionTokenCodec{}},
+		defaultVersion: CompactSessionTokenVersion,
+	}
+}
+
+// Register a codec with the given version. Versions must be non-empty, made of
+// ASCII letters and digits, and cannot be registered twice.
+func (r *SessionTokenCodecRegistry) Register(version string, codec SessionTokenCodec) error {
+	if version == "" || strings.IndexFunc(version, func(c rune) bool {
+		return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+	}) != -1 {
+		return fmt.Errorf("%w: invalid session token version %q", ErrErmes, version)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.codecs[version]; ok {
+		return fmt.Errorf("%w: session token version %q already registered", ErrErmes, version)
+	}
+
+	r.codecs[version] = codec
+	return nil
+}
+
+// Set the version of the codec used to marshall.
+// errors:
+// - ErrUnknownSessionTokenVersion: If no codec is registered for the version.
+func (r *SessionTokenCodecRegistry) SetDefault(version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.codecs[version]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownSessionTokenVersion, version)
+	}
+
+	r.defaultVersion = version
+	return nil
+}
+
+// Marshall a session token with the default codec. If signer is not nil, the
+// token is signed.
+func (r *SessionTokenCodecRegistry) Marshall(sessionToken SessionToken, signer TokenSigner) ([]byte, error) {
+	r.mu.RLock()
+	version, codec := r.defaultVersion, r.codecs[r.defaultVersion]
+	r.mu.RUnlock()
+
+	signed, err := sign(codec, sessionToken, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := codec.Encode(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(version+"."), data...), nil
+}
+
+// Unmarshall a session token with the codec of its version. Returns nil if
+// there is no token. If verifier is not nil, the token must be signed and its
+// signature is verified.
+// errors:
+// - ErrInvalidSessionTokenSignature: If the token is not signed or the
+// signature does not match.
+// - ErrUnknownSessionTokenVersion: If no codec is registered for the version of
+// the token.
+func (r *SessionTokenCodecRegistry) Unmarshall(sessionTokenBytes []byte, verifier TokenVerifier) (*SessionToken, error) {
+	if len(sessionTokenBytes) == 0 {
+		return nil, nil
+	}
+
+	var codec SessionTokenCodec = JSONSessionTokenCodec{}
+	data := sessionTokenBytes
+
+	// The legacy JSON form has no version.
+	if sessionTokenBytes[0] != '{' {
+		version, versionData, ok := bytes.Cut(sessionTokenBytes, []byte("."))
+		if !ok {
+			return nil, fmt.Errorf("%w: missing version", ErrUnknownSessionTokenVersion)
+		}
+
+		r.mu.RLock()
+		codec, ok = r.codecs[string(version)]
+		r.mu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownSessionTokenVersion, version)
+		}
+
+		data = versionData
+	}
+
+	signed, err := codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if verifier != nil {
+		if err := verify(codec, signed, verifier); err != nil {
+			return nil, err
+		}
+	}
+
+	return &signed.SessionToken, nil
+}
+
+// Sign the session token, if signer is not nil.
+func sign(codec SessionTokenCodec, sessionToken SessionToken, signer TokenSigner) (SignedSessionToken, error) {
+	signed := SignedSessionToken{SessionToken: sessionToken}
+	if signer == nil {
+		return signed, nil
+	}
+
+	payload, err := codec.Payload(sessionToken)
+	if err != nil {
+		return SignedSessionToken{}, err
+	}
+
+	signed.KeyId, signed.Signature, err = signer.Sign(payload)
+	return signed, err
+}
+
+// Verify the signature of the session token.
+func verify(codec SessionTokenCodec, signed SignedSessionToken, verifier TokenVerifier) error {
+	if len(signed.Signature) == 0 {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSessionTokenSignature)
+	}
+
+	payload, err := codec.Payload(signed.SessionToken)
+	if err != nil {
+		return err
+	}
+
+	return verifier.Verify(payload, signed.KeyId, signed.Signature)
+}
+
+// CompactSessionTokenCodec encodes session tokens as the base64url (without
+// padding) encoding of the length-prefixed host, session id, key id and
+// signature. The signature covers the host and the session id.
+type CompactSessionTokenCodec struct{}
+
+// Assert that CompactSessionTokenCodec implements SessionTokenCodec.
+var _ SessionTokenCodec = CompactSessionTokenCodec{}
+
+// Encode a session token with its signature.
+func (CompactSessionTokenCodec) Encode(signed SignedSessionToken) ([]byte, error) {
+	var buf []byte
+	for _, field := range [][]byte{
+		[]byte(signed.Host),
+		[]byte(signed.SessionId),
+		[]byte(signed.KeyId),
+		signed.Signature,
+	} {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+
+	data := make([]byte, base64.RawURLEncoding.EncodedLen(len(buf)))
+	base64.RawURLEncoding.Encode(data, buf)
+	return data, nil
+}
+
+// Decode a session token encoded with Encode.
+func (CompactSessionTokenCodec) Decode(data []byte) (SignedSessionToken, error) {
+	buf := make([]byte, base64.RawURLEncoding.DecodedLen(len(data)))
+	n, err := base64.RawURLEncoding.Decode(buf, data)
+	if err != nil {
+		return SignedSessionToken{}, fmt.Errorf("%w: malformed session token: %v", ErrErmes, err)
+	}
+	buf = buf[:n]
+
+	var fields [4][]byte
+	for i := range fields {
+		length, read := binary.Uvarint(buf)
+		if read <= 0 || uint64(len(buf)-read) < length {
+			return SignedSessionToken{}, fmt.Errorf("%w: malformed session token", ErrErmes)
+		}
+
+		fields[i], buf = buf[read:read+int(length)], buf[read+int(length):]
+	}
+
+	if len(buf) != 0 {
+		return SignedSessionToken{}, fmt.Errorf("%w: malformed session token", ErrErmes)
+	}
+
+	signed := SignedSessionToken{
+		SessionToken: NewSessionToken(NewSessionLocation(string(fields[0]), string(fields[1]))),
+		KeyId:        string(fields[2]),
+	}
+	if len(fields[3]) != 0 {
+		signed.Signature = fields[3]
+	}
+
+	return signed, nil
+}
+
+// Return the bytes covered by the signature of the session token.
+func (CompactSessionTokenCodec) Payload(sessionToken SessionToken) ([]byte, error) {
+	return CompactSessionTokenCodec{}.Encode(SignedSessionToken{SessionToken: sessionToken})
+}
+
+// JSONSessionTokenCodec encodes session tokens as JSON objects, the legacy
+// form of the tokens. The signature covers the JSON encoding of the token.
+type JSONSessionTokenCodec struct{}
+
+// Assert that JSONSessionTokenCodec implements SessionTokenCodec.
+var _ SessionTokenCodec = JSONSessionTokenCodec{}
+
+// Encode a session token with its signature.
+func (JSONSessionTokenCodec) Encode(signed SignedSessionToken) ([]byte, error) {
+	return json.Marshal(signed)
+}
+
+// Decode a session token encoded with Encode.
+func (JSONSessionTokenCodec) Decode(data []byte) (SignedSessionToken, error) {
+	var signed SignedSessionToken
+	err := json.Unmarshal(data, &signed)
+	return signed, err
+}
+
+// Return the bytes covered by the signature of the session token.
+func (JSONSessionTokenCodec) Payload(sessionToken SessionToken) ([]byte, error) {
+	return json.Marshal(sessionToken)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// TokenSigner signs session tokens.
+type TokenSigner interface {
+	// Sign the payload of a session token. Returns the id of the key used, so
+	// that the verifier can select the key to verify the signature with.
+	Sign(payload []byte) (keyId string, signature []byte, err error)
+}
+
+// TokenVerifier verifies the signature of session tokens.
+type TokenVerifier interface {
+	// Verify the signature of the payload of a session token.
+	// errors:
+	// - ErrInvalidSessionTokenSignature: If the key is unknown or the signature
+	// does not match.
+	Verify(payload []byte, keyId string, signature []byte) error
+}
+
+// HMACKeyring signs and verifies session tokens with HMAC-SHA256. Tokens are
+// signed with the current key and verified with any key of the keyring, so
+// keys can be rotated by adding the new key to every node, switching the
+// current key, and removing the old key once the tokens signed with it expired.
+type HMACKeyring struct {
+	// The id of the key used to sign.
+	currentKeyId string
+	// The keys by id.
+	keys map[string][]byte
+}
+
+// Assert that HMACKeyring implements TokenSigner and TokenVerifier.
+var _ TokenSigner = (*HMACKeyring)(nil)
+var _ TokenVerifier = (*HMACKeyring)(nil)
+
+// NewHMACKeyring creates a new HMACKeyring that signs with the key
+// currentKeyId. The keys are copied.
+func NewHMACKeyring(currentKeyId string, keys map[string][]byte) (*HMACKeyring, error) {
+	if _, ok := keys[currentKeyId]; !ok {
+		return nil, fmt.Errorf("%w: unknown current key %q", ErrErmes, currentKeyId)
+	}
+
+	copied := make(map[string][]byte, len(keys))
+	for keyId, key := range keys {
+		copied[keyId] = append([]byte(nil), key...)
+	}
+
+	return &HMACKeyring{
+		currentKeyId: currentKeyId,
+		keys:         copied,
+	}, nil
+}
+
+// Sign the payload with the current key.
+func (k *HMACKeyring) Sign(payload []byte) (string, []byte, error) {
+	return k.currentKeyId, hmacSHA256(k.keys[k.currentKeyId], payload), nil
+}
+
+// Verify the signature of the payload with the key keyId.
+func (k *HMACKeyring) Verify(payload []byte, keyId string, signature []byte) error {
+	key, ok := k.keys[keyId]
+	if !ok {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidSessionTokenSignature, keyId)
+	}
+
+	if !hmac.Equal(signature, hmacSHA256(key, payload)) {
+		return ErrInvalidSessionTokenSignature
+	}
+
+	return nil
+}
+
+// Compute the HMAC-SHA256 of the payload.
+func hmacSHA256(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Ed25519Signer signs session tokens with an Ed25519 private key. The tokens
+// can be verified by an Ed25519Verifier that only knows the public key, so the
+// nodes that verify the tokens do not need to share a secret.
+type Ed25519Signer struct {
+	// The id of the key.
+	keyId string
+	// The private key.
+	privateKey ed25519.PrivateKey
+}
+
+// Assert that Ed25519Signer implements TokenSigner.
+var _ TokenSigner = (*Ed25519Signer)(nil)
+
+// NewEd25519Signer creates a new Ed25519Signer.
+func NewEd25519Signer(keyId string, privateKey ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{
+		keyId:      keyId,
+		privateKey: privateKey,
+	}
+}
+
+// Sign the payload with the private key.
+func (s *Ed25519Signer) Sign(payload []byte) (string, []byte, error) {
+	return s.keyId, ed25519.Sign(s.privateKey, payload), nil
+}
+
+// Ed25519Verifier verifies session tokens with a set of Ed25519 public keys.
+// Keys are rotated by adding the public key of the new signer before it is
+// used, and removing the old one once the tokens signed with it expired.
+type Ed25519Verifier struct {
+	// The public keys by id.
+	publicKeys map[string]ed25519.PublicKey
+}
+
+// Assert that Ed25519Verifier implements TokenVerifier.
+var _ TokenVerifier = (*Ed25519Verifier)(nil)
+
+// NewEd25519Verifier creates a new Ed25519Verifier. The map is copied.
+func NewEd25519Verifier(publicKeys map[string]ed25519.PublicKey) *Ed25519Verifier {
+	copied := make(map[string]ed25519.PublicKey, len(publicKeys))
+	for keyId, publicKey := range publicKeys {
+		copied[keyId] = publicKey
+	}
+
+	return &Ed25519Verifier{publicKeys: copied}
+}
+
+// Verify the signature of the payload with the public key keyId.
+func (v *Ed25519Verifier) Verify(payload []byte, keyId string, signature []byte) error {
+	publicKey, ok := v.publicKeys[keyId]
+	if !ok {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidSessionTokenSignature, keyId)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, payload, signature) {
+		return ErrInvalidSessionTokenSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package api_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+func newKeyring(t *testing.T, currentKeyId string) *api.HMACKeyring {
+	keyring, err := api.NewHMACKeyring(currentKeyId, map[string][]byte{
+		"k1": []byte("first secret"),
+		"k2": []byte("second secret"),
+	})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return keyring
+}
+
+// Decode a compact token, modify it and encode it again.
+func tamper(t *testing.T, tokenBytes []byte, modify func(signed *api.SignedSessionToken)) []byte {
+	codec := api.CompactSessionTokenCodec{}
+	data, ok := bytes.CutPrefix(tokenBytes, []byte(api.CompactSessionTokenVersion+"."))
+	if !ok {
+		t.Fatalf("Expected a compact token, got %s", tokenBytes)
+	}
+
+	signed, err := codec.Decode(data)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	modify(&signed)
+	data, _ = codec.Encode(signed)
+	return append([]byte(api.CompactSessionTokenVersion+"."), data...)
+}
+
+func TestSignedSessionToken(t *testing.T) {
+	keyring := newKeyring(t, "k1")
+	token := api.NewSessionToken(api.NewSessionLocation("n1", "s1"))
+
+	tokenBytes, err := api.MarshallSessionToken(token, keyring)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	decoded, err := api.UnmarshallSessionToken(tokenBytes, keyring)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	} else if *decoded != token {
+		t.Errorf("Expected %v, got %v", token, *decoded)
+	}
+
+	// A token signed with the previous key is accepted after the rotation.
+	decoded, err = api.UnmarshallSessionToken(tokenBytes, newKeyring(t, "k2"))
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	} else if *decoded != token {
+		t.Errorf("Expected %v, got %v", token, *decoded)
+	}
+}
+
+func TestForgedSessionToken(t *testing.T) {
+	keyring := newKeyring(t, "k1")
+	token := api.NewSessionToken(api.NewSessionLocation("n1", "s1"))
+
+	tokenBytes, err := api.MarshallSessionToken(token, keyring)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	unsigned, _ := api.MarshallSessionToken(token, nil)
+	otherKeyring, _ := api.NewHMACKeyring("k1", map[string][]byte{"k1": []byte("other secret")})
+	otherSigned, _ := api.MarshallSessionToken(token, otherKeyring)
+
+	forged := map[string][]byte{
+		"tampered host": tamper(t, tokenBytes, func(signed *api.SignedSessionToken) { signed.Host = "n2" }),
+		"unsigned":      unsigned,
+		"unknown key":   tamper(t, tokenBytes, func(signed *api.SignedSessionToken) { signed.KeyId = "k3" }),
+		"other secret":  otherSigned,
+	}
+
+	for name, tokenBytes := range forged {
+		_, err := api.UnmarshallSessionToken(tokenBytes, keyring)
+		if !errors.Is(err, api.ErrInvalidSessionTokenSignature) {
+			t.Errorf("%s: Expected error %v, got %v", name, api.ErrInvalidSessionTokenSignature, err)
+		}
+	}
+}
+
+func TestEd25519SessionToken(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	signer := api.NewEd25519Signer("ed1", privateKey)
+	verifier := api.NewEd25519Verifier(map[string]ed25519.PublicKey{"ed1": publicKey})
+	token := api.NewSessionToken(api.NewSessionLocation("n1", "s1"))
+
+	tokenBytes, err := api.MarshallSessionToken(token, signer)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	decoded, err := api.UnmarshallSessionToken(tokenBytes, verifier)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	} else if *decoded != token {
+		t.Errorf("Expected %v, got %v", token, *decoded)
+	}
+
+	tampered := tamper(t, tokenBytes, func(signed *api.SignedSessionToken) { signed.SessionId = "s2" })
+	_, err = api.UnmarshallSessionToken(tampered, verifier)
+	if !errors.Is(err, api.ErrInvalidSessionTokenSignature) {
+		t.Errorf("Expected error %v, got %v", api.ErrInvalidSessionTokenSignature, err)
+	}
+}
+
+func TestEncryptedSessionToken(t *testing.T) {
+	keys := map[string][]byte{
+		"v1": bytes.Repeat([]byte{1}, 32),
+		"v2": bytes.Repeat([]byte{2}, 32),
+	}
+	v1, err := api.NewAEADKeyring("v1", keys)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	v2, _ := api.NewAEADKeyring("v2", keys)
+
+	tokenBytes, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("edge1.internal", "s1")), nil)
+	sealed, err := v1.Seal(tokenBytes)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if bytes.Contains(sealed, []byte("edge1.internal")) || !bytes.HasPrefix(sealed, []byte("v1.")) {
+		t.Errorf("Expected an opaque token with key id v1, got %s", sealed)
+	}
+
+	// A token sealed with the previous key is opened after the rotation.
+	opened, err := v2.Open(sealed)
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	} else if !bytes.Equal(opened, tokenBytes) {
+		t.Errorf("Expected %s, got %s", tokenBytes, opened)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	invalid := map[string][]byte{
+		"tampered":    tampered,
+		"swapped key": append([]byte("v2"), sealed[2:]...),
+		"unknown key": append([]byte("v3"), sealed[2:]...),
+		"no key id":   sealed[3:],
+	}
+
+	for name, sealed := range invalid {
+		_, err := v1.Open(sealed)
+		if !errors.Is(err, api.ErrSessionTokenDecryption) {
+			t.Errorf("%s: Expected error %v, got %v", name, api.ErrSessionTokenDecryption, err)
+		}
+	}
+}
+
+func TestSessionTokenVersions(t *testing.T) {
+	keyring := newKeyring(t, "k1")
+	token := api.NewSessionToken(api.NewSessionLocation("n1", "s1"))
+
+	// The legacy JSON form, signed or not, is still accepted.
+	legacy := []byte(`{"host":"n1","sessionId":"s1"}`)
+	decoded, err := api.UnmarshallSessionToken(legacy, nil)
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	} else if *decoded != token {
+		t.Errorf("Expected %v, got %v", token, *decoded)
+	}
+
+	payload, _ := api.JSONSessionTokenCodec{}.Payload(token)
+	keyId, signature, _ := keyring.Sign(payload)
+	signed, _ := api.JSONSessionTokenCodec{}.Encode(api.SignedSessionToken{
+		SessionToken: token,
+		KeyId:        keyId,
+		Signature:    signature,
+	})
+	if _, err := api.UnmarshallSessionToken(signed, keyring); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	// The compact form is the default.
+	compact, _ := api.MarshallSessionToken(token, nil)
+	if !bytes.HasPrefix(compact, []byte(api.CompactSessionTokenVersion+".")) || len(compact) >= len(legacy) {
+		t.Errorf("Expected a compact token, got %s", compact)
+	}
+
+	_, err = api.UnmarshallSessionToken([]byte("9.AAAA"), nil)
+	if !errors.Is(err, api.ErrUnknownSessionTokenVersion) {
+		t.Errorf("Expected error %v, got %v", api.ErrUnknownSessionTokenVersion, err)
+	}
+
+	// A registered codec can become the default.
+	registry := api.NewSessionTokenCodecRegistry()
+	if err := registry.Register("json", api.JSONSessionTokenCodec{}); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if err := registry.Register("json", api.JSONSessionTokenCodec{}); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if err := registry.SetDefault("json"); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	tokenBytes, err := registry.Marshall(token, keyring)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	} else if !bytes.HasPrefix(tokenBytes, []byte("json.{")) {
+		t.Errorf("Expected a json token, got %s", tokenBytes)
+	}
+
+	decoded, err = registry.Unmarshall(tokenBytes, keyring)
+	if err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	} else if *decoded != token {
+		t.Errorf("Expected %v, got %v", token, *decoded)
+	}
+}
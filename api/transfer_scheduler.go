@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// The priority class of a transfer, the transfers of higher priority start and
+// consume the bandwidth before the ones of lower priority.
+type TransferPriority int
+
+const (
+	TransferPriorityLow TransferPriority = iota
+	TransferPriorityNormal
+	TransferPriorityHigh
+)
+
+// The number of priority classes.
+const transferPriorities = int(TransferPriorityHigh) + 1
+
+// Schedules the offload transfers of a node, limiting the concurrent transfers
+// to each target node and the bandwidth of the session streams. A nil
+// scheduler does not limit the transfers.
+type TransferScheduler struct {
+	opt TransferSchedulerOptions
+	mu  sync.Mutex
+	// The number of active transfers by target host.
+	active map[string]int
+	// The transfers waiting to start, in order of arrival.
+	waiting []*transferSlot
+	// The bytes available to the session streams, refilled at the rate of the
+	// bandwidth up to one second of transfer.
+	tokens   float64
+	refilled time.Time
+	// The number of streams waiting for bandwidth, by priority.
+	throttled [transferPriorities]int
+}
+
+// A transfer waiting to start.
+type transferSlot struct {
+	host     string
+	priority TransferPriority
+	ready    chan struct{}
+	granted  bool
+}
+
+// Create a new TransferScheduler.
+func NewTransferScheduler(opt TransferSchedulerOptions) *TransferScheduler {
+	return &TransferScheduler{
+		opt:      opt,
+		active:   map[string]int{},
+		tokens:   float64(opt.bytesPerSecond),
+		refilled: time.Now(),
+	}
+}
+
+// Clamp a priority to the known classes.
+func (p TransferPriority) class() int {
+	return min(max(int(p), 0), transferPriorities-1)
+}
+
+// Wait for a transfer to the given host to start, the returned function must
+// be called once the transfer ends. The waiting transfers start in order of
+// priority, then of arrival.
+//
+// errors:
+// - The error of the context if it is done before the transfer starts.
+func (s *TransferScheduler) Acquire(ctx context.Context, host string, priority TransferPriority) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	slot := &transferSlot{host: host, priority: priority, ready: make(chan struct{})}
+
+	s.mu.Lock()
+	s.waiting = append(s.waiting, slot)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.active[host]--
+		if s.active[host] == 0 {
+			delete(s.active, host)
+		}
+		s.dispatch()
+	}
+
+	select {
+	case <-slot.ready:
+		return sync.OnceFunc(release), nil
+	case <-ctx.Done():
+	}
+
+	// The transfer may have started concurrently.
+	s.mu.Lock()
+	granted := slot.granted
+	if !granted {
+		s.remove(slot)
+	}
+	s.mu.Unlock()
+
+	if granted {
+		release()
+	}
+
+	return nil, ctx.Err()
+}
+
+// Start the waiting transfers whose target has a free slot, in order of
+// priority. Must be called with the lock held.
+func (s *TransferScheduler) dispatch() {
+	for class := transferPriorities - 1; class >= 0; class-- {
+		for i := 0; i < len(s.waiting); i++ {
+			slot := s.waiting[i]
+			if slot.priority.class() != class {
+				continue
+			}
+
+			if s.opt.maxTransfersPerTarget > 0 && s.active[slot.host] >= s.opt.maxTransfersPerTarget {
+				continue
+			}
+
+			s.active[slot.host]++
+			slot.granted = true
+			close(slot.ready)
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			i--
+		}
+	}
+}
+
+// Remove a waiting transfer. Must be called with the lock held.
+func (s *TransferScheduler) remove(slot *transferSlot) {
+	for i, waiting := range s.waiting {
+		if waiting == slot {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// Wait until n bytes of bandwidth are available to a stream of the given
+// priority, n must not exceed the bytes per second.
+func (s *TransferScheduler) wait(ctx context.Context, priority TransferPriority, n int) error {
+	class := priority.class()
+	rate := float64(s.opt.bytesPerSecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		// Refill the bytes available since the last refill.
+		now := time.Now()
+		s.tokens = min(rate, s.tokens+now.Sub(s.refilled).Seconds()*rate)
+		s.refilled = now
+
+		// The streams of higher priority are served first.
+		preempted := false
+		for higher := class + 1; higher < transferPriorities; higher++ {
+			preempted = preempted || s.throttled[higher] > 0
+		}
+
+		if !preempted && s.tokens >= float64(n) {
+			s.tokens -= float64(n)
+			return nil
+		}
+
+		delay := time.Millisecond
+		if !preempted {
+			delay = max(delay, time.Duration((float64(n)-s.tokens)/rate*float64(time.Second)))
+		}
+
+		s.throttled[class]++
+		s.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+
+		s.mu.Lock()
+		s.throttled[class]--
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Wrap a session stream so that it is read within the bandwidth of the
+// scheduler. The reads fail with the error of the context if it is done while
+// waiting for bandwidth.
+func (s *TransferScheduler) Throttle(ctx context.Context, reader io.ReadCloser, priority TransferPriority) io.ReadCloser {
+	if s == nil || s.opt.bytesPerSecond <= 0 {
+		return reader
+	}
+
+	return &throttledReader{ReadCloser: reader, ctx: ctx, scheduler: s, priority: priority}
+}
+
+// A session stream read within the bandwidth of a scheduler.
+type throttledReader struct {
+	io.ReadCloser
+	ctx       context.Context
+	scheduler *TransferScheduler
+	priority  TransferPriority
+}
+
+// Read at most one second of transfer, once the bandwidth is available.
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.scheduler.opt.bytesPerSecond {
+		p = p[:r.scheduler.opt.bytesPerSecond]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		// The bytes are accounted once read, since the stream may be shorter.
+		if waitErr := r.scheduler.wait(r.ctx, r.priority, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,60 @@
+package api
+
+// Options of the scheduler of the offload transfers.
+type TransferSchedulerOptions struct {
+	// The maximum number of bytes per second of the session streams, shared by
+	// all the transfers.
+	bytesPerSecond int64
+	// The maximum number of concurrent transfers to the same target node.
+	maxTransfersPerTarget int
+}
+
+// Get the maximum number of bytes per second of the session streams, 0 if the
+// bandwidth is not limited.
+func (o TransferSchedulerOptions) BytesPerSecond() int64 {
+	return o.bytesPerSecond
+}
+
+// Get the maximum number of concurrent transfers to the same target node, 0 if
+// the transfers are not limited.
+func (o TransferSchedulerOptions) MaxTransfersPerTarget() int {
+	return o.maxTransfersPerTarget
+}
+
+// Builder for TransferSchedulerOptions.
+type TransferSchedulerOptionsBuilder struct {
+	options TransferSchedulerOptions
+}
+
+// Create a new TransferSchedulerOptionsBuilder.
+func NewTransferSchedulerOptionsBuilder() *TransferSchedulerOptionsBuilder {
+	return &TransferSchedulerOptionsBuilder{
+		options: DefaultTransferSchedulerOptions(),
+	}
+}
+
+// Set the maximum number of bytes per second of the session streams.
+func (builder *TransferSchedulerOptionsBuilder) BytesPerSecond(bytesPerSecond int64) *TransferSchedulerOptionsBuilder {
+	builder.options.bytesPerSecond = bytesPerSecond
+	return builder
+}
+
+// Set the maximum number of concurrent transfers to the same target node.
+func (builder *TransferSchedulerOptionsBuilder) MaxTransfersPerTarget(maxTransfersPerTarget int) *TransferSchedulerOptionsBuilder {
+	builder.options.maxTransfersPerTarget = maxTransfersPerTarget
+	return builder
+}
+
+// Build the TransferSchedulerOptions.
+func (builder *TransferSchedulerOptionsBuilder) Build() TransferSchedulerOptions {
+	return builder.options
+}
+
+// DefaultTransferSchedulerOptions returns the default options of the scheduler,
+// that does not limit the transfers.
+func DefaultTransferSchedulerOptions() TransferSchedulerOptions {
+	return TransferSchedulerOptions{
+		bytesPerSecond:        0,
+		maxTransfersPerTarget: 0,
+	}
+}
@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+func TestTransferSchedulerLimitsTransfersPerTarget(t *testing.T) {
+	ctx := context.Background()
+	scheduler := api.NewTransferScheduler(api.NewTransferSchedulerOptionsBuilder().MaxTransfersPerTarget(1).Build())
+
+	release, err := scheduler.Acquire(ctx, "edge1", api.TransferPriorityNormal)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The transfers to other targets are not limited.
+	other, err := scheduler.Acquire(ctx, "edge2", api.TransferPriorityLow)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	other()
+
+	// A transfer to the same target waits until the context is done.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := scheduler.Acquire(timeoutCtx, "edge1", api.TransferPriorityHigh); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// The waiting transfers start in order of priority.
+	var mu sync.Mutex
+	var started []api.TransferPriority
+	var wg sync.WaitGroup
+	for _, priority := range []api.TransferPriority{api.TransferPriorityLow, api.TransferPriorityHigh} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := scheduler.Acquire(ctx, "edge1", priority)
+			if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+				return
+			}
+
+			mu.Lock()
+			started = append(started, priority)
+			mu.Unlock()
+			release()
+		}()
+
+		// Wait for the transfer to be queued.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	release()
+	wg.Wait()
+
+	if len(started) != 2 || started[0] != api.TransferPriorityHigh {
+		t.Errorf("Expected the high priority transfer to start first, got %v", started)
+	}
+}
+
+func TestTransferSchedulerThrottlesStreams(t *testing.T) {
+	ctx := context.Background()
+	scheduler := api.NewTransferScheduler(api.NewTransferSchedulerOptionsBuilder().BytesPerSecond(10000).Build())
+
+	// One second of transfer is available at once, the rest at the rate of the
+	// bandwidth.
+	start := time.Now()
+	reader := scheduler.Throttle(ctx, io.NopCloser(bytes.NewReader(make([]byte, 15000))), api.TransferPriorityNormal)
+	n, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if n != 15000 {
+		t.Errorf("Expected 15000 bytes, got %d", n)
+	}
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the stream to be throttled, read in %v", elapsed)
+	}
+
+	// A throttled read fails once the context is done.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	reader = scheduler.Throttle(cancelCtx, io.NopCloser(bytes.NewReader(make([]byte, 15000))), api.TransferPriorityNormal)
+	if _, err := io.Copy(io.Discard, reader); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"sync"
+)
+
+// Token is the session token of a logical session, as last issued by the
+// nodes, with the host of the node that issued it.
+type Token struct {
+	// The bytes of the session token, opaque to the client.
+	Bytes []byte
+	// The host of the last node known to hold the session, empty if unknown.
+	Host string
+}
+
+// TokenStore stores the session tokens of the logical sessions of a client, by
+// the key returned by StoreKey.
+type TokenStore interface {
+	// Get the token of the session, false if there is none.
+	Get(session string) (Token, bool)
+	// Set the token of the session.
+	Set(session string, token Token)
+	// Delete the token of the session.
+	Delete(session string)
+}
+
+// StoreKey returns the key of the token of a logical session issued through the
+// given origin, the host the requests of the session are addressed to.
+func StoreKey(session string, origin string) string {
+	return session + "@" + origin
+}
+
+// MemoryTokenStore is a TokenStore that keeps the tokens in memory.
+type MemoryTokenStore struct {
+	// The mutex that protects the tokens.
+	mu sync.RWMutex
+	// The tokens by session.
+	tokens map[string]Token
+}
+
+// Assert that MemoryTokenStore implements TokenStore.
+var _ TokenStore = (*MemoryTokenStore)(nil)
+
+// NewMemoryTokenStore creates a new, empty, MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]Token{}}
+}
+
+// Get the token of the session, false if there is none.
+func (s *MemoryTokenStore) Get(session string) (Token, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[session]
+	return token, ok
+}
+
+// Set the token of the session.
+func (s *MemoryTokenStore) Set(session string, token Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[session] = token
+}
+
+// Delete the token of the session.
+func (s *MemoryTokenStore) Delete(session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, session)
+}
+
+// The key of the logical session in the context of the requests.
+type sessionContextKey struct{}
+
+// WithSession returns a copy of the context that makes the requests made with
+// it use the token of the given logical session. Requests without a session
+// share the default session, "".
+func WithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// SessionFromContext returns the logical session of the context, "" if none.
+func SessionFromContext(ctx context.Context) string {
+	session, _ := ctx.Value(sessionContextKey{}).(string)
+	return session
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ermes-labs/api-go/api"
+	ermes_http "github.com/ermes-labs/api-go/http"
+)
+
+// DefaultMaxRedirects is the default maximum number of redirects followed by a
+// Transport for a single request.
+const DefaultMaxRedirects = 10
+
+// DefaultMaxBodySize is the default maximum size of the request bodies that a
+// Transport buffers to replay them on redirects.
+const DefaultMaxBodySize = 10 << 20
+
+// The headers that are not sent to the hosts outside of the session, see
+// RoundTrip.
+var sensitiveHeaderNames = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// Transport is the client counterpart of http.Handle: an http.RoundTripper that
+// sends the session token of the logical session of each request (see
+// WithSession), stores the tokens issued by the nodes, follows the redirects to
+// the node that holds the session replaying the method and the body of the
+// request, and sends the next requests directly to the last known node. The
+// tokens are kept by logical session and by origin, the host the requests are
+// addressed to, see StoreKey: only the requests to the origin of a token carry
+// it and are sent to its node, so requests to unrelated hosts are untouched.
+// The redirects are followed with the token only if they issue a new token, or
+// if they lead to the origin or to the last known node of the session; the
+// other redirects to another host are followed without the token and without
+// the Authorization, Proxy-Authorization and Cookie headers.
+type Transport struct {
+	// The transport used to make the requests, http.DefaultTransport if nil.
+	Base http.RoundTripper
+	// The store of the session tokens, keyed by StoreKey.
+	Store TokenStore
+	// The header that carries the session token, if empty
+	// ermes_http.DefaultTokenHeaderName.
+	HeaderName string
+	// The maximum number of redirects followed for a single request, if 0
+	// DefaultMaxRedirects.
+	MaxRedirects int
+	// The maximum size of a request body that cannot be read again, as the
+	// ones without GetBody, buffered to replay it on redirects, if 0
+	// DefaultMaxBodySize. Larger bodies fail the request.
+	MaxBodySize int64
+}
+
+// Assert that Transport implements http.RoundTripper.
+var _ http.RoundTripper = (*Transport)(nil)
+
+// NewTransport creates a new Transport that stores the tokens in memory.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:  base,
+		Store: NewMemoryTokenStore(),
+	}
+}
+
+// NewClient creates a new http.Client that uses a Transport that stores the
+// tokens in memory. The redirects are followed by the Transport.
+func NewClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: NewTransport(base)}
+}
+
+// RoundTrip makes the request on the node of its logical session.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := StoreKey(SessionFromContext(req.Context()), req.URL.Host)
+
+	// Buffer the body, if it cannot be read again, to replay it on redirects.
+	getBody := req.GetBody
+	if req.Body != nil && req.Body != http.NoBody && getBody == nil {
+		body, err := io.ReadAll(io.LimitReader(req.Body, t.maxBodySize()+1))
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(body)) > t.maxBodySize() {
+			return nil, fmt.Errorf("%w: request body larger than %d bytes", api.ErrErmes, t.maxBodySize())
+		}
+
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	target := req.URL
+	// True if the target is a node of the session.
+	trusted := true
+	// The nodes that redirected the request, see ermes_http.MaxForwardingHops.
+	forwardedBy := ""
+	for redirects := 0; ; redirects++ {
+		out, err := t.newRequest(req, key, target, trusted, getBody)
+		if err != nil {
+			return nil, err
+		}
+
+		if forwardedBy != "" {
+			out.Header.Set(ermes_http.ForwardedByHeaderName, forwardedBy)
+		}
+
+		res, err := t.base().RoundTrip(out)
+		if err != nil {
+			return nil, err
+		}
+
+		// Store the token issued by the node.
+		tokenBytes := res.Header.Get(t.headerName())
+		if tokenBytes != "" {
+			t.Store.Set(key, Token{Bytes: []byte(tokenBytes), Host: out.URL.Host})
+		}
+
+		location, follow := t.redirectLocation(res, tokenBytes != "")
+		if !follow {
+			return res, nil
+		}
+
+		if redirects >= t.maxRedirects() {
+			res.Body.Close()
+			return nil, fmt.Errorf("%w: stopped after %d redirects", api.ErrErmes, redirects)
+		}
+
+		if target, err = out.URL.Parse(location); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+
+		forwardedBy = res.Header.Get(ermes_http.ForwardedByHeaderName)
+
+		// The node of the session is the target of a redirect that issued a
+		// token, or that leads to a known node.
+		token, ok := t.Store.Get(key)
+		trusted = tokenBytes != "" || target.Host == req.URL.Host || (ok && target.Host == token.Host)
+		if ok && trusted {
+			token.Host = target.Host
+			t.Store.Set(key, token)
+		}
+
+		// Drain the body to reuse the connection.
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
+// Create the request to the target URL, on the last known node of the session
+// if the target is the URL of the original request. The session token and the
+// sensitive headers are sent only to the trusted targets.
+func (t *Transport) newRequest(req *http.Request, key string, target *url.URL, trusted bool, getBody func() (io.ReadCloser, error)) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	out.URL = target
+	out.Host = ""
+
+	if !trusted {
+		out.Header.Del(t.headerName())
+		for _, name := range sensitiveHeaderNames {
+			out.Header.Del(name)
+		}
+	}
+
+	token, ok := t.Store.Get(key)
+	if ok && trusted {
+		out.Header.Set(t.headerName(), string(token.Bytes))
+		if target == req.URL && token.Host != "" {
+			redirected := *target
+			redirected.Host = token.Host
+			out.URL = &redirected
+		}
+	}
+
+	if getBody != nil {
+		body, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body, out.GetBody = body, getBody
+	}
+
+	return out, nil
+}
+
+// Return the location of the redirect and true if the response is a redirect
+// to follow: a redirect that issues a session token, or one that preserves the
+// method and the body of the request.
+func (t *Transport) redirectLocation(res *http.Response, issuesToken bool) (string, bool) {
+	location := res.Header.Get("Location")
+	if location == "" {
+		return "", false
+	}
+
+	switch res.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return location, true
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		return location, issuesToken
+	default:
+		return "", false
+	}
+}
+
+// Return the base transport.
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+// Return the header that carries the session token.
+func (t *Transport) headerName() string {
+	if t.HeaderName == "" {
+		return ermes_http.DefaultTokenHeaderName
+	}
+
+	return t.HeaderName
+}
+
+// Return the maximum number of redirects.
+func (t *Transport) maxRedirects() int {
+	if t.MaxRedirects == 0 {
+		return DefaultMaxRedirects
+	}
+
+	return t.MaxRedirects
+}
+
+// Return the maximum size of the buffered request bodies.
+func (t *Transport) maxBodySize() int64 {
+	if t.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+
+	return t.MaxBodySize
+}
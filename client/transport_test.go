@@ -0,0 +1,242 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/client"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	ermes_http "github.com/ermes-labs/api-go/http"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Start a node reachable through a test server, whose host is the address of
+// the server. The handler echoes the method and the body of the requests.
+func newServer(t *testing.T, hits *atomic.Int64) (*api.Node, *httptest.Server) {
+	server := httptest.NewUnstartedServer(nil)
+	node := infrastructure.Node{AreaName: server.Listener.Addr().String(), Host: server.Listener.Addr().String()}
+	n := api.NewNode(node, memory_commands.NewCommands(node))
+	handler := ermes_http.CreateHandler(n, ermes_http.DefaultHandlerOptions(), func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Session-Id", sessionToken.SessionId)
+		_, err = w.Write([]byte(req.Method + " " + string(body)))
+		return err
+	})
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits.Add(1)
+		handler(w, req)
+	})
+	server.Start()
+	t.Cleanup(server.Close)
+	return n, server
+}
+
+func post(t *testing.T, c *http.Client, ctx context.Context, url string, body string) (string, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, io.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return res.Header.Get("X-Session-Id"), string(data)
+}
+
+func TestTransportFollowsOffloadedSession(t *testing.T) {
+	ctx := context.Background()
+	var hits1, hits2 atomic.Int64
+	n1, server1 := newServer(t, &hits1)
+	n2, _ := newServer(t, &hits2)
+	transport := client.NewTransport(nil)
+	c := &http.Client{Transport: transport}
+
+	// The first request creates the session on n1.
+	sessionId, body := post(t, c, ctx, server1.URL+"/path", "first")
+	if sessionId == "" || body != "POST first" {
+		t.Fatalf("Expected a new session and the echoed body, got %q and %q", sessionId, body)
+	}
+
+	// The session is offloaded to n2.
+	_, err := n1.OffloadSession(
+		ctx,
+		sessionId,
+		api.DefaultOffloadSessionOptions(),
+		api.LocalOffloadTarget{Node: n2},
+		func(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+			return false, nil
+		})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The redirect to n2 is followed with the method and the body.
+	redirectedSessionId, body := post(t, c, ctx, server1.URL+"/path", "second")
+	if redirectedSessionId != sessionId || body != "POST second" {
+		t.Fatalf("Expected the session %q and the echoed body, got %q and %q", sessionId, redirectedSessionId, body)
+	}
+
+	token, ok := transport.Store.Get(client.StoreKey("", server1.Listener.Addr().String()))
+	if !ok || token.Host != n2.Host {
+		t.Errorf("Expected a token for %s, got %v", n2.Host, token)
+	}
+
+	// The next requests go directly to n2.
+	hits1.Store(0)
+	if redirectedSessionId, _ = post(t, c, ctx, server1.URL+"/path", "third"); redirectedSessionId != sessionId || hits1.Load() != 0 || hits2.Load() != 2 {
+		t.Errorf("Expected the request to go directly to n2, got %d and %d hits", hits1.Load(), hits2.Load())
+	}
+
+	// Another logical session gets a new session.
+	otherSessionId, _ := post(t, c, client.WithSession(ctx, "other"), server1.URL+"/path", "")
+	if otherSessionId == "" || otherSessionId == sessionId {
+		t.Errorf("Expected a new session, got %q", otherSessionId)
+	}
+
+	// A request of the same session to an unrelated host reaches that host,
+	// without the token.
+	var unrelatedToken atomic.Value
+	unrelated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		unrelatedToken.Store(req.Header.Get(ermes_http.DefaultTokenHeaderName))
+	}))
+	defer unrelated.Close()
+
+	hits2.Store(0)
+	post(t, c, ctx, unrelated.URL+"/path", "")
+	if token, _ := unrelatedToken.Load().(string); unrelatedToken.Load() == nil || token != "" || hits2.Load() != 0 {
+		t.Errorf("Expected the request to reach the unrelated host without a token, got %q and %d hits on n2", token, hits2.Load())
+	}
+}
+
+func TestTransportStopsForwardingLoop(t *testing.T) {
+	ctx := context.Background()
+	var hits1, hits2, hits3 atomic.Int64
+	n1, server1 := newServer(t, &hits1)
+	n2, _ := newServer(t, &hits2)
+	n3, _ := newServer(t, &hits3)
+	c := &http.Client{Transport: client.NewTransport(nil)}
+
+	sessionId, _ := post(t, c, ctx, server1.URL+"/path", "first")
+
+	// The session moves to n3, then the tombstone of n2 points back to n1.
+	nodes := map[string]*api.Node{n1.Host: n1, n2.Host: n2}
+	location := api.NewSessionLocation(n1.Host, sessionId)
+	for _, target := range []*api.Node{n2, n3} {
+		var err error
+		location, err = nodes[location.Host].OffloadSession(
+			ctx,
+			location.SessionId,
+			api.DefaultOffloadSessionOptions(),
+			api.LocalOffloadTarget{Node: target},
+			func(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+				return false, nil
+			})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+	}
+
+	if _, err := n2.UpdateOffloadedSessionLocation(ctx, sessionId, api.NewSessionLocation(n1.Host, sessionId)); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The stale token bounces between n1 and n2 until n1 sees the loop.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server1.URL+"/path", nil)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusLoopDetected || hits1.Load() != 3 || hits2.Load() != 1 || hits3.Load() != 0 {
+		t.Errorf("Expected status %d after 3 hits on n1 and 1 on n2, got %d after %d and %d", http.StatusLoopDetected, res.StatusCode, hits1.Load(), hits2.Load())
+	}
+}
+
+func TestTransportRedirectsToOtherHosts(t *testing.T) {
+	ctx := context.Background()
+
+	// The origin redirects the requests to a host outside of the session.
+	var received http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+	}))
+	t.Cleanup(other.Close)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, other.URL+"/path", http.StatusTemporaryRedirect)
+	}))
+	t.Cleanup(origin.Close)
+
+	transport := client.NewTransport(nil)
+	key := client.StoreKey("", strings.TrimPrefix(origin.URL, "http://"))
+	transport.Store.Set(key, client.Token{Bytes: []byte("token")})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin.URL+"/path", nil)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "secret=1")
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	// The host receives neither the token nor the credentials of the request.
+	for _, name := range []string{ermes_http.DefaultTokenHeaderName, "Authorization", "Cookie"} {
+		if value := received.Get(name); value != "" {
+			t.Errorf("Expected no %s header, got %q", name, value)
+		}
+	}
+
+	// The host is not the node of the session.
+	if token, _ := transport.Store.Get(key); token.Host != "" {
+		t.Errorf("Expected no known node, got %q", token.Host)
+	}
+}
+
+func TestTransportLimitsBufferedBody(t *testing.T) {
+	ctx := context.Background()
+	var hits atomic.Int64
+	_, server := newServer(t, &hits)
+	transport := client.NewTransport(nil)
+	transport.MaxBodySize = 4
+
+	// The body cannot be read again, and is larger than the limit.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/path", io.NopCloser(strings.NewReader("too large")))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, err := transport.RoundTrip(req); !errors.Is(err, api.ErrErmes) {
+		t.Errorf("Expected error %v, got %v", api.ErrErmes, err)
+	}
+
+	if hits.Load() != 0 {
+		t.Errorf("Expected no request, got %d", hits.Load())
+	}
+}
@@ -0,0 +1,150 @@
+package commands_conformance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+func testConcurrency(t *testing.T, factory Factory) {
+	t.Run("AcquireWhileOffloading", func(t *testing.T) { testAcquireWhileOffloading(t, factory) })
+	t.Run("ConcurrentOffloads", func(t *testing.T) { testConcurrentOffloads(t, factory) })
+	t.Run("ConcurrentCreates", func(t *testing.T) { testConcurrentCreates(t, factory) })
+}
+
+// Acquisitions race with an offload: the offload must succeed only when no
+// acquisition is held, and no acquisition must succeed once it started.
+func testAcquireWhileOffloading(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	sessionId := createSession(t, cmd)
+	opt := api.DefaultAcquireSessionOptions()
+
+	var held atomic.Int64
+	var offloading atomic.Bool
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !offloading.Load() {
+				acquisitionId, _, err := cmd.AcquireSession(ctx, sessionId, opt)
+				if errors.Is(err, api.ErrSessionIsOffloading) {
+					return
+				} else if err != nil {
+					t.Errorf("Expected nil, got %v", err)
+					return
+				}
+
+				if offloading.Load() {
+					t.Errorf("Unexpected acquisition of an offloading session")
+				}
+
+				held.Add(1)
+				held.Add(-1)
+
+				if _, err := cmd.ReleaseSession(ctx, sessionId, acquisitionId); err != nil {
+					t.Errorf("Expected nil, got %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+		if errors.Is(err, api.ErrUnableToOffloadAcquiredSession) {
+			continue
+		}
+		expectNil(t, err)
+
+		offloading.Store(true)
+		if held.Load() != 0 {
+			t.Errorf("Expected no acquisition to be held while offloading")
+		}
+
+		if loader != nil {
+			go loader()
+		}
+		reader.Close()
+		break
+	}
+
+	wg.Wait()
+
+	_, _, err := cmd.AcquireSession(ctx, sessionId, opt)
+	expectError(t, err, api.ErrSessionIsOffloading)
+}
+
+// Concurrent offloads of the same session: exactly one must start.
+func testConcurrentOffloads(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	sessionId := createSession(t, cmd)
+
+	var started atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+			if errors.Is(err, api.ErrSessionIsOffloading) {
+				return
+			} else if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+				return
+			}
+
+			started.Add(1)
+			if loader != nil {
+				go loader()
+			}
+			reader.Close()
+		}()
+	}
+
+	wg.Wait()
+
+	if started.Load() != 1 {
+		t.Errorf("Expected exactly 1 offload to start, got %d", started.Load())
+	}
+}
+
+// Concurrent creations of the same session id: exactly one must succeed.
+func testConcurrentCreates(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	opt := api.NewCreateSessionOptionsBuilder().SessionId("contended").Build()
+
+	var created atomic.Int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cmd.CreateSession(ctx, opt)
+			if errors.Is(err, api.ErrSessionIdAlreadyExists) {
+				return
+			} else if err != nil {
+				t.Errorf("Expected nil, got %v", err)
+				return
+			}
+
+			created.Add(1)
+		}()
+	}
+
+	wg.Wait()
+
+	if created.Load() != 1 {
+		t.Errorf("Expected exactly 1 session to be created, got %d", created.Load())
+	}
+}
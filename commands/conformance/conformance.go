@@ -0,0 +1,178 @@
+package commands_conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Factory creates the api.Commands owned by the given node. Every call must
+// return commands with an independent state, as if they were run by different
+// nodes.
+type Factory func(t *testing.T, node infrastructure.Node) api.Commands
+
+// Commands that give access to the session key space. If the commands under
+// test implement this interface, the suite also checks that the session data
+// survives offloads.
+type SessionDataCommands interface {
+	// Get the value of a key in the session key space, nil if the key is not set.
+	GetSessionData(ctx context.Context, sessionId string, key string) ([]byte, error)
+	// Set the value of a key in the session key space. The write is fenced by
+	// the fencing token, 0 if not fenced.
+	SetSessionData(ctx context.Context, sessionId string, fencingToken uint64, key string, value []byte) error
+	// Delete a key from the session key space. The write is fenced by the
+	// fencing token, 0 if not fenced.
+	DeleteSessionData(ctx context.Context, sessionId string, fencingToken uint64, key string) error
+}
+
+// The nodes of the infrastructure used by the suite.
+var (
+	Cloud = infrastructure.Node{
+		AreaName:       "cloud",
+		Host:           "cloud.ermes",
+		GeoCoordinates: infrastructure.GeoCoordinates{Latitude: 50.1, Longitude: 8.7},
+		Resources:      infrastructure.Resources{"cpu": -1},
+	}
+	Edge1 = infrastructure.Node{
+		AreaName:       "edge1",
+		Host:           "edge1.ermes",
+		GeoCoordinates: infrastructure.GeoCoordinates{Latitude: 43.7, Longitude: 10.4},
+		Resources:      infrastructure.Resources{"cpu": 4},
+	}
+	Edge2 = infrastructure.Node{
+		AreaName:       "edge2",
+		Host:           "edge2.ermes",
+		GeoCoordinates: infrastructure.GeoCoordinates{Latitude: 45.5, Longitude: 9.2},
+		Resources:      infrastructure.Resources{"cpu": 4},
+	}
+)
+
+// The infrastructure used by the suite: a cloud node with two edge children.
+func Infrastructure() infrastructure.Infrastructure {
+	return infrastructure.Infrastructure{
+		AreaIdentifiers: []string{"region", "city"},
+		Areas: []infrastructure.Area{{
+			Node:  Cloud,
+			Areas: []infrastructure.Area{{Node: Edge1}, {Node: Edge2}},
+		}},
+	}
+}
+
+// Run the conformance suite against the commands created by the factory. The
+// suite checks the contracts documented on the api command interfaces.
+func Run(t *testing.T, factory Factory) {
+	t.Run("CreateSession", func(t *testing.T) { testCreateSession(t, factory) })
+	t.Run("AcquireSession", func(t *testing.T) { testAcquireSession(t, factory) })
+	t.Run("SessionLease", func(t *testing.T) { testSessionLease(t, factory) })
+	t.Run("AcquisitionModes", func(t *testing.T) { testAcquisitionModes(t, factory) })
+	t.Run("FencingToken", func(t *testing.T) { testFencingToken(t, factory) })
+	t.Run("ReadModeWrite", func(t *testing.T) { testReadModeWrite(t, factory) })
+	t.Run("FencedWriteWhileOffloading", func(t *testing.T) { testFencedWriteWhileOffloading(t, factory) })
+	t.Run("OffloadSession", func(t *testing.T) { testOffloadSession(t, factory) })
+	t.Run("OffloadedSessionTombstone", func(t *testing.T) { testOffloadedSessionTombstone(t, factory) })
+	t.Run("OnloadSession", func(t *testing.T) { testOnloadSession(t, factory) })
+	t.Run("AbortSessionOffload", func(t *testing.T) { testAbortSessionOffload(t, factory) })
+	t.Run("OnloadMalformedData", func(t *testing.T) { testOnloadMalformedData(t, factory) })
+	t.Run("PendingOnload", func(t *testing.T) { testPendingOnload(t, factory) })
+	t.Run("OffloadJournal", func(t *testing.T) { testOffloadJournal(t, factory) })
+	t.Run("RecoverMovedOffload", func(t *testing.T) { testRecoverMovedOffload(t, factory) })
+	t.Run("OffloadProgress", func(t *testing.T) { testOffloadProgress(t, factory) })
+	t.Run("CancelOffload", func(t *testing.T) { testCancelOffload(t, factory) })
+	t.Run("OffloadPlan", func(t *testing.T) { testOffloadPlan(t, factory) })
+	t.Run("RecoverOffloads", func(t *testing.T) { testRecoverOffloads(t, factory) })
+	t.Run("RecoverConfirmedOffloadAbortedByTarget", func(t *testing.T) { testRecoverConfirmedOffloadAbortedByTarget(t, factory) })
+	t.Run("CompactOffloadedSessions", func(t *testing.T) { testCompactOffloadedSessions(t, factory) })
+	t.Run("CompactOffloadedSessionsKeepsPendingOffloads", func(t *testing.T) { testCompactOffloadedSessionsKeepsPendingOffloads(t, factory) })
+	t.Run("SessionMetadata", func(t *testing.T) { testSessionMetadata(t, factory) })
+	t.Run("SessionSize", func(t *testing.T) { testSessionSize(t, factory) })
+	t.Run("Scan", func(t *testing.T) { testScan(t, factory) })
+	t.Run("ResourcesUsage", func(t *testing.T) { testResourcesUsage(t, factory) })
+	t.Run("BestOffloadTargets", func(t *testing.T) { testBestOffloadTargets(t, factory) })
+	t.Run("GarbageCollectSessions", func(t *testing.T) { testGarbageCollectSessions(t, factory) })
+	t.Run("GarbageCollectJournaledTombstone", func(t *testing.T) { testGarbageCollectJournaledTombstone(t, factory) })
+	t.Run("ReleaseAfterCancel", func(t *testing.T) { testReleaseAfterCancel(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+// Create the commands of a node and load the infrastructure.
+func newCommands(t *testing.T, factory Factory, node infrastructure.Node) api.Commands {
+	t.Helper()
+
+	cmd := factory(t, node)
+	if err := cmd.LoadInfrastructure(context.Background(), Infrastructure()); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return cmd
+}
+
+// Create a session with the default options.
+func createSession(t *testing.T, cmd api.Commands) string {
+	t.Helper()
+
+	sessionId, err := cmd.CreateSession(context.Background(), api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return sessionId
+}
+
+// Fail if err is not nil.
+func expectNil(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+}
+
+// Fail if err does not match target.
+func expectError(t *testing.T, err error, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("Expected error %v, got %v", target, err)
+	}
+}
+
+// Scan all the pages of a scan function.
+func scanAll(
+	t *testing.T,
+	count int64,
+	scan func(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error),
+) []string {
+	t.Helper()
+
+	all := make([]string, 0)
+	cursor := uint64(0)
+	for {
+		ids, newCursor, err := scan(context.Background(), cursor, count)
+		expectNil(t, err)
+
+		if int64(len(ids)) > count {
+			t.Errorf("Expected at most %d ids, got %d", count, len(ids))
+		}
+
+		all = append(all, ids...)
+		if newCursor == 0 {
+			return all
+		}
+
+		cursor = newCursor
+	}
+}
+
+// Returns true if the id is in the list.
+func contains(ids []string, id string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package commands_conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+func testResourcesUsage(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	cloud := newCommands(t, factory, Cloud)
+
+	// The infrastructure tree.
+	parent, err := edge1.GetParentNodeOf(ctx, Edge1.Host)
+	expectNil(t, err)
+	if parent == nil || parent.Host != Cloud.Host {
+		t.Errorf("Expected parent %s, got %v", Cloud.Host, parent)
+	}
+
+	parent, err = edge1.GetParentNodeOf(ctx, Cloud.Host)
+	expectNil(t, err)
+	if parent != nil {
+		t.Errorf("Expected no parent, got %v", parent)
+	}
+
+	children, err := edge1.GetChildrenNodesOf(ctx, Cloud.Host)
+	expectNil(t, err)
+	if len(children) != 2 {
+		t.Errorf("Expected 2 children, got %v", children)
+	}
+
+	_, err = edge1.GetParentNodeOf(ctx, "missing")
+	expectError(t, err, api.ErrNodeNotFound)
+
+	_, err = edge1.GetChildrenNodesOf(ctx, "missing")
+	expectError(t, err, api.ErrNodeNotFound)
+
+	// The resources usage of the sessions.
+	_, err = edge1.GetSessionResourcesUsage(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = edge1.UpdateSessionResourcesUsage(ctx, "missing", api.ResourcesUsage{"cpu": 1})
+	expectError(t, err, api.ErrSessionNotFound)
+
+	session1 := createSession(t, edge1)
+	session2 := createSession(t, edge1)
+	expectNil(t, edge1.UpdateSessionResourcesUsage(ctx, session1, api.ResourcesUsage{"cpu": 1.5}))
+	expectNil(t, edge1.UpdateSessionResourcesUsage(ctx, session2, api.ResourcesUsage{"cpu": 0.5, "memory": 2}))
+
+	resourcesUsage, err := edge1.GetSessionResourcesUsage(ctx, session2)
+	expectNil(t, err)
+	if resourcesUsage["cpu"] != 0.5 || resourcesUsage["memory"] != 2 {
+		t.Errorf("Expected cpu 0.5 and memory 2, got %v", resourcesUsage)
+	}
+
+	// The usage is replaced, not added.
+	expectNil(t, edge1.UpdateSessionResourcesUsage(ctx, session1, api.ResourcesUsage{"cpu": 1}))
+
+	sessions, resourcesUsage, err := edge1.GetNodeResourcesUsage(ctx, Edge1.Host)
+	expectNil(t, err)
+	if sessions != 2 || resourcesUsage["cpu"] != 1.5 || resourcesUsage["memory"] != 2 {
+		t.Errorf("Expected 2 sessions, cpu 1.5 and memory 2, got %d and %v", sessions, resourcesUsage)
+	}
+
+	// The update flows from the child to the parent.
+	node, sessions, resourcesUsageNodesMap, err := edge1.ResourcesUsageUpdateToParent(ctx)
+	expectNil(t, err)
+	if node.Host != Cloud.Host {
+		t.Errorf("Expected parent %s, got %s", Cloud.Host, node.Host)
+	}
+	if sessions != 2 || resourcesUsageNodesMap[Edge1.Host]["cpu"] != 1.5 {
+		t.Errorf("Expected 2 sessions and cpu 1.5, got %d and %v", sessions, resourcesUsageNodesMap)
+	}
+
+	expectNil(t, cloud.ResourcesUsageUpdateFromChild(ctx, sessions, resourcesUsageNodesMap))
+
+	sessions, resourcesUsage, err = cloud.GetNodeResourcesUsage(ctx, Edge1.Host)
+	expectNil(t, err)
+	if sessions != 2 || resourcesUsage["cpu"] != 1.5 {
+		t.Errorf("Expected 2 sessions and cpu 1.5, got %d and %v", sessions, resourcesUsage)
+	}
+
+	_, _, _, err = cloud.ResourcesUsageUpdateToParent(ctx)
+	expectError(t, err, api.ErrNodeNotFound)
+}
+
+func testBestOffloadTargets(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	opt := api.NewBestOffloadTargetsOptionsBuilder().MaxTargets(2).Build()
+
+	acquired := createSession(t, cmd)
+	_, _, err := cmd.AcquireSession(ctx, acquired, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+
+	for i := 0; i < 3; i++ {
+		sessionId := createSession(t, cmd)
+		expectNil(t, cmd.UpdateSessionResourcesUsage(ctx, sessionId, api.ResourcesUsage{"cpu": float64(i)}))
+	}
+
+	sessions, err := cmd.BestSessionsToOffload(ctx, opt)
+	expectNil(t, err)
+	if len(sessions) == 0 || len(sessions) > 2 {
+		t.Errorf("Expected 1 or 2 sessions, got %v", sessions)
+	}
+	if _, ok := sessions[acquired]; ok {
+		t.Errorf("Expected the acquired session %s not to be chosen", acquired)
+	}
+
+	targets, err := cmd.BestOffloadTargetNodes(ctx, Edge1.Host, sessions, opt)
+	expectNil(t, err)
+	if len(targets) > 2 {
+		t.Errorf("Expected at most 2 targets, got %v", targets)
+	}
+
+	for _, target := range targets {
+		if _, ok := sessions[target[0]]; !ok {
+			t.Errorf("Unexpected session %s", target[0])
+		}
+		if target[1] != Edge2.Host && target[1] != Cloud.Host {
+			t.Errorf("Unexpected node %s", target[1])
+		}
+	}
+
+	lookupNode, err := cmd.FindLookupNode(ctx, []string{acquired})
+	expectNil(t, err)
+	if lookupNode.Host == "" {
+		t.Errorf("Expected a lookup node, got %v", lookupNode)
+	}
+}
@@ -0,0 +1,519 @@
+package commands_conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Create an api.Node on the commands of a node.
+func newNode(t *testing.T, factory Factory, node infrastructure.Node) *api.Node {
+	t.Helper()
+
+	return api.NewNode(node, newCommands(t, factory, node))
+}
+
+// Notify nothing to the last visited node.
+func notifyNothing(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+	return false, nil
+}
+
+// An offload target that fails on demand.
+type faultyTarget struct {
+	api.LocalOffloadTarget
+	prepareErr error
+	commitErr  error
+}
+
+func (t faultyTarget) PrepareOnload(ctx context.Context, metadata api.SessionMetadata, reader io.Reader, onloadedFrom api.SessionLocation) (api.SessionLocation, error) {
+	if t.prepareErr != nil {
+		return api.SessionLocation{}, t.prepareErr
+	}
+
+	return t.LocalOffloadTarget.PrepareOnload(ctx, metadata, reader, onloadedFrom)
+}
+
+func (t faultyTarget) CommitOnload(ctx context.Context, location api.SessionLocation) error {
+	if t.commitErr != nil {
+		return t.commitErr
+	}
+
+	return t.LocalOffloadTarget.CommitOnload(ctx, location)
+}
+
+func testRecoverMovedOffload(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	cloud := newNode(t, factory, Cloud)
+	targets := func(host string) api.OffloadTarget {
+		return api.LocalOffloadTarget{Node: edge2}
+	}
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+	sessionId := sessionToken.SessionId
+
+	// The offload is confirmed but the commit does not reach the target.
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, commitErr: errors.New("commit failed")}
+	_, err = edge1.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions(), target, notifyNothing)
+	expectError(t, err, api.ErrOffloadNotCommitted)
+
+	// The target commits the onload later, and the session moves on.
+	expectNil(t, edge2.CommitSessionOnload(ctx, sessionId))
+	_, err = edge2.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions(), api.LocalOffloadTarget{Node: cloud}, notifyNothing)
+	expectNil(t, err)
+
+	status, err := edge2.GetSessionOnloadStatus(ctx, sessionId)
+	expectNil(t, err)
+	if status != api.OnloadStatusMoved {
+		t.Errorf("Expected status %v, got %v", api.OnloadStatusMoved, status)
+	}
+
+	// The offload is completed, and its tombstone can be collected.
+	expectNil(t, edge1.RecoverOffloads(ctx, targets))
+
+	entries, err := edge1.Cmd.ScanOffloadJournal(ctx)
+	expectNil(t, err)
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty journal, got %v", entries)
+	}
+
+	expectNil(t, edge1.Cmd.DeleteOffloadedSession(ctx, sessionId))
+}
+
+func testGarbageCollectJournaledTombstone(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	targets := func(host string) api.OffloadTarget {
+		return api.LocalOffloadTarget{Node: edge2}
+	}
+
+	sessionToken, err := edge1.CreateSession(ctx, api.NewCreateSessionOptionsBuilder().UnixExpiresAt(time.Now().Unix()-10).Build())
+	expectNil(t, err)
+	sessionId := sessionToken.SessionId
+
+	// The tombstone of an offload confirmed but not committed expires.
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, commitErr: errors.New("commit failed")}
+	_, err = edge1.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions(), target, notifyNothing)
+	expectError(t, err, api.ErrOffloadNotCommitted)
+
+	// The tombstone and its journal entry are kept for the recovery.
+	expectNil(t, edge1.GarbageCollectSessions(ctx, api.DefaultGarbageCollectSessionsOptions()))
+	_, _, err = edge1.Cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectNil(t, err)
+
+	entries, err := edge1.Cmd.ScanOffloadJournal(ctx)
+	expectNil(t, err)
+	if len(entries) != 1 {
+		t.Errorf("Expected a journal entry, got %v", entries)
+	}
+
+	// Once the offload is recovered, the tombstone is collected.
+	expectNil(t, edge1.RecoverOffloads(ctx, targets))
+	expectNil(t, edge1.GarbageCollectSessions(ctx, api.DefaultGarbageCollectSessionsOptions()))
+	_, _, err = edge1.Cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectError(t, err, api.ErrSessionNotFound)
+}
+
+func testReleaseAfterCancel(t *testing.T, factory Factory) {
+	edge1 := newNode(t, factory, Edge1)
+
+	offloadable := func(sessionId string) bool {
+		ids, _, err := edge1.ScanOffloadableSessions(context.Background(), 0, 10)
+		expectNil(t, err)
+		return slices.Contains(ids, sessionId)
+	}
+
+	// The sessions are released even if the context is cancelled while the
+	// callbacks run.
+	ctx, cancel := context.WithCancel(context.Background())
+	sessionToken, err := edge1.CreateAndAcquireSession(ctx, api.DefaultCreateAndAcquireSessionOptions(), func(api.SessionToken, uint64) error {
+		cancel()
+		return nil
+	})
+	expectNil(t, err)
+	if !offloadable(sessionToken.SessionId) {
+		t.Errorf("Expected %s to be released", sessionToken.SessionId)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = edge1.AcquireSession(ctx, sessionToken, api.DefaultAcquireSessionOptions(), func(uint64) error {
+		cancel()
+		return nil
+	})
+	expectNil(t, err)
+	if !offloadable(sessionToken.SessionId) {
+		t.Errorf("Expected %s to be released", sessionToken.SessionId)
+	}
+}
+
+func testOffloadProgress(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+
+	data, hasData := edge1.Cmd.(SessionDataCommands)
+	if hasData {
+		expectNil(t, data.SetSessionData(ctx, sessionToken.SessionId, 0, "key", []byte("value")))
+	}
+
+	var phases []api.OffloadPhase
+	var last api.OffloadProgress
+	opt := api.NewOffloadSessionOptionsBuilder().Observer(func(progress api.OffloadProgress) {
+		if len(phases) == 0 || phases[len(phases)-1] != progress.Phase {
+			phases = append(phases, progress.Phase)
+		}
+		last = progress
+	}).Build()
+
+	_, err = edge1.OffloadSession(ctx, sessionToken.SessionId, opt, api.LocalOffloadTarget{Node: edge2}, nil)
+	expectNil(t, err)
+
+	expected := []api.OffloadPhase{api.OffloadPhaseReading, api.OffloadPhaseOnloading, api.OffloadPhaseConfirming, api.OffloadPhaseCommitting}
+	if fmt.Sprint(phases) != fmt.Sprint(expected) {
+		t.Errorf("Expected phases %v, got %v", expected, phases)
+	}
+
+	if (hasData && last.BytesSent == 0) || last.BytesAcknowledged != last.BytesSent {
+		t.Errorf("Expected the sent bytes to be acknowledged, got %+v", last)
+	}
+
+	if active := edge1.ActiveOffloads(); len(active) != 0 {
+		t.Errorf("Expected no active offloads, got %v", active)
+	}
+}
+
+// An offload target whose prepare blocks until the offload is cancelled.
+type blockingTarget struct {
+	api.LocalOffloadTarget
+	started chan struct{}
+}
+
+func (t blockingTarget) PrepareOnload(ctx context.Context, metadata api.SessionMetadata, reader io.Reader, onloadedFrom api.SessionLocation) (api.SessionLocation, error) {
+	close(t.started)
+	<-ctx.Done()
+	return api.SessionLocation{}, ctx.Err()
+}
+
+func testCancelOffload(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+
+	target := blockingTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, started: make(chan struct{})}
+	offloadErr := make(chan error, 1)
+	go func() {
+		_, err := edge1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions(), target, nil)
+		offloadErr <- err
+	}()
+
+	<-target.started
+	if active := edge1.ActiveOffloads(); len(active) != 1 || active[0].SessionId != sessionToken.SessionId || active[0].Phase != api.OffloadPhaseOnloading {
+		t.Errorf("Expected the offload to be onloading, got %v", active)
+	}
+
+	expectNil(t, edge1.CancelOffload(sessionToken.SessionId))
+	expectError(t, <-offloadErr, context.Canceled)
+	expectError(t, edge1.CancelOffload(sessionToken.SessionId), api.ErrSessionIsNotOffloading)
+
+	// The offload has been rolled back.
+	_, _, err = edge1.Cmd.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+}
+
+func testOffloadPlan(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	nodes := map[string]*api.Node{Edge1.Host: edge1, Edge2.Host: edge2}
+
+	data, hasData := edge1.Cmd.(SessionDataCommands)
+	sessions := map[string]api.SessionInfoForOffloadDecision{}
+	for _, usage := range []float64{1, 2} {
+		sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+		expectNil(t, err)
+
+		if hasData {
+			expectNil(t, data.SetSessionData(ctx, sessionToken.SessionId, 0, "key", []byte("value")))
+		}
+
+		sessions[sessionToken.SessionId] = api.SessionInfoForOffloadDecision{ResourcesUsage: api.ResourcesUsage{"cpu": usage}}
+	}
+
+	var targets [][2]string
+	for sessionId := range sessions {
+		targets = append(targets, [2]string{sessionId, "missing"}, [2]string{sessionId, Edge2.Host})
+	}
+
+	plan, err := edge1.PlanOffload(ctx, sessions, Edge1, targets)
+	expectNil(t, err)
+	if len(plan.Offloads) != 2 || (hasData && plan.EstimatedBytes == 0) {
+		t.Fatalf("Expected 2 offloads of some bytes, got %+v", plan)
+	}
+
+	if plan.ResourcesDeltas[Edge1.Host]["cpu"] != -3 || plan.ResourcesDeltas["missing"]["cpu"] != 3 {
+		t.Errorf("Expected 3 cpu to move from %s to the preferred target, got %v", Edge1.Host, plan.ResourcesDeltas)
+	}
+
+	// Planning moves nothing.
+	for sessionId := range sessions {
+		_, err := edge1.GetSessionMetadata(ctx, sessionId)
+		expectNil(t, err)
+	}
+
+	// The plan is approved in its serialized form.
+	planJSON, err := json.Marshal(plan)
+	expectNil(t, err)
+
+	var approved api.OffloadPlan
+	expectNil(t, json.Unmarshal(planJSON, &approved))
+
+	targetFor := func(host string) api.OffloadTarget {
+		if node, ok := nodes[host]; ok {
+			return api.LocalOffloadTarget{Node: node}
+		}
+
+		return faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, prepareErr: errors.New("unreachable")}
+	}
+
+	_, err = edge2.ExecuteOffloadPlan(ctx, approved, api.DefaultOffloadSessionOptions(), targetFor, nil)
+	expectError(t, err, api.ErrOffloadPlanNotForNode)
+
+	// The unreachable target is skipped.
+	results, err := edge1.ExecuteOffloadPlan(ctx, approved, api.DefaultOffloadSessionOptions(), targetFor, nil)
+	expectNil(t, err)
+	for _, result := range results {
+		expectNil(t, result.Err)
+
+		_, err := edge2.GetSessionMetadata(ctx, result.NewLocation.SessionId)
+		expectNil(t, err)
+	}
+
+	// An offload without targets is reported.
+	unplanned := api.OffloadPlan{Host: Edge1.Host, Offloads: []api.PlannedOffload{{SessionId: "missing"}}}
+	results, err = edge1.ExecuteOffloadPlan(ctx, unplanned, api.DefaultOffloadSessionOptions(), targetFor, nil)
+	if err != nil || len(results) != 1 || !errors.Is(results[0].Err, api.ErrNoTargetPlanned) {
+		t.Errorf("Expected error %v, got %v, %v", api.ErrNoTargetPlanned, results, err)
+	}
+}
+
+func testRecoverOffloads(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	targets := func(host string) api.OffloadTarget {
+		return api.LocalOffloadTarget{Node: edge2}
+	}
+
+	// An offload interrupted before contacting the target.
+	notContacted := createSession(t, edge1.Cmd)
+	_, _, err := edge1.Cmd.OffloadSession(ctx, notContacted, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+
+	// An offload interrupted while the onload is pending.
+	prepared := createSession(t, edge1.Cmd)
+	metadata, err := edge1.GetSessionMetadata(ctx, prepared)
+	expectNil(t, err)
+
+	reader, loader, err := edge1.Cmd.OffloadSession(ctx, prepared, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+	defer reader.Close()
+	expectNil(t, edge1.Cmd.RecordSessionOffloadTarget(ctx, prepared, api.NewSessionLocation(Edge2.Host, prepared)))
+
+	_, err = targets(Edge2.Host).PrepareOnload(ctx, metadata, reader, api.NewSessionLocation(Edge1.Host, prepared))
+	expectNil(t, err)
+
+	// An offload confirmed but not committed on the target.
+	confirmed := createSession(t, edge1.Cmd)
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, commitErr: errors.New("commit failed")}
+	_, err = edge1.OffloadSession(ctx, confirmed, api.DefaultOffloadSessionOptions(), target, nil)
+	expectError(t, err, api.ErrOffloadNotCommitted)
+
+	expectNil(t, edge1.RecoverOffloads(ctx, targets))
+
+	// The offloads that were not confirmed are aborted on both nodes.
+	for _, sessionId := range []string{notContacted, prepared} {
+		_, _, err := edge1.Cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+		expectNil(t, err)
+
+		if status, err := edge2.GetSessionOnloadStatus(ctx, sessionId); err != nil || status != api.OnloadStatusAborted {
+			t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusAborted, status, err)
+		}
+	}
+
+	// The confirmed offload is committed on the target.
+	if status, err := edge2.GetSessionOnloadStatus(ctx, confirmed); err != nil || status != api.OnloadStatusCommitted {
+		t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusCommitted, status, err)
+	}
+
+	// Only the offloads started after the recovery are journaled.
+	entries, err := edge1.Cmd.ScanOffloadJournal(ctx)
+	expectNil(t, err)
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", entries)
+	}
+}
+
+func testRecoverConfirmedOffloadAbortedByTarget(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	targets := func(host string) api.OffloadTarget {
+		return api.LocalOffloadTarget{Node: edge2}
+	}
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+
+	// The offload is confirmed, but the target aborts the onload.
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: edge2}, commitErr: errors.New("commit failed")}
+	_, err = edge1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions(), target, nil)
+	expectError(t, err, api.ErrOffloadNotCommitted)
+	expectNil(t, edge2.AbortSessionOnload(ctx, sessionToken.SessionId))
+	expectError(t, edge1.RecoverOffloads(ctx, targets), api.ErrOffloadNotCommitted)
+
+	// The offload is left in the journal.
+	entries, err := edge1.Cmd.ScanOffloadJournal(ctx)
+	expectNil(t, err)
+	if len(entries) != 1 || entries[0].SessionId != sessionToken.SessionId {
+		t.Errorf("Expected the entry of %s, got %v", sessionToken.SessionId, entries)
+	}
+}
+
+func testCompactOffloadedSessions(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	cloud := newNode(t, factory, Cloud)
+	nodes := map[string]*api.Node{Edge1.Host: edge1, Edge2.Host: edge2, Cloud.Host: cloud}
+	resolve := func(ctx context.Context, location api.SessionLocation) (*api.SessionLocation, error) {
+		return nodes[location.Host].ResolveSessionLocation(ctx, location.SessionId)
+	}
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+
+	// The session moves from edge1 to edge2 and then to the cloud, edge1 is
+	// not notified.
+	location := sessionToken.SessionLocation
+	for _, target := range []*api.Node{edge2, cloud} {
+		location, err = nodes[location.Host].OffloadSession(ctx, location.SessionId, api.DefaultOffloadSessionOptions(), api.LocalOffloadTarget{Node: target}, notifyNothing)
+		expectNil(t, err)
+	}
+
+	// The chain is longer than the maximum hops.
+	_, _, err = edge1.CompactOffloadedSession(ctx, sessionToken.SessionId, api.NewCompactOffloadedSessionsOptionsBuilder().MaxHops(1).Build(), resolve)
+	expectError(t, err, api.ErrTooManyForwardingHops)
+
+	// The tombstone of edge1 points directly to the cloud.
+	compactions, err := edge1.CompactOffloadedSessions(ctx, api.DefaultCompactOffloadedSessionsOptions(), resolve)
+	expectNil(t, err)
+	if len(compactions) != 1 || compactions[0].Err != nil || compactions[0].Location != location || compactions[0].Collected {
+		t.Fatalf("Expected the tombstone to be compacted to %v, got %+v", location, compactions)
+	}
+
+	offloadedTo, _, err := edge1.Cmd.GetOffloadedSessionLocation(ctx, sessionToken.SessionId)
+	if err != nil || offloadedTo != location {
+		t.Errorf("Expected location %v, got %v, %v", location, offloadedTo, err)
+	}
+
+	// A chain that leads back to a visited tombstone is a loop.
+	edge2Location := api.NewSessionLocation(Edge2.Host, sessionToken.SessionId)
+	_, err = edge1.Cmd.UpdateOffloadedSessionLocation(ctx, sessionToken.SessionId, edge2Location)
+	expectNil(t, err)
+	_, err = edge2.Cmd.UpdateOffloadedSessionLocation(ctx, sessionToken.SessionId, sessionToken.SessionLocation)
+	expectNil(t, err)
+
+	_, _, err = edge1.CompactOffloadedSession(ctx, sessionToken.SessionId, api.DefaultCompactOffloadedSessionsOptions(), resolve)
+	expectError(t, err, api.ErrSessionForwardingLoop)
+
+	_, err = edge1.Cmd.UpdateOffloadedSessionLocation(ctx, sessionToken.SessionId, location)
+	expectNil(t, err)
+
+	// A chain that leads to no session is collected.
+	_, err = edge2.Cmd.UpdateOffloadedSessionLocation(ctx, sessionToken.SessionId, api.NewSessionLocation(Cloud.Host, "missing"))
+	expectNil(t, err)
+
+	_, collected, err := edge2.CompactOffloadedSession(ctx, sessionToken.SessionId, api.DefaultCompactOffloadedSessionsOptions(), resolve)
+	if err != nil || !collected {
+		t.Errorf("Expected the tombstone to be collected, got %v, %v", collected, err)
+	}
+
+	_, _, err = edge2.Cmd.GetOffloadedSessionLocation(ctx, sessionToken.SessionId)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	// Once the client has been redirected, the tombstone can be collected.
+	_, err = edge1.AcquireSession(ctx, sessionToken, api.DefaultAcquireSessionOptions(), func(uint64) error { return nil })
+	expectNil(t, err)
+
+	location, collected, err = edge1.CompactOffloadedSession(ctx, sessionToken.SessionId, api.NewCompactOffloadedSessionsOptionsBuilder().CollectRedirected().Build(), resolve)
+	if err != nil || !collected || location != offloadedTo {
+		t.Errorf("Expected the tombstone to be collected, got %v, %v, %v", location, collected, err)
+	}
+}
+
+func testCompactOffloadedSessionsKeepsPendingOffloads(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newNode(t, factory, Edge1)
+	edge2 := newNode(t, factory, Edge2)
+	cloud := newNode(t, factory, Cloud)
+	nodes := map[string]*api.Node{Edge1.Host: edge1, Edge2.Host: edge2, Cloud.Host: cloud}
+	resolve := func(ctx context.Context, location api.SessionLocation) (*api.SessionLocation, error) {
+		return nodes[location.Host].ResolveSessionLocation(ctx, location.SessionId)
+	}
+
+	sessionToken, err := edge1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	expectNil(t, err)
+
+	// The session moves from edge1 to edge2, and then to the cloud that does
+	// not commit.
+	sessionId := sessionToken.SessionId
+	_, err = edge1.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions(), api.LocalOffloadTarget{Node: edge2}, notifyNothing)
+	expectNil(t, err)
+
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: cloud}, commitErr: errors.New("commit failed")}
+	location, err := edge2.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions(), target, notifyNothing)
+	expectError(t, err, api.ErrOffloadNotCommitted)
+
+	// The pending onload on the cloud is live, the tombstone of edge1 points
+	// to it.
+	compacted, collected, err := edge1.CompactOffloadedSession(ctx, sessionId, api.DefaultCompactOffloadedSessionsOptions(), resolve)
+	if err != nil || collected || compacted != location {
+		t.Errorf("Expected the tombstone to be compacted to %v, got %v, %v, %v", location, compacted, collected, err)
+	}
+
+	_, _, err = edge1.Cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectNil(t, err)
+
+	// The tombstone of the journaled offload of edge2 is left to the recovery.
+	compactions, err := edge2.CompactOffloadedSessions(ctx, api.NewCompactOffloadedSessionsOptionsBuilder().CollectRedirected().Build(), resolve)
+	expectNil(t, err)
+	if len(compactions) != 1 || !errors.Is(compactions[0].Err, api.ErrOffloadNotCommitted) || compactions[0].Collected {
+		t.Errorf("Expected the tombstone to be skipped, got %+v", compactions)
+	}
+
+	entries, err := edge2.Cmd.ScanOffloadJournal(ctx)
+	expectNil(t, err)
+	if len(entries) != 1 || entries[0].SessionId != sessionId {
+		t.Errorf("Expected the entry of %s, got %v", sessionId, entries)
+	}
+}
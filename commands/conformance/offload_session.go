@@ -0,0 +1,585 @@
+package commands_conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Offload a session from a node to another, returns the new location.
+func offload(
+	t *testing.T,
+	from api.Commands,
+	fromHost string,
+	to api.Commands,
+	toHost string,
+	sessionId string,
+	notifyLastVisitedNode func(ctx context.Context, oldLocation api.SessionLocation) (bool, error),
+) api.SessionLocation {
+	t.Helper()
+	ctx := context.Background()
+
+	metadata, err := from.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+
+	reader, loader, err := from.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+
+	if loader != nil {
+		go loader()
+	}
+
+	opt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation(fromHost, sessionId)).Build()
+	newSessionId, err := to.OnloadSession(ctx, metadata, reader, opt)
+	reader.Close()
+	expectNil(t, err)
+
+	newLocation := api.NewSessionLocation(toHost, newSessionId)
+	expectNil(t, from.ConfirmSessionOffload(ctx, sessionId, newLocation, api.DefaultOffloadSessionOptions(), notifyLastVisitedNode))
+
+	return newLocation
+}
+
+func testOffloadSession(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	opt := api.DefaultOffloadSessionOptions()
+	newLocation := api.NewSessionLocation(Edge2.Host, "new")
+
+	_, _, err := cmd.OffloadSession(ctx, "missing", opt)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = cmd.ConfirmSessionOffload(ctx, "missing", newLocation, opt, nil)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	_, err = cmd.UpdateOffloadedSessionLocation(ctx, "missing", newLocation)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, cmd)
+
+	err = cmd.ConfirmSessionOffload(ctx, sessionId, newLocation, opt, nil)
+	expectError(t, err, api.ErrSessionIsNotOffloading)
+
+	_, err = cmd.UpdateOffloadedSessionLocation(ctx, sessionId, newLocation)
+	expectError(t, err, api.ErrSessionIsNotOffloaded)
+
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, opt)
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	reader.Close()
+
+	// An offloading session cannot be offloaded again or acquired by default.
+	_, _, err = cmd.OffloadSession(ctx, sessionId, opt)
+	expectError(t, err, api.ErrSessionIsOffloading)
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectError(t, err, api.ErrSessionIsOffloading)
+
+	offloadable := scanAll(t, 10, cmd.ScanOffloadableSessions)
+	if contains(offloadable, sessionId) {
+		t.Errorf("Expected %s not to be offloadable, got %v", sessionId, offloadable)
+	}
+
+	// Unless the acquisition allows it.
+	allowWhileOffloading := api.NewAcquireSessionOptionsBuilder().AllowOffloading().AllowWhileOffloading().Build()
+	acquisitionId, _, err := cmd.AcquireSession(ctx, sessionId, allowWhileOffloading)
+	expectNil(t, err)
+
+	expectNil(t, cmd.ConfirmSessionOffload(ctx, sessionId, newLocation, opt, nil))
+
+	// The release of an acquisition that outlived the offload returns the new
+	// location.
+	offloadedTo, err := cmd.ReleaseSession(ctx, sessionId, acquisitionId)
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != newLocation {
+		t.Errorf("Expected location %v, got %v", newLocation, offloadedTo)
+	}
+
+	// The offloaded session is a tombstone that points to the new location.
+	_, err = cmd.GetSessionMetadata(ctx, sessionId)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	_, _, err = cmd.OffloadSession(ctx, sessionId, opt)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	offloaded := scanAll(t, 10, cmd.ScanOffloadedSessions)
+	if !contains(offloaded, sessionId) {
+		t.Errorf("Expected %s to be offloaded, got %v", sessionId, offloaded)
+	}
+
+	sessions := scanAll(t, 10, cmd.ScanSessions)
+	if contains(sessions, sessionId) {
+		t.Errorf("Expected %s not to be scanned, got %v", sessionId, sessions)
+	}
+
+	// No client has been redirected yet.
+	clientRedirected, err := cmd.UpdateOffloadedSessionLocation(ctx, sessionId, newLocation)
+	expectNil(t, err)
+	if clientRedirected {
+		t.Errorf("Expected no client to be redirected")
+	}
+
+	_, offloadedTo, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != newLocation {
+		t.Errorf("Expected location %v, got %v", newLocation, offloadedTo)
+	}
+
+	// The acquisition redirected the client.
+	movedLocation := api.NewSessionLocation(Cloud.Host, "moved")
+	clientRedirected, err = cmd.UpdateOffloadedSessionLocation(ctx, sessionId, movedLocation)
+	expectNil(t, err)
+	if !clientRedirected {
+		t.Errorf("Expected the client to be redirected")
+	}
+
+	_, offloadedTo, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != movedLocation {
+		t.Errorf("Expected location %v, got %v", movedLocation, offloadedTo)
+	}
+}
+
+func testOffloadedSessionTombstone(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	opt := api.DefaultOffloadSessionOptions()
+	newLocation := api.NewSessionLocation(Edge2.Host, "new")
+
+	_, _, err := cmd.GetOffloadedSessionLocation(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	expectError(t, cmd.DeleteOffloadedSession(ctx, "missing"), api.ErrSessionNotFound)
+
+	// A live session is not a tombstone.
+	sessionId := createSession(t, cmd)
+	_, _, err = cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectError(t, err, api.ErrSessionIsNotOffloaded)
+
+	expectError(t, cmd.DeleteOffloadedSession(ctx, sessionId), api.ErrSessionIsNotOffloaded)
+
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, opt)
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	reader.Close()
+
+	expectNil(t, cmd.ConfirmSessionOffload(ctx, sessionId, newLocation, opt, nil))
+
+	offloadedTo, clientRedirected, err := cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectNil(t, err)
+	if offloadedTo != newLocation || clientRedirected {
+		t.Errorf("Expected location %v and no client redirected, got %v and %v", newLocation, offloadedTo, clientRedirected)
+	}
+
+	// The location follows the updates and the redirects.
+	movedLocation := api.NewSessionLocation(Cloud.Host, "moved")
+	_, err = cmd.UpdateOffloadedSessionLocation(ctx, sessionId, movedLocation)
+	expectNil(t, err)
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+
+	offloadedTo, clientRedirected, err = cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectNil(t, err)
+	if offloadedTo != movedLocation || !clientRedirected {
+		t.Errorf("Expected location %v and the client redirected, got %v and %v", movedLocation, offloadedTo, clientRedirected)
+	}
+
+	// The tombstone is kept until the offload leaves the journal.
+	expectError(t, cmd.DeleteOffloadedSession(ctx, sessionId), api.ErrOffloadNotCommitted)
+	expectNil(t, cmd.CompleteSessionOffload(ctx, sessionId))
+
+	// The deleted tombstone no longer redirects.
+	expectNil(t, cmd.DeleteOffloadedSession(ctx, sessionId))
+
+	_, _, err = cmd.GetOffloadedSessionLocation(ctx, sessionId)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectError(t, err, api.ErrSessionNotFound)
+
+	offloaded := scanAll(t, 10, cmd.ScanOffloadedSessions)
+	if contains(offloaded, sessionId) {
+		t.Errorf("Expected %s not to be offloaded, got %v", sessionId, offloaded)
+	}
+}
+
+func testOnloadSession(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+	cloud := newCommands(t, factory, Cloud)
+
+	sessionId := createSession(t, edge1)
+	expiresAt := int64(4102444800)
+	expectNil(t, edge1.SetSessionMetadata(ctx, sessionId, api.NewSessionMetadataOptionsBuilder().UnixExpiresAt(expiresAt).Build()))
+
+	data1, hasData := edge1.(SessionDataCommands)
+	if hasData {
+		expectNil(t, data1.SetSessionData(ctx, sessionId, 0, "key", []byte("value")))
+		expectNil(t, data1.SetSessionData(ctx, sessionId, 0, "empty", []byte{}))
+	}
+
+	// The client did not leave edge1, so no node has to be notified.
+	newLocation := offload(t, edge1, Edge1.Host, edge2, Edge2.Host, sessionId, func(ctx context.Context, oldLocation api.SessionLocation) (bool, error) {
+		t.Errorf("Unexpected notification of %v", oldLocation)
+		return false, nil
+	})
+
+	if newLocation.SessionId != sessionId {
+		t.Errorf("Expected the session id %s to be preserved, got %s", sessionId, newLocation.SessionId)
+	}
+
+	metadata, err := edge2.GetSessionMetadata(ctx, newLocation.SessionId)
+	expectNil(t, err)
+	if metadata.CreatedIn != Edge1.Host || metadata.ExpiresAt == nil || *metadata.ExpiresAt != expiresAt {
+		t.Errorf("Expected the metadata to be preserved, got %v", metadata)
+	}
+
+	if data2, ok := edge2.(SessionDataCommands); hasData && ok {
+		value, err := data2.GetSessionData(ctx, newLocation.SessionId, "key")
+		expectNil(t, err)
+		if string(value) != "value" {
+			t.Errorf("Expected value, got %q", value)
+		}
+
+		value, err = data2.GetSessionData(ctx, newLocation.SessionId, "missing")
+		expectNil(t, err)
+		if value != nil {
+			t.Errorf("Expected nil, got %q", value)
+		}
+	}
+
+	// The session cannot be onloaded twice.
+	_, err = edge2.OnloadSession(ctx, metadata, emptyReader{}, api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation(Edge1.Host, sessionId)).Build())
+	expectError(t, err, api.ErrSessionAlreadyOnloaded)
+
+	// The client did not reach edge2 yet, so edge1 is notified.
+	notified := make([]api.SessionLocation, 0)
+	cloudLocation := offload(t, edge2, Edge2.Host, cloud, Cloud.Host, newLocation.SessionId, func(ctx context.Context, oldLocation api.SessionLocation) (bool, error) {
+		notified = append(notified, oldLocation)
+		return edge1.UpdateOffloadedSessionLocation(ctx, oldLocation.SessionId, api.NewSessionLocation(Cloud.Host, sessionId))
+	})
+
+	if len(notified) != 1 || notified[0] != api.NewSessionLocation(Edge1.Host, sessionId) {
+		t.Errorf("Expected a notification of %v, got %v", api.NewSessionLocation(Edge1.Host, sessionId), notified)
+	}
+
+	_, offloadedTo, err := edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != cloudLocation {
+		t.Errorf("Expected location %v, got %v", cloudLocation, offloadedTo)
+	}
+
+	// Once the client reached the cloud, the session can come back to edge1.
+	acquisitionId, _, err := cloud.AcquireSession(ctx, cloudLocation.SessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	_, err = cloud.ReleaseSession(ctx, cloudLocation.SessionId, acquisitionId)
+	expectNil(t, err)
+
+	backLocation := offload(t, cloud, Cloud.Host, edge1, Edge1.Host, cloudLocation.SessionId, func(ctx context.Context, oldLocation api.SessionLocation) (bool, error) {
+		t.Errorf("Unexpected notification of %v", oldLocation)
+		return false, nil
+	})
+
+	_, offloadedTo, err = edge1.AcquireSession(ctx, backLocation.SessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo != nil {
+		t.Errorf("Expected the session to be back on edge1, got %v", offloadedTo)
+	}
+
+	// Without the previous location, a new session id may be assigned.
+	newSessionId, err := edge2.OnloadSession(ctx, metadata, emptyReader{}, api.DefaultOnloadSessionOptions())
+	expectNil(t, err)
+	if _, err := edge2.GetSessionMetadata(ctx, newSessionId); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+func testAbortSessionOffload(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+	opt := api.DefaultOffloadSessionOptions()
+
+	err := edge1.AbortSessionOffload(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, edge1)
+	err = edge1.AbortSessionOffload(ctx, sessionId)
+	expectError(t, err, api.ErrSessionIsNotOffloading)
+
+	// An aborted offload returns the session active.
+	reader, _, err := edge1.OffloadSession(ctx, sessionId, opt)
+	expectNil(t, err)
+	reader.Close()
+	expectNil(t, edge1.AbortSessionOffload(ctx, sessionId))
+
+	acquisitionId, offloadedTo, err := edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo != nil {
+		t.Errorf("Expected the session to be active, got %v", offloadedTo)
+	}
+
+	_, err = edge1.ReleaseSession(ctx, sessionId, acquisitionId)
+	expectNil(t, err)
+
+	if ids := scanAll(t, 10, edge1.ScanOffloadableSessions); !contains(ids, sessionId) {
+		t.Errorf("Expected %s to be offloadable, got %v", sessionId, ids)
+	}
+
+	// A confirmed offload can no longer be aborted.
+	offload(t, edge1, Edge1.Host, edge2, Edge2.Host, sessionId, nil)
+	err = edge1.AbortSessionOffload(ctx, sessionId)
+	expectError(t, err, api.ErrSessionIsNotOffloading)
+}
+
+func testOnloadMalformedData(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+
+	metadata, err := edge1.GetSessionMetadata(ctx, createSession(t, edge1))
+	expectNil(t, err)
+
+	// A length prefix beyond any field, or one of a field that is not sent, is
+	// rejected without allocating the field.
+	for _, length := range []uint64{1 << 63, 1 << 40, 16} {
+		stream := binary.AppendUvarint(nil, length)
+		_, err := edge2.OnloadSession(ctx, metadata, bytes.NewReader(stream), api.DefaultOnloadSessionOptions())
+		if err == nil {
+			t.Errorf("Expected an error for a field of %d bytes, got nil", length)
+		}
+	}
+}
+
+func testPendingOnload(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+	pending := api.NewOnloadSessionOptionsBuilder().Pending().Build()
+
+	err := edge2.CommitSessionOnload(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = edge2.AbortSessionOnload(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = edge2.CommitSessionOnload(ctx, createSession(t, edge2))
+	expectError(t, err, api.ErrSessionIsNotOnloading)
+
+	sessionId := createSession(t, edge1)
+	data1, hasData := edge1.(SessionDataCommands)
+	if hasData {
+		expectNil(t, data1.SetSessionData(ctx, sessionId, 0, "key", []byte("value")))
+	}
+
+	metadata, err := edge1.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+	reader, loader, err := edge1.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+
+	// The pending onload is invisible.
+	edge1Location := api.NewSessionLocation(Edge1.Host, sessionId)
+	opt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(edge1Location).Pending().Build()
+	onloadedId, err := edge2.OnloadSession(ctx, metadata, reader, opt)
+	reader.Close()
+	expectNil(t, err)
+
+	_, err = edge2.GetSessionMetadata(ctx, onloadedId)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	_, _, err = edge2.AcquireSession(ctx, onloadedId, api.DefaultAcquireSessionOptions())
+	expectError(t, err, api.ErrSessionNotFound)
+
+	if ids := scanAll(t, 10, edge2.ScanSessions); contains(ids, onloadedId) {
+		t.Errorf("Expected %s not to be scanned, got %v", onloadedId, ids)
+	}
+
+	_, err = edge2.OnloadSession(ctx, metadata, emptyReader{}, opt)
+	expectError(t, err, api.ErrSessionAlreadyOnloaded)
+
+	// Once committed, the session is visible with its data.
+	expectNil(t, edge2.CommitSessionOnload(ctx, onloadedId))
+	err = edge2.CommitSessionOnload(ctx, onloadedId)
+	expectError(t, err, api.ErrSessionIsNotOnloading)
+
+	_, err = edge2.GetSessionMetadata(ctx, onloadedId)
+	expectNil(t, err)
+
+	if data2, ok := edge2.(SessionDataCommands); hasData && ok {
+		value, err := data2.GetSessionData(ctx, onloadedId, "key")
+		expectNil(t, err)
+		if string(value) != "value" {
+			t.Errorf("Expected value, got %q", value)
+		}
+	}
+
+	edge2Location := api.NewSessionLocation(Edge2.Host, onloadedId)
+	expectNil(t, edge1.ConfirmSessionOffload(ctx, sessionId, edge2Location, api.DefaultOffloadSessionOptions(), nil))
+
+	// An aborted onload of a new session deletes it.
+	newSessionId, err := edge2.OnloadSession(ctx, metadata, emptyReader{}, pending)
+	expectNil(t, err)
+	expectNil(t, edge2.AbortSessionOnload(ctx, newSessionId))
+
+	err = edge2.CommitSessionOnload(ctx, newSessionId)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	// An aborted onload that would replace a tombstone keeps it, pointing to the
+	// node that still owns the session.
+	metadata, err = edge2.GetSessionMetadata(ctx, onloadedId)
+	expectNil(t, err)
+	reader, loader, err = edge2.OffloadSession(ctx, onloadedId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+
+	opt = api.NewOnloadSessionOptionsBuilder().OnloadedFrom(edge2Location).Pending().Build()
+	_, err = edge1.OnloadSession(ctx, metadata, reader, opt)
+	reader.Close()
+	expectNil(t, err)
+
+	_, offloadedTo, err := edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != edge2Location {
+		t.Errorf("Expected location %v, got %v", edge2Location, offloadedTo)
+	}
+
+	expectNil(t, edge1.AbortSessionOnload(ctx, sessionId))
+	expectNil(t, edge2.AbortSessionOffload(ctx, onloadedId))
+
+	_, offloadedTo, err = edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo == nil || *offloadedTo != edge2Location {
+		t.Errorf("Expected location %v, got %v", edge2Location, offloadedTo)
+	}
+
+	_, offloadedTo, err = edge2.AcquireSession(ctx, onloadedId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if offloadedTo != nil {
+		t.Errorf("Expected the session to be active, got %v", offloadedTo)
+	}
+
+	if data1, ok := edge1.(SessionDataCommands); hasData && ok {
+		_, err := data1.GetSessionData(ctx, sessionId, "key")
+		expectError(t, err, api.ErrSessionNotFound)
+	}
+}
+
+func testOffloadJournal(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+	opt := api.DefaultOffloadSessionOptions()
+
+	err := edge1.RecordSessionOffloadTarget(ctx, "missing", api.NewSessionLocation(Edge2.Host, "missing"))
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = edge1.CompleteSessionOffload(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	status, err := edge2.GetSessionOnloadStatus(ctx, "missing")
+	expectNil(t, err)
+	if status != api.OnloadStatusAborted {
+		t.Errorf("Expected status %v, got %v", api.OnloadStatusAborted, status)
+	}
+
+	sessionId := createSession(t, edge1)
+	newLocation := api.NewSessionLocation(Edge2.Host, sessionId)
+	err = edge1.RecordSessionOffloadTarget(ctx, sessionId, newLocation)
+	expectError(t, err, api.ErrSessionIsNotOffloading)
+
+	err = edge1.CompleteSessionOffload(ctx, sessionId)
+	expectError(t, err, api.ErrSessionIsNotOffloaded)
+
+	expectJournal := func(expected ...api.OffloadJournalEntry) {
+		t.Helper()
+
+		entries, err := edge1.ScanOffloadJournal(ctx)
+		expectNil(t, err)
+		if len(entries) != len(expected) {
+			t.Fatalf("Expected journal %v, got %v", expected, entries)
+		}
+
+		for i, entry := range entries {
+			if entry.SessionId != expected[i].SessionId || entry.Confirmed != expected[i].Confirmed ||
+				(entry.NewLocation == nil) != (expected[i].NewLocation == nil) ||
+				entry.NewLocation != nil && *entry.NewLocation != *expected[i].NewLocation {
+				t.Errorf("Expected journal %v, got %v", expected, entries)
+			}
+		}
+	}
+
+	// An aborted offload is deleted from the journal.
+	reader, _, err := edge1.OffloadSession(ctx, sessionId, opt)
+	expectNil(t, err)
+	reader.Close()
+	expectJournal(api.OffloadJournalEntry{SessionId: sessionId})
+
+	expectNil(t, edge1.RecordSessionOffloadTarget(ctx, sessionId, newLocation))
+	expectJournal(api.OffloadJournalEntry{SessionId: sessionId, NewLocation: &newLocation})
+
+	expectNil(t, edge1.AbortSessionOffload(ctx, sessionId))
+	expectJournal()
+
+	// A confirmed offload stays in the journal until it is completed.
+	metadata, err := edge1.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+	reader, loader, err := edge1.OffloadSession(ctx, sessionId, opt)
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+
+	expectNil(t, edge1.RecordSessionOffloadTarget(ctx, sessionId, newLocation))
+	onloadOpt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation(Edge1.Host, sessionId)).Pending().Build()
+	_, err = edge2.OnloadSession(ctx, metadata, reader, onloadOpt)
+	reader.Close()
+	expectNil(t, err)
+
+	status, err = edge2.GetSessionOnloadStatus(ctx, sessionId)
+	expectNil(t, err)
+	if status != api.OnloadStatusPending {
+		t.Errorf("Expected status %v, got %v", api.OnloadStatusPending, status)
+	}
+
+	expectNil(t, edge1.ConfirmSessionOffload(ctx, sessionId, newLocation, opt, nil))
+	expectJournal(api.OffloadJournalEntry{SessionId: sessionId, NewLocation: &newLocation, Confirmed: true})
+
+	expectNil(t, edge2.CommitSessionOnload(ctx, sessionId))
+	status, err = edge2.GetSessionOnloadStatus(ctx, sessionId)
+	expectNil(t, err)
+	if status != api.OnloadStatusCommitted {
+		t.Errorf("Expected status %v, got %v", api.OnloadStatusCommitted, status)
+	}
+
+	expectNil(t, edge1.CompleteSessionOffload(ctx, sessionId))
+	expectNil(t, edge1.CompleteSessionOffload(ctx, sessionId))
+	expectJournal()
+}
+
+// A reader of an empty session.
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
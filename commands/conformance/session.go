@@ -0,0 +1,649 @@
+package commands_conformance
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+func testCreateSession(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	before := time.Now().Unix()
+	sessionId := createSession(t, cmd)
+	if sessionId == "" {
+		t.Fatalf("Expected a session id, got an empty one")
+	}
+
+	metadata, err := cmd.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+
+	if metadata.CreatedIn != Edge1.Host {
+		t.Errorf("Expected CreatedIn %s, got %s", Edge1.Host, metadata.CreatedIn)
+	}
+	if metadata.CreatedAt < before || metadata.UpdatedAt < metadata.CreatedAt {
+		t.Errorf("Expected CreatedAt >= %d and UpdatedAt >= CreatedAt, got %d and %d", before, metadata.CreatedAt, metadata.UpdatedAt)
+	}
+	if metadata.ExpiresAt != nil {
+		t.Errorf("Expected no expiration, got %d", *metadata.ExpiresAt)
+	}
+
+	// The session id and the metadata can be chosen.
+	coordinates := infrastructure.GeoCoordinates{Latitude: 41.9, Longitude: 12.5}
+	opt := api.NewCreateSessionOptionsBuilder().
+		SessionId("chosen").
+		UnixExpiresAt(before + 60).
+		ClientGeoCoordinates(coordinates).
+		Build()
+	sessionId, err = cmd.CreateSession(ctx, opt)
+	expectNil(t, err)
+
+	if sessionId != "chosen" {
+		t.Errorf("Expected session id chosen, got %s", sessionId)
+	}
+
+	metadata, err = cmd.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+
+	if metadata.ExpiresAt == nil || *metadata.ExpiresAt != before+60 {
+		t.Errorf("Expected expiration %d, got %v", before+60, metadata.ExpiresAt)
+	}
+	if metadata.ClientGeoCoordinates == nil || *metadata.ClientGeoCoordinates != coordinates {
+		t.Errorf("Expected coordinates %v, got %v", coordinates, metadata.ClientGeoCoordinates)
+	}
+
+	// The session id must be unique.
+	_, err = cmd.CreateSession(ctx, api.NewCreateSessionOptionsBuilder().SessionId("chosen").Build())
+	expectError(t, err, api.ErrSessionIdAlreadyExists)
+
+	// Create and acquire a session.
+	sessionId, acquisitionId, err := cmd.CreateAndAcquireSession(ctx, api.DefaultCreateAndAcquireSessionOptions())
+	expectNil(t, err)
+
+	_, _, err = cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectError(t, err, api.ErrUnableToOffloadAcquiredSession)
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, acquisitionId)
+	expectNil(t, err)
+}
+
+func testAcquireSession(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	defaultOpt := api.DefaultAcquireSessionOptions()
+	allowOffloadingOpt := api.NewAcquireSessionOptionsBuilder().AllowOffloading().Build()
+
+	_, _, err := cmd.AcquireSession(ctx, "missing", defaultOpt)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	_, err = cmd.ReleaseSession(ctx, "missing", 1)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, cmd)
+
+	// A release needs an acquisition.
+	_, err = cmd.ReleaseSession(ctx, sessionId, 1)
+	expectError(t, err, api.ErrNoAcquisitionToRelease)
+
+	// Acquisitions have increasing ids.
+	acquisitionIds := make([]uint64, 0)
+	for i := 0; i < 2; i++ {
+		acquisitionId, offloadedTo, err := cmd.AcquireSession(ctx, sessionId, defaultOpt)
+		expectNil(t, err)
+
+		if offloadedTo != nil {
+			t.Errorf("Expected nil location, got %v", offloadedTo)
+		}
+		if acquisitionId == 0 || (i > 0 && acquisitionId <= acquisitionIds[i-1]) {
+			t.Errorf("Expected an increasing acquisition id, got %d after %v", acquisitionId, acquisitionIds)
+		}
+
+		acquisitionIds = append(acquisitionIds, acquisitionId)
+	}
+
+	// Each acquisition is released once.
+	for _, acquisitionId := range acquisitionIds {
+		_, err = cmd.ReleaseSession(ctx, sessionId, acquisitionId)
+		expectNil(t, err)
+	}
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, acquisitionIds[0])
+	expectError(t, err, api.ErrNoAcquisitionToRelease)
+
+	// An acquisition that allows offloading keeps the session offloadable.
+	allowOffloadingId, _, err := cmd.AcquireSession(ctx, sessionId, allowOffloadingOpt)
+	expectNil(t, err)
+
+	offloadable := scanAll(t, 10, cmd.ScanOffloadableSessions)
+	if !contains(offloadable, sessionId) {
+		t.Errorf("Expected %s to be offloadable, got %v", sessionId, offloadable)
+	}
+
+	acquisitionId, _, err := cmd.AcquireSession(ctx, sessionId, defaultOpt)
+	expectNil(t, err)
+
+	offloadable = scanAll(t, 10, cmd.ScanOffloadableSessions)
+	if contains(offloadable, sessionId) {
+		t.Errorf("Expected %s not to be offloadable, got %v", sessionId, offloadable)
+	}
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, acquisitionId)
+	expectNil(t, err)
+
+	_, _, err = cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, allowOffloadingId)
+	expectNil(t, err)
+}
+
+func testSessionLease(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	lease := 200 * time.Millisecond
+	leaseOpt := api.NewAcquireSessionOptionsBuilder().Lease(lease).Build()
+
+	err := cmd.RenewSessionLease(ctx, "missing", 1, lease)
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, cmd)
+	err = cmd.RenewSessionLease(ctx, sessionId, 1, lease)
+	expectError(t, err, api.ErrLeaseExpired)
+
+	// A renewed lease keeps the session acquired.
+	acquisitionId, _, err := cmd.AcquireSession(ctx, sessionId, leaseOpt)
+	expectNil(t, err)
+
+	time.Sleep(lease / 2)
+	expectNil(t, cmd.RenewSessionLease(ctx, sessionId, acquisitionId, lease))
+	time.Sleep(lease / 2)
+
+	_, _, err = cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectError(t, err, api.ErrUnableToOffloadAcquiredSession)
+
+	// Once expired, the acquisition is treated as released.
+	time.Sleep(lease)
+
+	offloadable := scanAll(t, 10, cmd.ScanOffloadableSessions)
+	if !contains(offloadable, sessionId) {
+		t.Errorf("Expected %s to be offloadable, got %v", sessionId, offloadable)
+	}
+
+	err = cmd.RenewSessionLease(ctx, sessionId, acquisitionId, lease)
+	expectError(t, err, api.ErrLeaseExpired)
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, acquisitionId)
+	expectError(t, err, api.ErrNoAcquisitionToRelease)
+
+	// An acquisition without lease gets one when renewed.
+	acquisitionId, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	expectNil(t, cmd.RenewSessionLease(ctx, sessionId, acquisitionId, lease))
+	time.Sleep(2 * lease)
+
+	_, _, err = cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+
+	// An expired session acquired with an expired lease is collected.
+	expiredId, err := cmd.CreateSession(ctx, api.NewCreateSessionOptionsBuilder().UnixExpiresAt(time.Now().Unix()-10).Build())
+	expectNil(t, err)
+
+	_, _, err = cmd.AcquireSession(ctx, expiredId, leaseOpt)
+	expectNil(t, err)
+	time.Sleep(2 * lease)
+
+	var cursor *string
+	for {
+		cursor, err = cmd.GarbageCollectSessions(ctx, api.DefaultGarbageCollectSessionsOptions(), cursor)
+		expectNil(t, err)
+
+		if cursor == nil {
+			break
+		}
+	}
+
+	_, err = cmd.GetSessionMetadata(ctx, expiredId)
+	expectError(t, err, api.ErrSessionNotFound)
+}
+
+func testAcquisitionModes(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	readOpt := api.NewAcquireSessionOptionsBuilder().Read().Build()
+	writeOpt := api.NewAcquireSessionOptionsBuilder().AllowOffloading().Write().Build()
+
+	sessionId := createSession(t, cmd)
+
+	// The readers share the session, and exclude the writers.
+	firstReader, _, err := cmd.AcquireSession(ctx, sessionId, readOpt)
+	expectNil(t, err)
+	secondReader, _, err := cmd.AcquireSession(ctx, sessionId, readOpt)
+	expectNil(t, err)
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, writeOpt)
+	expectError(t, err, api.ErrSessionIsAcquired)
+
+	// The unrestricted acquisitions coexist with the readers.
+	unrestricted, _, err := cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	_, err = cmd.ReleaseSession(ctx, sessionId, unrestricted)
+	expectNil(t, err)
+
+	// The readers do not block the offload.
+	offloadable := scanAll(t, 10, cmd.ScanOffloadableSessions)
+	if !contains(offloadable, sessionId) {
+		t.Errorf("Expected %s to be offloadable, got %v", sessionId, offloadable)
+	}
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, firstReader)
+	expectNil(t, err)
+	_, err = cmd.ReleaseSession(ctx, sessionId, secondReader)
+	expectNil(t, err)
+
+	// The writer excludes the readers, the other writers and the unrestricted
+	// acquisitions.
+	writer, _, err := cmd.AcquireSession(ctx, sessionId, writeOpt)
+	expectNil(t, err)
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, readOpt)
+	expectError(t, err, api.ErrSessionIsAcquired)
+	_, _, err = cmd.AcquireSession(ctx, sessionId, writeOpt)
+	expectError(t, err, api.ErrSessionIsAcquired)
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectError(t, err, api.ErrSessionIsAcquired)
+
+	// The writer blocks the offload, even if the options allow offloading.
+	_, _, err = cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectError(t, err, api.ErrUnableToOffloadAcquiredSession)
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, writer)
+	expectNil(t, err)
+
+	// The unrestricted acquisitions exclude the writers as well.
+	unrestricted, _, err = cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	_, _, err = cmd.AcquireSession(ctx, sessionId, writeOpt)
+	expectError(t, err, api.ErrSessionIsAcquired)
+	_, err = cmd.ReleaseSession(ctx, sessionId, unrestricted)
+	expectNil(t, err)
+
+	// While offloading, only the readers can be allowed.
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+	defer reader.Close()
+
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.NewAcquireSessionOptionsBuilder().Read().AllowWhileOffloading().Build())
+	expectNil(t, err)
+	_, _, err = cmd.AcquireSession(ctx, sessionId, api.NewAcquireSessionOptionsBuilder().Write().AllowWhileOffloading().Build())
+	expectError(t, err, api.ErrSessionIsOffloading)
+}
+
+func testFencingToken(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	edge1 := newCommands(t, factory, Edge1)
+	edge2 := newCommands(t, factory, Edge2)
+
+	sessionId := createSession(t, edge1)
+	fenced := func(fencingToken uint64) api.SessionMetadataOptions {
+		return api.NewSessionMetadataOptionsBuilder().FencingToken(fencingToken).Build()
+	}
+
+	first, _, err := edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	second, _, err := edge1.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+
+	// The writes of live acquisitions are accepted.
+	expectNil(t, edge1.SetSessionMetadata(ctx, sessionId, fenced(first)))
+	expectNil(t, edge1.SetSessionMetadata(ctx, sessionId, fenced(second)))
+
+	data1, hasData := edge1.(SessionDataCommands)
+	if hasData {
+		expectNil(t, data1.SetSessionData(ctx, sessionId, first, "key", []byte("first")))
+	}
+
+	// The writes of released acquisitions are rejected.
+	_, err = edge1.ReleaseSession(ctx, sessionId, first)
+	expectNil(t, err)
+
+	err = edge1.SetSessionMetadata(ctx, sessionId, fenced(first))
+	expectError(t, err, api.ErrStaleFencingToken)
+	err = edge1.SetSessionMetadata(ctx, sessionId, fenced(second+1))
+	expectError(t, err, api.ErrStaleFencingToken)
+
+	if hasData {
+		err = data1.SetSessionData(ctx, sessionId, first, "key", []byte("stale"))
+		expectError(t, err, api.ErrStaleFencingToken)
+		expectNil(t, data1.SetSessionData(ctx, sessionId, second, "key", []byte("second")))
+	}
+
+	metadata, err := edge1.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+	if metadata.FencingToken != second {
+		t.Errorf("Expected fencing token %d, got %d", second, metadata.FencingToken)
+	}
+
+	_, err = edge1.ReleaseSession(ctx, sessionId, second)
+	expectNil(t, err)
+
+	// The fencing tokens keep increasing across nodes.
+	newLocation := offload(t, edge1, Edge1.Host, edge2, Edge2.Host, sessionId, func(ctx context.Context, oldLocation api.SessionLocation) (bool, error) {
+		t.Errorf("Unexpected notification of %v", oldLocation)
+		return false, nil
+	})
+
+	third, _, err := edge2.AcquireSession(ctx, newLocation.SessionId, api.NewAcquireSessionOptionsBuilder().AllowOffloading().Build())
+	expectNil(t, err)
+	if third <= second {
+		t.Errorf("Expected a fencing token greater than %d, got %d", second, third)
+	}
+
+	// An acquisition does not survive the offload of the session.
+	backLocation := offload(t, edge2, Edge2.Host, edge1, Edge1.Host, newLocation.SessionId, func(ctx context.Context, oldLocation api.SessionLocation) (bool, error) {
+		t.Errorf("Unexpected notification of %v", oldLocation)
+		return false, nil
+	})
+
+	err = edge2.SetSessionMetadata(ctx, newLocation.SessionId, fenced(third))
+	expectError(t, err, api.ErrStaleFencingToken)
+
+	fourth, _, err := edge1.AcquireSession(ctx, backLocation.SessionId, api.DefaultAcquireSessionOptions())
+	expectNil(t, err)
+	if fourth <= third {
+		t.Errorf("Expected a fencing token greater than %d, got %d", third, fourth)
+	}
+
+	// The data offloaded before a later offload cannot be onloaded.
+	opt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation(Edge1.Host, sessionId)).Build()
+	_, err = edge2.OnloadSession(ctx, metadata, emptyReader{}, opt)
+	expectError(t, err, api.ErrStaleFencingToken)
+}
+
+func testReadModeWrite(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	sessionId := createSession(t, cmd)
+	reader, _, err := cmd.AcquireSession(ctx, sessionId, api.NewAcquireSessionOptionsBuilder().Mode(api.AcquisitionModeRead).Build())
+	expectNil(t, err)
+
+	// The writes carrying the fencing token of a reader are rejected.
+	err = cmd.SetSessionMetadata(ctx, sessionId, api.NewSessionMetadataOptionsBuilder().FencingToken(reader).Build())
+	expectError(t, err, api.ErrAcquisitionIsReadOnly)
+
+	data, hasData := cmd.(SessionDataCommands)
+	if hasData {
+		err = data.SetSessionData(ctx, sessionId, reader, "key", []byte("value"))
+		expectError(t, err, api.ErrAcquisitionIsReadOnly)
+		err = data.DeleteSessionData(ctx, sessionId, reader, "key")
+		expectError(t, err, api.ErrAcquisitionIsReadOnly)
+	}
+
+	_, err = cmd.ReleaseSession(ctx, sessionId, reader)
+	expectNil(t, err)
+
+	// The writes of the other modes are accepted.
+	for _, mode := range []api.AcquisitionMode{api.AcquisitionModeUnrestricted, api.AcquisitionModeWrite} {
+		fencingToken, _, err := cmd.AcquireSession(ctx, sessionId, api.NewAcquireSessionOptionsBuilder().Mode(mode).Build())
+		expectNil(t, err)
+
+		expectNil(t, cmd.SetSessionMetadata(ctx, sessionId, api.NewSessionMetadataOptionsBuilder().FencingToken(fencingToken).Build()))
+		if hasData {
+			expectNil(t, data.SetSessionData(ctx, sessionId, fencingToken, "key", []byte("value")))
+		}
+
+		_, err = cmd.ReleaseSession(ctx, sessionId, fencingToken)
+		expectNil(t, err)
+	}
+}
+
+func testFencedWriteWhileOffloading(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	sessionId := createSession(t, cmd)
+	fencingToken, _, err := cmd.AcquireSession(ctx, sessionId, api.NewAcquireSessionOptionsBuilder().AllowOffloading().Build())
+	expectNil(t, err)
+
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+	reader.Close()
+
+	// The writes would be lost once the offload is confirmed.
+	opt := api.NewSessionMetadataOptionsBuilder().FencingToken(fencingToken).Build()
+	err = cmd.SetSessionMetadata(ctx, sessionId, opt)
+	expectError(t, err, api.ErrSessionIsOffloading)
+
+	data, hasData := cmd.(SessionDataCommands)
+	if hasData {
+		err = data.SetSessionData(ctx, sessionId, fencingToken, "key", []byte("lost"))
+		expectError(t, err, api.ErrSessionIsOffloading)
+		err = data.DeleteSessionData(ctx, sessionId, fencingToken, "key")
+		expectError(t, err, api.ErrSessionIsOffloading)
+	}
+
+	// The writes are accepted again once the offload is aborted.
+	expectNil(t, cmd.AbortSessionOffload(ctx, sessionId))
+	expectNil(t, cmd.SetSessionMetadata(ctx, sessionId, opt))
+	if hasData {
+		expectNil(t, data.SetSessionData(ctx, sessionId, fencingToken, "key", []byte("kept")))
+	}
+}
+
+func testSessionMetadata(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	_, err := cmd.GetSessionMetadata(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	err = cmd.SetSessionMetadata(ctx, "missing", api.DefaultSessionMetadataOptions())
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, cmd)
+	coordinates := infrastructure.GeoCoordinates{Latitude: 41.9, Longitude: 12.5}
+	expiresAt := time.Now().Unix() + 3600
+
+	opt := api.NewSessionMetadataOptionsBuilder().
+		ClientGeoCoordinates(coordinates).
+		UnixExpiresAt(expiresAt).
+		Build()
+	expectNil(t, cmd.SetSessionMetadata(ctx, sessionId, opt))
+
+	metadata, err := cmd.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+
+	if metadata.ClientGeoCoordinates == nil || *metadata.ClientGeoCoordinates != coordinates {
+		t.Errorf("Expected coordinates %v, got %v", coordinates, metadata.ClientGeoCoordinates)
+	}
+	if metadata.ExpiresAt == nil || *metadata.ExpiresAt != expiresAt {
+		t.Errorf("Expected expiration %d, got %v", expiresAt, metadata.ExpiresAt)
+	}
+
+	// Marking the session as expired overrides the expiration time.
+	opt = api.NewSessionMetadataOptionsBuilder().UnixExpiresAt(expiresAt).MarkExpired().Build()
+	expectNil(t, cmd.SetSessionMetadata(ctx, sessionId, opt))
+
+	metadata, err = cmd.GetSessionMetadata(ctx, sessionId)
+	expectNil(t, err)
+
+	if metadata.ExpiresAt == nil || *metadata.ExpiresAt > time.Now().Unix() {
+		t.Errorf("Expected the session to be expired, got %v", metadata.ExpiresAt)
+	}
+}
+
+func testSessionSize(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	_, err := cmd.GetSessionSize(ctx, "missing")
+	expectError(t, err, api.ErrSessionNotFound)
+
+	sessionId := createSession(t, cmd)
+	size, err := cmd.GetSessionSize(ctx, sessionId)
+	expectNil(t, err)
+
+	if size != 0 {
+		t.Errorf("Expected an empty session, got %d bytes", size)
+	}
+
+	data, hasData := cmd.(SessionDataCommands)
+	if !hasData {
+		return
+	}
+
+	expectNil(t, data.SetSessionData(ctx, sessionId, 0, "key", []byte("value")))
+	size, err = cmd.GetSessionSize(ctx, sessionId)
+	expectNil(t, err)
+
+	// The size is the one of the stream of an offload.
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	expectNil(t, err)
+	if loader != nil {
+		go loader()
+	}
+
+	stream, err := io.ReadAll(reader)
+	expectNil(t, err)
+	reader.Close()
+	expectNil(t, cmd.AbortSessionOffload(ctx, sessionId))
+
+	if size == 0 || size != int64(len(stream)) {
+		t.Errorf("Expected %d bytes, got %d", len(stream), size)
+	}
+}
+
+func testScan(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+
+	_, _, err := cmd.ScanSessions(ctx, 0, 0)
+	expectError(t, err, api.ErrInvalidCount)
+
+	_, _, err = cmd.ScanOffloadableSessions(ctx, 0, -1)
+	expectError(t, err, api.ErrInvalidCount)
+
+	_, _, err = cmd.ScanOffloadedSessions(ctx, 0, 0)
+	expectError(t, err, api.ErrInvalidCount)
+
+	_, _, err = cmd.ScanSessions(ctx, 1<<62, 10)
+	expectError(t, err, api.ErrInvalidCursor)
+
+	// An empty scan completes immediately.
+	ids, newCursor, err := cmd.ScanSessions(ctx, 0, 10)
+	expectNil(t, err)
+	if len(ids) != 0 || newCursor != 0 {
+		t.Errorf("Expected an empty completed scan, got %v and cursor %d", ids, newCursor)
+	}
+
+	created := make(map[string]bool)
+	for i := 0; i < 25; i++ {
+		created[createSession(t, cmd)] = true
+	}
+
+	// Every session is returned exactly once.
+	for _, count := range []int64{1, 7, 25, 100} {
+		scanned := scanAll(t, count, cmd.ScanSessions)
+		if len(scanned) != len(created) {
+			t.Errorf("Expected %d sessions with count %d, got %d", len(created), count, len(scanned))
+		}
+
+		seen := make(map[string]bool)
+		for _, id := range scanned {
+			if !created[id] || seen[id] {
+				t.Errorf("Unexpected session %s with count %d", id, count)
+			}
+			seen[id] = true
+		}
+	}
+
+	// Sessions created during a scan do not break it.
+	ids, cursor, err := cmd.ScanSessions(ctx, 0, 10)
+	expectNil(t, err)
+	createSession(t, cmd)
+	for cursor != 0 {
+		var page []string
+		page, cursor, err = cmd.ScanSessions(ctx, cursor, 10)
+		expectNil(t, err)
+		ids = append(ids, page...)
+	}
+
+	for id := range created {
+		if !contains(ids, id) {
+			t.Errorf("Expected %s to be scanned", id)
+		}
+	}
+}
+
+func testGarbageCollectSessions(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	cmd := newCommands(t, factory, Edge1)
+	now := time.Now().Unix()
+
+	create := func(expiresAt int64) string {
+		sessionId, err := cmd.CreateSession(ctx, api.NewCreateSessionOptionsBuilder().UnixExpiresAt(expiresAt).Build())
+		expectNil(t, err)
+		return sessionId
+	}
+
+	expired := create(now - 10)
+	alive := createSession(t, cmd)
+	notExpired := create(now + 3600)
+	expiredAcquired := create(now - 10)
+	longExpiredAcquired := create(now - 3600)
+
+	for _, sessionId := range []string{expiredAcquired, longExpiredAcquired} {
+		_, _, err := cmd.AcquireSession(ctx, sessionId, api.DefaultAcquireSessionOptions())
+		expectNil(t, err)
+	}
+
+	collect := func(opt api.GarbageCollectSessionsOptions) {
+		var cursor *string
+		for {
+			var err error
+			cursor, err = cmd.GarbageCollectSessions(ctx, opt, cursor)
+			expectNil(t, err)
+
+			if cursor == nil {
+				return
+			}
+		}
+	}
+
+	exists := func(sessionId string) bool {
+		_, err := cmd.GetSessionMetadata(ctx, sessionId)
+		return err == nil
+	}
+
+	collect(api.DefaultGarbageCollectSessionsOptions())
+
+	if exists(expired) {
+		t.Errorf("Expected %s to be collected", expired)
+	}
+	for _, sessionId := range []string{alive, notExpired, expiredAcquired, longExpiredAcquired} {
+		if !exists(sessionId) {
+			t.Errorf("Expected %s not to be collected", sessionId)
+		}
+	}
+
+	// Collect the acquired sessions expired more than a minute ago.
+	collect(api.NewGarbageCollectSessionsOptionsBuilder().CollectExpiredButUnreleasedOlderThan(time.Minute).Build())
+
+	if exists(longExpiredAcquired) {
+		t.Errorf("Expected %s to be collected", longExpiredAcquired)
+	}
+	if !exists(expiredAcquired) {
+		t.Errorf("Expected %s not to be collected", expiredAcquired)
+	}
+}
@@ -0,0 +1,76 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WriteField writes a length-prefixed field.
+func WriteField(w io.Writer, field []byte) error {
+	var length [binary.MaxVarintLen64]byte
+	if _, err := w.Write(length[:binary.PutUvarint(length[:], uint64(len(field)))]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(field)
+	return err
+}
+
+// MaxFieldSize is the maximum length of a field read by ReadField, the maximum
+// size of a Redis string.
+const MaxFieldSize = 512 * 1024 * 1024
+
+// ErrFieldTooLarge is returned when the length prefix of a field exceeds
+// MaxFieldSize.
+var ErrFieldTooLarge = errors.New("framed field too large")
+
+// ReadField reads a length-prefixed field. A clean io.EOF is returned only if
+// the stream ends before the field. The length comes from the stream, so the
+// field grows as its bytes are read instead of being allocated upfront.
+func ReadField(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > MaxFieldSize {
+		return nil, ErrFieldTooLarge
+	}
+
+	var field bytes.Buffer
+	field.Grow(int(min(length, bufferedFieldSize)))
+	if n, err := io.CopyN(&field, r, int64(length)); err != nil || n != int64(length) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, UnexpectedEOF(err)
+	}
+
+	return field.Bytes(), nil
+}
+
+// The bytes of a field allocated before they are read.
+const bufferedFieldSize = 64 * 1024
+
+// Counter is a writer that counts the bytes written to it.
+type Counter int64
+
+// Write counts the bytes.
+func (c *Counter) Write(p []byte) (int, error) {
+	*c += Counter(len(p))
+	return len(p), nil
+}
+
+// UnexpectedEOF turns an io.EOF in the middle of a record into
+// io.ErrUnexpectedEOF.
+func UnexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"sort"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// BestSessions returns the sessions with the highest resources usage, at most
+// maxSessions.
+func BestSessions(
+	candidates map[string]api.SessionInfoForOffloadDecision,
+	maxSessions int,
+) map[string]api.SessionInfoForOffloadDecision {
+	sessions := make(map[string]api.SessionInfoForOffloadDecision)
+	for _, id := range SortByResourcesUsage(candidates) {
+		if len(sessions) == maxSessions {
+			break
+		}
+
+		sessions[id] = candidates[id]
+	}
+
+	return sessions
+}
+
+// BestTargets matches each session with the nodes that have enough free
+// resources to host it, the closest to the client first (or to the origin node
+// if the client coordinates are unknown). The result lists the first choice of
+// every session, then the second one and so on, at most maxTargets pairs. The
+// usages map holds the known resources usage of the candidate nodes.
+func BestTargets(
+	origin infrastructure.Node,
+	nodes map[string]infrastructure.Node,
+	usages map[string]api.ResourcesUsage,
+	sessions map[string]api.SessionInfoForOffloadDecision,
+	maxTargets int,
+) [][2]string {
+	// Rank the candidate nodes of each session.
+	ids := SortByResourcesUsage(sessions)
+	rankings := make([][]string, len(ids))
+	for i, id := range ids {
+		info := sessions[id]
+		from := origin.GeoCoordinates
+		if info.Metadata.ClientGeoCoordinates != nil {
+			from = *info.Metadata.ClientGeoCoordinates
+		}
+
+		for host, usage := range usages {
+			if host != origin.Host && Fits(nodes[host], usage, info.ResourcesUsage) {
+				rankings[i] = append(rankings[i], host)
+			}
+		}
+
+		sort.Slice(rankings[i], func(a, b int) bool {
+			nodeA, nodeB := nodes[rankings[i][a]], nodes[rankings[i][b]]
+			distanceA, distanceB := from.DistanceTo(nodeA.GeoCoordinates), from.DistanceTo(nodeB.GeoCoordinates)
+			if distanceA != distanceB {
+				return distanceA < distanceB
+			}
+
+			return nodeA.Host < nodeB.Host
+		})
+	}
+
+	// Interleave the rankings by priority.
+	targets := make([][2]string, 0)
+	for rank := 0; len(targets) < maxTargets; rank++ {
+		added := false
+		for i, id := range ids {
+			if rank < len(rankings[i]) && len(targets) < maxTargets {
+				targets = append(targets, [2]string{id, rankings[i][rank]})
+				added = true
+			}
+		}
+
+		if !added {
+			break
+		}
+	}
+
+	return targets
+}
+
+// Fits returns true if the node has enough free resources to host the session.
+// A resource with a negative or missing capacity is unbounded.
+func Fits(node infrastructure.Node, nodeUsage api.ResourcesUsage, sessionUsage api.ResourcesUsage) bool {
+	for resource, value := range sessionUsage {
+		capacity, ok := node.Resources[resource]
+		if ok && capacity >= 0 && nodeUsage[resource]+value > capacity {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SortByResourcesUsage sorts the session ids by decreasing total resources
+// usage, then by id.
+func SortByResourcesUsage(sessions map[string]api.SessionInfoForOffloadDecision) []string {
+	totals := make(map[string]float64, len(sessions))
+	ids := make([]string, 0, len(sessions))
+	for id, info := range sessions {
+		for _, value := range info.ResourcesUsage {
+			totals[id] += value
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if totals[ids[i]] != totals[ids[j]] {
+			return totals[ids[i]] > totals[ids[j]]
+		}
+
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
@@ -0,0 +1,78 @@
+package topology
+
+import "github.com/ermes-labs/api-go/infrastructure"
+
+// Tree is the tree of nodes of an infrastructure.
+type Tree struct {
+	// The nodes by host.
+	Nodes map[string]infrastructure.Node
+	// The host of the parent of each node, roots have no entry.
+	Parents map[string]string
+	// The hosts of the children of each node.
+	Children map[string][]string
+}
+
+// NewTree builds the tree of the given infrastructure. The node that owns the
+// commands is always part of the tree, even if it is not in the infrastructure
+// or no infrastructure has been loaded (infra is nil).
+func NewTree(self infrastructure.Node, infra *infrastructure.Infrastructure) *Tree {
+	t := &Tree{
+		Nodes:    map[string]infrastructure.Node{self.Host: self},
+		Parents:  make(map[string]string),
+		Children: make(map[string][]string),
+	}
+
+	if infra == nil {
+		return t
+	}
+
+	var load func(area infrastructure.Area, parent string)
+	load = func(area infrastructure.Area, parent string) {
+		t.Nodes[area.Host] = area.Node
+		if parent != "" {
+			t.Parents[area.Host] = parent
+			t.Children[parent] = append(t.Children[parent], area.Host)
+		}
+
+		for _, subArea := range area.Areas {
+			load(subArea, area.Host)
+		}
+	}
+
+	for _, area := range infra.Areas {
+		load(area, "")
+	}
+
+	return t
+}
+
+// Get the parent node of a node, nil if the node is a root. The second value
+// is false if the node is not part of the tree.
+func (t *Tree) Parent(nodeId string) (*infrastructure.Node, bool) {
+	if _, ok := t.Nodes[nodeId]; !ok {
+		return nil, false
+	}
+
+	parent, ok := t.Parents[nodeId]
+	if !ok {
+		return nil, true
+	}
+
+	node := t.Nodes[parent]
+	return &node, true
+}
+
+// Get the children nodes of a node. The second value is false if the node is
+// not part of the tree.
+func (t *Tree) ChildrenOf(nodeId string) ([]infrastructure.Node, bool) {
+	if _, ok := t.Nodes[nodeId]; !ok {
+		return nil, false
+	}
+
+	children := make([]infrastructure.Node, 0, len(t.Children[nodeId]))
+	for _, child := range t.Children[nodeId] {
+		children = append(children, t.Nodes[child])
+	}
+
+	return children, true
+}
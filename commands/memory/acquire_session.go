@@ -0,0 +1,129 @@
+package memory_commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Acquires a session. If the session has been offloaded it returns the new
+// location of the session without acquiring it.
+func (c *Commands) AcquireSession(
+	ctx context.Context,
+	sessionId string,
+	opt api.AcquireSessionOptions,
+) (acquisitionId uint64, offloadedTo *api.SessionLocation, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.acquireSession(sessionId, opt)
+}
+
+// Acquires a session. The caller must hold the lock.
+func (c *Commands) acquireSession(
+	sessionId string,
+	opt api.AcquireSessionOptions,
+) (uint64, *api.SessionLocation, error) {
+	s, ok := c.sessions[sessionId]
+	if !ok {
+		return 0, nil, api.ErrSessionNotFound
+	}
+
+	// If the session has been offloaded, the client is being redirected.
+	if s.offloadedTo != nil {
+		s.clientRedirected = true
+		return 0, copyLocation(s.offloadedTo), nil
+	}
+
+	if s.offloading && !opt.AllowWhileOffloading() {
+		return 0, nil, api.ErrSessionIsOffloading
+	}
+
+	s.expireLeases(nowMillis())
+	if s.conflicts(opt.Mode(), nowMillis()) {
+		return 0, nil, api.ErrSessionIsAcquired
+	}
+
+	s.lastAcquisitionId++
+	s.acquisitions[s.lastAcquisitionId] = &acquisition{
+		mode:            opt.Mode(),
+		allowOffloading: opt.AllowOffloading(),
+		leaseExpiresAt:  leaseExpiresAt(opt.LeaseDuration()),
+	}
+
+	// The client reached this node.
+	s.lastVisited = nil
+
+	return s.lastAcquisitionId, nil, nil
+}
+
+// Releases a previously acquired session. If the session has been offloaded
+// while acquired it returns the new location of the session.
+func (c *Commands) ReleaseSession(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+) (*api.SessionLocation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[sessionId]
+	if !ok {
+		return nil, api.ErrSessionNotFound
+	}
+
+	s.expireLeases(nowMillis())
+	if _, ok := s.acquisitions[acquisitionId]; !ok {
+		return nil, api.ErrNoAcquisitionToRelease
+	}
+
+	delete(s.acquisitions, acquisitionId)
+	s.metadata.UpdatedAt = now()
+
+	return copyLocation(s.offloadedTo), nil
+}
+
+// Renews the lease of an acquisition.
+func (c *Commands) RenewSessionLease(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+	leaseDuration time.Duration,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[sessionId]
+	if !ok {
+		return api.ErrSessionNotFound
+	}
+
+	s.expireLeases(nowMillis())
+	a, ok := s.acquisitions[acquisitionId]
+	if !ok {
+		return api.ErrLeaseExpired
+	}
+
+	a.leaseExpiresAt = leaseExpiresAt(leaseDuration)
+	return nil
+}
+
+// Returns the sessions that are neither offloading, offloaded nor acquired
+// with acquisitions that do not allow offloading.
+func (c *Commands) ScanOffloadableSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.scan(cursor, count, isOffloadable)
+}
+
+// Returns true if the session can be offloaded.
+func isOffloadable(s *session) bool {
+	_, blocking := s.liveAcquisitions(nowMillis())
+	return s.offloadedTo == nil && !s.offloading && blocking == 0
+}
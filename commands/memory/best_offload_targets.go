@@ -0,0 +1,75 @@
+package memory_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/policy"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Return the offloadable sessions with the highest resources usage, at most
+// opt.MaxTargets.
+func (c *Commands) BestSessionsToOffload(
+	ctx context.Context,
+	opt api.BestOffloadTargetsOptions,
+) (sessions map[string]api.SessionInfoForOffloadDecision, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	candidates := make(map[string]api.SessionInfoForOffloadDecision)
+	for id, s := range c.sessions {
+		if isOffloadable(s) {
+			candidates[id] = api.SessionInfoForOffloadDecision{
+				Metadata:       copyMetadata(s.metadata),
+				ResourcesUsage: copyResourcesUsage(s.resourcesUsage),
+			}
+		}
+	}
+
+	return policy.BestSessions(candidates, opt.MaxTargets), nil
+}
+
+// Return the best offload targets for the sessions of the given node, see
+// policy.BestTargets.
+func (c *Commands) BestOffloadTargetNodes(
+	ctx context.Context,
+	nodeId string,
+	sessions map[string]api.SessionInfoForOffloadDecision,
+	opt api.BestOffloadTargetsOptions,
+) ([][2]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	origin, ok := c.tree.Nodes[nodeId]
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	// The known resources usage of the candidate nodes.
+	usages := make(map[string]api.ResourcesUsage)
+	for host := range c.tree.Nodes {
+		if host == nodeId {
+			continue
+		}
+
+		if host == c.node.Host {
+			_, usages[host] = c.localUsage()
+		} else if usage, ok := c.nodesUsage[host]; ok {
+			usages[host] = copyResourcesUsage(usage.resourcesUsage)
+		} else {
+			usages[host] = make(api.ResourcesUsage)
+		}
+	}
+
+	return policy.BestTargets(origin, c.tree.Nodes, usages, sessions, opt.MaxTargets), nil
+}
+
+// Get the lookup node for a session offloading. The in-memory commands hold the
+// whole infrastructure, so the node itself is the lookup node.
+func (c *Commands) FindLookupNode(
+	ctx context.Context,
+	sessionIds []string,
+) (node infrastructure.Node, err error) {
+	return c.node, nil
+}
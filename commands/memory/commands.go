@@ -0,0 +1,242 @@
+package memory_commands
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/topology"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Commands is an in-memory, concurrency-safe implementation of api.Commands.
+// All the state lives in the process memory, it is meant to be used in tests
+// and in single-process deployments.
+type Commands struct {
+	// The mutex that protects the state.
+	mu sync.Mutex
+	// The node that owns the commands.
+	node infrastructure.Node
+	// The sessions stored in the node, including the offloaded ones.
+	sessions map[string]*session
+	// The pending onloads by session id, invisible until committed. A tombstone
+	// replaced by a pending onload stays in sessions until the commit.
+	onloading map[string]*session
+	// The offload journal by session id.
+	journal map[string]*api.OffloadJournalEntry
+	// The last sequence number assigned to a session, used as scan cursor.
+	seq uint64
+	// The tree of the loaded infrastructure.
+	tree *topology.Tree
+	// The resources usage of the other nodes, as reported by the children.
+	nodesUsage map[string]*nodeUsage
+}
+
+// Assert that Commands implements api.Commands.
+var _ api.Commands = (*Commands)(nil)
+
+// The state of a session.
+type session struct {
+	// The sequence number of the session.
+	seq uint64
+	// The metadata of the session.
+	metadata api.SessionMetadata
+	// The data of the session.
+	data map[string][]byte
+	// The resources usage of the session.
+	resourcesUsage api.ResourcesUsage
+	// The active acquisitions by id, possibly with an expired lease.
+	acquisitions map[uint64]*acquisition
+	// The id of the last acquisition of the session.
+	lastAcquisitionId uint64
+	// True if the offload of the session has been started.
+	offloading bool
+	// The location of the session once offloaded, nil if not offloaded.
+	offloadedTo *api.SessionLocation
+	// True if a client has been redirected to offloadedTo.
+	clientRedirected bool
+	// The location last visited by the client, nil if the client already
+	// visited this node.
+	lastVisited *api.SessionLocation
+}
+
+// An acquisition of a session.
+type acquisition struct {
+	// The mode of the acquisition.
+	mode api.AcquisitionMode
+	// True if the acquisition allows offloading.
+	allowOffloading bool
+	// The expiration of the lease as a Unix timestamp in milliseconds, 0 if the
+	// acquisition has no lease.
+	leaseExpiresAt int64
+}
+
+// Returns true if the lease of the acquisition expired.
+func (a *acquisition) expired(nowMillis int64) bool {
+	return a.leaseExpiresAt != 0 && a.leaseExpiresAt <= nowMillis
+}
+
+// Delete the acquisitions whose lease expired, that are treated as released.
+func (s *session) expireLeases(nowMillis int64) {
+	for id, a := range s.acquisitions {
+		if a.expired(nowMillis) {
+			delete(s.acquisitions, id)
+		}
+	}
+}
+
+// Returns the number of the acquisitions whose lease did not expire, and of
+// those that do not allow offloading.
+func (s *session) liveAcquisitions(nowMillis int64) (live int, blocking int) {
+	for _, a := range s.acquisitions {
+		if !a.expired(nowMillis) {
+			live++
+			if !a.allowOffloading {
+				blocking++
+			}
+		}
+	}
+
+	return live, blocking
+}
+
+// Returns true if the acquisitions whose lease did not expire conflict with an
+// acquisition in the given mode.
+func (s *session) conflicts(mode api.AcquisitionMode, nowMillis int64) bool {
+	for _, a := range s.acquisitions {
+		if a.expired(nowMillis) {
+			continue
+		}
+
+		if a.mode == api.AcquisitionModeWrite || mode == api.AcquisitionModeWrite {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The resources usage of a node.
+type nodeUsage struct {
+	// The number of sessions.
+	sessions uint
+	// The resources usage.
+	resourcesUsage api.ResourcesUsage
+}
+
+// NewCommands creates a new in-memory Commands owned by the given node.
+func NewCommands(node infrastructure.Node) *Commands {
+	return &Commands{
+		node:       node,
+		sessions:   make(map[string]*session),
+		onloading:  make(map[string]*session),
+		journal:    make(map[string]*api.OffloadJournalEntry),
+		tree:       topology.NewTree(node, nil),
+		nodesUsage: make(map[string]*nodeUsage),
+	}
+}
+
+// Returns the session with the given id if it is stored and not offloaded.
+// The caller must hold the lock.
+func (c *Commands) liveSession(sessionId string) (*session, error) {
+	s, ok := c.sessions[sessionId]
+	if !ok || s.offloadedTo != nil {
+		return nil, api.ErrSessionNotFound
+	}
+
+	return s, nil
+}
+
+// Returns the session with the given id if it is stored and not offloaded, and
+// the fencing token is the id of one of its acquisitions whose lease did not
+// expire and that is not in read mode. A fencing token of 0 is not checked. A
+// fenced write is rejected while the session is offloading, since it would be
+// lost once the offload is confirmed. The caller must hold the lock.
+func (c *Commands) fencedSession(sessionId string, fencingToken uint64) (*session, error) {
+	if fencingToken == 0 {
+		return c.liveSession(sessionId)
+	}
+
+	s, ok := c.sessions[sessionId]
+	if !ok {
+		return nil, api.ErrSessionNotFound
+	}
+
+	// The writer did not notice that the session has been offloaded.
+	if s.offloadedTo != nil {
+		return nil, api.ErrStaleFencingToken
+	}
+
+	if s.offloading {
+		return nil, api.ErrSessionIsOffloading
+	}
+
+	s.expireLeases(nowMillis())
+	a, ok := s.acquisitions[fencingToken]
+	if !ok {
+		return nil, api.ErrStaleFencingToken
+	}
+
+	if a.mode == api.AcquisitionModeRead {
+		return nil, api.ErrAcquisitionIsReadOnly
+	}
+
+	return s, nil
+}
+
+// Returns the next sequence number. The caller must hold the lock.
+func (c *Commands) nextSeq() uint64 {
+	c.seq++
+	return c.seq
+}
+
+// Generate a new random session id.
+func newSessionId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// The current time as a Unix timestamp (UTC).
+func now() int64 {
+	return time.Now().Unix()
+}
+
+// The current time as a Unix timestamp in milliseconds, used for the leases.
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// The expiration of a lease of the given duration from now, 0 if no duration.
+func leaseExpiresAt(leaseDuration time.Duration) int64 {
+	if leaseDuration <= 0 {
+		return 0
+	}
+
+	return nowMillis() + leaseDuration.Milliseconds()
+}
+
+// Copy a location.
+func copyLocation(location *api.SessionLocation) *api.SessionLocation {
+	if location == nil {
+		return nil
+	}
+
+	copied := *location
+	return &copied
+}
+
+// Copy the resources usage.
+func copyResourcesUsage(resourcesUsage api.ResourcesUsage) api.ResourcesUsage {
+	copied := make(api.ResourcesUsage, len(resourcesUsage))
+	for resource, value := range resourcesUsage {
+		copied[resource] = value
+	}
+
+	return copied
+}
@@ -0,0 +1,265 @@
+package memory_commands_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+func newNode(host string) (*api.Node, *memory_commands.Commands) {
+	cmd := memory_commands.NewCommands(infrastructure.Node{AreaName: host, Host: host})
+	return api.NewNode(infrastructure.Node{AreaName: host, Host: host}, cmd), cmd
+}
+
+func TestOffloadSessionBetweenNodes(t *testing.T) {
+	ctx := context.Background()
+	n1, cmd1 := newNode("n1")
+	n2, cmd2 := newNode("n2")
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if err := cmd1.SetSessionData(ctx, sessionToken.SessionId, 0, "key", []byte("value")); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	newLocation, err := n1.OffloadSession(
+		ctx,
+		sessionToken.SessionId,
+		api.DefaultOffloadSessionOptions(),
+		api.LocalOffloadTarget{Node: n2},
+		func(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+			t.Errorf("Unexpected notification of %v", lastVisitedLocation)
+			return false, nil
+		})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if newLocation != api.NewSessionLocation("n2", sessionToken.SessionId) {
+		t.Errorf("Expected the session to keep its id on n2, got %v", newLocation)
+	}
+
+	value, err := cmd2.GetSessionData(ctx, newLocation.SessionId, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+
+	// Acquiring the session on n1 redirects to n2 without running the callback.
+	newToken, err := n1.AcquireSession(ctx, sessionToken, api.DefaultAcquireSessionOptions(), func(uint64) error {
+		t.Errorf("Unexpected acquisition of an offloaded session")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	} else if newToken == nil || newToken.SessionLocation != newLocation {
+		t.Errorf("Expected token for %v, got %v", newLocation, newToken)
+	}
+
+	if _, err := cmd1.GetSessionData(ctx, sessionToken.SessionId, "key"); !errors.Is(err, api.ErrSessionNotFound) {
+		t.Errorf("Expected error %v, got %v", api.ErrSessionNotFound, err)
+	}
+}
+
+func TestAcquiredSessionIsNotOffloadable(t *testing.T) {
+	ctx := context.Background()
+	n1, cmd1 := newNode("n1")
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	_, err = n1.AcquireSession(ctx, sessionToken, api.DefaultAcquireSessionOptions(), func(uint64) error {
+		_, _, err := cmd1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions())
+		return err
+	})
+	if !errors.Is(err, api.ErrUnableToOffloadAcquiredSession) {
+		t.Errorf("Expected error %v, got %v", api.ErrUnableToOffloadAcquiredSession, err)
+	}
+
+	// Once released the session can be offloaded.
+	if _, _, err := cmd1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions()); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+func TestAcquireWaitsWhileOffloading(t *testing.T) {
+	ctx := context.Background()
+	n1, cmd1 := newNode("n1")
+	opt := api.NewAcquireSessionOptionsBuilder().WaitWhileOffloading(10 * time.Millisecond).Build()
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, _, err := cmd1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions()); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The wait is bounded by the context.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = n1.AcquireSession(timeoutCtx, sessionToken, opt, func(uint64) error {
+		t.Errorf("Unexpected acquisition of an offloading session")
+		return nil
+	})
+	if !errors.Is(err, api.ErrSessionIsOffloading) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error %v, got %v", api.ErrSessionIsOffloading, err)
+	}
+
+	// Once the offload completes, the new location is returned.
+	newLocation := api.NewSessionLocation("n2", sessionToken.SessionId)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cmd1.ConfirmSessionOffload(ctx, sessionToken.SessionId, newLocation, api.DefaultOffloadSessionOptions(), nil)
+	}()
+
+	newToken, err := n1.AcquireSession(ctx, sessionToken, opt, func(uint64) error {
+		t.Errorf("Unexpected acquisition of an offloaded session")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	} else if newToken == nil || newToken.SessionLocation != newLocation {
+		t.Errorf("Expected token for %v, got %v", newLocation, newToken)
+	}
+}
+
+// An offload target that fails on demand.
+type faultyTarget struct {
+	api.LocalOffloadTarget
+	prepareErr error
+	commitErr  error
+}
+
+func (t faultyTarget) PrepareOnload(ctx context.Context, metadata api.SessionMetadata, reader io.Reader, onloadedFrom api.SessionLocation) (api.SessionLocation, error) {
+	if t.prepareErr != nil {
+		return api.SessionLocation{}, t.prepareErr
+	}
+
+	return t.LocalOffloadTarget.PrepareOnload(ctx, metadata, reader, onloadedFrom)
+}
+
+func (t faultyTarget) CommitOnload(ctx context.Context, location api.SessionLocation) error {
+	if t.commitErr != nil {
+		return t.commitErr
+	}
+
+	return t.LocalOffloadTarget.CommitOnload(ctx, location)
+}
+
+func TestOffloadSessionRollback(t *testing.T) {
+	ctx := context.Background()
+	n1, cmd1 := newNode("n1")
+	n2, _ := newNode("n2")
+	prepareErr := errors.New("prepare failed")
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: n2}, prepareErr: prepareErr}
+	_, err = n1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions(), target, nil)
+	if !errors.Is(err, prepareErr) {
+		t.Errorf("Expected error %v, got %v", prepareErr, err)
+	}
+
+	// The session is still owned by n1, and can be offloaded again.
+	if _, err := n2.GetSessionMetadata(ctx, sessionToken.SessionId); !errors.Is(err, api.ErrSessionNotFound) {
+		t.Errorf("Expected error %v, got %v", api.ErrSessionNotFound, err)
+	}
+
+	if _, _, err := cmd1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions()); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+// An offload target that prepares the onload but loses the response, and
+// cancels the offload.
+type lostResponseTarget struct {
+	api.LocalOffloadTarget
+	cancel context.CancelFunc
+}
+
+func (t lostResponseTarget) PrepareOnload(ctx context.Context, metadata api.SessionMetadata, reader io.Reader, onloadedFrom api.SessionLocation) (api.SessionLocation, error) {
+	defer t.cancel()
+	if _, err := t.LocalOffloadTarget.PrepareOnload(ctx, metadata, reader, onloadedFrom); err != nil {
+		return api.SessionLocation{}, err
+	}
+
+	return api.SessionLocation{}, errors.New("response lost")
+}
+
+func TestOffloadSessionRollbackAbortsPreparedOnload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n1, _ := newNode("n1")
+	n2, _ := newNode("n2")
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	target := lostResponseTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: n2}, cancel: cancel}
+	if _, err := n1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions(), target, nil); err == nil {
+		t.Fatalf("Expected an error, got nil")
+	}
+
+	// The onload prepared on n2 is aborted, even if the offload was cancelled.
+	status, err := n2.GetSessionOnloadStatus(context.Background(), sessionToken.SessionId)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if status != api.OnloadStatusAborted {
+		t.Errorf("Expected status %v, got %v", api.OnloadStatusAborted, status)
+	}
+
+	// The session is still owned by n1.
+	if _, err := n1.GetSessionMetadata(context.Background(), sessionToken.SessionId); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
+
+func TestOffloadSessionNotCommitted(t *testing.T) {
+	ctx := context.Background()
+	n1, _ := newNode("n1")
+	n2, _ := newNode("n2")
+	commitErr := errors.New("commit failed")
+
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	target := faultyTarget{LocalOffloadTarget: api.LocalOffloadTarget{Node: n2}, commitErr: commitErr}
+	newLocation, err := n1.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions(), target, nil)
+	if !errors.Is(err, api.ErrOffloadNotCommitted) || !errors.Is(err, commitErr) {
+		t.Errorf("Expected error %v, got %v", api.ErrOffloadNotCommitted, err)
+	}
+
+	// The onload stays pending on n2 until the commit is retried.
+	if _, err := n2.GetSessionMetadata(ctx, newLocation.SessionId); !errors.Is(err, api.ErrSessionNotFound) {
+		t.Errorf("Expected error %v, got %v", api.ErrSessionNotFound, err)
+	}
+
+	if err := n2.CommitSessionOnload(ctx, newLocation.SessionId); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, err := n2.GetSessionMetadata(ctx, newLocation.SessionId); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
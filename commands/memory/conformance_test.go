@@ -0,0 +1,16 @@
+package memory_commands_test
+
+import (
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+	commands_conformance "github.com/ermes-labs/api-go/commands/conformance"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+func TestConformance(t *testing.T) {
+	commands_conformance.Run(t, func(t *testing.T, node infrastructure.Node) api.Commands {
+		return memory_commands.NewCommands(node)
+	})
+}
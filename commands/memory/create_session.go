@@ -0,0 +1,100 @@
+package memory_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Creates a new session and returns the id of the session.
+func (c *Commands) CreateSession(
+	ctx context.Context,
+	opt api.CreateSessionOptions,
+) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.createSession(opt)
+}
+
+// Creates a new session and acquires it. Returns the id of the session and the
+// id of the acquisition.
+func (c *Commands) CreateAndAcquireSession(
+	ctx context.Context,
+	opt api.CreateAndAcquireSessionOptions,
+) (sessionId string, acquisitionId uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sessionId, err = c.createSession(opt.CreateSessionOptions); err != nil {
+		return "", 0, err
+	}
+
+	// A newly created session cannot be offloaded or offloading.
+	if acquisitionId, _, err = c.acquireSession(sessionId, opt.AcquireSessionOptions); err != nil {
+		return "", 0, err
+	}
+
+	return sessionId, acquisitionId, nil
+}
+
+// Returns the ids of the sessions that are stored and not offloaded.
+func (c *Commands) ScanSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.scan(cursor, count, func(s *session) bool {
+		return s.offloadedTo == nil
+	})
+}
+
+// Creates a new session. The caller must hold the lock.
+func (c *Commands) createSession(opt api.CreateSessionOptions) (string, error) {
+	var sessionId string
+	if opt.SessionId() != nil {
+		sessionId = *opt.SessionId()
+		// The id must not be used, even by an offloaded session.
+		if _, ok := c.sessions[sessionId]; ok {
+			return "", api.ErrSessionIdAlreadyExists
+		}
+	} else {
+		sessionId = newSessionId()
+	}
+
+	// If not given, approximate the client coordinates with the node ones.
+	clientGeoCoordinates := c.node.GeoCoordinates
+	if opt.ClientGeoCoordinates() != nil {
+		clientGeoCoordinates = *opt.ClientGeoCoordinates()
+	}
+
+	createdAt := now()
+	c.sessions[sessionId] = &session{
+		seq: c.nextSeq(),
+		metadata: api.SessionMetadata{
+			ClientGeoCoordinates: &clientGeoCoordinates,
+			CreatedIn:            c.node.Host,
+			CreatedAt:            createdAt,
+			UpdatedAt:            createdAt,
+			ExpiresAt:            copyInt64(opt.ExpiresAt()),
+		},
+		data:           make(map[string][]byte),
+		resourcesUsage: make(api.ResourcesUsage),
+		acquisitions:   make(map[uint64]*acquisition),
+	}
+
+	return sessionId, nil
+}
+
+// Copy an optional int64.
+func copyInt64(value *int64) *int64 {
+	if value == nil {
+		return nil
+	}
+
+	copied := *value
+	return &copied
+}
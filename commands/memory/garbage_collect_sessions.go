@@ -0,0 +1,43 @@
+package memory_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Garbage collect the expired sessions. Expired sessions are collected if they
+// are not acquired (acquisitions whose lease expired do not count), or if they expired more than the configured duration ago.
+// Offloading sessions and the tombstones of journaled offloads, that are needed
+// to recover them, are never collected. The whole key space is collected in
+// a single pass, so the returned cursor is always nil.
+func (c *Commands) GarbageCollectSessions(
+	ctx context.Context,
+	opt api.GarbageCollectSessionsOptions,
+	cursor *string,
+) (*string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := now()
+	for id, s := range c.sessions {
+		if s.offloading || s.metadata.ExpiresAt == nil || *s.metadata.ExpiresAt > now {
+			continue
+		}
+
+		if _, journaled := c.journal[id]; journaled {
+			continue
+		}
+
+		if live, _ := s.liveAcquisitions(nowMillis()); live > 0 {
+			olderThan := opt.ExpiredUnreleasedOlderThan()
+			if olderThan == nil || *s.metadata.ExpiresAt > now-*olderThan {
+				continue
+			}
+		}
+
+		delete(c.sessions, id)
+	}
+
+	return nil, nil
+}
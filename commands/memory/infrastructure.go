@@ -0,0 +1,195 @@
+package memory_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/topology"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Load the infrastructure. The previously loaded infrastructure is replaced.
+func (c *Commands) LoadInfrastructure(
+	ctx context.Context,
+	infra infrastructure.Infrastructure,
+) (err error) {
+	if _, err := infrastructure.CheckInfrastructure(infra); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tree = topology.NewTree(c.node, &infra)
+	return nil
+}
+
+// Get the parent node of a node, nil if the node is a root.
+func (c *Commands) GetParentNodeOf(
+	ctx context.Context,
+	nodeId string,
+) (*infrastructure.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parent, ok := c.tree.Parent(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return parent, nil
+}
+
+// Get the children nodes of a node.
+func (c *Commands) GetChildrenNodesOf(
+	ctx context.Context,
+	nodeId string,
+) ([]infrastructure.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	children, ok := c.tree.ChildrenOf(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return children, nil
+}
+
+// Get the resources usage of a session.
+func (c *Commands) GetSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+) (resourcesUsage api.ResourcesUsage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyResourcesUsage(s.resourcesUsage), nil
+}
+
+// Get the resources usage of a node. The usage of this node is computed from
+// its sessions, the usage of the other nodes is the last one reported.
+func (c *Commands) GetNodeResourcesUsage(
+	ctx context.Context,
+	nodeId string,
+) (sessions uint, resourcesUsage api.ResourcesUsage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nodeId == c.node.Host {
+		sessions, resourcesUsage = c.localUsage()
+		return sessions, resourcesUsage, nil
+	}
+
+	if usage, ok := c.nodesUsage[nodeId]; ok {
+		return usage.sessions, copyResourcesUsage(usage.resourcesUsage), nil
+	}
+
+	if _, ok := c.tree.Nodes[nodeId]; ok {
+		return 0, make(api.ResourcesUsage), nil
+	}
+
+	return 0, nil, api.ErrNodeNotFound
+}
+
+// Update the resources usage of a session.
+func (c *Commands) UpdateSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+	resourcesUsage api.ResourcesUsage,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(sessionId)
+	if err != nil {
+		return err
+	}
+
+	s.resourcesUsage = copyResourcesUsage(resourcesUsage)
+	s.metadata.UpdatedAt = now()
+	return nil
+}
+
+// Get the update to send to the parent node: the number of sessions in the
+// sub-tree of this node and the resources usage of each node of the sub-tree.
+// errors:
+// - ErrNodeNotFound: If the node has no parent.
+func (c *Commands) ResourcesUsageUpdateToParent(
+	ctx context.Context,
+) (node infrastructure.Node, sessions uint, resourcesUsageNodesMap map[string]api.ResourcesUsage, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parent, ok := c.tree.Parents[c.node.Host]
+	if !ok {
+		return infrastructure.Node{}, 0, nil, api.ErrNodeNotFound
+	}
+
+	sessions, resourcesUsage := c.localUsage()
+	resourcesUsageNodesMap = map[string]api.ResourcesUsage{c.node.Host: resourcesUsage}
+
+	for nodeId, usage := range c.nodesUsage {
+		resourcesUsageNodesMap[nodeId] = copyResourcesUsage(usage.resourcesUsage)
+	}
+
+	// Add the sessions of the sub-trees of the children.
+	for _, child := range c.tree.Children[c.node.Host] {
+		if usage, ok := c.nodesUsage[child]; ok {
+			sessions += usage.sessions
+		}
+	}
+
+	return c.tree.Nodes[parent], sessions, resourcesUsageNodesMap, nil
+}
+
+// Store the update from a child node. The sessions are attributed to the
+// child, while the resources usage is stored for each node of its sub-tree.
+func (c *Commands) ResourcesUsageUpdateFromChild(
+	ctx context.Context,
+	sessions uint,
+	resourcesUsageNodesMap map[string]api.ResourcesUsage,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for nodeId, resourcesUsage := range resourcesUsageNodesMap {
+		usage, ok := c.nodesUsage[nodeId]
+		if !ok {
+			usage = &nodeUsage{}
+			c.nodesUsage[nodeId] = usage
+		}
+
+		usage.resourcesUsage = copyResourcesUsage(resourcesUsage)
+		if c.tree.Parents[nodeId] == c.node.Host {
+			usage.sessions = sessions
+		}
+	}
+
+	return nil
+}
+
+// Compute the number of sessions and the resources usage of this node. The
+// caller must hold the lock.
+func (c *Commands) localUsage() (uint, api.ResourcesUsage) {
+	sessions := uint(0)
+	resourcesUsage := make(api.ResourcesUsage)
+
+	for _, s := range c.sessions {
+		if s.offloadedTo != nil {
+			continue
+		}
+
+		sessions++
+		for resource, value := range s.resourcesUsage {
+			resourcesUsage[resource] += value
+		}
+	}
+
+	return sessions, resourcesUsage
+}
@@ -0,0 +1,95 @@
+package memory_commands
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Records the location an offloading session is being offloaded to.
+func (c *Commands) RecordSessionOffloadTarget(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(id)
+	if err != nil {
+		return err
+	}
+
+	if !s.offloading {
+		return api.ErrSessionIsNotOffloading
+	}
+
+	c.journal[id] = &api.OffloadJournalEntry{SessionId: id, NewLocation: &newLocation}
+	return nil
+}
+
+// Completes a confirmed offload, deleting it from the journal.
+func (c *Commands) CompleteSessionOffload(
+	ctx context.Context,
+	id string,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[id]
+	if !ok {
+		return api.ErrSessionNotFound
+	}
+
+	if s.offloadedTo == nil {
+		return api.ErrSessionIsNotOffloaded
+	}
+
+	delete(c.journal, id)
+	return nil
+}
+
+// Returns the entries of the journal, sorted by session id.
+func (c *Commands) ScanOffloadJournal(
+	ctx context.Context,
+) ([]api.OffloadJournalEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]api.OffloadJournalEntry, 0, len(c.journal))
+	for _, entry := range c.journal {
+		copied := *entry
+		copied.NewLocation = copyLocation(entry.NewLocation)
+		entries = append(entries, copied)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SessionId < entries[j].SessionId
+	})
+
+	return entries, nil
+}
+
+// Returns the status of the onload of a session.
+func (c *Commands) GetSessionOnloadStatus(
+	ctx context.Context,
+	sessionId string,
+) (api.OnloadStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.onloading[sessionId]; ok {
+		return api.OnloadStatusPending, nil
+	}
+
+	s, ok := c.sessions[sessionId]
+	switch {
+	case !ok:
+		return api.OnloadStatusAborted, nil
+	case s.offloadedTo != nil:
+		return api.OnloadStatusMoved, nil
+	default:
+		return api.OnloadStatusCommitted, nil
+	}
+}
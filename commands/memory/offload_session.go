@@ -0,0 +1,183 @@
+package memory_commands
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Starts the offload of a session. The session data is snapshotted, so no
+// loader is returned.
+func (c *Commands) OffloadSession(
+	ctx context.Context,
+	id string,
+	opt api.OffloadSessionOptions,
+) (sessionDataReadCloser io.ReadCloser, loader func(), err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.offloading {
+		return nil, nil, api.ErrSessionIsOffloading
+	}
+
+	if _, blocking := s.liveAcquisitions(nowMillis()); blocking > 0 {
+		return nil, nil, api.ErrUnableToOffloadAcquiredSession
+	}
+
+	s.offloading = true
+	c.journal[id] = &api.OffloadJournalEntry{SessionId: id}
+
+	return io.NopCloser(bytes.NewReader(encodeSessionData(s.data))), nil, nil
+}
+
+// Confirms the offload of a session. The session data is deleted and the
+// session is kept as a tombstone that points to the new location. If the client
+// did not visit this node yet, the last visited node is notified.
+func (c *Commands) ConfirmSessionOffload(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+	opt api.OffloadSessionOptions,
+	notifyLastVisitedNode func(ctx context.Context, oldLocation api.SessionLocation) (clientRedirected bool, err error),
+) (err error) {
+	c.mu.Lock()
+
+	s, err := c.liveSession(id)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	if !s.offloading {
+		c.mu.Unlock()
+		return api.ErrSessionIsNotOffloading
+	}
+
+	lastVisited := s.lastVisited
+	c.journal[id] = &api.OffloadJournalEntry{SessionId: id, NewLocation: &newLocation, Confirmed: true}
+	s.offloading = false
+	s.offloadedTo = &newLocation
+	s.clientRedirected = false
+	s.lastVisited = nil
+	s.data = nil
+	s.resourcesUsage = nil
+	c.mu.Unlock()
+
+	// Notify the last visited node outside the lock.
+	if lastVisited != nil && notifyLastVisitedNode != nil {
+		_, err = notifyLastVisitedNode(ctx, *lastVisited)
+	}
+
+	return err
+}
+
+// Aborts the offload of a session, that returns active.
+func (c *Commands) AbortSessionOffload(
+	ctx context.Context,
+	id string,
+) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[id]
+	if !ok {
+		return api.ErrSessionNotFound
+	}
+
+	// The offload has already been confirmed.
+	if !s.offloading {
+		return api.ErrSessionIsNotOffloading
+	}
+
+	s.offloading = false
+	delete(c.journal, id)
+	return nil
+}
+
+// Updates the location of an offloaded session.
+func (c *Commands) UpdateOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) (clientRedirected bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[id]
+	if !ok {
+		return false, api.ErrSessionNotFound
+	}
+
+	if s.offloadedTo == nil {
+		return false, api.ErrSessionIsNotOffloaded
+	}
+
+	s.offloadedTo = &newLocation
+	return s.clientRedirected, nil
+}
+
+// Returns the offloaded sessions.
+func (c *Commands) ScanOffloadedSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.scan(cursor, count, func(s *session) bool {
+		return s.offloadedTo != nil
+	})
+}
+
+// Returns the location of an offloaded session.
+func (c *Commands) GetOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+) (offloadedTo api.SessionLocation, clientRedirected bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[id]
+	if !ok {
+		return api.SessionLocation{}, false, api.ErrSessionNotFound
+	}
+
+	if s.offloadedTo == nil {
+		return api.SessionLocation{}, false, api.ErrSessionIsNotOffloaded
+	}
+
+	return *s.offloadedTo, s.clientRedirected, nil
+}
+
+// Deletes the tombstone of an offloaded session.
+func (c *Commands) DeleteOffloadedSession(
+	ctx context.Context,
+	id string,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.sessions[id]
+	if !ok {
+		return api.ErrSessionNotFound
+	}
+
+	if s.offloadedTo == nil {
+		return api.ErrSessionIsNotOffloaded
+	}
+
+	if _, ok := c.journal[id]; ok {
+		return api.ErrOffloadNotCommitted
+	}
+
+	delete(c.sessions, id)
+	return nil
+}
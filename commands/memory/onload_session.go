@@ -0,0 +1,150 @@
+package memory_commands
+
+import (
+	"context"
+	"io"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Onloads a session and returns its id. If the options carry the previous
+// location of the session the id is preserved, and a tombstone left by a
+// previous offload of the same session is replaced. The acquisitions of the
+// tombstone are dropped, and the fencing tokens continue from the highest
+// between the one of the metadata and the last one issued by this node. A
+// pending onload is kept apart until it is committed or aborted.
+func (c *Commands) OnloadSession(
+	ctx context.Context,
+	metadata api.SessionMetadata,
+	reader io.Reader,
+	opt api.OnloadSessionOptions,
+) (string, error) {
+	// Read the whole session data before touching the state.
+	data, err := decodeSessionData(reader)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	onloaded := &session{
+		metadata:       copyMetadata(metadata),
+		data:           data,
+		resourcesUsage: make(api.ResourcesUsage),
+		acquisitions:   make(map[uint64]*acquisition),
+		lastVisited:    copyLocation(opt.OnloadedFrom()),
+		// Keep the fencing tokens increasing across nodes.
+		lastAcquisitionId: metadata.FencingToken,
+	}
+	onloaded.metadata.UpdatedAt = now()
+
+	var sessionId string
+	if opt.OnloadedFrom() != nil {
+		sessionId = opt.OnloadedFrom().SessionId
+
+		if _, ok := c.onloading[sessionId]; ok {
+			return "", api.ErrSessionAlreadyOnloaded
+		}
+
+		if err := c.checkOnload(sessionId, onloaded); err != nil {
+			return "", err
+		}
+	} else {
+		sessionId = newSessionId()
+	}
+
+	if opt.Pending() {
+		c.onloading[sessionId] = onloaded
+	} else {
+		c.onload(sessionId, onloaded)
+	}
+
+	return sessionId, nil
+}
+
+// Commits the pending onload of a session, that replaces the tombstone of the
+// session, if any.
+func (c *Commands) CommitSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	onloaded, ok := c.onloading[sessionId]
+	if !ok {
+		return c.notOnloading(sessionId)
+	}
+
+	// The tombstone may have changed while the onload was pending.
+	if err := c.checkOnload(sessionId, onloaded); err != nil {
+		return err
+	}
+
+	delete(c.onloading, sessionId)
+	c.onload(sessionId, onloaded)
+
+	return nil
+}
+
+// Aborts the pending onload of a session. The tombstone of the session, if
+// any, points again to the location the session was onloaded from.
+func (c *Commands) AbortSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	onloaded, ok := c.onloading[sessionId]
+	if !ok {
+		return c.notOnloading(sessionId)
+	}
+
+	delete(c.onloading, sessionId)
+	if s, ok := c.sessions[sessionId]; ok && s.offloadedTo != nil && onloaded.lastVisited != nil {
+		s.offloadedTo = copyLocation(onloaded.lastVisited)
+		s.clientRedirected = false
+	}
+
+	return nil
+}
+
+// Store the onloaded session, replacing the tombstone of the session and its
+// journaled offload. The caller must hold the lock.
+func (c *Commands) onload(sessionId string, onloaded *session) {
+	onloaded.seq = c.nextSeq()
+	c.sessions[sessionId] = onloaded
+	delete(c.journal, sessionId)
+}
+
+// Returns an error if the onloaded session can not replace the session stored
+// with the same id. The caller must hold the lock.
+func (c *Commands) checkOnload(sessionId string, onloaded *session) error {
+	s, ok := c.sessions[sessionId]
+	if !ok {
+		return nil
+	}
+
+	if s.offloadedTo == nil {
+		return api.ErrSessionAlreadyOnloaded
+	}
+
+	// The session has been offloaded again after the onloaded data.
+	if s.lastAcquisitionId > onloaded.lastAcquisitionId {
+		return api.ErrStaleFencingToken
+	}
+
+	return nil
+}
+
+// Returns the error for a session whose onload is not pending. The caller must
+// hold the lock.
+func (c *Commands) notOnloading(sessionId string) error {
+	if _, ok := c.sessions[sessionId]; ok {
+		return api.ErrSessionIsNotOnloading
+	}
+
+	return api.ErrSessionNotFound
+}
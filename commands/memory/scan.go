@@ -0,0 +1,52 @@
+package memory_commands
+
+import (
+	"sort"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Scan the sessions that match the filter. The cursor is the sequence number
+// of the next session to return, 0 to start from the beginning. The returned
+// cursor is 0 when the scan is completed. Sessions stored for the whole scan are
+// returned at least once. The caller must hold the lock.
+func (c *Commands) scan(
+	cursor uint64,
+	count int64,
+	filter func(s *session) bool,
+) (ids []string, newCursor uint64, err error) {
+	if count <= 0 {
+		return nil, 0, api.ErrInvalidCount
+	}
+
+	if cursor > c.seq {
+		return nil, 0, api.ErrInvalidCursor
+	}
+
+	// Collect the matching sessions from the cursor.
+	matches := make([]*session, 0)
+	idsBySeq := make(map[uint64]string)
+	for id, s := range c.sessions {
+		if s.seq >= cursor && filter(s) {
+			matches = append(matches, s)
+			idsBySeq[s.seq] = id
+		}
+	}
+
+	// Sort them by sequence number.
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].seq < matches[j].seq
+	})
+
+	ids = make([]string, 0, count)
+	for i, s := range matches {
+		// If the page is full, return the cursor of the next session.
+		if int64(i) == count {
+			return ids, s.seq, nil
+		}
+
+		ids = append(ids, idsBySeq[s.seq])
+	}
+
+	return ids, 0, nil
+}
@@ -0,0 +1,151 @@
+package memory_commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"sort"
+
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+)
+
+// Get the value of a key in the session key space, nil if the key is not set.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionData(
+	ctx context.Context,
+	sessionId string,
+	key string,
+) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return bytes.Clone(value), nil
+}
+
+// Set the value of a key in the session key space. The write is fenced by the
+// fencing token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) SetSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+	value []byte,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.fencedSession(sessionId, fencingToken)
+	if err != nil {
+		return err
+	}
+
+	s.data[key] = bytes.Clone(value)
+	return nil
+}
+
+// Delete a key from the session key space. The write is fenced by the fencing
+// token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) DeleteSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.fencedSession(sessionId, fencingToken)
+	if err != nil {
+		return err
+	}
+
+	delete(s.data, key)
+	return nil
+}
+
+// Encode the session data as a sequence of length-prefixed key-value pairs,
+// sorted by key.
+func encodeSessionData(data map[string][]byte) []byte {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		framing.WriteField(&buf, []byte(key))
+		framing.WriteField(&buf, data[key])
+	}
+
+	return buf.Bytes()
+}
+
+// Decode the session data encoded by encodeSessionData.
+func decodeSessionData(reader io.Reader) (map[string][]byte, error) {
+	r := bufio.NewReader(reader)
+	data := make(map[string][]byte)
+
+	for {
+		key, err := framing.ReadField(r)
+		// A clean EOF before a key is the end of the stream.
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := framing.ReadField(r)
+		if err != nil {
+			return nil, framing.UnexpectedEOF(err)
+		}
+
+		data[string(key)] = value
+	}
+}
+
+// Returns the number of bytes of the session data stream of an offload of the
+// session.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionSize(
+	ctx context.Context,
+	sessionId string,
+) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(encodeSessionData(s.data))), nil
+}
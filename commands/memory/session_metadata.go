@@ -0,0 +1,68 @@
+package memory_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Returns the metadata associated with a session.
+func (c *Commands) GetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+) (api.SessionMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.liveSession(sessionId)
+	if err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	metadata := copyMetadata(s.metadata)
+	metadata.FencingToken = s.lastAcquisitionId
+	return metadata, nil
+}
+
+// Sets the metadata associated with a session.
+func (c *Commands) SetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+	opt api.SessionMetadataOptions,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.fencedSession(sessionId, opt.FencingToken())
+	if err != nil {
+		return err
+	}
+
+	updatedAt := now()
+	if opt.ClientGeoCoordinates() != nil {
+		clientGeoCoordinates := *opt.ClientGeoCoordinates()
+		s.metadata.ClientGeoCoordinates = &clientGeoCoordinates
+	}
+
+	if opt.Expired() {
+		s.metadata.ExpiresAt = &updatedAt
+	} else if opt.ExpiresAt() != nil {
+		s.metadata.ExpiresAt = copyInt64(opt.ExpiresAt())
+	}
+
+	s.metadata.UpdatedAt = updatedAt
+	return nil
+}
+
+// Copy the metadata, including the referenced values. The fencing token is
+// stored as the id of the last acquisition, and not copied.
+func copyMetadata(metadata api.SessionMetadata) api.SessionMetadata {
+	if metadata.ClientGeoCoordinates != nil {
+		clientGeoCoordinates := *metadata.ClientGeoCoordinates
+		metadata.ClientGeoCoordinates = &clientGeoCoordinates
+	}
+
+	metadata.ExpiresAt = copyInt64(metadata.ExpiresAt)
+	metadata.FencingToken = 0
+	return metadata
+}
@@ -0,0 +1,67 @@
+package redis_commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Acquires a session. If the session has been offloaded it returns the new
+// location of the session without acquiring it.
+func (c *Commands) AcquireSession(
+	ctx context.Context,
+	sessionId string,
+	opt api.AcquireSessionOptions,
+) (acquisitionId uint64, offloadedTo *api.SessionLocation, err error) {
+	reply, err := c.run(ctx, acquireSessionScript,
+		[]string{c.sessionKey(sessionId), c.acquisitionsKey(sessionId)},
+		encodeAcquisition(opt), flag(opt.AllowWhileOffloading()),
+		nowMillis(), int(opt.Mode()))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	offloadedTo, err = decodeLocation(reply, 1)
+	return uint64(reply[0].(int64)), offloadedTo, err
+}
+
+// Releases a previously acquired session. If the session has been offloaded
+// while acquired it returns the new location of the session.
+func (c *Commands) ReleaseSession(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+) (*api.SessionLocation, error) {
+	reply, err := c.run(ctx, releaseSessionScript,
+		[]string{c.sessionKey(sessionId), c.acquisitionsKey(sessionId)},
+		acquisitionId, now(), nowMillis())
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeLocation(reply, 0)
+}
+
+// Renews the lease of an acquisition.
+func (c *Commands) RenewSessionLease(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+	leaseDuration time.Duration,
+) error {
+	_, err := c.run(ctx, renewSessionLeaseScript,
+		[]string{c.sessionKey(sessionId), c.acquisitionsKey(sessionId)},
+		acquisitionId, nowMillis(), leaseExpiresAt(leaseDuration))
+	return err
+}
+
+// Returns the sessions that are neither offloading, offloaded nor acquired
+// with acquisitions that do not allow offloading.
+func (c *Commands) ScanOffloadableSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, "offloadable")
+}
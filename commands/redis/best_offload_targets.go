@@ -0,0 +1,110 @@
+package redis_commands
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/policy"
+	"github.com/ermes-labs/api-go/infrastructure"
+	"github.com/redis/go-redis/v9"
+)
+
+// Return the offloadable sessions with the highest resources usage, at most
+// opt.MaxTargets.
+func (c *Commands) BestSessionsToOffload(
+	ctx context.Context,
+	opt api.BestOffloadTargetsOptions,
+) (sessions map[string]api.SessionInfoForOffloadDecision, err error) {
+	candidates := make(map[string]api.SessionInfoForOffloadDecision)
+
+	cursor := uint64(0)
+	for {
+		var ids []string
+		ids, cursor, err = c.scan(ctx, cursor, 128, "offloadable")
+		if err != nil {
+			return nil, err
+		}
+
+		cmds, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, id := range ids {
+				pipe.HGetAll(ctx, c.sessionKey(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, cmd := range cmds {
+			fields := cmd.(*redis.MapStringStringCmd).Val()
+			// The session has been collected in the meantime.
+			if len(fields) == 0 {
+				continue
+			}
+
+			metadata, err := decodeMetadata(fields)
+			if err != nil {
+				return nil, err
+			}
+
+			var resourcesUsage api.ResourcesUsage
+			if err := json.Unmarshal([]byte(fields["resources"]), &resourcesUsage); err != nil {
+				return nil, err
+			}
+
+			candidates[ids[i]] = api.SessionInfoForOffloadDecision{
+				Metadata:       metadata,
+				ResourcesUsage: resourcesUsage,
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return policy.BestSessions(candidates, opt.MaxTargets), nil
+}
+
+// Return the best offload targets for the sessions of the given node, see
+// policy.BestTargets.
+func (c *Commands) BestOffloadTargetNodes(
+	ctx context.Context,
+	nodeId string,
+	sessions map[string]api.SessionInfoForOffloadDecision,
+	opt api.BestOffloadTargetsOptions,
+) ([][2]string, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	origin, ok := t.Nodes[nodeId]
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	// The known resources usage of the candidate nodes.
+	usages := make(map[string]api.ResourcesUsage)
+	for host := range t.Nodes {
+		if host == nodeId {
+			continue
+		}
+
+		if _, usages[host], err = c.GetNodeResourcesUsage(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy.BestTargets(origin, t.Nodes, usages, sessions, opt.MaxTargets), nil
+}
+
+// Get the lookup node for a session offloading. The Redis commands hold the
+// whole infrastructure, so the node itself is the lookup node.
+func (c *Commands) FindLookupNode(
+	ctx context.Context,
+	sessionIds []string,
+) (node infrastructure.Node, err error) {
+	return c.node, nil
+}
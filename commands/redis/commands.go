@@ -0,0 +1,266 @@
+package redis_commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+	"github.com/redis/go-redis/v9"
+)
+
+// Commands is an implementation of api.Commands on top of Redis. Every state
+// transition of a session is an atomic server-side script. The data of a
+// session lives under the key prefix returned by SessionKey, so that it can be
+// streamed out by OffloadSession and streamed in by OnloadSession.
+//
+// The scripts access keys derived from the session ids, so the commands
+// support a single Redis instance (or a cluster with all the keys of a node in
+// a single hash slot, e.g. with a "{node}" key prefix).
+type Commands struct {
+	// The Redis client.
+	client redis.UniversalClient
+	// The node that owns the commands.
+	node infrastructure.Node
+	// The prefix of all the keys.
+	keyPrefix string
+}
+
+// Assert that Commands implements api.Commands.
+var _ api.Commands = (*Commands)(nil)
+
+// NewCommands creates new Redis Commands owned by the given node. All the keys
+// are prefixed with keyPrefix, which allows multiple nodes to share a Redis
+// instance.
+func NewCommands(client redis.UniversalClient, node infrastructure.Node, keyPrefix string) *Commands {
+	return &Commands{
+		client:    client,
+		node:      node,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// SessionKey returns the key of the session key space that corresponds to the
+// given key. All the keys of the session key space are moved with the session
+// when it is offloaded.
+func (c *Commands) SessionKey(sessionId string, key string) string {
+	return c.sessionDataPrefix(sessionId) + key
+}
+
+// The key of the hash that holds the state of a session.
+func (c *Commands) sessionKey(sessionId string) string {
+	return c.sessionKeyPrefix() + sessionId
+}
+
+// The prefix of the keys of the hashes of the sessions.
+func (c *Commands) sessionKeyPrefix() string {
+	return c.keyPrefix + "session:"
+}
+
+// The key of the hash of the acquisitions of a session.
+func (c *Commands) acquisitionsKey(sessionId string) string {
+	return c.acquisitionsKeyPrefix() + sessionId
+}
+
+// The prefix of the keys of the hashes of the acquisitions of the sessions.
+func (c *Commands) acquisitionsKeyPrefix() string {
+	return c.keyPrefix + "acquisitions:"
+}
+
+// The key of the hash of the pending onload of a session.
+func (c *Commands) onloadingKey(sessionId string) string {
+	return c.keyPrefix + "onloading:" + sessionId
+}
+
+// The key of the hash of the offload journal.
+func (c *Commands) journalKey() string {
+	return c.keyPrefix + "journal"
+}
+
+// The prefix of the session key space.
+func (c *Commands) sessionDataPrefix(sessionId string) string {
+	return c.keyPrefix + "data:" + sessionId + ":"
+}
+
+// The prefix of the key space restored by an onload, until the onload moves it
+// to the session.
+func (c *Commands) stagingPrefix(onloadId string) string {
+	return c.keyPrefix + "staging:" + onloadId + ":"
+}
+
+// The key of the counter used to assign the sequence numbers.
+func (c *Commands) seqKey() string {
+	return c.keyPrefix + "seq"
+}
+
+// The key of the sorted set of the session ids, scored by sequence number.
+func (c *Commands) sessionsKey() string {
+	return c.keyPrefix + "sessions"
+}
+
+// The key of the counter of the sessions that are not offloaded.
+func (c *Commands) liveKey() string {
+	return c.keyPrefix + "live"
+}
+
+// The key of the hash of the resources usage of the node.
+func (c *Commands) usageKey() string {
+	return c.keyPrefix + "usage"
+}
+
+// The key of the loaded infrastructure.
+func (c *Commands) infrastructureKey() string {
+	return c.keyPrefix + "infrastructure"
+}
+
+// The key of the hash of the resources usage of the other nodes.
+func (c *Commands) nodesUsageKey() string {
+	return c.keyPrefix + "nodes:usage"
+}
+
+// The key of the hash of the number of sessions of the other nodes.
+func (c *Commands) nodesSessionsKey() string {
+	return c.keyPrefix + "nodes:sessions"
+}
+
+// The errors returned by the scripts.
+var scriptErrors = map[string]error{
+	"SESSION_NOT_FOUND":                  api.ErrSessionNotFound,
+	"SESSION_IS_OFFLOADING":              api.ErrSessionIsOffloading,
+	"SESSION_IS_NOT_OFFLOADING":          api.ErrSessionIsNotOffloading,
+	"SESSION_IS_NOT_OFFLOADED":           api.ErrSessionIsNotOffloaded,
+	"OFFLOAD_NOT_COMMITTED":              api.ErrOffloadNotCommitted,
+	"SESSION_ALREADY_ONLOADED":           api.ErrSessionAlreadyOnloaded,
+	"SESSION_IS_NOT_ONLOADING":           api.ErrSessionIsNotOnloading,
+	"SESSION_ID_ALREADY_EXISTS":          api.ErrSessionIdAlreadyExists,
+	"NO_ACQUISITION_TO_RELEASE":          api.ErrNoAcquisitionToRelease,
+	"LEASE_EXPIRED":                      api.ErrLeaseExpired,
+	"SESSION_IS_ACQUIRED":                api.ErrSessionIsAcquired,
+	"STALE_FENCING_TOKEN":                api.ErrStaleFencingToken,
+	"ACQUISITION_IS_READ_ONLY":           api.ErrAcquisitionIsReadOnly,
+	"UNABLE_TO_OFFLOAD_ACQUIRED_SESSION": api.ErrUnableToOffloadAcquiredSession,
+	"INVALID_CURSOR":                     api.ErrInvalidCursor,
+	"ONLOAD_CHANGED":                     errOnloadChanged,
+}
+
+// Run a script. Scripts reply with a list whose first element is "OK" or one of
+// the codes in scriptErrors, followed by the results.
+func (c *Commands) run(
+	ctx context.Context,
+	script *redis.Script,
+	keys []string,
+	args ...interface{},
+) ([]interface{}, error) {
+	reply, err := script.Run(ctx, c.client, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	status, _ := reply[0].(string)
+	if status != "OK" {
+		if err, ok := scriptErrors[status]; ok {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: unexpected script reply %v", api.ErrErmes, reply)
+	}
+
+	return reply[1:], nil
+}
+
+// Decode the optional location returned by a script.
+func decodeLocation(reply []interface{}, index int) (*api.SessionLocation, error) {
+	if len(reply) <= index {
+		return nil, nil
+	}
+
+	encoded, _ := reply[index].(string)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	var location api.SessionLocation
+	if err := json.Unmarshal([]byte(encoded), &location); err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// Encode an optional value as JSON, the empty string if nil.
+func encodeOptional[T any](value *T) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Encode a flag as a script argument.
+func flag(value bool) string {
+	if value {
+		return "1"
+	}
+
+	return "0"
+}
+
+// Generate a new random session id.
+func newSessionId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// The current time as a Unix timestamp (UTC).
+func now() int64 {
+	return time.Now().Unix()
+}
+
+// The current time as a Unix timestamp in milliseconds, used for the leases.
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// The expiration of a lease of the given duration from now, 0 if no duration.
+func leaseExpiresAt(leaseDuration time.Duration) int64 {
+	if leaseDuration <= 0 {
+		return 0
+	}
+
+	return nowMillis() + leaseDuration.Milliseconds()
+}
+
+// Encode an acquisition as a value of the hash of the acquisitions.
+func encodeAcquisition(opt api.AcquireSessionOptions) string {
+	return fmt.Sprintf("%s:%d:%d", flag(opt.AllowOffloading()), opt.Mode(), leaseExpiresAt(opt.LeaseDuration()))
+}
+
+// Escape the glob-style special characters of a key prefix.
+func escapePattern(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(prefix)
+}
+
+// Parse an optional int64 stored as a string.
+func parseOptionalInt64(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parsed, nil
+}
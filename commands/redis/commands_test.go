@@ -0,0 +1,137 @@
+package redis_commands_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ermes-labs/api-go/api"
+	redis_commands "github.com/ermes-labs/api-go/commands/redis"
+	"github.com/ermes-labs/api-go/infrastructure"
+	"github.com/redis/go-redis/v9"
+)
+
+func newCommands(t *testing.T, server *miniredis.Miniredis, host string) *redis_commands.Commands {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return redis_commands.NewCommands(client, infrastructure.Node{AreaName: host, Host: host}, host+":")
+}
+
+// Returns the metadata and the data stream of a new session with a key.
+func offloadedSession(t *testing.T, cmd *redis_commands.Commands, key string) (api.SessionMetadata, io.ReadCloser) {
+	ctx := context.Background()
+	sessionId, err := cmd.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if err := cmd.SetSessionData(ctx, sessionId, 0, key, []byte("value")); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	metadata, err := cmd.GetSessionMetadata(ctx, sessionId)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	reader, loader, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if loader != nil {
+		go loader()
+	}
+
+	return metadata, reader
+}
+
+// A reader that runs a function before its first read.
+type hookReader struct {
+	io.Reader
+	hook func()
+}
+
+func (r *hookReader) Read(p []byte) (int, error) {
+	if r.hook != nil {
+		r.hook()
+		r.hook = nil
+	}
+
+	return r.Reader.Read(p)
+}
+
+func TestConcurrentOnloadsDoNotMixData(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	source := newCommands(t, server, "edge1")
+	target := newCommands(t, server, "edge2")
+	opt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation("edge1", "s1")).Build()
+
+	metadataA, readerA := offloadedSession(t, source, "a")
+	metadataB, readerB := offloadedSession(t, source, "b")
+	defer readerA.Close()
+	defer readerB.Close()
+
+	// The first onload completes while the second one is restoring its data.
+	var errA error
+	_, errB := target.OnloadSession(ctx, metadataB, &hookReader{Reader: readerB, hook: func() {
+		_, errA = target.OnloadSession(ctx, metadataA, readerA, opt)
+	}}, opt)
+
+	if errA != nil {
+		t.Fatalf("Expected nil, got %v", errA)
+	}
+	if !errors.Is(errB, api.ErrSessionAlreadyOnloaded) {
+		t.Fatalf("Expected error %v, got %v", api.ErrSessionAlreadyOnloaded, errB)
+	}
+
+	// Only the data of the first onload is in the session, and nothing is left
+	// staged.
+	if value, err := target.GetSessionData(ctx, "s1", "a"); err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+
+	if value, err := target.GetSessionData(ctx, "s1", "b"); err != nil || value != nil {
+		t.Errorf("Expected no value, got %q, %v", value, err)
+	}
+
+	for _, key := range server.Keys() {
+		if strings.Contains(key, "staging:") {
+			t.Errorf("Expected no staged key, got %s", key)
+		}
+	}
+}
+
+func TestAbortedOnloadDeletesData(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	source := newCommands(t, server, "edge1")
+	target := newCommands(t, server, "edge2")
+	opt := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(api.NewSessionLocation("edge1", "s1")).Pending().Build()
+
+	metadata, reader := offloadedSession(t, source, "a")
+	defer reader.Close()
+
+	if _, err := target.OnloadSession(ctx, metadata, reader, opt); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if !server.Exists(target.SessionKey("s1", "a")) {
+		t.Fatalf("Expected the key of the pending onload to be moved")
+	}
+
+	if err := target.AbortSessionOnload(ctx, "s1"); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The key space of the session is deleted with the pending onload.
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, "edge2:") {
+			t.Errorf("Expected no key of the aborted onload, got %s", key)
+		}
+	}
+}
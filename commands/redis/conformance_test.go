@@ -0,0 +1,22 @@
+package redis_commands_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ermes-labs/api-go/api"
+	commands_conformance "github.com/ermes-labs/api-go/commands/conformance"
+	redis_commands "github.com/ermes-labs/api-go/commands/redis"
+	"github.com/ermes-labs/api-go/infrastructure"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestConformance(t *testing.T) {
+	commands_conformance.Run(t, func(t *testing.T, node infrastructure.Node) api.Commands {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		return redis_commands.NewCommands(client, node, "ermes:")
+	})
+}
@@ -0,0 +1,82 @@
+package redis_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Creates a new session and returns the id of the session.
+func (c *Commands) CreateSession(
+	ctx context.Context,
+	opt api.CreateSessionOptions,
+) (string, error) {
+	sessionId, _, err := c.createSession(ctx, opt, nil)
+	return sessionId, err
+}
+
+// Creates a new session and acquires it. Returns the id of the session and the
+// id of the acquisition.
+func (c *Commands) CreateAndAcquireSession(
+	ctx context.Context,
+	opt api.CreateAndAcquireSessionOptions,
+) (sessionId string, acquisitionId uint64, err error) {
+	return c.createSession(ctx, opt.CreateSessionOptions, &opt.AcquireSessionOptions)
+}
+
+// Returns the ids of the sessions that are stored and not offloaded.
+func (c *Commands) ScanSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, "live")
+}
+
+// Creates a new session, and acquires it if acquireOpt is not nil.
+func (c *Commands) createSession(
+	ctx context.Context,
+	opt api.CreateSessionOptions,
+	acquireOpt *api.AcquireSessionOptions,
+) (string, uint64, error) {
+	sessionId := newSessionId()
+	if opt.SessionId() != nil {
+		sessionId = *opt.SessionId()
+	}
+
+	// If not given, approximate the client coordinates with the node ones.
+	clientGeoCoordinates := c.node.GeoCoordinates
+	if opt.ClientGeoCoordinates() != nil {
+		clientGeoCoordinates = *opt.ClientGeoCoordinates()
+	}
+
+	encodedClientGeoCoordinates, err := encodeOptional(&clientGeoCoordinates)
+	if err != nil {
+		return "", 0, err
+	}
+
+	expiresAt, err := encodeOptional(opt.ExpiresAt())
+	if err != nil {
+		return "", 0, err
+	}
+
+	acquisition := ""
+	if acquireOpt != nil {
+		acquisition = encodeAcquisition(*acquireOpt)
+	}
+
+	reply, err := c.run(ctx, createSessionScript,
+		[]string{c.sessionKey(sessionId), c.seqKey(), c.sessionsKey(), c.liveKey(), c.acquisitionsKey(sessionId)},
+		sessionId,
+		c.node.Host,
+		now(),
+		expiresAt,
+		encodedClientGeoCoordinates,
+		flag(acquireOpt != nil),
+		acquisition)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return sessionId, uint64(reply[0].(int64)), nil
+}
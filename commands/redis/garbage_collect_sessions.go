@@ -0,0 +1,56 @@
+package redis_commands
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// The number of sessions inspected by each garbage collection step.
+const garbageCollectBatchSize = 128
+
+// Garbage collect the expired sessions. Expired sessions are collected if they
+// are not acquired, or if they expired more than the configured duration ago.
+// Offloading sessions and the tombstones of journaled offloads, that are needed
+// to recover them, are never collected. Each call inspects a batch of
+// sessions and returns the cursor of the next batch, nil once completed.
+func (c *Commands) GarbageCollectSessions(
+	ctx context.Context,
+	opt api.GarbageCollectSessionsOptions,
+	cursor *string,
+) (*string, error) {
+	from := uint64(0)
+	if cursor != nil {
+		var err error
+		if from, err = strconv.ParseUint(*cursor, 10, 64); err != nil {
+			return nil, api.ErrInvalidCursor
+		}
+	}
+
+	olderThan, err := encodeOptional(opt.ExpiredUnreleasedOlderThan())
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.run(ctx, garbageCollectSessionsScript,
+		[]string{c.sessionsKey(), c.usageKey(), c.liveKey(), c.journalKey()},
+		from, garbageCollectBatchSize, now(), olderThan, c.sessionKeyPrefix(), c.acquisitionsKeyPrefix(), nowMillis())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range reply[1].([]interface{}) {
+		if err := c.deleteSessionData(ctx, id.(string)); err != nil {
+			return nil, err
+		}
+	}
+
+	next := reply[0].(int64)
+	if next == 0 {
+		return nil, nil
+	}
+
+	nextCursor := strconv.FormatInt(next, 10)
+	return &nextCursor, nil
+}
@@ -0,0 +1,299 @@
+package redis_commands
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/topology"
+	"github.com/ermes-labs/api-go/infrastructure"
+	"github.com/redis/go-redis/v9"
+)
+
+// Load the infrastructure. The previously loaded infrastructure is replaced.
+func (c *Commands) LoadInfrastructure(
+	ctx context.Context,
+	infra infrastructure.Infrastructure,
+) (err error) {
+	if _, err := infrastructure.CheckInfrastructure(infra); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(infra)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Set(ctx, c.infrastructureKey(), encoded, 0).Err()
+}
+
+// Get the parent node of a node, nil if the node is a root.
+func (c *Commands) GetParentNodeOf(
+	ctx context.Context,
+	nodeId string,
+) (*infrastructure.Node, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, ok := t.Parent(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return parent, nil
+}
+
+// Get the children nodes of a node.
+func (c *Commands) GetChildrenNodesOf(
+	ctx context.Context,
+	nodeId string,
+) ([]infrastructure.Node, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children, ok := t.ChildrenOf(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return children, nil
+}
+
+// Get the resources usage of a session.
+func (c *Commands) GetSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+) (resourcesUsage api.ResourcesUsage, err error) {
+	values, err := c.client.HMGet(ctx, c.sessionKey(sessionId), "state", "resources").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if state, _ := values[0].(string); state == "" || state == "offloaded" {
+		return nil, api.ErrSessionNotFound
+	}
+
+	encoded, _ := values[1].(string)
+	if err := json.Unmarshal([]byte(encoded), &resourcesUsage); err != nil {
+		return nil, err
+	}
+
+	return resourcesUsage, nil
+}
+
+// Get the resources usage of a node. The usage of this node is maintained by
+// the scripts that change the state of the sessions, the usage of the other
+// nodes is the last one reported.
+func (c *Commands) GetNodeResourcesUsage(
+	ctx context.Context,
+	nodeId string,
+) (sessions uint, resourcesUsage api.ResourcesUsage, err error) {
+	if nodeId == c.node.Host {
+		return c.localUsage(ctx)
+	}
+
+	var usage, count *redis.StringCmd
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		usage = pipe.HGet(ctx, c.nodesUsageKey(), nodeId)
+		count = pipe.HGet(ctx, c.nodesSessionsKey(), nodeId)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+
+	if usage.Err() == redis.Nil {
+		t, err := c.tree(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if _, ok := t.Nodes[nodeId]; ok {
+			return 0, make(api.ResourcesUsage), nil
+		}
+
+		return 0, nil, api.ErrNodeNotFound
+	}
+
+	if err := json.Unmarshal([]byte(usage.Val()), &resourcesUsage); err != nil {
+		return 0, nil, err
+	}
+
+	if count.Err() == nil {
+		parsed, err := strconv.ParseUint(count.Val(), 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		sessions = uint(parsed)
+	}
+
+	return sessions, resourcesUsage, nil
+}
+
+// Update the resources usage of a session.
+func (c *Commands) UpdateSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+	resourcesUsage api.ResourcesUsage,
+) (err error) {
+	if resourcesUsage == nil {
+		resourcesUsage = make(api.ResourcesUsage)
+	}
+
+	encoded, err := json.Marshal(resourcesUsage)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.run(ctx, updateSessionResourcesUsageScript,
+		[]string{c.sessionKey(sessionId), c.usageKey()},
+		encoded, now())
+	return err
+}
+
+// Get the update to send to the parent node: the number of sessions in the
+// sub-tree of this node and the resources usage of each node of the sub-tree.
+// errors:
+// - ErrNodeNotFound: If the node has no parent.
+func (c *Commands) ResourcesUsageUpdateToParent(
+	ctx context.Context,
+) (node infrastructure.Node, sessions uint, resourcesUsageNodesMap map[string]api.ResourcesUsage, err error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	parent, ok := t.Parents[c.node.Host]
+	if !ok {
+		return infrastructure.Node{}, 0, nil, api.ErrNodeNotFound
+	}
+
+	sessions, resourcesUsage, err := c.localUsage(ctx)
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	var usages, counts *redis.MapStringStringCmd
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		usages = pipe.HGetAll(ctx, c.nodesUsageKey())
+		counts = pipe.HGetAll(ctx, c.nodesSessionsKey())
+		return nil
+	})
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	resourcesUsageNodesMap = map[string]api.ResourcesUsage{c.node.Host: resourcesUsage}
+	for nodeId, encoded := range usages.Val() {
+		var usage api.ResourcesUsage
+		if err := json.Unmarshal([]byte(encoded), &usage); err != nil {
+			return infrastructure.Node{}, 0, nil, err
+		}
+
+		resourcesUsageNodesMap[nodeId] = usage
+	}
+
+	// Add the sessions of the sub-trees of the children.
+	for _, child := range t.Children[c.node.Host] {
+		if count, ok := counts.Val()[child]; ok {
+			parsed, err := strconv.ParseUint(count, 10, 64)
+			if err != nil {
+				return infrastructure.Node{}, 0, nil, err
+			}
+
+			sessions += uint(parsed)
+		}
+	}
+
+	return t.Nodes[parent], sessions, resourcesUsageNodesMap, nil
+}
+
+// Store the update from a child node. The sessions are attributed to the
+// child, while the resources usage is stored for each node of its sub-tree.
+func (c *Commands) ResourcesUsageUpdateFromChild(
+	ctx context.Context,
+	sessions uint,
+	resourcesUsageNodesMap map[string]api.ResourcesUsage,
+) (err error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for nodeId, resourcesUsage := range resourcesUsageNodesMap {
+			encoded, err := json.Marshal(resourcesUsage)
+			if err != nil {
+				return err
+			}
+
+			pipe.HSet(ctx, c.nodesUsageKey(), nodeId, encoded)
+			if t.Parents[nodeId] == c.node.Host {
+				pipe.HSet(ctx, c.nodesSessionsKey(), nodeId, sessions)
+			}
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Get the number of sessions and the resources usage of this node.
+func (c *Commands) localUsage(ctx context.Context) (uint, api.ResourcesUsage, error) {
+	var live *redis.StringCmd
+	var usage *redis.MapStringStringCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		live = pipe.Get(ctx, c.liveKey())
+		usage = pipe.HGetAll(ctx, c.usageKey())
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, nil, err
+	}
+
+	sessions := uint(0)
+	if live.Err() == nil {
+		parsed, err := strconv.ParseUint(live.Val(), 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		sessions = uint(parsed)
+	}
+
+	resourcesUsage := make(api.ResourcesUsage)
+	for resource, value := range usage.Val() {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		resourcesUsage[resource] = parsed
+	}
+
+	return sessions, resourcesUsage, nil
+}
+
+// Read the tree of the loaded infrastructure.
+func (c *Commands) tree(ctx context.Context) (*topology.Tree, error) {
+	encoded, err := c.client.Get(ctx, c.infrastructureKey()).Bytes()
+	if err == redis.Nil {
+		return topology.NewTree(c.node, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var infra infrastructure.Infrastructure
+	if err := json.Unmarshal(encoded, &infra); err != nil {
+		return nil, err
+	}
+
+	return topology.NewTree(c.node, &infra), nil
+}
@@ -0,0 +1,78 @@
+package redis_commands
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Records the location an offloading session is being offloaded to.
+func (c *Commands) RecordSessionOffloadTarget(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) error {
+	entry, err := json.Marshal(api.OffloadJournalEntry{SessionId: id, NewLocation: &newLocation})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.run(ctx, recordSessionOffloadTargetScript, []string{c.sessionKey(id), c.journalKey()}, id, entry)
+	return err
+}
+
+// Completes a confirmed offload, deleting it from the journal.
+func (c *Commands) CompleteSessionOffload(
+	ctx context.Context,
+	id string,
+) error {
+	_, err := c.run(ctx, completeSessionOffloadScript, []string{c.sessionKey(id), c.journalKey()}, id)
+	return err
+}
+
+// Returns the entries of the journal, sorted by session id.
+func (c *Commands) ScanOffloadJournal(
+	ctx context.Context,
+) ([]api.OffloadJournalEntry, error) {
+	values, err := c.client.HVals(ctx, c.journalKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]api.OffloadJournalEntry, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal([]byte(value), &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SessionId < entries[j].SessionId
+	})
+
+	return entries, nil
+}
+
+// Returns the status of the onload of a session.
+func (c *Commands) GetSessionOnloadStatus(
+	ctx context.Context,
+	sessionId string,
+) (api.OnloadStatus, error) {
+	reply, err := c.run(ctx, onloadStatusScript, []string{c.onloadingKey(sessionId), c.sessionKey(sessionId)})
+	if err != nil {
+		return api.OnloadStatusUnknown, err
+	}
+
+	switch reply[0] {
+	case "pending":
+		return api.OnloadStatusPending, nil
+	case "committed":
+		return api.OnloadStatusCommitted, nil
+	case "moved":
+		return api.OnloadStatusMoved, nil
+	default:
+		return api.OnloadStatusAborted, nil
+	}
+}
@@ -0,0 +1,212 @@
+package redis_commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+	"github.com/redis/go-redis/v9"
+)
+
+// Starts the offload of a session. The returned loader streams the session key
+// space into the reader, each key is framed as its name relative to the
+// session key space, its TTL in milliseconds (0 if none) and its DUMP payload.
+func (c *Commands) OffloadSession(
+	ctx context.Context,
+	id string,
+	opt api.OffloadSessionOptions,
+) (sessionDataReadCloser io.ReadCloser, loader func(), err error) {
+	entry, err := json.Marshal(api.OffloadJournalEntry{SessionId: id})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := c.run(ctx, offloadSessionScript, []string{c.sessionKey(id), c.acquisitionsKey(id), c.journalKey()}, nowMillis(), id, entry); err != nil {
+		return nil, nil, err
+	}
+
+	reader, writer := io.Pipe()
+	loader = func() {
+		// A nil error closes the writer with io.EOF.
+		writer.CloseWithError(c.dumpSessionData(ctx, id, writer))
+	}
+
+	return reader, loader, nil
+}
+
+// Confirms the offload of a session. The session key space is deleted and the
+// session is kept as a tombstone that points to the new location. If the client
+// did not visit this node yet, the last visited node is notified.
+func (c *Commands) ConfirmSessionOffload(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+	opt api.OffloadSessionOptions,
+	notifyLastVisitedNode func(ctx context.Context, oldLocation api.SessionLocation) (clientRedirected bool, err error),
+) (err error) {
+	encodedNewLocation, err := json.Marshal(newLocation)
+	if err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(api.OffloadJournalEntry{SessionId: id, NewLocation: &newLocation, Confirmed: true})
+	if err != nil {
+		return err
+	}
+
+	reply, err := c.run(ctx, confirmSessionOffloadScript,
+		[]string{c.sessionKey(id), c.usageKey(), c.liveKey(), c.journalKey()},
+		encodedNewLocation, id, entry)
+	if err != nil {
+		return err
+	}
+
+	lastVisited, err := decodeLocation(reply, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := c.deleteSessionData(ctx, id); err != nil {
+		return err
+	}
+
+	if lastVisited != nil && notifyLastVisitedNode != nil {
+		_, err = notifyLastVisitedNode(ctx, *lastVisited)
+	}
+
+	return err
+}
+
+// Aborts the offload of a session, that returns active.
+func (c *Commands) AbortSessionOffload(
+	ctx context.Context,
+	id string,
+) (err error) {
+	_, err = c.run(ctx, abortSessionOffloadScript, []string{c.sessionKey(id), c.journalKey()}, id)
+	return err
+}
+
+// Updates the location of an offloaded session.
+func (c *Commands) UpdateOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) (clientRedirected bool, err error) {
+	encodedNewLocation, err := json.Marshal(newLocation)
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := c.run(ctx, updateOffloadedSessionLocationScript,
+		[]string{c.sessionKey(id)},
+		encodedNewLocation)
+	if err != nil {
+		return false, err
+	}
+
+	return reply[0] == "1", nil
+}
+
+// Returns the location of an offloaded session.
+func (c *Commands) GetOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+) (offloadedTo api.SessionLocation, clientRedirected bool, err error) {
+	values, err := c.client.HMGet(ctx, c.sessionKey(id), "state", "offloadedTo", "clientRedirected").Result()
+	if err != nil {
+		return api.SessionLocation{}, false, err
+	}
+
+	state, ok := values[0].(string)
+	if !ok {
+		return api.SessionLocation{}, false, api.ErrSessionNotFound
+	}
+
+	if state != "offloaded" {
+		return api.SessionLocation{}, false, api.ErrSessionIsNotOffloaded
+	}
+
+	location, err := decodeLocation(values, 1)
+	if err != nil {
+		return api.SessionLocation{}, false, err
+	}
+	if location == nil {
+		return api.SessionLocation{}, false, api.ErrSessionIsNotOffloaded
+	}
+
+	return *location, values[2] == "1", nil
+}
+
+// Deletes the tombstone of an offloaded session.
+func (c *Commands) DeleteOffloadedSession(
+	ctx context.Context,
+	id string,
+) error {
+	_, err := c.run(ctx, deleteOffloadedSessionScript,
+		[]string{c.sessionKey(id), c.acquisitionsKey(id), c.sessionsKey(), c.journalKey()},
+		id)
+	return err
+}
+
+// Returns the offloaded sessions.
+func (c *Commands) ScanOffloadedSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, "offloaded")
+}
+
+// Write the framed session key space.
+func (c *Commands) dumpSessionData(ctx context.Context, id string, w io.Writer) error {
+	prefix := c.sessionDataPrefix(id)
+	buf := bufio.NewWriter(w)
+
+	iter := c.client.Scan(ctx, 0, escapePattern(prefix)+"*", 128).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		var ttl *redis.DurationCmd
+		var dump *redis.StringCmd
+		_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			ttl = pipe.PTTL(ctx, key)
+			dump = pipe.Dump(ctx, key)
+			return nil
+		})
+
+		// The key expired or has been deleted in the meantime.
+		if dump.Err() == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		milliseconds := ttl.Val().Milliseconds()
+		if milliseconds < 0 {
+			milliseconds = 0
+		}
+
+		fields := [][]byte{
+			[]byte(strings.TrimPrefix(key, prefix)),
+			[]byte(strconv.FormatInt(milliseconds, 10)),
+			[]byte(dump.Val()),
+		}
+		for _, field := range fields {
+			if err := framing.WriteField(buf, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
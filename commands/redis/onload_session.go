@@ -0,0 +1,209 @@
+package redis_commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+)
+
+// The pending onload of a session changed while it was being aborted.
+var errOnloadChanged = errors.New("pending onload changed")
+
+// Onloads a session and returns its id. If the options carry the previous
+// location of the session the id is preserved, and a tombstone left by a
+// previous offload of the same session is replaced. The session key space is
+// restored under a prefix of its own and moved to the session by the script
+// that onloads it, so that concurrent onloads of the same session do not mix
+// their keys. The onload is rejected if the tombstone has been offloaded after
+// the onloaded data. A pending onload keeps the tombstone until it is
+// committed, while its key space is already moved.
+func (c *Commands) OnloadSession(
+	ctx context.Context,
+	metadata api.SessionMetadata,
+	reader io.Reader,
+	opt api.OnloadSessionOptions,
+) (string, error) {
+	sessionId := newSessionId()
+	if opt.OnloadedFrom() != nil {
+		sessionId = opt.OnloadedFrom().SessionId
+
+		// Fail fast, without touching the key space of an onloaded session.
+		values, err := c.client.HMGet(ctx, c.sessionKey(sessionId), "state", "lastAcquisitionId").Result()
+		if err != nil {
+			return "", err
+		}
+
+		if pending, err := c.client.Exists(ctx, c.onloadingKey(sessionId)).Result(); err != nil {
+			return "", err
+		} else if pending == 1 {
+			return "", api.ErrSessionAlreadyOnloaded
+		}
+
+		if state, ok := values[0].(string); ok && state != "offloaded" {
+			return "", api.ErrSessionAlreadyOnloaded
+		}
+
+		if last, ok := values[1].(string); ok {
+			if lastAcquisitionId, err := strconv.ParseUint(last, 10, 64); err != nil {
+				return "", err
+			} else if lastAcquisitionId > metadata.FencingToken {
+				return "", api.ErrStaleFencingToken
+			}
+		}
+	}
+
+	stagingPrefix := c.stagingPrefix(newSessionId())
+	keys, err := c.restoreSessionData(ctx, stagingPrefix, reader)
+	if err != nil {
+		c.deleteStagedKeys(ctx, stagingPrefix, keys)
+		return "", err
+	}
+
+	clientGeoCoordinates, err := encodeOptional(metadata.ClientGeoCoordinates)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt, err := encodeOptional(metadata.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	lastVisited, err := encodeOptional(opt.OnloadedFrom())
+	if err != nil {
+		return "", err
+	}
+
+	args := []interface{}{
+		sessionId,
+		metadata.CreatedIn,
+		metadata.CreatedAt,
+		now(),
+		expiresAt,
+		clientGeoCoordinates,
+		lastVisited,
+		metadata.FencingToken,
+		flag(opt.Pending()),
+		stagingPrefix,
+		c.sessionDataPrefix(sessionId),
+	}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	// The staged key space is moved only if the onload succeeds.
+	if _, err = c.run(ctx, onloadSessionScript, c.onloadKeys(sessionId), args...); err != nil {
+		c.deleteStagedKeys(ctx, stagingPrefix, keys)
+		return "", err
+	}
+
+	return sessionId, nil
+}
+
+// Commits the pending onload of a session.
+func (c *Commands) CommitSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	_, err := c.run(ctx, commitSessionOnloadScript, c.onloadKeys(sessionId), sessionId)
+	return err
+}
+
+// Aborts the pending onload of a session and deletes its key space. The moved
+// keys are read from the hash of the pending onload and deleted by the script
+// that aborts it, which is retried if the pending onload changed in between.
+func (c *Commands) AbortSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	for {
+		fields, err := c.client.HGetAll(ctx, c.onloadingKey(sessionId)).Result()
+		if err != nil {
+			return err
+		}
+
+		keys := []string{c.onloadingKey(sessionId), c.sessionKey(sessionId)}
+		for field := range fields {
+			if key, ok := strings.CutPrefix(field, "key:"); ok {
+				keys = append(keys, c.SessionKey(sessionId, key))
+			}
+		}
+
+		if _, err = c.run(ctx, abortSessionOnloadScript, keys, fields["onload"]); err != errOnloadChanged {
+			return err
+		}
+	}
+}
+
+// The keys of the scripts that onload a session.
+func (c *Commands) onloadKeys(sessionId string) []string {
+	return []string{
+		c.sessionKey(sessionId),
+		c.seqKey(),
+		c.sessionsKey(),
+		c.liveKey(),
+		c.acquisitionsKey(sessionId),
+		c.onloadingKey(sessionId),
+		c.journalKey(),
+	}
+}
+
+// Restore the framed session key space written by dumpSessionData under the
+// given prefix, returns the restored keys without the prefix.
+func (c *Commands) restoreSessionData(ctx context.Context, prefix string, reader io.Reader) ([]string, error) {
+	r := bufio.NewReader(reader)
+	keys := []string{}
+
+	for {
+		key, err := framing.ReadField(r)
+		// A clean EOF before a key is the end of the stream.
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return keys, err
+		}
+
+		var fields [2][]byte
+		for i := range fields {
+			if fields[i], err = framing.ReadField(r); err != nil {
+				return keys, framing.UnexpectedEOF(err)
+			}
+		}
+
+		milliseconds, err := strconv.ParseInt(string(fields[0]), 10, 64)
+		if err != nil {
+			return keys, err
+		}
+
+		ttl := time.Duration(milliseconds) * time.Millisecond
+		if err := c.client.RestoreReplace(ctx, prefix+string(key), ttl, string(fields[1])).Err(); err != nil {
+			return keys, err
+		}
+
+		keys = append(keys, string(key))
+	}
+}
+
+// Delete the keys restored under the prefix by an onload that failed.
+func (c *Commands) deleteStagedKeys(ctx context.Context, prefix string, keys []string) error {
+	for start := 0; start < len(keys); start += 128 {
+		batch := make([]string, 0, 128)
+		for _, key := range keys[start:min(start+128, len(keys))] {
+			batch = append(batch, prefix+key)
+		}
+
+		if err := c.client.Del(ctx, batch...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
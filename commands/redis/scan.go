@@ -0,0 +1,65 @@
+package redis_commands
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/redis/go-redis/v9"
+)
+
+// Scan the sessions that match the filter. The cursor is the sequence number
+// of the next session to return, 0 to start from the beginning. The returned
+// cursor is 0 when the scan is completed. Sessions stored for the whole scan are
+// returned at least once. Each call examines at most count sessions, so fewer
+// ids may be returned before the scan is completed.
+func (c *Commands) scan(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+	filter string,
+) (ids []string, newCursor uint64, err error) {
+	if count <= 0 {
+		return nil, 0, api.ErrInvalidCount
+	}
+
+	// One more session is read to find the cursor of the next page.
+	page, err := c.client.ZRangeByScoreWithScores(ctx, c.sessionsKey(), &redis.ZRangeBy{
+		Min:   strconv.FormatUint(cursor, 10),
+		Max:   "+inf",
+		Count: count + 1,
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if int64(len(page)) > count {
+		newCursor = uint64(page[count].Score)
+		page = page[:count]
+	}
+
+	// The sessions of the page are filtered by a script that declares their
+	// keys.
+	keys := make([]string, 1, 1+2*len(page))
+	keys[0] = c.seqKey()
+	args := []interface{}{cursor, filter, nowMillis()}
+	for _, member := range page {
+		keys = append(keys, c.sessionKey(member.Member.(string)))
+		args = append(args, member.Member)
+	}
+	for _, member := range page {
+		keys = append(keys, c.acquisitionsKey(member.Member.(string)))
+	}
+
+	reply, err := c.run(ctx, scanScript, keys, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids = make([]string, 0, len(reply[0].([]interface{})))
+	for _, id := range reply[0].([]interface{}) {
+		ids = append(ids, id.(string))
+	}
+
+	return ids, newCursor, nil
+}
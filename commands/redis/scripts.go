@@ -0,0 +1,660 @@
+package redis_commands
+
+import "github.com/redis/go-redis/v9"
+
+// The hash of a session holds the fields:
+//   - seq: the sequence number of the session.
+//   - createdIn, createdAt, updatedAt, expiresAt, clientGeoCoordinates: the
+//     metadata of the session, expiresAt and clientGeoCoordinates may be empty.
+//   - lastAcquisitionId: the id of the last acquisition of the session.
+//   - state: "active", "offloading" or "offloaded".
+//   - offloadedTo: the location of the offloaded session, as JSON.
+//   - clientRedirected: "1" if a client has been redirected to offloadedTo.
+//   - lastVisited: the location last visited by the client, as JSON, empty if
+//     the client already visited this node.
+//   - resources: the resources usage of the session, as JSON.
+//
+// The hash of the acquisitions of a session maps the id of each active
+// acquisition to "<allowOffloading>:<mode>:<leaseExpiresAt>", where mode is the
+// api.AcquisitionMode and leaseExpiresAt is a Unix timestamp in milliseconds, 0
+// if the acquisition has no lease.
+//
+// The hash of the offload journal maps the id of each journaled session to its
+// api.OffloadJournalEntry, as JSON.
+
+// The functions shared by the scripts that handle the acquisitions. The fencing
+// token of a write is the id of a live acquisition, "0" if the write is not
+// fenced.
+const acquisitionsLua = `
+local function expired(value, now)
+	local expiresAt = tonumber(string.match(value, ':(%d+)$'))
+	return expiresAt ~= 0 and expiresAt <= now
+end
+
+local function countAcquisitions(key, now)
+	local live, blocking, readers, writers = 0, 0, 0, 0
+	local fields = redis.call('HGETALL', key)
+	for i = 1, #fields, 2 do
+		if expired(fields[i + 1], now) then
+			redis.call('HDEL', key, fields[i])
+		else
+			live = live + 1
+			if string.sub(fields[i + 1], 1, 1) ~= '1' then
+				blocking = blocking + 1
+			end
+			local mode = string.match(fields[i + 1], '^%d:(%d+):')
+			if mode == '1' then
+				readers = readers + 1
+			elseif mode == '2' then
+				writers = writers + 1
+			end
+		end
+	end
+	return live, blocking, readers, writers
+end
+
+local function checkFencingToken(state, key, fencingToken, now)
+	if not state or (state == 'offloaded' and fencingToken == '0') then
+		return 'SESSION_NOT_FOUND'
+	end
+	if fencingToken == '0' then
+		return nil
+	end
+	if state == 'offloaded' then
+		return 'STALE_FENCING_TOKEN'
+	end
+	if state == 'offloading' then
+		return 'SESSION_IS_OFFLOADING'
+	end
+	countAcquisitions(key, now)
+	local acquisition = redis.call('HGET', key, fencingToken)
+	if not acquisition then
+		return 'STALE_FENCING_TOKEN'
+	end
+	if string.match(acquisition, '^%d:(%d+):') == '1' then
+		return 'ACQUISITION_IS_READ_ONLY'
+	end
+	return nil
+end
+`
+
+// Create a session, and optionally acquire it. Reply the id of the acquisition,
+// 0 if not acquired.
+// KEYS: session, seq, sessions, live, acquisitions.
+// ARGV: id, createdIn, createdAt, expiresAt, clientGeoCoordinates, acquire,
+// acquisition.
+var createSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return {'SESSION_ID_ALREADY_EXISTS'}
+end
+
+local seq = redis.call('INCR', KEYS[2])
+local acquisitionId = 0
+redis.call('DEL', KEYS[5])
+if ARGV[6] == '1' then
+	acquisitionId = 1
+	redis.call('HSET', KEYS[5], acquisitionId, ARGV[7])
+end
+
+redis.call('HSET', KEYS[1],
+	'seq', seq,
+	'createdIn', ARGV[2],
+	'createdAt', ARGV[3],
+	'updatedAt', ARGV[3],
+	'expiresAt', ARGV[4],
+	'clientGeoCoordinates', ARGV[5],
+	'lastAcquisitionId', acquisitionId,
+	'state', 'active',
+	'offloadedTo', '',
+	'clientRedirected', '0',
+	'lastVisited', '',
+	'resources', '{}')
+redis.call('ZADD', KEYS[3], seq, ARGV[1])
+redis.call('INCR', KEYS[4])
+
+return {'OK', acquisitionId}
+`)
+
+// Acquire a session. Reply the id of the acquisition, or 0 and the location of
+// the session if it is offloaded.
+// KEYS: session, acquisitions.
+// ARGV: acquisition, allowWhileOffloading, now in milliseconds, mode.
+var acquireSessionScript = redis.NewScript(acquisitionsLua + `
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state == 'offloaded' then
+	redis.call('HSET', KEYS[1], 'clientRedirected', '1')
+	return {'OK', 0, redis.call('HGET', KEYS[1], 'offloadedTo')}
+end
+
+if state == 'offloading' and ARGV[2] ~= '1' then
+	return {'SESSION_IS_OFFLOADING'}
+end
+
+local live, _, _, writers = countAcquisitions(KEYS[2], tonumber(ARGV[3]))
+if writers > 0 or (ARGV[4] == '2' and live > 0) then
+	return {'SESSION_IS_ACQUIRED'}
+end
+
+local acquisitionId = redis.call('HINCRBY', KEYS[1], 'lastAcquisitionId', 1)
+redis.call('HSET', KEYS[2], acquisitionId, ARGV[1])
+redis.call('HSET', KEYS[1], 'lastVisited', '')
+
+return {'OK', acquisitionId}
+`)
+
+// Release an acquisition of a session. If the session is offloaded, reply its
+// location.
+// KEYS: session, acquisitions.
+// ARGV: acquisitionId, now, now in milliseconds.
+var releaseSessionScript = redis.NewScript(acquisitionsLua + `
+local values = redis.call('HMGET', KEYS[1], 'state', 'offloadedTo')
+if not values[1] then
+	return {'SESSION_NOT_FOUND'}
+end
+
+countAcquisitions(KEYS[2], tonumber(ARGV[3]))
+if redis.call('HDEL', KEYS[2], ARGV[1]) == 0 then
+	return {'NO_ACQUISITION_TO_RELEASE'}
+end
+
+redis.call('HSET', KEYS[1], 'updatedAt', ARGV[2])
+
+if values[1] == 'offloaded' then
+	return {'OK', values[2]}
+end
+
+return {'OK'}
+`)
+
+// Renew the lease of an acquisition of a session.
+// KEYS: session, acquisitions.
+// ARGV: acquisitionId, now in milliseconds, leaseExpiresAt.
+var renewSessionLeaseScript = redis.NewScript(acquisitionsLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return {'SESSION_NOT_FOUND'}
+end
+
+countAcquisitions(KEYS[2], tonumber(ARGV[2]))
+local value = redis.call('HGET', KEYS[2], ARGV[1])
+if not value then
+	return {'LEASE_EXPIRED'}
+end
+
+redis.call('HSET', KEYS[2], ARGV[1], string.match(value, '^(.*):%d+$') .. ':' .. ARGV[3])
+
+return {'OK'}
+`)
+
+// Filter the sessions of a page of a scan. Reply the ids of the sessions that
+// match the filter, the sessions deleted in the meantime are skipped.
+// KEYS: seq, then the session key of each session, then the acquisitions key
+// of each session.
+// ARGV: cursor, filter ("all", "live", "offloadable" or "offloaded"), now in
+// milliseconds, then the id of each session.
+var scanScript = redis.NewScript(acquisitionsLua + `
+if tonumber(ARGV[1]) > tonumber(redis.call('GET', KEYS[1]) or '0') then
+	return {'INVALID_CURSOR'}
+end
+
+local function matches(state, acquisitions)
+	if ARGV[2] == 'live' then
+		return state ~= 'offloaded'
+	elseif ARGV[2] == 'offloadable' then
+		if state ~= 'active' then
+			return false
+		end
+		local _, blocking = countAcquisitions(acquisitions, tonumber(ARGV[3]))
+		return blocking == 0
+	elseif ARGV[2] == 'offloaded' then
+		return state == 'offloaded'
+	end
+	return true
+end
+
+local count = #ARGV - 3
+local ids = {}
+for i = 1, count do
+	local state = redis.call('HGET', KEYS[1 + i], 'state')
+	if state and matches(state, KEYS[1 + count + i]) then
+		table.insert(ids, ARGV[3 + i])
+	end
+end
+
+return {'OK', ids}
+`)
+
+// Start the offload of a session, and journal it.
+// KEYS: session, acquisitions, journal.
+// ARGV: now in milliseconds, id, journal entry.
+var offloadSessionScript = redis.NewScript(acquisitionsLua + `
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state or state == 'offloaded' then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state == 'offloading' then
+	return {'SESSION_IS_OFFLOADING'}
+end
+
+local _, blocking = countAcquisitions(KEYS[2], tonumber(ARGV[1]))
+if blocking > 0 then
+	return {'UNABLE_TO_OFFLOAD_ACQUIRED_SESSION'}
+end
+
+redis.call('HSET', KEYS[1], 'state', 'offloading')
+redis.call('HSET', KEYS[3], ARGV[2], ARGV[3])
+
+return {'OK'}
+`)
+
+// Confirm the offload of a session, reply the last visited location.
+// KEYS: session, usage, live, journal.
+// ARGV: newLocation, id, journal entry.
+var confirmSessionOffloadScript = redis.NewScript(`
+local values = redis.call('HMGET', KEYS[1], 'state', 'lastVisited', 'resources')
+if not values[1] or values[1] == 'offloaded' then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if values[1] ~= 'offloading' then
+	return {'SESSION_IS_NOT_OFFLOADING'}
+end
+
+for resource, value in pairs(cjson.decode(values[3])) do
+	redis.call('HINCRBYFLOAT', KEYS[2], resource, tostring(-value))
+end
+redis.call('DECR', KEYS[3])
+redis.call('HSET', KEYS[4], ARGV[2], ARGV[3])
+
+redis.call('HSET', KEYS[1],
+	'state', 'offloaded',
+	'offloadedTo', ARGV[1],
+	'clientRedirected', '0',
+	'lastVisited', '',
+	'resources', '{}')
+
+return {'OK', values[2]}
+`)
+
+// Abort the offload of a session, that returns active.
+// KEYS: session, journal.
+// ARGV: id.
+var abortSessionOffloadScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state ~= 'offloading' then
+	return {'SESSION_IS_NOT_OFFLOADING'}
+end
+
+redis.call('HSET', KEYS[1], 'state', 'active')
+redis.call('HDEL', KEYS[2], ARGV[1])
+
+return {'OK'}
+`)
+
+// Record the location an offloading session is being offloaded to.
+// KEYS: session, journal.
+// ARGV: id, journal entry.
+var recordSessionOffloadTargetScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state or state == 'offloaded' then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state ~= 'offloading' then
+	return {'SESSION_IS_NOT_OFFLOADING'}
+end
+
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+
+return {'OK'}
+`)
+
+// Complete a confirmed offload, deleting it from the journal.
+// KEYS: session, journal.
+// ARGV: id.
+var completeSessionOffloadScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state ~= 'offloaded' then
+	return {'SESSION_IS_NOT_OFFLOADED'}
+end
+
+redis.call('HDEL', KEYS[2], ARGV[1])
+
+return {'OK'}
+`)
+
+// Reply the status of the onload of a session: pending, committed, moved if
+// the session is a tombstone, or aborted.
+// KEYS: onloading, session.
+var onloadStatusScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return {'OK', 'pending'}
+end
+
+local state = redis.call('HGET', KEYS[2], 'state')
+if not state then
+	return {'OK', 'aborted'}
+end
+
+if state == 'offloaded' then
+	return {'OK', 'moved'}
+end
+
+return {'OK', 'committed'}
+`)
+
+// Update the location of an offloaded session, reply if a client has been
+// redirected.
+// KEYS: session.
+// ARGV: newLocation.
+var updateOffloadedSessionLocationScript = redis.NewScript(`
+local values = redis.call('HMGET', KEYS[1], 'state', 'clientRedirected')
+if not values[1] then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if values[1] ~= 'offloaded' then
+	return {'SESSION_IS_NOT_OFFLOADED'}
+end
+
+redis.call('HSET', KEYS[1], 'offloadedTo', ARGV[1])
+
+return {'OK', values[2]}
+`)
+
+// Delete the tombstone of an offloaded session.
+// KEYS: session, acquisitions, sessions, journal.
+// ARGV: id.
+var deleteOffloadedSessionScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state then
+	return {'SESSION_NOT_FOUND'}
+end
+
+if state ~= 'offloaded' then
+	return {'SESSION_IS_NOT_OFFLOADED'}
+end
+
+if redis.call('HEXISTS', KEYS[4], ARGV[1]) == 1 then
+	return {'OFFLOAD_NOT_COMMITTED'}
+end
+
+redis.call('DEL', KEYS[1], KEYS[2])
+redis.call('ZREM', KEYS[3], ARGV[1])
+
+return {'OK'}
+`)
+
+// The functions shared by the scripts that onload a session. A tombstone of the
+// same session is replaced, dropping its acquisitions, unless it has been
+// offloaded after the onloaded data. The fencing tokens continue from the
+// highest between the one of the metadata and the last one of the tombstone.
+// The keys are those of onloadSessionScript.
+const onloadLua = `
+local function checkOnload(fencingToken)
+	local values = redis.call('HMGET', KEYS[1], 'state', 'lastAcquisitionId')
+	if values[1] and values[1] ~= 'offloaded' then
+		return 'SESSION_ALREADY_ONLOADED'
+	end
+	if tonumber(values[2] or '0') > tonumber(fencingToken) then
+		return 'STALE_FENCING_TOKEN'
+	end
+	return nil
+end
+
+local function onload(id, createdIn, createdAt, updatedAt, expiresAt, clientGeoCoordinates, lastVisited, fencingToken)
+	local err = checkOnload(fencingToken)
+	if err then
+		return err
+	end
+
+	redis.call('DEL', KEYS[5])
+
+	local seq = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1],
+		'seq', seq,
+		'createdIn', createdIn,
+		'createdAt', createdAt,
+		'updatedAt', updatedAt,
+		'expiresAt', expiresAt,
+		'clientGeoCoordinates', clientGeoCoordinates,
+		'lastAcquisitionId', fencingToken,
+		'state', 'active',
+		'offloadedTo', '',
+		'clientRedirected', '0',
+		'lastVisited', lastVisited,
+		'resources', '{}')
+	redis.call('ZADD', KEYS[3], seq, id)
+	redis.call('INCR', KEYS[4])
+	redis.call('HDEL', KEYS[7], id)
+	return nil
+end
+`
+
+// The hash of a pending onload holds the arguments of onload, until the onload
+// is committed or aborted. The data of the session is already moved, the hash
+// holds the staging prefix of the onload in the field "onload" and a field
+// "key:<key>" for each moved key, so that an abort can declare them.
+
+// Onload a session whose data has been restored under the staging prefix, or
+// keep it pending. Once the onload is accepted, the staged keys (that may have
+// expired in the meantime) are renamed to the session key space.
+// KEYS: session, seq, sessions, live, acquisitions, onloading, journal.
+// ARGV: id, createdIn, createdAt, updatedAt, expiresAt, clientGeoCoordinates,
+// lastVisited, fencingToken, pending, stagingPrefix, dataPrefix, keys...
+var onloadSessionScript = redis.NewScript(onloadLua + `
+if redis.call('EXISTS', KEYS[6]) == 1 then
+	return {'SESSION_ALREADY_ONLOADED'}
+end
+
+local function moveStagedKeys()
+	for i = 12, #ARGV do
+		if redis.call('EXISTS', ARGV[10] .. ARGV[i]) == 1 then
+			redis.call('RENAME', ARGV[10] .. ARGV[i], ARGV[11] .. ARGV[i])
+		end
+	end
+end
+
+local err
+if ARGV[9] == '1' then
+	err = checkOnload(ARGV[8])
+	if not err then
+		redis.call('HSET', KEYS[6],
+			'createdIn', ARGV[2],
+			'createdAt', ARGV[3],
+			'updatedAt', ARGV[4],
+			'expiresAt', ARGV[5],
+			'clientGeoCoordinates', ARGV[6],
+			'lastVisited', ARGV[7],
+			'fencingToken', ARGV[8],
+			'onload', ARGV[10])
+		for i = 12, #ARGV do
+			redis.call('HSET', KEYS[6], 'key:' .. ARGV[i], '')
+		end
+	end
+else
+	err = onload(unpack(ARGV, 1, 8))
+end
+
+if err then
+	return {err}
+end
+
+moveStagedKeys()
+
+return {'OK'}
+`)
+
+// Commit the pending onload of a session.
+// KEYS: the keys of onloadSessionScript.
+// ARGV: id.
+var commitSessionOnloadScript = redis.NewScript(onloadLua + `
+local values = redis.call('HMGET', KEYS[6], 'createdIn', 'createdAt', 'updatedAt', 'expiresAt', 'clientGeoCoordinates', 'lastVisited', 'fencingToken')
+if not values[1] then
+	if redis.call('EXISTS', KEYS[1]) == 1 then
+		return {'SESSION_IS_NOT_ONLOADING'}
+	end
+	return {'SESSION_NOT_FOUND'}
+end
+
+local err = onload(ARGV[1], unpack(values))
+if err then
+	return {err}
+end
+
+redis.call('DEL', KEYS[6])
+
+return {'OK'}
+`)
+
+// Abort the pending onload of a session and delete its moved keys, a tombstone
+// of the session points again to the location the session was onloaded from.
+// Reply ONLOAD_CHANGED if the pending onload is not the one whose keys are
+// given.
+// KEYS: onloading, session, then the data key of each moved key.
+// ARGV: the staging prefix of the pending onload.
+var abortSessionOnloadScript = redis.NewScript(`
+local values = redis.call('HMGET', KEYS[1], 'lastVisited', 'onload')
+local lastVisited = values[1]
+if not lastVisited then
+	if redis.call('EXISTS', KEYS[2]) == 1 then
+		return {'SESSION_IS_NOT_ONLOADING'}
+	end
+	return {'SESSION_NOT_FOUND'}
+end
+
+if values[2] ~= ARGV[1] then
+	return {'ONLOAD_CHANGED'}
+end
+
+redis.call('DEL', KEYS[1])
+for i = 3, #KEYS do
+	redis.call('DEL', KEYS[i])
+end
+if lastVisited ~= '' and redis.call('HGET', KEYS[2], 'state') == 'offloaded' then
+	redis.call('HSET', KEYS[2], 'offloadedTo', lastVisited, 'clientRedirected', '0')
+end
+
+return {'OK'}
+`)
+
+// Set the metadata of a session.
+// KEYS: session, acquisitions.
+// ARGV: clientGeoCoordinates, expiresAt, expired, now, fencingToken, now in
+// milliseconds. Empty values are not set.
+var setSessionMetadataScript = redis.NewScript(acquisitionsLua + `
+local err = checkFencingToken(redis.call('HGET', KEYS[1], 'state'), KEYS[2], ARGV[5], tonumber(ARGV[6]))
+if err then
+	return {err}
+end
+
+if ARGV[1] ~= '' then
+	redis.call('HSET', KEYS[1], 'clientGeoCoordinates', ARGV[1])
+end
+
+if ARGV[3] == '1' then
+	redis.call('HSET', KEYS[1], 'expiresAt', ARGV[4])
+elseif ARGV[2] ~= '' then
+	redis.call('HSET', KEYS[1], 'expiresAt', ARGV[2])
+end
+
+redis.call('HSET', KEYS[1], 'updatedAt', ARGV[4])
+
+return {'OK'}
+`)
+
+// Replace the resources usage of a session and update the usage of the node.
+// KEYS: session, usage.
+// ARGV: resources, now.
+var updateSessionResourcesUsageScript = redis.NewScript(`
+local values = redis.call('HMGET', KEYS[1], 'state', 'resources')
+if not values[1] or values[1] == 'offloaded' then
+	return {'SESSION_NOT_FOUND'}
+end
+
+for resource, value in pairs(cjson.decode(values[2])) do
+	redis.call('HINCRBYFLOAT', KEYS[2], resource, tostring(-value))
+end
+for resource, value in pairs(cjson.decode(ARGV[1])) do
+	redis.call('HINCRBYFLOAT', KEYS[2], resource, tostring(value))
+end
+
+redis.call('HSET', KEYS[1], 'resources', ARGV[1], 'updatedAt', ARGV[2])
+
+return {'OK'}
+`)
+
+// Set or delete a key of the session key space if the session is stored and
+// not offloaded, and the fencing token is valid.
+// KEYS: session, data key, acquisitions.
+// ARGV: operation ("set" or "del"), value, fencingToken, now in milliseconds.
+var sessionDataScript = redis.NewScript(acquisitionsLua + `
+local err = checkFencingToken(redis.call('HGET', KEYS[1], 'state'), KEYS[3], ARGV[3], tonumber(ARGV[4]))
+if err then
+	return {err}
+end
+
+if ARGV[1] == 'set' then
+	redis.call('SET', KEYS[2], ARGV[2])
+else
+	redis.call('DEL', KEYS[2])
+end
+
+return {'OK'}
+`)
+
+// Collect a batch of expired sessions, reply the next cursor and the collected
+// session ids.
+// KEYS: sessions, usage, live, journal.
+// ARGV: cursor, batch size, now, expiredUnreleasedOlderThan (may be empty),
+// session key prefix, acquisitions key prefix, now in milliseconds.
+var garbageCollectSessionsScript = redis.NewScript(acquisitionsLua + `
+local now = tonumber(ARGV[3])
+local batch = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], '+inf', 'WITHSCORES', 'LIMIT', 0, tonumber(ARGV[2]))
+local collected = {}
+local next = 0
+
+for i = 1, #batch, 2 do
+	local key = ARGV[5] .. batch[i]
+	local values = redis.call('HMGET', key, 'state', 'expiresAt', 'resources')
+	local expiresAt = tonumber(values[2])
+
+	local journaled = redis.call('HEXISTS', KEYS[4], batch[i]) == 1
+	if values[1] and values[1] ~= 'offloading' and not journaled and expiresAt and expiresAt <= now then
+		local acquisitions = countAcquisitions(ARGV[6] .. batch[i], tonumber(ARGV[7]))
+		local collect = acquisitions == 0 or (ARGV[4] ~= '' and expiresAt <= now - tonumber(ARGV[4]))
+		if collect then
+			if values[1] == 'active' then
+				for resource, value in pairs(cjson.decode(values[3])) do
+					redis.call('HINCRBYFLOAT', KEYS[2], resource, tostring(-value))
+				end
+				redis.call('DECR', KEYS[3])
+			end
+
+			redis.call('DEL', key, ARGV[6] .. batch[i])
+			redis.call('ZREM', KEYS[1], batch[i])
+			table.insert(collected, batch[i])
+		end
+	end
+
+	next = tonumber(batch[i + 1]) + 1
+end
+
+if #batch < 2 * tonumber(ARGV[2]) then
+	next = 0
+end
+
+return {'OK', next, collected}
+`)
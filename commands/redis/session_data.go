@@ -0,0 +1,113 @@
+package redis_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+	"github.com/redis/go-redis/v9"
+)
+
+// Get the value of a string key in the session key space, nil if the key is
+// not set. Other types can be read with the client at SessionKey.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionData(
+	ctx context.Context,
+	sessionId string,
+	key string,
+) ([]byte, error) {
+	var state *redis.StringCmd
+	var value *redis.StringCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		state = pipe.HGet(ctx, c.sessionKey(sessionId), "state")
+		value = pipe.Get(ctx, c.SessionKey(sessionId, key))
+		return nil
+	})
+
+	if state.Err() == redis.Nil || state.Val() == "offloaded" {
+		return nil, api.ErrSessionNotFound
+	}
+
+	if value.Err() == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value.Bytes()
+}
+
+// Set the value of a string key in the session key space. The write is fenced
+// by the fencing token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) SetSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+	value []byte,
+) error {
+	_, err := c.run(ctx, sessionDataScript,
+		[]string{c.sessionKey(sessionId), c.SessionKey(sessionId, key), c.acquisitionsKey(sessionId)},
+		"set", value, fencingToken, nowMillis())
+	return err
+}
+
+// Delete a key from the session key space. The write is fenced by the fencing
+// token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) DeleteSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+) error {
+	_, err := c.run(ctx, sessionDataScript,
+		[]string{c.sessionKey(sessionId), c.SessionKey(sessionId, key), c.acquisitionsKey(sessionId)},
+		"del", "", fencingToken, nowMillis())
+	return err
+}
+
+// Delete the whole session key space.
+func (c *Commands) deleteSessionData(ctx context.Context, sessionId string) error {
+	iter := c.client.Scan(ctx, 0, escapePattern(c.sessionDataPrefix(sessionId))+"*", 128).Iterator()
+	for iter.Next(ctx) {
+		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+// Returns the number of bytes of the session data stream of an offload of the
+// session, the key space is dumped to measure it.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionSize(
+	ctx context.Context,
+	sessionId string,
+) (int64, error) {
+	if _, err := c.GetSessionMetadata(ctx, sessionId); err != nil {
+		return 0, err
+	}
+
+	var size framing.Counter
+	err := c.dumpSessionData(ctx, sessionId, &size)
+	return int64(size), err
+}
@@ -0,0 +1,82 @@
+package redis_commands
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Returns the metadata associated with a session.
+func (c *Commands) GetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+) (api.SessionMetadata, error) {
+	fields, err := c.client.HGetAll(ctx, c.sessionKey(sessionId)).Result()
+	if err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if len(fields) == 0 || fields["state"] == "offloaded" {
+		return api.SessionMetadata{}, api.ErrSessionNotFound
+	}
+
+	return decodeMetadata(fields)
+}
+
+// Sets the metadata associated with a session.
+func (c *Commands) SetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+	opt api.SessionMetadataOptions,
+) error {
+	clientGeoCoordinates, err := encodeOptional(opt.ClientGeoCoordinates())
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := encodeOptional(opt.ExpiresAt())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.run(ctx, setSessionMetadataScript,
+		[]string{c.sessionKey(sessionId), c.acquisitionsKey(sessionId)},
+		clientGeoCoordinates, expiresAt, flag(opt.Expired()), now(), opt.FencingToken(), nowMillis())
+	return err
+}
+
+// Decode the metadata from the fields of the hash of a session.
+func decodeMetadata(fields map[string]string) (api.SessionMetadata, error) {
+	metadata := api.SessionMetadata{CreatedIn: fields["createdIn"]}
+
+	var err error
+	if metadata.CreatedAt, err = strconv.ParseInt(fields["createdAt"], 10, 64); err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if metadata.UpdatedAt, err = strconv.ParseInt(fields["updatedAt"], 10, 64); err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if metadata.ExpiresAt, err = parseOptionalInt64(fields["expiresAt"]); err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if metadata.FencingToken, err = strconv.ParseUint(fields["lastAcquisitionId"], 10, 64); err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if fields["clientGeoCoordinates"] != "" {
+		var clientGeoCoordinates infrastructure.GeoCoordinates
+		if err := json.Unmarshal([]byte(fields["clientGeoCoordinates"]), &clientGeoCoordinates); err != nil {
+			return api.SessionMetadata{}, err
+		}
+
+		metadata.ClientGeoCoordinates = &clientGeoCoordinates
+	}
+
+	return metadata, nil
+}
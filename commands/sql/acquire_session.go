@@ -0,0 +1,182 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Acquires a session. If the session has been offloaded it returns the new
+// location of the session without acquiring it.
+func (c *Commands) AcquireSession(
+	ctx context.Context,
+	sessionId string,
+	opt api.AcquireSessionOptions,
+) (acquisitionId uint64, offloadedTo *api.SessionLocation, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		acquisitionId, offloadedTo, err = c.acquireSession(ctx, tx, sessionId, opt)
+		return err
+	})
+
+	return acquisitionId, offloadedTo, err
+}
+
+// Acquires a session in the transaction.
+func (c *Commands) acquireSession(
+	ctx context.Context,
+	tx *sql.Tx,
+	sessionId string,
+	opt api.AcquireSessionOptions,
+) (uint64, *api.SessionLocation, error) {
+	s, err := c.lockSession(ctx, tx, sessionId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// If the session has been offloaded, the client is being redirected.
+	if s.state == stateOffloaded {
+		if _, err := c.exec(ctx, tx,
+			`UPDATE ermes_offload_tombstones SET client_redirected = ? WHERE session_id = ?`,
+			true, sessionId); err != nil {
+			return 0, nil, err
+		}
+
+		offloadedTo, err := c.offloadedTo(ctx, tx, sessionId)
+		return 0, offloadedTo, err
+	}
+
+	if s.state == stateOffloading && !opt.AllowWhileOffloading() {
+		return 0, nil, api.ErrSessionIsOffloading
+	}
+
+	if _, _, err := c.expireLeases(ctx, tx, sessionId); err != nil {
+		return 0, nil, err
+	}
+
+	// The writers exclude everyone else, the others exclude only the writers.
+	var conflicts int64
+	if err := c.queryRow(ctx, tx,
+		`SELECT COUNT(*) FROM ermes_session_acquisitions WHERE session_id = ? AND (mode = ? OR ?)`,
+		sessionId, int(api.AcquisitionModeWrite), opt.Mode() == api.AcquisitionModeWrite).Scan(&conflicts); err != nil {
+		return 0, nil, err
+	} else if conflicts > 0 {
+		return 0, nil, api.ErrSessionIsAcquired
+	}
+
+	acquisitionId := s.lastAcquisitionId + 1
+	if _, err := c.exec(ctx, tx,
+		`INSERT INTO ermes_session_acquisitions (session_id, acquisition_id, allow_offloading, mode, lease_expires_at) VALUES (?, ?, ?, ?, ?)`,
+		sessionId, acquisitionId, opt.AllowOffloading(), int(opt.Mode()), leaseExpiresAt(opt.LeaseDuration())); err != nil {
+		return 0, nil, err
+	}
+
+	// The client reached this node.
+	_, err = c.exec(ctx, tx,
+		`UPDATE ermes_sessions SET last_acquisition_id = ?, last_visited = NULL WHERE id = ?`,
+		acquisitionId, sessionId)
+	return acquisitionId, nil, err
+}
+
+// Releases a previously acquired session. If the session has been offloaded
+// while acquired it returns the new location of the session.
+func (c *Commands) ReleaseSession(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+) (offloadedTo *api.SessionLocation, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, sessionId)
+		if err != nil {
+			return err
+		}
+
+		if _, _, err := c.expireLeases(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		result, err := c.exec(ctx, tx,
+			`DELETE FROM ermes_session_acquisitions WHERE session_id = ? AND acquisition_id = ?`,
+			sessionId, acquisitionId)
+		if err != nil {
+			return err
+		}
+
+		if deleted, err := result.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return api.ErrNoAcquisitionToRelease
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`UPDATE ermes_session_metadata SET updated_at = ? WHERE session_id = ?`,
+			now(), sessionId); err != nil {
+			return err
+		}
+
+		if s.state == stateOffloaded {
+			offloadedTo, err = c.offloadedTo(ctx, tx, sessionId)
+		}
+
+		return err
+	})
+
+	return offloadedTo, err
+}
+
+// Renews the lease of an acquisition.
+func (c *Commands) RenewSessionLease(
+	ctx context.Context,
+	sessionId string,
+	acquisitionId uint64,
+	leaseDuration time.Duration,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockSession(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		if _, _, err := c.expireLeases(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		result, err := c.exec(ctx, tx,
+			`UPDATE ermes_session_acquisitions SET lease_expires_at = ? WHERE session_id = ? AND acquisition_id = ?`,
+			leaseExpiresAt(leaseDuration), sessionId, acquisitionId)
+		if err != nil {
+			return err
+		}
+
+		if updated, err := result.RowsAffected(); err != nil {
+			return err
+		} else if updated == 0 {
+			return api.ErrLeaseExpired
+		}
+
+		return nil
+	})
+}
+
+// Returns the sessions that are neither offloading, offloaded nor acquired
+// with acquisitions that do not allow offloading.
+func (c *Commands) ScanOffloadableSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, filterOffloadable, nowMillis())
+}
+
+// Get the location of an offloaded session.
+func (c *Commands) offloadedTo(ctx context.Context, tx *sql.Tx, sessionId string) (*api.SessionLocation, error) {
+	var encoded sql.NullString
+	err := c.queryRow(ctx, tx,
+		`SELECT offloaded_to FROM ermes_offload_tombstones WHERE session_id = ?`,
+		sessionId).Scan(&encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeOptional[api.SessionLocation](encoded)
+}
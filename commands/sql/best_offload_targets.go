@@ -0,0 +1,127 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/policy"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Return the offloadable sessions with the highest resources usage, at most
+// opt.MaxTargets.
+func (c *Commands) BestSessionsToOffload(
+	ctx context.Context,
+	opt api.BestOffloadTargetsOptions,
+) (sessions map[string]api.SessionInfoForOffloadDecision, err error) {
+	candidates := make(map[string]api.SessionInfoForOffloadDecision)
+
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := c.query(ctx, tx,
+			`SELECT ermes_sessions.id, m.created_in, m.created_at, m.updated_at, m.expires_at, m.client_geo_coordinates
+			FROM ermes_sessions JOIN ermes_session_metadata m ON m.session_id = ermes_sessions.id
+			WHERE `+filterOffloadable, nowMillis())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			var metadata api.SessionMetadata
+			var expiresAt sql.NullInt64
+			var clientGeoCoordinates sql.NullString
+			if err := rows.Scan(&id, &metadata.CreatedIn, &metadata.CreatedAt, &metadata.UpdatedAt, &expiresAt, &clientGeoCoordinates); err != nil {
+				return err
+			}
+
+			if expiresAt.Valid {
+				metadata.ExpiresAt = &expiresAt.Int64
+			}
+
+			if metadata.ClientGeoCoordinates, err = decodeOptional[infrastructure.GeoCoordinates](clientGeoCoordinates); err != nil {
+				return err
+			}
+
+			candidates[id] = api.SessionInfoForOffloadDecision{
+				Metadata:       metadata,
+				ResourcesUsage: make(api.ResourcesUsage),
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = c.query(ctx, tx,
+			`SELECT r.session_id, r.resource, r.value
+			FROM ermes_session_resources r JOIN ermes_sessions ON ermes_sessions.id = r.session_id
+			WHERE `+filterOffloadable, nowMillis())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id, resource string
+			var value float64
+			if err := rows.Scan(&id, &resource, &value); err != nil {
+				return err
+			}
+
+			if candidate, ok := candidates[id]; ok {
+				candidate.ResourcesUsage[resource] = value
+			}
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.BestSessions(candidates, opt.MaxTargets), nil
+}
+
+// Return the best offload targets for the sessions of the given node, see
+// policy.BestTargets.
+func (c *Commands) BestOffloadTargetNodes(
+	ctx context.Context,
+	nodeId string,
+	sessions map[string]api.SessionInfoForOffloadDecision,
+	opt api.BestOffloadTargetsOptions,
+) ([][2]string, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	origin, ok := t.Nodes[nodeId]
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	// The known resources usage of the candidate nodes.
+	usages := make(map[string]api.ResourcesUsage)
+	for host := range t.Nodes {
+		if host == nodeId {
+			continue
+		}
+
+		if _, usages[host], err = c.GetNodeResourcesUsage(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	return policy.BestTargets(origin, t.Nodes, usages, sessions, opt.MaxTargets), nil
+}
+
+// Get the lookup node for a session offloading. The SQL commands hold the whole
+// infrastructure, so the node itself is the lookup node.
+func (c *Commands) FindLookupNode(
+	ctx context.Context,
+	sessionIds []string,
+) (node infrastructure.Node, err error) {
+	return c.node, nil
+}
@@ -0,0 +1,307 @@
+package sql_commands
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Dialect is the SQL dialect spoken by the database.
+type Dialect int
+
+const (
+	// SQLite, e.g. with the modernc.org/sqlite driver. Open the database with
+	// the "_txlock=immediate" and "_pragma=busy_timeout(...)" DSN parameters, so
+	// that concurrent transactions wait for each other instead of failing.
+	SQLite Dialect = iota
+	// PostgreSQL, e.g. with the github.com/jackc/pgx/v5/stdlib driver.
+	Postgres
+)
+
+// Commands is an implementation of api.Commands on top of database/sql. Every
+// state transition of a session is a transaction that locks the row of the
+// session. The schema is created by CreateSchema.
+//
+// The tables are not partitioned by node, so each node needs its own database
+// (or its own schema, e.g. with the Postgres search_path).
+type Commands struct {
+	// The database.
+	db *sql.DB
+	// The dialect of the database.
+	dialect Dialect
+	// The node that owns the commands.
+	node infrastructure.Node
+}
+
+// Assert that Commands implements api.Commands.
+var _ api.Commands = (*Commands)(nil)
+
+// NewCommands creates new SQL Commands owned by the given node.
+func NewCommands(db *sql.DB, dialect Dialect, node infrastructure.Node) *Commands {
+	return &Commands{
+		db:      db,
+		dialect: dialect,
+		node:    node,
+	}
+}
+
+// The states of a session.
+const (
+	stateActive     = "active"
+	stateOffloading = "offloading"
+	stateOffloaded  = "offloaded"
+)
+
+// The state of a session, as stored in the sessions table.
+type session struct {
+	// The state of the session.
+	state string
+	// The id of the last acquisition of the session.
+	lastAcquisitionId uint64
+	// The location last visited by the client, nil if the client already
+	// visited this node.
+	lastVisited *api.SessionLocation
+}
+
+// A database handle, either *sql.DB or *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Run f in a transaction, committed if f returns nil and rolled back otherwise.
+func (c *Commands) transaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Execute a query written with "?" placeholders.
+func (c *Commands) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, c.rebind(query), args...)
+}
+
+// Run a query written with "?" placeholders.
+func (c *Commands) query(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.QueryContext(ctx, c.rebind(query), args...)
+}
+
+// Run a query written with "?" placeholders that returns at most one row.
+func (c *Commands) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, c.rebind(query), args...)
+}
+
+// Rewrite the "?" placeholders in the syntax of the dialect. The queries of the
+// package never contain a literal "?".
+func (c *Commands) rebind(query string) string {
+	if c.dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// The locking clause of a SELECT that is followed by an update of the selected
+// rows. SQLite locks the whole database when the transaction begins.
+func (c *Commands) forUpdate() string {
+	if c.dialect == Postgres {
+		return " FOR UPDATE"
+	}
+
+	return ""
+}
+
+// Lock and return the state of a session, including offloaded ones.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) lockSession(ctx context.Context, tx *sql.Tx, sessionId string) (*session, error) {
+	var s session
+	var lastVisited sql.NullString
+	err := c.queryRow(ctx, tx,
+		`SELECT state, last_acquisition_id, last_visited FROM ermes_sessions WHERE id = ?`+c.forUpdate(),
+		sessionId).Scan(&s.state, &s.lastAcquisitionId, &lastVisited)
+	if err == sql.ErrNoRows {
+		return nil, api.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if s.lastVisited, err = decodeOptional[api.SessionLocation](lastVisited); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Lock and return the state of a session that is not offloaded.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) lockLiveSession(ctx context.Context, tx *sql.Tx, sessionId string) (*session, error) {
+	s, err := c.lockSession(ctx, tx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.state == stateOffloaded {
+		return nil, api.ErrSessionNotFound
+	}
+
+	return s, nil
+}
+
+// Lock and return the state of a session that is not offloaded, if the fencing
+// token is the id of one of its acquisitions whose lease did not expire and
+// that is not in read mode. A fencing token of 0 is not checked.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired, or the session has been offloaded.
+// - ErrSessionIsOffloading: If the session is offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) lockFencedSession(ctx context.Context, tx *sql.Tx, sessionId string, fencingToken uint64) (*session, error) {
+	if fencingToken == 0 {
+		return c.lockLiveSession(ctx, tx, sessionId)
+	}
+
+	s, err := c.lockSession(ctx, tx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The writer did not notice that the session has been offloaded.
+	if s.state == stateOffloaded {
+		return nil, api.ErrStaleFencingToken
+	}
+
+	// The write would be lost once the offload is confirmed.
+	if s.state == stateOffloading {
+		return nil, api.ErrSessionIsOffloading
+	}
+
+	if _, _, err := c.expireLeases(ctx, tx, sessionId); err != nil {
+		return nil, err
+	}
+
+	var mode api.AcquisitionMode
+	if err := c.queryRow(ctx, tx,
+		`SELECT mode FROM ermes_session_acquisitions WHERE session_id = ? AND acquisition_id = ?`,
+		sessionId, fencingToken).Scan(&mode); err == sql.ErrNoRows {
+		return nil, api.ErrStaleFencingToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if mode == api.AcquisitionModeRead {
+		return nil, api.ErrAcquisitionIsReadOnly
+	}
+
+	return s, nil
+}
+
+// Delete the acquisitions of a locked session whose lease expired, that are
+// treated as released, and return the number of the remaining ones and of those
+// that do not allow offloading.
+func (c *Commands) expireLeases(ctx context.Context, tx *sql.Tx, sessionId string) (live int64, blocking int64, err error) {
+	if _, err := c.exec(ctx, tx,
+		`DELETE FROM ermes_session_acquisitions WHERE session_id = ? AND lease_expires_at <= ?`,
+		sessionId, nowMillis()); err != nil {
+		return 0, 0, err
+	}
+
+	err = c.queryRow(ctx, tx,
+		`SELECT COUNT(*), COALESCE(SUM(CASE WHEN allow_offloading THEN 0 ELSE 1 END), 0) FROM ermes_session_acquisitions WHERE session_id = ?`,
+		sessionId).Scan(&live, &blocking)
+	return live, blocking, err
+}
+
+// Assign the next sequence number.
+func (c *Commands) nextSeq(ctx context.Context, tx *sql.Tx) (uint64, error) {
+	if _, err := c.exec(ctx, tx, `UPDATE ermes_counters SET value = value + 1 WHERE name = 'seq'`); err != nil {
+		return 0, err
+	}
+
+	var seq uint64
+	err := c.queryRow(ctx, tx, `SELECT value FROM ermes_counters WHERE name = 'seq'`).Scan(&seq)
+	return seq, err
+}
+
+// The expiration of a lease of the given duration from now, NULL if no duration.
+func leaseExpiresAt(leaseDuration time.Duration) sql.NullInt64 {
+	if leaseDuration <= 0 {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: nowMillis() + leaseDuration.Milliseconds(), Valid: true}
+}
+
+// Encode an optional value as JSON, NULL if nil.
+func encodeOptional[T any](value *T) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	return sql.NullString{String: string(encoded), Valid: true}, err
+}
+
+// Decode an optional JSON value, nil if NULL.
+func decodeOptional[T any](encoded sql.NullString) (*T, error) {
+	if !encoded.Valid {
+		return nil, nil
+	}
+
+	var value T
+	if err := json.Unmarshal([]byte(encoded.String), &value); err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+// Generate a new random session id.
+func newSessionId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// The current time as a Unix timestamp (UTC).
+func now() int64 {
+	return time.Now().Unix()
+}
+
+// The current time as a Unix timestamp in milliseconds, used for the leases.
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
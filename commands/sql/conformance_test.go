@@ -0,0 +1,32 @@
+package sql_commands_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/ermes-labs/api-go/api"
+	commands_conformance "github.com/ermes-labs/api-go/commands/conformance"
+	sql_commands "github.com/ermes-labs/api-go/commands/sql"
+	"github.com/ermes-labs/api-go/infrastructure"
+	_ "modernc.org/sqlite"
+)
+
+func TestConformance(t *testing.T) {
+	commands_conformance.Run(t, func(t *testing.T, node infrastructure.Node) api.Commands {
+		path := filepath.Join(t.TempDir(), node.Host+".db")
+		db, err := sql.Open("sqlite", "file:"+path+"?_txlock=immediate&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		cmd := sql_commands.NewCommands(db, sql_commands.SQLite, node)
+		if err := cmd.CreateSchema(context.Background()); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		return cmd
+	})
+}
@@ -0,0 +1,110 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Creates a new session and returns the id of the session.
+func (c *Commands) CreateSession(
+	ctx context.Context,
+	opt api.CreateSessionOptions,
+) (sessionId string, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		sessionId, err = c.createSession(ctx, tx, opt)
+		return err
+	})
+
+	return sessionId, err
+}
+
+// Creates a new session and acquires it. Returns the id of the session and the
+// id of the acquisition.
+func (c *Commands) CreateAndAcquireSession(
+	ctx context.Context,
+	opt api.CreateAndAcquireSessionOptions,
+) (sessionId string, acquisitionId uint64, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		if sessionId, err = c.createSession(ctx, tx, opt.CreateSessionOptions); err != nil {
+			return err
+		}
+
+		// A newly created session cannot be offloaded or offloading.
+		acquisitionId, _, err = c.acquireSession(ctx, tx, sessionId, opt.AcquireSessionOptions)
+		return err
+	})
+
+	return sessionId, acquisitionId, err
+}
+
+// Returns the ids of the sessions that are stored and not offloaded.
+func (c *Commands) ScanSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, filterLive)
+}
+
+// Creates a new session in the transaction.
+func (c *Commands) createSession(
+	ctx context.Context,
+	tx *sql.Tx,
+	opt api.CreateSessionOptions,
+) (string, error) {
+	sessionId := newSessionId()
+	if opt.SessionId() != nil {
+		sessionId = *opt.SessionId()
+	}
+
+	seq, err := c.nextSeq(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+
+	// The id must not be used, even by an offloaded session.
+	result, err := c.exec(ctx, tx,
+		`INSERT INTO ermes_sessions (id, seq, state) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		sessionId, seq, stateActive)
+	if err != nil {
+		return "", err
+	}
+
+	if inserted, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if inserted == 0 {
+		return "", api.ErrSessionIdAlreadyExists
+	}
+
+	// If not given, approximate the client coordinates with the node ones.
+	clientGeoCoordinates := c.node.GeoCoordinates
+	if opt.ClientGeoCoordinates() != nil {
+		clientGeoCoordinates = *opt.ClientGeoCoordinates()
+	}
+
+	encodedClientGeoCoordinates, err := encodeOptional(&clientGeoCoordinates)
+	if err != nil {
+		return "", err
+	}
+
+	createdAt := now()
+	_, err = c.exec(ctx, tx,
+		`INSERT INTO ermes_session_metadata (session_id, created_in, created_at, updated_at, expires_at, client_geo_coordinates) VALUES (?, ?, ?, ?, ?, ?)`,
+		sessionId, c.node.Host, createdAt, createdAt, nullInt64(opt.ExpiresAt()), encodedClientGeoCoordinates)
+	if err != nil {
+		return "", err
+	}
+
+	return sessionId, nil
+}
+
+// Convert an optional int64 to a nullable column value.
+func nullInt64(value *int64) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: *value, Valid: true}
+}
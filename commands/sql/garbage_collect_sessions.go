@@ -0,0 +1,87 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// The number of sessions collected by each garbage collection step.
+const garbageCollectBatchSize = 128
+
+// Garbage collect the expired sessions. Expired sessions are collected if they
+// are not acquired (acquisitions whose lease expired do not count), or if they expired more than the configured duration ago.
+// Offloading sessions and the tombstones of journaled offloads, that are needed
+// to recover them, are never collected. Each call collects a batch of
+// sessions and returns the cursor of the next batch, nil once completed.
+func (c *Commands) GarbageCollectSessions(
+	ctx context.Context,
+	opt api.GarbageCollectSessionsOptions,
+	cursor *string,
+) (nextCursor *string, err error) {
+	from := uint64(0)
+	if cursor != nil {
+		if from, err = strconv.ParseUint(*cursor, 10, 64); err != nil {
+			return nil, api.ErrInvalidCursor
+		}
+	}
+
+	now := now()
+	acquired := `FALSE`
+	args := []interface{}{from, now, nowMillis()}
+	if olderThan := opt.ExpiredUnreleasedOlderThan(); olderThan != nil {
+		acquired = `m.expires_at <= ?`
+		args = append(args, now-*olderThan)
+	}
+	args = append(args, garbageCollectBatchSize)
+
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := c.query(ctx, tx,
+			`SELECT s.id, s.seq FROM ermes_sessions s JOIN ermes_session_metadata m ON m.session_id = s.id
+			WHERE s.seq >= ? AND s.state <> 'offloading' AND m.expires_at <= ?
+			AND NOT EXISTS (SELECT 1 FROM ermes_offload_journal j WHERE j.session_id = s.id)
+			AND (NOT EXISTS (
+				SELECT 1 FROM ermes_session_acquisitions a
+				WHERE a.session_id = s.id AND (a.lease_expires_at IS NULL OR a.lease_expires_at > ?)) OR `+acquired+`)
+			ORDER BY s.seq LIMIT ?`+c.forUpdate(),
+			args...)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]string, 0, garbageCollectBatchSize)
+		var last uint64
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id, &last); err != nil {
+				rows.Close()
+				return err
+			}
+
+			ids = append(ids, id)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := c.deleteSession(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		// A full batch may be followed by other expired sessions.
+		if len(ids) == garbageCollectBatchSize {
+			next := strconv.FormatUint(last+1, 10)
+			nextCursor = &next
+		}
+
+		return nil
+	})
+
+	return nextCursor, err
+}
@@ -0,0 +1,314 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/topology"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Load the infrastructure. The previously loaded infrastructure is replaced.
+func (c *Commands) LoadInfrastructure(
+	ctx context.Context,
+	infra infrastructure.Infrastructure,
+) (err error) {
+	if _, err := infrastructure.CheckInfrastructure(infra); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(infra)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.exec(ctx, c.db,
+		`INSERT INTO ermes_infrastructure (id, definition) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET definition = excluded.definition`,
+		string(encoded))
+	return err
+}
+
+// Get the parent node of a node, nil if the node is a root.
+func (c *Commands) GetParentNodeOf(
+	ctx context.Context,
+	nodeId string,
+) (*infrastructure.Node, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, ok := t.Parent(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return parent, nil
+}
+
+// Get the children nodes of a node.
+func (c *Commands) GetChildrenNodesOf(
+	ctx context.Context,
+	nodeId string,
+) ([]infrastructure.Node, error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	children, ok := t.ChildrenOf(nodeId)
+	if !ok {
+		return nil, api.ErrNodeNotFound
+	}
+
+	return children, nil
+}
+
+// Get the resources usage of a session.
+func (c *Commands) GetSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+) (resourcesUsage api.ResourcesUsage, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockLiveSession(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		rows, err := c.query(ctx, tx,
+			`SELECT resource, value FROM ermes_session_resources WHERE session_id = ?`,
+			sessionId)
+		if err != nil {
+			return err
+		}
+
+		resourcesUsage, err = scanResourcesUsage(rows)
+		return err
+	})
+
+	return resourcesUsage, err
+}
+
+// Get the resources usage of a node. The usage of this node is computed from
+// its sessions, the usage of the other nodes is the last one reported.
+func (c *Commands) GetNodeResourcesUsage(
+	ctx context.Context,
+	nodeId string,
+) (sessions uint, resourcesUsage api.ResourcesUsage, err error) {
+	if nodeId == c.node.Host {
+		return c.localUsage(ctx)
+	}
+
+	var count sql.NullInt64
+	var encoded string
+	err = c.queryRow(ctx, c.db,
+		`SELECT sessions, resources FROM ermes_nodes_usage WHERE node_id = ?`,
+		nodeId).Scan(&count, &encoded)
+	if err == sql.ErrNoRows {
+		t, err := c.tree(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if _, ok := t.Nodes[nodeId]; ok {
+			return 0, make(api.ResourcesUsage), nil
+		}
+
+		return 0, nil, api.ErrNodeNotFound
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	if err := json.Unmarshal([]byte(encoded), &resourcesUsage); err != nil {
+		return 0, nil, err
+	}
+
+	return uint(count.Int64), resourcesUsage, nil
+}
+
+// Update the resources usage of a session.
+func (c *Commands) UpdateSessionResourcesUsage(
+	ctx context.Context,
+	sessionId string,
+	resourcesUsage api.ResourcesUsage,
+) (err error) {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockLiveSession(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		// The usage is replaced, not added.
+		if _, err := c.exec(ctx, tx,
+			`DELETE FROM ermes_session_resources WHERE session_id = ?`,
+			sessionId); err != nil {
+			return err
+		}
+
+		for resource, value := range resourcesUsage {
+			if _, err := c.exec(ctx, tx,
+				`INSERT INTO ermes_session_resources (session_id, resource, value) VALUES (?, ?, ?)`,
+				sessionId, resource, value); err != nil {
+				return err
+			}
+		}
+
+		_, err := c.exec(ctx, tx,
+			`UPDATE ermes_session_metadata SET updated_at = ? WHERE session_id = ?`,
+			now(), sessionId)
+		return err
+	})
+}
+
+// Get the update to send to the parent node: the number of sessions in the
+// sub-tree of this node and the resources usage of each node of the sub-tree.
+// errors:
+// - ErrNodeNotFound: If the node has no parent.
+func (c *Commands) ResourcesUsageUpdateToParent(
+	ctx context.Context,
+) (node infrastructure.Node, sessions uint, resourcesUsageNodesMap map[string]api.ResourcesUsage, err error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	parent, ok := t.Parents[c.node.Host]
+	if !ok {
+		return infrastructure.Node{}, 0, nil, api.ErrNodeNotFound
+	}
+
+	sessions, resourcesUsage, err := c.localUsage(ctx)
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	rows, err := c.query(ctx, c.db, `SELECT node_id, sessions, resources FROM ermes_nodes_usage`)
+	if err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+	defer rows.Close()
+
+	resourcesUsageNodesMap = map[string]api.ResourcesUsage{c.node.Host: resourcesUsage}
+	for rows.Next() {
+		var nodeId, encoded string
+		var count sql.NullInt64
+		if err := rows.Scan(&nodeId, &count, &encoded); err != nil {
+			return infrastructure.Node{}, 0, nil, err
+		}
+
+		var usage api.ResourcesUsage
+		if err := json.Unmarshal([]byte(encoded), &usage); err != nil {
+			return infrastructure.Node{}, 0, nil, err
+		}
+
+		resourcesUsageNodesMap[nodeId] = usage
+		// Add the sessions of the sub-trees of the children.
+		if t.Parents[nodeId] == c.node.Host {
+			sessions += uint(count.Int64)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return infrastructure.Node{}, 0, nil, err
+	}
+
+	return t.Nodes[parent], sessions, resourcesUsageNodesMap, nil
+}
+
+// Store the update from a child node. The sessions are attributed to the
+// child, while the resources usage is stored for each node of its sub-tree.
+func (c *Commands) ResourcesUsageUpdateFromChild(
+	ctx context.Context,
+	sessions uint,
+	resourcesUsageNodesMap map[string]api.ResourcesUsage,
+) (err error) {
+	t, err := c.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		for nodeId, resourcesUsage := range resourcesUsageNodesMap {
+			encoded, err := json.Marshal(resourcesUsage)
+			if err != nil {
+				return err
+			}
+
+			var count sql.NullInt64
+			if t.Parents[nodeId] == c.node.Host {
+				count = sql.NullInt64{Int64: int64(sessions), Valid: true}
+			}
+
+			if _, err := c.exec(ctx, tx,
+				`INSERT INTO ermes_nodes_usage (node_id, sessions, resources) VALUES (?, ?, ?)
+				ON CONFLICT (node_id) DO UPDATE SET
+					sessions = COALESCE(excluded.sessions, ermes_nodes_usage.sessions),
+					resources = excluded.resources`,
+				nodeId, count, string(encoded)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get the number of sessions and the resources usage of this node.
+func (c *Commands) localUsage(ctx context.Context) (uint, api.ResourcesUsage, error) {
+	var sessions uint
+	if err := c.queryRow(ctx, c.db,
+		`SELECT COUNT(*) FROM ermes_sessions WHERE `+filterLive).Scan(&sessions); err != nil {
+		return 0, nil, err
+	}
+
+	// The resources usage of the offloaded sessions is deleted on confirmation.
+	rows, err := c.query(ctx, c.db,
+		`SELECT resource, SUM(value) FROM ermes_session_resources GROUP BY resource`)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resourcesUsage, err := scanResourcesUsage(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return sessions, resourcesUsage, nil
+}
+
+// Read the rows of (resource, value) pairs and close them.
+func scanResourcesUsage(rows *sql.Rows) (api.ResourcesUsage, error) {
+	defer rows.Close()
+
+	resourcesUsage := make(api.ResourcesUsage)
+	for rows.Next() {
+		var resource string
+		var value float64
+		if err := rows.Scan(&resource, &value); err != nil {
+			return nil, err
+		}
+
+		resourcesUsage[resource] = value
+	}
+
+	return resourcesUsage, rows.Err()
+}
+
+// Read the tree of the loaded infrastructure.
+func (c *Commands) tree(ctx context.Context) (*topology.Tree, error) {
+	var encoded string
+	err := c.queryRow(ctx, c.db, `SELECT definition FROM ermes_infrastructure WHERE id = 1`).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return topology.NewTree(c.node, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var infra infrastructure.Infrastructure
+	if err := json.Unmarshal([]byte(encoded), &infra); err != nil {
+		return nil, err
+	}
+
+	return topology.NewTree(c.node, &infra), nil
+}
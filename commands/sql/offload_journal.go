@@ -0,0 +1,132 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Records the location an offloading session is being offloaded to.
+func (c *Commands) RecordSessionOffloadTarget(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) error {
+	encodedNewLocation, err := encodeOptional(&newLocation)
+	if err != nil {
+		return err
+	}
+
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockLiveSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloading {
+			return api.ErrSessionIsNotOffloading
+		}
+
+		return c.journalOffload(ctx, tx, id, encodedNewLocation, false)
+	})
+}
+
+// Completes a confirmed offload, deleting it from the journal.
+func (c *Commands) CompleteSessionOffload(
+	ctx context.Context,
+	id string,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloaded {
+			return api.ErrSessionIsNotOffloaded
+		}
+
+		_, err = c.exec(ctx, tx, `DELETE FROM ermes_offload_journal WHERE session_id = ?`, id)
+		return err
+	})
+}
+
+// Returns the entries of the journal, sorted by session id.
+func (c *Commands) ScanOffloadJournal(
+	ctx context.Context,
+) (entries []api.OffloadJournalEntry, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := c.query(ctx, tx,
+			`SELECT session_id, new_location, confirmed FROM ermes_offload_journal ORDER BY session_id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		entries = make([]api.OffloadJournalEntry, 0)
+		for rows.Next() {
+			var entry api.OffloadJournalEntry
+			var newLocation sql.NullString
+			if err := rows.Scan(&entry.SessionId, &newLocation, &entry.Confirmed); err != nil {
+				return err
+			}
+
+			if entry.NewLocation, err = decodeOptional[api.SessionLocation](newLocation); err != nil {
+				return err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Returns the status of the onload of a session.
+func (c *Commands) GetSessionOnloadStatus(
+	ctx context.Context,
+	sessionId string,
+) (status api.OnloadStatus, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		var pending bool
+		if err := c.queryRow(ctx, tx,
+			`SELECT EXISTS (SELECT 1 FROM ermes_pending_onloads WHERE session_id = ?)`,
+			sessionId).Scan(&pending); err != nil {
+			return err
+		} else if pending {
+			status = api.OnloadStatusPending
+			return nil
+		}
+
+		s, err := c.lockSession(ctx, tx, sessionId)
+		switch {
+		case err == api.ErrSessionNotFound:
+			status = api.OnloadStatusAborted
+		case err != nil:
+			return err
+		case s.state == stateOffloaded:
+			status = api.OnloadStatusMoved
+		default:
+			status = api.OnloadStatusCommitted
+		}
+
+		return nil
+	})
+
+	return status, err
+}
+
+// Write the journal entry of an offload.
+func (c *Commands) journalOffload(ctx context.Context, tx *sql.Tx, id string, newLocation sql.NullString, confirmed bool) error {
+	_, err := c.exec(ctx, tx,
+		`INSERT INTO ermes_offload_journal (session_id, new_location, confirmed) VALUES (?, ?, ?)
+		ON CONFLICT (session_id) DO UPDATE SET new_location = excluded.new_location, confirmed = excluded.confirmed`,
+		id, newLocation, confirmed)
+	return err
+}
@@ -0,0 +1,279 @@
+package sql_commands
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+)
+
+// Starts the offload of a session. The loader streams the session key space
+// into the reader as a sequence of length-prefixed key-value pairs, sorted by
+// key.
+func (c *Commands) OffloadSession(
+	ctx context.Context,
+	id string,
+	opt api.OffloadSessionOptions,
+) (sessionDataReadCloser io.ReadCloser, loader func(), err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockLiveSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state == stateOffloading {
+			return api.ErrSessionIsOffloading
+		}
+
+		if _, blocking, err := c.expireLeases(ctx, tx, id); err != nil {
+			return err
+		} else if blocking > 0 {
+			return api.ErrUnableToOffloadAcquiredSession
+		}
+
+		if _, err := c.exec(ctx, tx, `UPDATE ermes_sessions SET state = ? WHERE id = ?`, stateOffloading, id); err != nil {
+			return err
+		}
+
+		return c.journalOffload(ctx, tx, id, sql.NullString{}, false)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, writer := io.Pipe()
+	loader = func() {
+		// A nil error closes the writer with io.EOF.
+		writer.CloseWithError(c.writeSessionData(ctx, id, writer))
+	}
+
+	return reader, loader, nil
+}
+
+// Confirms the offload of a session. The session key space is deleted and the
+// session is kept as a tombstone that points to the new location. If the client
+// did not visit this node yet, the last visited node is notified.
+func (c *Commands) ConfirmSessionOffload(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+	opt api.OffloadSessionOptions,
+	notifyLastVisitedNode func(ctx context.Context, oldLocation api.SessionLocation) (clientRedirected bool, err error),
+) (err error) {
+	encodedNewLocation, err := encodeOptional(&newLocation)
+	if err != nil {
+		return err
+	}
+
+	var lastVisited *api.SessionLocation
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockLiveSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloading {
+			return api.ErrSessionIsNotOffloading
+		}
+
+		lastVisited = s.lastVisited
+		for _, table := range []string{"ermes_session_data", "ermes_session_resources"} {
+			if _, err := c.exec(ctx, tx, `DELETE FROM `+table+` WHERE session_id = ?`, id); err != nil {
+				return err
+			}
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`INSERT INTO ermes_offload_tombstones (session_id, offloaded_to, client_redirected) VALUES (?, ?, ?)
+			ON CONFLICT (session_id) DO UPDATE SET offloaded_to = excluded.offloaded_to, client_redirected = excluded.client_redirected`,
+			id, encodedNewLocation, false); err != nil {
+			return err
+		}
+
+		if err := c.journalOffload(ctx, tx, id, encodedNewLocation, true); err != nil {
+			return err
+		}
+
+		_, err = c.exec(ctx, tx,
+			`UPDATE ermes_sessions SET state = ?, last_visited = NULL WHERE id = ?`,
+			stateOffloaded, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Notify the last visited node outside the transaction.
+	if lastVisited != nil && notifyLastVisitedNode != nil {
+		_, err = notifyLastVisitedNode(ctx, *lastVisited)
+	}
+
+	return err
+}
+
+// Aborts the offload of a session, that returns active.
+func (c *Commands) AbortSessionOffload(
+	ctx context.Context,
+	id string,
+) (err error) {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		// The offload has already been confirmed.
+		if s.state != stateOffloading {
+			return api.ErrSessionIsNotOffloading
+		}
+
+		if _, err := c.exec(ctx, tx, `UPDATE ermes_sessions SET state = ? WHERE id = ?`, stateActive, id); err != nil {
+			return err
+		}
+
+		_, err = c.exec(ctx, tx, `DELETE FROM ermes_offload_journal WHERE session_id = ?`, id)
+		return err
+	})
+}
+
+// Updates the location of an offloaded session.
+func (c *Commands) UpdateOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+	newLocation api.SessionLocation,
+) (clientRedirected bool, err error) {
+	encodedNewLocation, err := encodeOptional(&newLocation)
+	if err != nil {
+		return false, err
+	}
+
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloaded {
+			return api.ErrSessionIsNotOffloaded
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`UPDATE ermes_offload_tombstones SET offloaded_to = ? WHERE session_id = ?`,
+			encodedNewLocation, id); err != nil {
+			return err
+		}
+
+		return c.queryRow(ctx, tx,
+			`SELECT client_redirected FROM ermes_offload_tombstones WHERE session_id = ?`,
+			id).Scan(&clientRedirected)
+	})
+
+	return clientRedirected, err
+}
+
+// Returns the location of an offloaded session.
+func (c *Commands) GetOffloadedSessionLocation(
+	ctx context.Context,
+	id string,
+) (offloadedTo api.SessionLocation, clientRedirected bool, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloaded {
+			return api.ErrSessionIsNotOffloaded
+		}
+
+		var encoded sql.NullString
+		if err := c.queryRow(ctx, tx,
+			`SELECT offloaded_to, client_redirected FROM ermes_offload_tombstones WHERE session_id = ?`,
+			id).Scan(&encoded, &clientRedirected); err != nil {
+			return err
+		}
+
+		location, err := decodeOptional[api.SessionLocation](encoded)
+		if err == nil && location != nil {
+			offloadedTo = *location
+		}
+
+		return err
+	})
+
+	return offloadedTo, clientRedirected, err
+}
+
+// Deletes the tombstone of an offloaded session.
+func (c *Commands) DeleteOffloadedSession(
+	ctx context.Context,
+	id string,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		s, err := c.lockSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if s.state != stateOffloaded {
+			return api.ErrSessionIsNotOffloaded
+		}
+
+		var journaled int64
+		if err := c.queryRow(ctx, tx,
+			`SELECT COUNT(*) FROM ermes_offload_journal WHERE session_id = ?`,
+			id).Scan(&journaled); err != nil {
+			return err
+		} else if journaled > 0 {
+			return api.ErrOffloadNotCommitted
+		}
+
+		return c.deleteSession(ctx, tx, id)
+	})
+}
+
+// Returns the offloaded sessions.
+func (c *Commands) ScanOffloadedSessions(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+) (ids []string, newCursor uint64, err error) {
+	return c.scan(ctx, cursor, count, filterOffloaded)
+}
+
+// Write the session key space as a sequence of length-prefixed key-value
+// pairs, sorted by key.
+func (c *Commands) writeSessionData(ctx context.Context, sessionId string, w io.Writer) error {
+	rows, err := c.query(ctx, c.db,
+		`SELECT data_key, value FROM ermes_session_data WHERE session_id = ? ORDER BY data_key`,
+		sessionId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	buf := bufio.NewWriter(w)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+
+		if err := framing.WriteField(buf, []byte(key)); err != nil {
+			return err
+		}
+		if err := framing.WriteField(buf, value); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
@@ -0,0 +1,270 @@
+package sql_commands
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+)
+
+// The columns of an onloaded session.
+type onloadedSession struct {
+	createdIn            string
+	createdAt            int64
+	updatedAt            int64
+	expiresAt            sql.NullInt64
+	clientGeoCoordinates sql.NullString
+	lastVisited          sql.NullString
+	fencingToken         uint64
+}
+
+// Onloads a session and returns its id. If the options carry the previous
+// location of the session the id is preserved, and a tombstone left by a
+// previous offload of the same session is replaced. The session key space is
+// inserted in the same transaction, so the session becomes visible only once
+// the whole stream has been read. The onload is rejected if the tombstone has
+// been offloaded after the onloaded data. A pending onload is stored apart, with
+// its key space, until it is committed or aborted.
+func (c *Commands) OnloadSession(
+	ctx context.Context,
+	metadata api.SessionMetadata,
+	reader io.Reader,
+	opt api.OnloadSessionOptions,
+) (sessionId string, err error) {
+	onloaded := onloadedSession{
+		createdIn: metadata.CreatedIn,
+		createdAt: metadata.CreatedAt,
+		updatedAt: now(),
+		expiresAt: nullInt64(metadata.ExpiresAt),
+		// Keep the fencing tokens increasing across nodes.
+		fencingToken: metadata.FencingToken,
+	}
+
+	if onloaded.clientGeoCoordinates, err = encodeOptional(metadata.ClientGeoCoordinates); err != nil {
+		return "", err
+	}
+
+	if onloaded.lastVisited, err = encodeOptional(opt.OnloadedFrom()); err != nil {
+		return "", err
+	}
+
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		if opt.OnloadedFrom() != nil {
+			sessionId = opt.OnloadedFrom().SessionId
+
+			var pending bool
+			if err := c.queryRow(ctx, tx,
+				`SELECT EXISTS (SELECT 1 FROM ermes_pending_onloads WHERE session_id = ?)`,
+				sessionId).Scan(&pending); err != nil {
+				return err
+			} else if pending {
+				return api.ErrSessionAlreadyOnloaded
+			}
+
+			if _, err := c.checkOnload(ctx, tx, sessionId, onloaded.fencingToken); err != nil {
+				return err
+			}
+		} else {
+			sessionId = newSessionId()
+		}
+
+		if !opt.Pending() {
+			if err := c.onload(ctx, tx, sessionId, onloaded); err != nil {
+				return err
+			}
+
+			return c.readSessionData(ctx, tx, "ermes_session_data", sessionId, reader)
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`INSERT INTO ermes_pending_onloads (session_id, created_in, created_at, updated_at, expires_at, client_geo_coordinates, last_visited, fencing_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			sessionId, onloaded.createdIn, onloaded.createdAt, onloaded.updatedAt, onloaded.expiresAt, onloaded.clientGeoCoordinates, onloaded.lastVisited, onloaded.fencingToken); err != nil {
+			return err
+		}
+
+		return c.readSessionData(ctx, tx, "ermes_pending_session_data", sessionId, reader)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sessionId, nil
+}
+
+// Commits the pending onload of a session, that replaces the tombstone of the
+// session, if any.
+func (c *Commands) CommitSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		onloaded, err := c.lockPendingOnload(ctx, tx, sessionId)
+		if err != nil {
+			return err
+		}
+
+		if err := c.onload(ctx, tx, sessionId, *onloaded); err != nil {
+			return err
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`INSERT INTO ermes_session_data (session_id, data_key, value)
+			SELECT session_id, data_key, value FROM ermes_pending_session_data WHERE session_id = ?`,
+			sessionId); err != nil {
+			return err
+		}
+
+		return c.deletePendingOnload(ctx, tx, sessionId)
+	})
+}
+
+// Aborts the pending onload of a session. The tombstone of the session, if
+// any, points again to the location the session was onloaded from.
+func (c *Commands) AbortSessionOnload(
+	ctx context.Context,
+	sessionId string,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		onloaded, err := c.lockPendingOnload(ctx, tx, sessionId)
+		if err != nil {
+			return err
+		}
+
+		if onloaded.lastVisited.Valid {
+			if _, err := c.exec(ctx, tx,
+				`UPDATE ermes_offload_tombstones SET offloaded_to = ?, client_redirected = ? WHERE session_id = ?`,
+				onloaded.lastVisited, false, sessionId); err != nil {
+				return err
+			}
+		}
+
+		return c.deletePendingOnload(ctx, tx, sessionId)
+	})
+}
+
+// Lock and return the pending onload of a session.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrSessionIsNotOnloading: If the onload of the session is not pending.
+func (c *Commands) lockPendingOnload(ctx context.Context, tx *sql.Tx, sessionId string) (*onloadedSession, error) {
+	var onloaded onloadedSession
+	err := c.queryRow(ctx, tx,
+		`SELECT created_in, created_at, updated_at, expires_at, client_geo_coordinates, last_visited, fencing_token
+		FROM ermes_pending_onloads WHERE session_id = ?`+c.forUpdate(),
+		sessionId).Scan(&onloaded.createdIn, &onloaded.createdAt, &onloaded.updatedAt, &onloaded.expiresAt,
+		&onloaded.clientGeoCoordinates, &onloaded.lastVisited, &onloaded.fencingToken)
+	if err == nil {
+		return &onloaded, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if _, err := c.lockSession(ctx, tx, sessionId); err != nil {
+		return nil, err
+	}
+
+	return nil, api.ErrSessionIsNotOnloading
+}
+
+// Delete the pending onload of a session and its key space.
+func (c *Commands) deletePendingOnload(ctx context.Context, tx *sql.Tx, sessionId string) error {
+	for _, table := range []string{"ermes_pending_session_data", "ermes_pending_onloads"} {
+		if _, err := c.exec(ctx, tx, `DELETE FROM `+table+` WHERE session_id = ?`, sessionId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the onloaded session replaces a tombstone of the session,
+// and an error if it can not replace the session stored with the same id.
+func (c *Commands) checkOnload(ctx context.Context, tx *sql.Tx, sessionId string, fencingToken uint64) (replaces bool, err error) {
+	s, err := c.lockSession(ctx, tx, sessionId)
+	if err == api.ErrSessionNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if s.state != stateOffloaded {
+		return false, api.ErrSessionAlreadyOnloaded
+	}
+
+	// The session has been offloaded again after the onloaded data.
+	if s.lastAcquisitionId > fencingToken {
+		return false, api.ErrStaleFencingToken
+	}
+
+	return true, nil
+}
+
+// Store the onloaded session, without its key space, replacing the tombstone
+// of the session and dropping its acquisitions and its journaled offload.
+func (c *Commands) onload(ctx context.Context, tx *sql.Tx, sessionId string, onloaded onloadedSession) error {
+	replaces, err := c.checkOnload(ctx, tx, sessionId, onloaded.fencingToken)
+	if err != nil {
+		return err
+	}
+
+	if replaces {
+		for _, table := range []string{"ermes_offload_tombstones", "ermes_offload_journal", "ermes_session_metadata", "ermes_session_acquisitions"} {
+			if _, err := c.exec(ctx, tx, `DELETE FROM `+table+` WHERE session_id = ?`, sessionId); err != nil {
+				return err
+			}
+		}
+	}
+
+	seq, err := c.nextSeq(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if replaces {
+		_, err = c.exec(ctx, tx,
+			`UPDATE ermes_sessions SET seq = ?, state = ?, last_acquisition_id = ?, last_visited = ? WHERE id = ?`,
+			seq, stateActive, onloaded.fencingToken, onloaded.lastVisited, sessionId)
+	} else {
+		_, err = c.exec(ctx, tx,
+			`INSERT INTO ermes_sessions (id, seq, state, last_acquisition_id, last_visited) VALUES (?, ?, ?, ?, ?)`,
+			sessionId, seq, stateActive, onloaded.fencingToken, onloaded.lastVisited)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.exec(ctx, tx,
+		`INSERT INTO ermes_session_metadata (session_id, created_in, created_at, updated_at, expires_at, client_geo_coordinates) VALUES (?, ?, ?, ?, ?, ?)`,
+		sessionId, onloaded.createdIn, onloaded.createdAt, onloaded.updatedAt, onloaded.expiresAt, onloaded.clientGeoCoordinates)
+	return err
+}
+
+// Insert the session key space written by writeSessionData into the given table.
+func (c *Commands) readSessionData(ctx context.Context, tx *sql.Tx, table string, sessionId string, reader io.Reader) error {
+	r := bufio.NewReader(reader)
+
+	for {
+		key, err := framing.ReadField(r)
+		// A clean EOF before a key is the end of the stream.
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		value, err := framing.ReadField(r)
+		if err != nil {
+			return framing.UnexpectedEOF(err)
+		}
+
+		if _, err := c.exec(ctx, tx,
+			`INSERT INTO `+table+` (session_id, data_key, value) VALUES (?, ?, ?)`,
+			sessionId, string(key), value); err != nil {
+			return err
+		}
+	}
+}
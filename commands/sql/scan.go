@@ -0,0 +1,70 @@
+package sql_commands
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// The conditions on the sessions table used to filter the scans. The
+// offloadable filter takes the current time in milliseconds.
+const (
+	filterLive        = `state <> 'offloaded'`
+	filterOffloadable = `state = 'active' AND NOT EXISTS (
+		SELECT 1 FROM ermes_session_acquisitions a
+		WHERE a.session_id = ermes_sessions.id AND NOT a.allow_offloading AND (a.lease_expires_at IS NULL OR a.lease_expires_at > ?))`
+	filterOffloaded = `state = 'offloaded'`
+)
+
+// Scan the sessions that match the filter. The cursor is the sequence number
+// of the next session to return, 0 to start from the beginning. The returned
+// cursor is 0 when the scan is completed. Sessions stored for the whole scan are
+// returned at least once.
+func (c *Commands) scan(
+	ctx context.Context,
+	cursor uint64,
+	count int64,
+	filter string,
+	filterArgs ...interface{},
+) (ids []string, newCursor uint64, err error) {
+	if count <= 0 {
+		return nil, 0, api.ErrInvalidCount
+	}
+
+	var seq uint64
+	if err := c.queryRow(ctx, c.db, `SELECT value FROM ermes_counters WHERE name = 'seq'`).Scan(&seq); err != nil {
+		return nil, 0, err
+	}
+
+	if cursor > seq {
+		return nil, 0, api.ErrInvalidCursor
+	}
+
+	// Fetch one more session to know the cursor of the next page.
+	args := append(append([]interface{}{cursor}, filterArgs...), count+1)
+	rows, err := c.query(ctx, c.db,
+		`SELECT id, seq FROM ermes_sessions WHERE seq >= ? AND `+filter+` ORDER BY seq LIMIT ?`,
+		args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	ids = make([]string, 0, count)
+	for rows.Next() {
+		var id string
+		var seq uint64
+		if err := rows.Scan(&id, &seq); err != nil {
+			return nil, 0, err
+		}
+
+		// If the page is full, return the cursor of the next session.
+		if int64(len(ids)) == count {
+			return ids, seq, nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, 0, rows.Err()
+}
@@ -0,0 +1,143 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// The schema of the commands. "BLOB" is replaced with the binary type of the
+// dialect.
+//
+//   - ermes_counters: the last sequence number assigned to a session.
+//   - ermes_sessions: the state of the sessions, including the offloaded ones.
+//     The sequence number orders the scans.
+//   - ermes_session_acquisitions: the active acquisitions of the sessions, with
+//     their api.AcquisitionMode and the expiration of their lease in
+//     milliseconds, NULL if they have none.
+//   - ermes_session_metadata: the metadata of the sessions.
+//   - ermes_offload_tombstones: the location of the offloaded sessions.
+//   - ermes_offload_journal: the journaled offloads, with the location the
+//     session is offloaded to, NULL if the target has not been contacted yet.
+//   - ermes_session_resources: the resources usage of the sessions.
+//   - ermes_session_data: the session key space.
+//   - ermes_pending_onloads: the onloads that are neither committed nor
+//     aborted, with the columns of the onloaded session.
+//   - ermes_pending_session_data: the key space of the pending onloads.
+//   - ermes_infrastructure: the loaded infrastructure, as JSON.
+//   - ermes_nodes_usage: the resources usage reported by the children nodes.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS ermes_counters (
+		name TEXT PRIMARY KEY,
+		value BIGINT NOT NULL
+	)`,
+	`INSERT INTO ermes_counters (name, value) VALUES ('seq', 0) ON CONFLICT (name) DO NOTHING`,
+	`CREATE TABLE IF NOT EXISTS ermes_sessions (
+		id TEXT PRIMARY KEY,
+		seq BIGINT NOT NULL UNIQUE,
+		state TEXT NOT NULL,
+		last_acquisition_id BIGINT NOT NULL DEFAULT 0,
+		last_visited TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_session_acquisitions (
+		session_id TEXT NOT NULL REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		acquisition_id BIGINT NOT NULL,
+		allow_offloading BOOLEAN NOT NULL,
+		mode INTEGER NOT NULL DEFAULT 0,
+		lease_expires_at BIGINT,
+		PRIMARY KEY (session_id, acquisition_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_session_metadata (
+		session_id TEXT PRIMARY KEY REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		created_in TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		expires_at BIGINT,
+		client_geo_coordinates TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS ermes_session_metadata_expires_at ON ermes_session_metadata (expires_at)`,
+	`CREATE TABLE IF NOT EXISTS ermes_offload_tombstones (
+		session_id TEXT PRIMARY KEY REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		offloaded_to TEXT NOT NULL,
+		client_redirected BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_offload_journal (
+		session_id TEXT PRIMARY KEY REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		new_location TEXT,
+		confirmed BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_session_resources (
+		session_id TEXT NOT NULL REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		resource TEXT NOT NULL,
+		value DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (session_id, resource)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_session_data (
+		session_id TEXT NOT NULL REFERENCES ermes_sessions (id) ON DELETE CASCADE,
+		data_key TEXT NOT NULL,
+		value BLOB NOT NULL,
+		PRIMARY KEY (session_id, data_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_pending_onloads (
+		session_id TEXT PRIMARY KEY,
+		created_in TEXT NOT NULL,
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		expires_at BIGINT,
+		client_geo_coordinates TEXT,
+		last_visited TEXT,
+		fencing_token BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_pending_session_data (
+		session_id TEXT NOT NULL REFERENCES ermes_pending_onloads (session_id) ON DELETE CASCADE,
+		data_key TEXT NOT NULL,
+		value BLOB NOT NULL,
+		PRIMARY KEY (session_id, data_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_infrastructure (
+		id INTEGER PRIMARY KEY,
+		definition TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS ermes_nodes_usage (
+		node_id TEXT PRIMARY KEY,
+		sessions BIGINT,
+		resources TEXT NOT NULL
+	)`,
+}
+
+// CreateSchema creates the tables used by the commands, if they do not exist.
+func (c *Commands) CreateSchema(ctx context.Context) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		for _, statement := range schema {
+			if c.dialect == Postgres {
+				statement = strings.ReplaceAll(statement, "BLOB", "BYTEA")
+			}
+
+			if _, err := c.exec(ctx, tx, statement); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete a session and all the rows that refer to it. The rows are deleted
+// explicitly, so that the foreign keys do not need to be enforced.
+func (c *Commands) deleteSession(ctx context.Context, tx *sql.Tx, sessionId string) error {
+	for _, table := range []string{
+		"ermes_session_acquisitions",
+		"ermes_session_data",
+		"ermes_session_resources",
+		"ermes_offload_tombstones",
+		"ermes_offload_journal",
+		"ermes_session_metadata",
+	} {
+		if _, err := c.exec(ctx, tx, `DELETE FROM `+table+` WHERE session_id = ?`, sessionId); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.exec(ctx, tx, `DELETE FROM ermes_sessions WHERE id = ?`, sessionId)
+	return err
+}
@@ -0,0 +1,111 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ermes-labs/api-go/commands/internal/framing"
+)
+
+// Get the value of a key in the session key space, nil if the key is not set.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionData(
+	ctx context.Context,
+	sessionId string,
+	key string,
+) (value []byte, err error) {
+	err = c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockLiveSession(ctx, tx, sessionId); err != nil {
+			return err
+		}
+
+		err := c.queryRow(ctx, tx,
+			`SELECT value FROM ermes_session_data WHERE session_id = ? AND data_key = ?`,
+			sessionId, key).Scan(&value)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		return err
+	})
+
+	return value, err
+}
+
+// Set the value of a key in the session key space. The write is fenced by the
+// fencing token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) SetSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+	value []byte,
+) error {
+	if value == nil {
+		value = []byte{}
+	}
+
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockFencedSession(ctx, tx, sessionId, fencingToken); err != nil {
+			return err
+		}
+
+		_, err := c.exec(ctx, tx,
+			`INSERT INTO ermes_session_data (session_id, data_key, value) VALUES (?, ?, ?)
+			ON CONFLICT (session_id, data_key) DO UPDATE SET value = excluded.value`,
+			sessionId, key, value)
+		return err
+	})
+}
+
+// Delete a key from the session key space. The write is fenced by the fencing
+// token of the acquisition that performs it, 0 if not fenced.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+// - ErrStaleFencingToken: If the acquisition has been released or its lease
+// expired.
+// - ErrSessionIsOffloading: If the write is fenced and the session is
+// offloading.
+// - ErrAcquisitionIsReadOnly: If the acquisition is in read mode.
+func (c *Commands) DeleteSessionData(
+	ctx context.Context,
+	sessionId string,
+	fencingToken uint64,
+	key string,
+) error {
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockFencedSession(ctx, tx, sessionId, fencingToken); err != nil {
+			return err
+		}
+
+		_, err := c.exec(ctx, tx,
+			`DELETE FROM ermes_session_data WHERE session_id = ? AND data_key = ?`,
+			sessionId, key)
+		return err
+	})
+}
+
+// Returns the number of bytes of the session data stream of an offload of the
+// session.
+// errors:
+// - ErrSessionNotFound: If no session with the given id is found.
+func (c *Commands) GetSessionSize(
+	ctx context.Context,
+	sessionId string,
+) (int64, error) {
+	if _, err := c.GetSessionMetadata(ctx, sessionId); err != nil {
+		return 0, err
+	}
+
+	var size framing.Counter
+	err := c.writeSessionData(ctx, sessionId, &size)
+	return int64(size), err
+}
@@ -0,0 +1,73 @@
+package sql_commands
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Returns the metadata associated with a session.
+func (c *Commands) GetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+) (api.SessionMetadata, error) {
+	var metadata api.SessionMetadata
+	var expiresAt sql.NullInt64
+	var clientGeoCoordinates sql.NullString
+	err := c.queryRow(ctx, c.db,
+		`SELECT m.created_in, m.created_at, m.updated_at, m.expires_at, m.client_geo_coordinates, s.last_acquisition_id
+		FROM ermes_session_metadata m JOIN ermes_sessions s ON s.id = m.session_id
+		WHERE s.id = ? AND s.`+filterLive,
+		sessionId).Scan(&metadata.CreatedIn, &metadata.CreatedAt, &metadata.UpdatedAt, &expiresAt, &clientGeoCoordinates, &metadata.FencingToken)
+	if err == sql.ErrNoRows {
+		return api.SessionMetadata{}, api.ErrSessionNotFound
+	} else if err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	if expiresAt.Valid {
+		metadata.ExpiresAt = &expiresAt.Int64
+	}
+
+	if metadata.ClientGeoCoordinates, err = decodeOptional[infrastructure.GeoCoordinates](clientGeoCoordinates); err != nil {
+		return api.SessionMetadata{}, err
+	}
+
+	return metadata, nil
+}
+
+// Sets the metadata associated with a session.
+func (c *Commands) SetSessionMetadata(
+	ctx context.Context,
+	sessionId string,
+	opt api.SessionMetadataOptions,
+) error {
+	clientGeoCoordinates, err := encodeOptional(opt.ClientGeoCoordinates())
+	if err != nil {
+		return err
+	}
+
+	return c.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := c.lockFencedSession(ctx, tx, sessionId, opt.FencingToken()); err != nil {
+			return err
+		}
+
+		updatedAt := now()
+		expiresAt := nullInt64(opt.ExpiresAt())
+		if opt.Expired() {
+			expiresAt = nullInt64(&updatedAt)
+		}
+
+		// Absent values are left unchanged.
+		_, err := c.exec(ctx, tx,
+			`UPDATE ermes_session_metadata SET
+				client_geo_coordinates = COALESCE(?, client_geo_coordinates),
+				expires_at = COALESCE(?, expires_at),
+				updated_at = ?
+			WHERE session_id = ?`,
+			clientGeoCoordinates, expiresAt, updatedAt, sessionId)
+		return err
+	})
+}
@@ -0,0 +1,66 @@
+package http_functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Trailer names.
+	checksumTrailerName = "X-Session-Checksum"
+)
+
+// A reader that computes the SHA-256 digest of the stream, and calls onEOF with
+// the hex-encoded digest once the stream ends. The error of onEOF, if any, is
+// returned in place of io.EOF.
+type checksumReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	onEOF  func(checksum string) error
+}
+
+// Wrap the reader with a checksum.
+func newChecksumReader(reader io.Reader, onEOF func(checksum string) error) *checksumReader {
+	return &checksumReader{reader: reader, hash: sha256.New(), onEOF: onEOF}
+}
+
+// Read from the reader, updating the digest.
+func (r *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.hash.Write(p[:n])
+
+	if err == io.EOF && r.onEOF != nil {
+		if checkErr := r.onEOF(hex.EncodeToString(r.hash.Sum(nil))); checkErr != nil {
+			return n, checkErr
+		}
+
+		// The digest is checked once.
+		r.onEOF = nil
+	}
+
+	return n, err
+}
+
+// Returns a function that verifies the checksum against the expected one, read
+// lazily since trailers are available only at the end of the body. A missing
+// expected checksum fails the verification, since a truncated stream may have
+// lost it.
+func verifyChecksum(expected func() string) func(checksum string) error {
+	return func(checksum string) error {
+		want := expected()
+		if want == "" {
+			return fmt.Errorf("%w: missing checksum", api.ErrSessionDataCorrupted)
+		}
+
+		if want != checksum {
+			return api.ErrSessionDataCorrupted
+		}
+
+		return nil
+	}
+}
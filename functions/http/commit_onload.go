@@ -0,0 +1,187 @@
+package http_functions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Param names.
+	onloadedSessionIdQueryParameterName = "sessionId"
+	// Type names.
+	commitOnloadRequestType = "commit_onload"
+	abortOnloadRequestType  = "abort_onload"
+)
+
+func (h *Handler) CommitOnload(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	sessionId := req.URL.Query().Get(onloadedSessionIdQueryParameterName)
+	h.resolveOnload(w, h.node.CommitSessionOnload(req.Context(), sessionId))
+}
+
+func (h *Handler) AbortOnload(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	sessionId := req.URL.Query().Get(onloadedSessionIdQueryParameterName)
+	h.resolveOnload(w, h.node.AbortSessionOnload(req.Context(), sessionId))
+}
+
+// Write the response to a commit or an abort of a pending onload.
+func (h *Handler) resolveOnload(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, api.ErrSessionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, api.ErrSessionIsNotOnloading):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) CreateResolveOnloadRequest(
+	ctx context.Context,
+	requestType string,
+	location api.SessionLocation,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		onloadedSessionIdQueryParameterName: {location.SessionId},
+		"type":                              {requestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     location.Host,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodPost, url.String(), nil)
+}
+
+func (h *Handler) IssueCommitOnloadRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) error {
+	return h.issueResolveOnloadRequest(ctx, commitOnloadRequestType, location)
+}
+
+func (h *Handler) IssueAbortOnloadRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) error {
+	return h.issueResolveOnloadRequest(ctx, abortOnloadRequestType, location)
+}
+
+// Issue a commit or an abort of a pending onload.
+func (h *Handler) issueResolveOnloadRequest(
+	ctx context.Context,
+	requestType string,
+	location api.SessionLocation,
+) error {
+	req, err := h.CreateResolveOnloadRequest(ctx, requestType, location)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return api.ErrSessionNotFound
+	case http.StatusConflict:
+		return api.ErrSessionIsNotOnloading
+	default:
+		return errors.New(requestType + " request failed")
+	}
+}
+
+// An offload target reached through the handler of another node.
+type remoteOffloadTarget struct {
+	h    *Handler
+	host string
+	// The preferred encodings of the session data.
+	encodings []string
+}
+
+// Returns the offload target that onloads the sessions on the given host.
+func (h *Handler) OffloadTarget(host string) api.OffloadTarget {
+	return remoteOffloadTarget{h: h, host: host, encodings: h.Encodings}
+}
+
+// Returns the offload target that onloads the sessions on the given host,
+// with the encodings of the options if set.
+func (h *Handler) OffloadTargetWithOptions(host string, opt api.OffloadSessionOptions) api.OffloadTarget {
+	if opt.Encodings() == nil {
+		return h.OffloadTarget(host)
+	}
+
+	return remoteOffloadTarget{h: h, host: host, encodings: opt.Encodings()}
+}
+
+// Returns the offload target that onloads batches of sessions on the given
+// host, with the encodings of the options if set.
+func (h *Handler) BatchOffloadTarget(host string, opt api.OffloadSessionOptions) api.BatchOffloadTarget {
+	return h.OffloadTargetWithOptions(host, opt).(remoteOffloadTarget)
+}
+
+func (t remoteOffloadTarget) Host() string {
+	return t.host
+}
+
+func (t remoteOffloadTarget) PrepareOnload(
+	ctx context.Context,
+	metadata api.SessionMetadata,
+	reader io.Reader,
+	onloadedFrom api.SessionLocation,
+) (api.SessionLocation, error) {
+	encoding, err := t.h.NegotiateOnloadEncoding(ctx, t.host, t.encodings)
+	if err != nil {
+		return api.SessionLocation{}, err
+	}
+
+	if t.h.ChunkSize > 0 {
+		return t.h.issueChunkedOnloadRequest(ctx, t.host, onloadedFrom, metadata, reader, encoding, true)
+	}
+
+	return t.h.issueOnloadRequest(ctx, t.host, onloadedFrom, metadata, reader, encoding, true)
+}
+
+func (t remoteOffloadTarget) PrepareOnloads(
+	ctx context.Context,
+	onloads []api.BatchOnload,
+) ([]api.BatchOnloadResult, error) {
+	encoding, err := t.h.NegotiateOnloadEncoding(ctx, t.host, t.encodings)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.h.IssueOnloadBatchRequest(ctx, t.host, onloads, encoding)
+}
+
+func (t remoteOffloadTarget) CommitOnload(ctx context.Context, location api.SessionLocation) error {
+	return t.h.IssueCommitOnloadRequest(ctx, location)
+}
+
+func (t remoteOffloadTarget) AbortOnload(ctx context.Context, location api.SessionLocation) error {
+	return t.h.IssueAbortOnloadRequest(ctx, location)
+}
+
+func (t remoteOffloadTarget) OnloadStatus(ctx context.Context, location api.SessionLocation) (api.OnloadStatus, error) {
+	return t.h.IssueOnloadStatusRequest(ctx, location)
+}
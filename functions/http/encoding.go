@@ -0,0 +1,235 @@
+package http_functions
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// Header names.
+	contentEncodingHeaderName = "Content-Encoding"
+	acceptEncodingHeaderName  = "Accept-Encoding"
+	// The encoding of the streams that are not encoded.
+	identityEncoding = "identity"
+	// The encodings of the handlers created by NewHandler.
+	DefaultEncodings = []string{"zstd", "gzip"}
+	// The error of the writes to a stream whose encoded body has been closed.
+	errEncodedBodyClosed = errors.New("encoded body closed")
+)
+
+// A content encoding of the session streams.
+type Codec interface {
+	// Returns a writer that encodes into w, closing it flushes the encoding.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// Returns a reader that decodes r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// The codecs by encoding name.
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{"zstd": zstdCodec{}, "gzip": gzipCodec{}}
+)
+
+// RegisterCodec registers the codec of an encoding, replacing the previous one.
+// zstd and gzip are registered by default, other encodings are negotiated once
+// a codec is registered for them.
+func RegisterCodec(encoding string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[encoding] = codec
+}
+
+// Returns the codec of an encoding, if registered.
+func lookupCodec(encoding string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[encoding]
+	return codec, ok
+}
+
+// The gzip codec of the standard library.
+type gzipCodec struct{}
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// The zstd codec of klauspost/compress.
+type zstdCodec struct{}
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.IOReadCloser(), nil
+}
+
+// Returns the encodings of the handler that have a registered codec.
+func (h *Handler) acceptedEncodings() []string {
+	accepted := make([]string, 0, len(h.Encodings))
+	for _, encoding := range h.Encodings {
+		if _, ok := lookupCodec(encoding); ok {
+			accepted = append(accepted, encoding)
+		}
+	}
+
+	return accepted
+}
+
+// Decode the body of a request according to its content encoding. The
+// encoding must be accepted by the handler. The returned reader must be closed
+// to release the decoder, that does not close the body.
+func (h *Handler) decodeBody(req *http.Request, body io.Reader) (io.ReadCloser, bool, error) {
+	encoding := req.Header.Get(contentEncodingHeaderName)
+	if encoding == "" || encoding == identityEncoding {
+		return io.NopCloser(body), true, nil
+	}
+
+	for _, accepted := range h.acceptedEncodings() {
+		if accepted == encoding {
+			codec, _ := lookupCodec(encoding)
+			reader, err := codec.NewReader(body)
+			return reader, true, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Encode a stream, the encoding runs in a go routine that starts with the
+// first read of the returned reader, and stops once it is closed. Some encoders
+// read the whole stream ahead, so the stream is not read before the request
+// is sent, when the trailers of the request cannot be updated yet. If the body
+// is a pipe, closing the returned reader closes it too, so that its writer does
+// not block if the encoding never started.
+func encodeBody(codec Codec, body io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	return &encodedBody{PipeReader: reader, body: body, encode: func() {
+		encoder, err := codec.NewWriter(writer)
+		if err == nil {
+			_, err = io.Copy(encoder, body)
+			if closeErr := encoder.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		// A nil error closes the reader with io.EOF.
+		writer.CloseWithError(err)
+	}}
+}
+
+// An encoded stream, whose encoding starts with the first read.
+type encodedBody struct {
+	*io.PipeReader
+	body   io.Reader
+	encode func()
+	start  sync.Once
+}
+
+// Read the encoded stream, starting the encoding if needed.
+func (b *encodedBody) Read(p []byte) (int, error) {
+	b.start.Do(func() {
+		go b.encode()
+	})
+
+	return b.PipeReader.Read(p)
+}
+
+// Close the encoded stream, and the body if it is a pipe.
+func (b *encodedBody) Close() error {
+	if body, ok := b.body.(*io.PipeReader); ok {
+		body.CloseWithError(errEncodedBodyClosed)
+	}
+
+	return b.PipeReader.Close()
+}
+
+// Write the encodings accepted by the onload of the handler.
+func (h *Handler) OnloadEncodings(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	w.Header().Set(acceptEncodingHeaderName, strings.Join(h.acceptedEncodings(), ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CreateOnloadEncodingsRequest(
+	ctx context.Context,
+	onloadToHost string,
+) (*http.Request, error) {
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     onloadToHost,
+		Path:     h.Path,
+		RawQuery: "type=" + onloadRequestType,
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodOptions, url.String(), nil)
+}
+
+// Returns the first of the preferred encodings accepted by the onload of the
+// given host, that has a registered codec, or the identity encoding. The nodes
+// that do not negotiate the encodings reject the request, and only accept the
+// identity encoding.
+func (h *Handler) NegotiateOnloadEncoding(
+	ctx context.Context,
+	onloadToHost string,
+	preferred []string,
+) (string, error) {
+	if len(preferred) == 0 {
+		return identityEncoding, nil
+	}
+
+	req, err := h.CreateOnloadEncodingsRequest(ctx, onloadToHost)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusBadRequest {
+		return identityEncoding, nil
+	}
+
+	if res.StatusCode != http.StatusNoContent {
+		return "", errors.New("onload encodings request failed")
+	}
+
+	accepted := make(map[string]bool)
+	for _, encoding := range strings.Split(res.Header.Get(acceptEncodingHeaderName), ",") {
+		accepted[strings.TrimSpace(encoding)] = true
+	}
+
+	for _, encoding := range preferred {
+		if _, ok := lookupCodec(encoding); ok && accepted[encoding] {
+			return encoding, nil
+		}
+	}
+
+	return identityEncoding, nil
+}
@@ -2,6 +2,9 @@ package http_functions
 
 import (
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ermes-labs/api-go/api"
 )
@@ -10,13 +13,46 @@ type Handler struct {
 	node   *api.Node
 	Scheme string
 	Path   string
+	// The content encodings of the session data accepted by the onload and
+	// preferred by the offloads, in order of preference. The encodings without
+	// a registered codec are ignored, and the identity encoding is always
+	// accepted.
+	Encodings []string
+	// The size of the chunks in which the offloads transfer the session data,
+	// a transfer is resumed from the last chunk acknowledged by the target. A
+	// size of zero streams the session data in a single request.
+	ChunkSize int
+	// The number of times a chunk is resent before the transfer fails.
+	ChunkRetries int
+	// The time a staged transfer is kept without receiving a chunk, after which
+	// its session data is discarded. Zero keeps the transfers until the onload.
+	TransferTTL time.Duration
+	// The maximum number of transfers staged at once, the transfers that exceed
+	// it are rejected. Zero does not limit the transfers.
+	MaxTransfers int
+	// The staged chunked transfers, by transfer id, and their number.
+	transfers     sync.Map
+	transferCount atomic.Int64
 }
 
+const (
+	// The number of times a chunk is resent by default.
+	DefaultChunkRetries = 3
+	// The time a staged transfer is kept without receiving a chunk by default.
+	DefaultTransferTTL = 10 * time.Minute
+	// The maximum number of transfers staged at once by default.
+	DefaultMaxTransfers = 64
+)
+
 func NewHandler(node *api.Node, scheme, path string) *Handler {
 	return &Handler{
-		node:   node,
-		Scheme: scheme,
-		Path:   path,
+		node:         node,
+		Scheme:       scheme,
+		Path:         path,
+		Encodings:    DefaultEncodings,
+		ChunkRetries: DefaultChunkRetries,
+		TransferTTL:  DefaultTransferTTL,
+		MaxTransfers: DefaultMaxTransfers,
 	}
 }
 
@@ -38,6 +74,18 @@ func (h *Handler) Handle(
 		h.Onload(w, req)
 	case confirmOffloadRequestType:
 		h.ConfirmOffload(w, req)
+	case commitOnloadRequestType:
+		h.CommitOnload(w, req)
+	case abortOnloadRequestType:
+		h.AbortOnload(w, req)
+	case onloadStatusRequestType:
+		h.OnloadStatus(w, req)
+	case onloadChunkRequestType:
+		h.OnloadChunk(w, req)
+	case onloadBatchRequestType:
+		h.OnloadBatch(w, req)
+	case sessionLocationRequestType:
+		h.SessionLocation(w, req)
 	default:
 		http.Error(w, "Invalid request type", http.StatusBadRequest)
 	}
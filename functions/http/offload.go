@@ -36,9 +36,7 @@ func (h *Handler) Offload(
 		req.Context(),
 		oldLocation.SessionId,
 		offloadOptions,
-		func(ctx context.Context, sm api.SessionMetadata, r io.Reader) (api.SessionLocation, error) {
-			return h.IssueOnloadRequest(ctx, offloadToHost, oldLocation, sm, r)
-		},
+		h.OffloadTargetWithOptions(offloadToHost, offloadOptions),
 		func(ctx context.Context, oldLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
 			return h.IssueConfirmOffloadRequest(ctx, oldLocation, newLocation)
 		},
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/ermes-labs/api-go/api"
 )
@@ -15,8 +16,8 @@ var (
 	// Header names.
 	oldLocationHeaderName = "X-Session-Old-Location"
 	metadataHeaderName    = "X-Session-Metadata"
-	// Options.
-	onloadOptions = api.NewOnloadSessionOptionsBuilder().Build()
+	// Param names.
+	pendingQueryParameterName = "pending"
 	// Type name.
 	onloadRequestType = "onload"
 )
@@ -25,7 +26,39 @@ func (h *Handler) Onload(
 	w http.ResponseWriter,
 	req *http.Request,
 ) {
-	// FIXME: should this go inside sessionMetadata?
+	// The offloading node negotiates the encoding of the session data.
+	if req.Method == http.MethodOptions {
+		h.OnloadEncodings(w, req)
+		return
+	}
+
+	var data io.Reader = req.Body
+	// The session data of a chunked transfer has been staged by the previous
+	// requests of the transfer.
+	if transferId := req.URL.Query().Get(transferQueryParameterName); transferId != "" {
+		t, ok := h.takeTransfer(transferId)
+		if !ok {
+			http.Error(w, "Unknown transfer", http.StatusNotFound)
+			return
+		}
+		defer t.discard()
+
+		data = t.file
+	}
+
+	// Decode the session data.
+	decoded, accepted, err := h.decodeBody(req, data)
+	if !accepted {
+		w.Header().Set(acceptEncodingHeaderName, strings.Join(h.acceptedEncodings(), ", "))
+		http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer decoded.Close()
+
 	var oldLocation api.SessionLocation
 	// Read the old location from the headers.
 	oldLocationString := req.Header.Get(oldLocationHeaderName)
@@ -40,7 +73,7 @@ func (h *Handler) Onload(
 	// Read the metadata from the headers.
 	metadataString := req.Header.Get(metadataHeaderName)
 	// Unmarshall the metadata.
-	err := json.Unmarshal([]byte(metadataString), &metadata)
+	err = json.Unmarshal([]byte(metadataString), &metadata)
 	// If there is an error, return it.
 	if err != nil {
 		// Return an error response
@@ -48,12 +81,31 @@ func (h *Handler) Onload(
 		return
 	}
 
+	// The old location allows to keep the session id and to notify the last
+	// visited node once the session is offloaded again.
+	builder := api.NewOnloadSessionOptionsBuilder().OnloadedFrom(oldLocation)
+	// A pending onload waits for the commit or the abort of the offloading node.
+	if req.URL.Query().Get(pendingQueryParameterName) == "true" {
+		builder.Pending()
+	}
+
+	// The checksum of the session data is sent as a trailer, or as a header by
+	// the chunked transfers, the stream fails before the onload is stored if it
+	// does not match.
+	body := newChecksumReader(decoded, verifyChecksum(func() string {
+		if checksum := req.Trailer.Get(checksumTrailerName); checksum != "" {
+			return checksum
+		}
+
+		return req.Header.Get(checksumTrailerName)
+	}))
+
 	// Return the metadata and the response body.
 	onloadedTo, err := h.node.OnloadSession(
 		req.Context(),
 		metadata,
-		req.Body,
-		onloadOptions,
+		body,
+		builder.Build(),
 	)
 
 	if err == nil {
@@ -64,12 +116,19 @@ func (h *Handler) Onload(
 			w.WriteHeader(http.StatusCreated)
 			// Write the location in the response body.
 			w.Write(locationBytes)
+			return
 		}
 
 		// FIXME: Handle the case in which the Marshall fails but the onload is
 		// successful.
 	}
 
+	// If the data has been corrupted, the onload can be retried.
+	if errors.Is(err, api.ErrSessionDataCorrupted) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
 	// If there is an error, return it.
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
@@ -81,12 +140,88 @@ func (h *Handler) CreateOnloadRequest(
 	metadata api.SessionMetadata,
 	body io.Reader,
 ) (*http.Request, error) {
-	// The endpoint.
+	return h.CreateEncodedOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, identityEncoding)
+}
+
+// Create an onload request whose body is encoded with the given encoding, that
+// must have a registered codec.
+func (h *Handler) CreateEncodedOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+) (*http.Request, error) {
+	return h.createOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, encoding, false)
+}
+
+// Create an onload request, whose onload is left pending on the target until
+// it is committed or aborted if pending is true.
+func (h *Handler) createOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+	pending bool,
+) (*http.Request, error) {
+	// The checksum of the decoded body is sent as a trailer once the body has
+	// been streamed.
+	trailer := http.Header{checksumTrailerName: nil}
+	var encoded io.Reader = newChecksumReader(body, func(checksum string) error {
+		trailer.Set(checksumTrailerName, checksum)
+		return nil
+	})
+
+	if encoding != identityEncoding {
+		codec, ok := lookupCodec(encoding)
+		if !ok {
+			return nil, errors.New("no codec registered for the encoding " + encoding)
+		}
+
+		encoded = encodeBody(codec, encoded)
+	}
+
+	// Create the request.
+	req, err := h.newOnloadRequest(ctx, onloadToHost, url.Values{}, oldLocation, metadata, encoded, encoding, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	// The body is sent chunked, as required by the trailers.
+	req.ContentLength = -1
+	req.Trailer = trailer
+
+	// Return the request.
+	return req, nil
+}
+
+// Create an onload request with the old location and the metadata of the
+// session in the headers.
+func (h *Handler) newOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	queryParams url.Values,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+	pending bool,
+) (*http.Request, error) {
+	// The endpoint, a pending onload is committed or aborted by the offloading
+	// node.
+	if pending {
+		queryParams.Set(pendingQueryParameterName, "true")
+	}
+	queryParams.Set("type", onloadRequestType)
+
 	url := url.URL{
 		Scheme:   h.Scheme,
 		Host:     onloadToHost,
 		Path:     h.Path,
-		RawQuery: "type=" + onloadRequestType,
+		RawQuery: queryParams.Encode(),
 	}
 
 	// Create the request.
@@ -95,6 +230,10 @@ func (h *Handler) CreateOnloadRequest(
 		return nil, err
 	}
 
+	if encoding != identityEncoding {
+		req.Header.Set(contentEncodingHeaderName, encoding)
+	}
+
 	// Marshall the old location and the metadata.
 	oldLocationJSON, err := json.Marshal(oldLocation)
 	if err != nil {
@@ -112,7 +251,6 @@ func (h *Handler) CreateOnloadRequest(
 	// Set the metadata in the headers.
 	req.Header.Set(metadataHeaderName, string(metadataJSON))
 
-	// Return the request.
 	return req, nil
 }
 
@@ -122,13 +260,45 @@ func (h *Handler) IssueOnloadRequest(
 	oldLocation api.SessionLocation,
 	metadata api.SessionMetadata,
 	body io.Reader,
+) (api.SessionLocation, error) {
+	return h.IssueEncodedOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, identityEncoding)
+}
+
+// Issue an onload request whose body is encoded with the given encoding, see
+// NegotiateOnloadEncoding.
+func (h *Handler) IssueEncodedOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+) (api.SessionLocation, error) {
+	return h.issueOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, encoding, false)
+}
+
+// Issue an onload request, whose onload is left pending on the target until it
+// is committed or aborted if pending is true.
+func (h *Handler) issueOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+	pending bool,
 ) (api.SessionLocation, error) {
 	// Create the request.
-	req, err := h.CreateOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body)
+	req, err := h.createOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, encoding, pending)
 	if err != nil {
 		return api.SessionLocation{}, err
 	}
 
+	return sendOnloadRequest(req)
+}
+
+// Send an onload request and read the location of the onloaded session.
+func sendOnloadRequest(req *http.Request) (api.SessionLocation, error) {
 	// Send the request.
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -137,8 +307,13 @@ func (h *Handler) IssueOnloadRequest(
 	// Defer the close of the response body.
 	defer res.Body.Close()
 
-	// If the status code is not OK, return an error.
-	if res.StatusCode != http.StatusOK {
+	// The target detected a corruption of the session data.
+	if res.StatusCode == http.StatusUnprocessableEntity {
+		return api.SessionLocation{}, api.ErrSessionDataCorrupted
+	}
+
+	// If the status code is not Created, return an error.
+	if res.StatusCode != http.StatusCreated {
 		// TODO: Return a more meaningful error.
 		return api.SessionLocation{}, errors.New("onload failed")
 	}
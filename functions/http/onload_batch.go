@@ -0,0 +1,339 @@
+package http_functions
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Type name.
+	onloadBatchRequestType = "onload_batch"
+	// The checksum sent in place of the digest of a session whose data could
+	// not be read, so that its onload is rejected.
+	unreadableChecksum = "unreadable"
+	// The size of the data frames of the batch stream.
+	batchFrameSize = 32 * 1024
+	// The maximum length of a frame read from a batch stream, that bounds the
+	// memory allocated for the length prefix sent by the peer.
+	maxBatchFrameSize uint64 = 1024 * 1024
+	// Returned when the length prefix of a frame exceeds maxBatchFrameSize.
+	errBatchFrameTooLarge = errors.New("batch frame too large")
+)
+
+// The header of a session in a batch stream.
+type batchOnloadHeader struct {
+	OldLocation api.SessionLocation `json:"oldLocation"`
+	Metadata    api.SessionMetadata `json:"metadata"`
+}
+
+// The outcome of the onload of a session of a batch.
+type batchOnloadResult struct {
+	Location  *api.SessionLocation `json:"location,omitempty"`
+	Error     string               `json:"error,omitempty"`
+	Corrupted bool                 `json:"corrupted,omitempty"`
+}
+
+// Write a length-prefixed frame.
+func writeFrame(w io.Writer, frame []byte) error {
+	var length [binary.MaxVarintLen64]byte
+	if _, err := w.Write(length[:binary.PutUvarint(length[:], uint64(len(frame)))]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Read a length-prefixed frame. A clean io.EOF is returned only if the stream
+// ends before the frame.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > maxBatchFrameSize {
+		return nil, errBatchFrameTooLarge
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// Write the sessions of a batch as a stream of frames. Each session is sent as
+// a header frame, its data frames, an empty frame and a checksum frame.
+func writeBatch(w io.Writer, onloads []api.BatchOnload) error {
+	buffer := make([]byte, batchFrameSize)
+	for _, onload := range onloads {
+		header, err := json.Marshal(batchOnloadHeader{OldLocation: onload.OnloadedFrom, Metadata: onload.Metadata})
+		if err != nil {
+			return err
+		}
+
+		if err := writeFrame(w, header); err != nil {
+			return err
+		}
+
+		checksum := unreadableChecksum
+		reader := newChecksumReader(onload.Reader, func(sum string) error {
+			checksum = sum
+			return nil
+		})
+
+		// A session whose data cannot be read is rejected by the target, the
+		// following sessions are still sent.
+		for {
+			n, err := reader.Read(buffer)
+			if n > 0 {
+				if err := writeFrame(w, buffer[:n]); err != nil {
+					return err
+				}
+			}
+
+			if err != nil {
+				break
+			}
+		}
+
+		if err := writeFrame(w, nil); err != nil {
+			return err
+		}
+
+		if err := writeFrame(w, []byte(checksum)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A reader of the data frames of a session of a batch stream, that reads the
+// checksum frame once the data ends.
+type batchDataReader struct {
+	r        *bufio.Reader
+	frame    []byte
+	checksum string
+	eof      bool
+}
+
+func (d *batchDataReader) Read(p []byte) (int, error) {
+	for len(d.frame) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+
+		frame, err := readFrame(d.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return 0, err
+		}
+
+		// An empty frame ends the data of the session.
+		if len(frame) == 0 {
+			checksum, err := readFrame(d.r)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+
+				return 0, err
+			}
+
+			d.checksum, d.eof = string(checksum), true
+		}
+
+		d.frame = frame
+	}
+
+	n := copy(p, d.frame)
+	d.frame = d.frame[n:]
+	return n, nil
+}
+
+// Onload the sessions of a batch stream in a pending state, the response
+// lists the outcome of the onload of each session in order. If the stream
+// breaks, the outcomes of the sessions that follow are missing.
+func (h *Handler) OnloadBatch(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	// Decode the batch stream.
+	decoded, accepted, err := h.decodeBody(req, req.Body)
+	if !accepted {
+		w.Header().Set(acceptEncodingHeaderName, strings.Join(h.acceptedEncodings(), ", "))
+		http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer decoded.Close()
+
+	r := bufio.NewReader(decoded)
+	results := []batchOnloadResult{}
+	for {
+		headerBytes, err := readFrame(r)
+		if err == io.EOF {
+			break
+		}
+
+		var header batchOnloadHeader
+		if err == nil {
+			err = json.Unmarshal(headerBytes, &header)
+		}
+		if err != nil {
+			results = append(results, batchOnloadResult{Error: err.Error()})
+			break
+		}
+
+		// The checksum of the session data follows the data.
+		data := &batchDataReader{r: r}
+		body := newChecksumReader(data, verifyChecksum(func() string {
+			return data.checksum
+		}))
+
+		location, err := h.node.OnloadSession(req.Context(), header.Metadata, body, api.NewOnloadSessionOptionsBuilder().
+			OnloadedFrom(header.OldLocation).
+			Pending().
+			Build())
+
+		// Skip the data of the session the onload has not read.
+		_, skipErr := io.Copy(io.Discard, data)
+
+		switch {
+		case err == nil && skipErr == nil:
+			results = append(results, batchOnloadResult{Location: &location})
+		case err != nil:
+			results = append(results, batchOnloadResult{Error: err.Error(), Corrupted: errors.Is(err, api.ErrSessionDataCorrupted)})
+		default:
+			results = append(results, batchOnloadResult{Error: skipErr.Error()})
+		}
+
+		// The stream is broken, the sessions that follow cannot be read.
+		if skipErr != nil {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+// Create a batch onload request whose stream is encoded with the given
+// encoding, that must have a registered codec.
+func (h *Handler) CreateOnloadBatchRequest(
+	ctx context.Context,
+	onloadToHost string,
+	onloads []api.BatchOnload,
+	encoding string,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		"type": {onloadBatchRequestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     onloadToHost,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	// The batch stream is written concurrently to the request.
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeBatch(writer, onloads))
+	}()
+
+	// The writer of the stream blocks until the pipe is closed, if the request
+	// is not sent.
+	var body io.Reader = reader
+	if encoding != identityEncoding {
+		codec, ok := lookupCodec(encoding)
+		if !ok {
+			err := errors.New("no codec registered for the encoding " + encoding)
+			reader.CloseWithError(err)
+			return nil, err
+		}
+
+		body = encodeBody(codec, body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url.String(), body)
+	if err != nil {
+		reader.CloseWithError(err)
+		return nil, err
+	}
+
+	if encoding != identityEncoding {
+		req.Header.Set(contentEncodingHeaderName, encoding)
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return req, nil
+}
+
+// Issue a batch onload request, see NegotiateOnloadEncoding. Returns the
+// outcome of the onload of each session.
+func (h *Handler) IssueOnloadBatchRequest(
+	ctx context.Context,
+	onloadToHost string,
+	onloads []api.BatchOnload,
+	encoding string,
+) ([]api.BatchOnloadResult, error) {
+	req, err := h.CreateOnloadBatchRequest(ctx, onloadToHost, onloads, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// TODO: Return a more meaningful error.
+		return nil, errors.New("onload batch failed")
+	}
+
+	var results []batchOnloadResult
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]api.BatchOnloadResult, len(results))
+	for i, result := range results {
+		switch {
+		case result.Location != nil:
+			outcomes[i].Location = *result.Location
+		case result.Corrupted:
+			outcomes[i].Err = api.ErrSessionDataCorrupted
+		default:
+			outcomes[i].Err = errors.New(result.Error)
+		}
+	}
+
+	return outcomes, nil
+}
@@ -0,0 +1,575 @@
+package http_functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Header names.
+	transferOffsetHeaderName = "X-Transfer-Offset"
+	// Param names.
+	transferQueryParameterName = "transfer"
+	offsetQueryParameterName   = "offset"
+	// Type name.
+	onloadChunkRequestType = "onload_chunk"
+)
+
+// Returned when the target already stages the maximum number of transfers.
+var errTooManyTransfers = errors.New("too many staged transfers")
+
+// ErrUnexpectedTransferOffset is returned when the target rejects a chunk whose
+// offset does not match the offset it acknowledged, the transfer resumes from
+// the acknowledged offset.
+var ErrUnexpectedTransferOffset = errors.New("unexpected transfer offset")
+
+// The session data of a chunked transfer, staged by the target until the
+// onload request of the transfer. The transfers are staged in temporary files
+// and are not resumed across a restart of the target: the source fails to
+// send the next chunk of an unknown transfer, and the offload is aborted so
+// that it can be retried from the start.
+type transfer struct {
+	mu   sync.Mutex
+	file *os.File
+	// The number of bytes received, the offset acknowledged to the source.
+	size int64
+	// The time of the last chunk received.
+	lastUsed time.Time
+}
+
+// Remove the staged session data of the transfer.
+func (t *transfer) discard() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.discardLocked()
+}
+
+// Remove the staged session data of the transfer, the caller must hold the
+// lock.
+func (t *transfer) discardLocked() {
+	if t.file != nil {
+		t.file.Close()
+		os.Remove(t.file.Name())
+		t.file = nil
+	}
+}
+
+// Returns the transfer with the given id, a missing transfer is created if
+// create is true.
+// errors:
+// - errTooManyTransfers: If the transfer would exceed h.MaxTransfers.
+func (h *Handler) stagedTransfer(transferId string, create bool) (*transfer, error) {
+	h.discardExpiredTransfers(time.Now())
+
+	if t, ok := h.transfers.Load(transferId); ok || !create {
+		t, _ := t.(*transfer)
+		return t, nil
+	}
+
+	if count := h.transferCount.Add(1); h.MaxTransfers > 0 && count > int64(h.MaxTransfers) {
+		h.transferCount.Add(-1)
+		return nil, errTooManyTransfers
+	}
+
+	file, err := os.CreateTemp("", "ermes-transfer-*")
+	if err != nil {
+		h.transferCount.Add(-1)
+		return nil, err
+	}
+
+	t := &transfer{file: file, lastUsed: time.Now()}
+	// The chunk may be resent while the first attempt is still in progress.
+	if existing, loaded := h.transfers.LoadOrStore(transferId, t); loaded {
+		h.transferCount.Add(-1)
+		t.discard()
+		return existing.(*transfer), nil
+	}
+
+	return t, nil
+}
+
+// Discard the transfers that did not receive a chunk for h.TransferTTL. The
+// transfers in use are skipped.
+func (h *Handler) discardExpiredTransfers(now time.Time) {
+	if h.TransferTTL <= 0 {
+		return
+	}
+
+	h.transfers.Range(func(key, value any) bool {
+		t := value.(*transfer)
+		if !t.mu.TryLock() {
+			return true
+		}
+		defer t.mu.Unlock()
+
+		if now.Sub(t.lastUsed) > h.TransferTTL && h.transfers.CompareAndDelete(key, t) {
+			h.transferCount.Add(-1)
+			t.discardLocked()
+		}
+
+		return true
+	})
+}
+
+// Remove the transfer with the given id, its staged session data can be read
+// from the start.
+func (h *Handler) takeTransfer(transferId string) (*transfer, bool) {
+	value, ok := h.transfers.LoadAndDelete(transferId)
+	if !ok {
+		return nil, false
+	}
+	h.transferCount.Add(-1)
+
+	t := value.(*transfer)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return nil, false
+	}
+
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+
+	return t, true
+}
+
+// Stage a chunk of the session data of a transfer. The response carries the
+// offset acknowledged by the target, a chunk whose offset does not match the
+// acknowledged one is rejected with a conflict, and the part of the chunk
+// already received is skipped. A GET request reads the acknowledged offset,
+// and a DELETE request discards the transfer.
+func (h *Handler) OnloadChunk(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	transferId := req.URL.Query().Get(transferQueryParameterName)
+	if transferId == "" {
+		http.Error(w, "Missing transfer", http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		if t, ok := h.takeTransfer(transferId); ok {
+			t.discard()
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.URL.Query().Get(offsetQueryParameterName), 10, 64)
+	if req.Method == http.MethodGet {
+		offset, err = 0, nil
+	}
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	// The first chunk starts the transfer.
+	t, err := h.stagedTransfer(transferId, req.Method == http.MethodPost && offset == 0)
+	if errors.Is(err, errTooManyTransfers) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if t == nil {
+		http.Error(w, "Unknown transfer", http.StatusNotFound)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		http.Error(w, "Unknown transfer", http.StatusNotFound)
+		return
+	}
+
+	// The transfer is used until the chunk has been staged.
+	defer func() { t.lastUsed = time.Now() }()
+
+	if req.Method == http.MethodGet {
+		w.Header().Set(transferOffsetHeaderName, strconv.FormatInt(t.size, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The source must resume from the acknowledged offset.
+	if offset > t.size {
+		w.Header().Set(transferOffsetHeaderName, strconv.FormatInt(t.size, 10))
+		http.Error(w, "Unexpected offset", http.StatusConflict)
+		return
+	}
+
+	// Skip the part of the chunk that has already been received, the received
+	// part is kept even if the rest of the chunk is not.
+	_, err = io.CopyN(io.Discard, req.Body, t.size-offset)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(t.file, req.Body)
+		t.size += n
+	} else if err == io.EOF {
+		err = nil
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(transferOffsetHeaderName, strconv.FormatInt(t.size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CreateOnloadChunkRequest(
+	ctx context.Context,
+	onloadToHost string,
+	transferId string,
+	offset int64,
+	chunk []byte,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		transferQueryParameterName: {transferId},
+		offsetQueryParameterName:   {strconv.FormatInt(offset, 10)},
+		"type":                     {onloadChunkRequestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     onloadToHost,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url.String(), bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return req, nil
+}
+
+// Send a chunk of the session data, returns the offset acknowledged by the
+// target, that may differ from the end of the chunk if the target has not
+// received all the previous chunks.
+// errors:
+// - ErrUnexpectedTransferOffset: If the target rejected the chunk, the
+// acknowledged offset is returned with the error.
+func (h *Handler) IssueOnloadChunkRequest(
+	ctx context.Context,
+	onloadToHost string,
+	transferId string,
+	offset int64,
+	chunk []byte,
+) (int64, error) {
+	req, err := h.CreateOnloadChunkRequest(ctx, onloadToHost, transferId, offset, chunk)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusConflict {
+		// TODO: Return a more meaningful error.
+		return 0, errors.New("onload chunk failed")
+	}
+
+	acknowledged, err := strconv.ParseInt(res.Header.Get(transferOffsetHeaderName), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.StatusCode == http.StatusConflict {
+		return acknowledged, ErrUnexpectedTransferOffset
+	}
+
+	return acknowledged, nil
+}
+
+func (h *Handler) CreateDiscardTransferRequest(
+	ctx context.Context,
+	onloadToHost string,
+	transferId string,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		transferQueryParameterName: {transferId},
+		"type":                     {onloadChunkRequestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     onloadToHost,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodDelete, url.String(), nil)
+}
+
+func (h *Handler) IssueDiscardTransferRequest(
+	ctx context.Context,
+	onloadToHost string,
+	transferId string,
+) error {
+	req, err := h.CreateDiscardTransferRequest(ctx, onloadToHost, transferId)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		// TODO: Return a more meaningful error.
+		return errors.New("discard transfer failed")
+	}
+
+	return nil
+}
+
+// Issue an onload request whose session data is transferred in chunks of
+// h.ChunkSize bytes. A chunk that fails is resent from the offset acknowledged
+// by the target up to h.ChunkRetries times, then the transfer is discarded.
+//
+// errors:
+// - api.ErrSessionDataCorrupted: If the staged session data does not match
+// the checksum of the stream.
+func (h *Handler) IssueChunkedOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+) (api.SessionLocation, error) {
+	return h.issueChunkedOnloadRequest(ctx, onloadToHost, oldLocation, metadata, body, encoding, false)
+}
+
+// Issue a chunked onload request, whose onload is left pending on the target
+// until it is committed or aborted if pending is true.
+func (h *Handler) issueChunkedOnloadRequest(
+	ctx context.Context,
+	onloadToHost string,
+	oldLocation api.SessionLocation,
+	metadata api.SessionMetadata,
+	body io.Reader,
+	encoding string,
+	pending bool,
+) (location api.SessionLocation, err error) {
+	transferId, err := newTransferId()
+	if err != nil {
+		return api.SessionLocation{}, err
+	}
+
+	// The checksum of the decoded stream is sent with the onload request.
+	var checksum string
+	var data io.Reader = newChecksumReader(body, func(sum string) error {
+		checksum = sum
+		return nil
+	})
+
+	if encoding != identityEncoding {
+		codec, ok := lookupCodec(encoding)
+		if !ok {
+			return api.SessionLocation{}, errors.New("no codec registered for the encoding " + encoding)
+		}
+
+		encoded := encodeBody(codec, data)
+		defer encoded.Close()
+		data = encoded
+	}
+
+	// The staged session data is discarded if the transfer fails.
+	defer func() {
+		if err != nil {
+			h.IssueDiscardTransferRequest(context.WithoutCancel(ctx), onloadToHost, transferId)
+		}
+	}()
+
+	chunks := newChunkReader(data, h.ChunkSize)
+	defer chunks.close()
+
+	for offset := int64(0); ; {
+		chunk, err := chunks.readFrom(offset)
+		if err != nil {
+			return api.SessionLocation{}, err
+		}
+
+		// Resend the chunk from the acknowledged offset until it is received.
+		for attempt := 0; ; attempt++ {
+			var acknowledged int64
+			acknowledged, err = h.IssueOnloadChunkRequest(ctx, onloadToHost, transferId, offset, chunk)
+			if err == nil {
+				offset = acknowledged
+				// The offsets of an encoded stream do not match the session data.
+				if encoding == identityEncoding {
+					api.AcknowledgeOffloadedBytes(body, offset)
+				}
+				break
+			}
+
+			if attempt >= h.ChunkRetries || ctx.Err() != nil {
+				return api.SessionLocation{}, err
+			}
+
+			// The offset is read again, unless the target rejected the chunk
+			// with the offset it acknowledged.
+			if !errors.Is(err, ErrUnexpectedTransferOffset) {
+				var ackErr error
+				if acknowledged, ackErr = h.issueTransferOffsetRequest(ctx, onloadToHost, transferId); ackErr != nil {
+					continue
+				}
+			}
+
+			offset = acknowledged
+			if chunk, err = chunks.readFrom(offset); err != nil {
+				return api.SessionLocation{}, err
+			}
+		}
+
+		if chunks.done(offset) {
+			break
+		}
+	}
+
+	req, err := h.newOnloadRequest(ctx, onloadToHost, url.Values{
+		transferQueryParameterName: {transferId},
+	}, oldLocation, metadata, http.NoBody, encoding, pending)
+	if err != nil {
+		return api.SessionLocation{}, err
+	}
+
+	req.Header.Set(checksumTrailerName, checksum)
+	return sendOnloadRequest(req)
+}
+
+// Read the offset of a transfer acknowledged by the target.
+func (h *Handler) issueTransferOffsetRequest(
+	ctx context.Context,
+	onloadToHost string,
+	transferId string,
+) (int64, error) {
+	req, err := h.CreateOnloadChunkRequest(ctx, onloadToHost, transferId, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Method = http.MethodGet
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return 0, errors.New("transfer offset failed")
+	}
+
+	return strconv.ParseInt(res.Header.Get(transferOffsetHeaderName), 10, 64)
+}
+
+// A reader of the session data that spools the stream read so far in a
+// temporary file, so that the stream can be re-read from any offset that the
+// target acknowledged, and not only from the last chunk read.
+type chunkReader struct {
+	reader io.Reader
+	chunk  []byte
+	// The stream read so far, created with the first chunk.
+	spool *os.File
+	// The number of bytes read from the stream.
+	size int64
+	eof  bool
+}
+
+func newChunkReader(reader io.Reader, size int) *chunkReader {
+	return &chunkReader{reader: reader, chunk: make([]byte, size)}
+}
+
+// Returns the next chunk of the stream from the offset. Once the offset reaches
+// the end of the stream read so far, the next chunk is read and spooled.
+func (r *chunkReader) readFrom(offset int64) ([]byte, error) {
+	if offset < 0 || offset > r.size {
+		return nil, errors.New("offset " + strconv.FormatInt(offset, 10) + " has not been read")
+	}
+
+	if offset < r.size {
+		n, err := r.spool.ReadAt(r.chunk[:min(int64(len(r.chunk)), r.size-offset)], offset)
+		return r.chunk[:n], err
+	}
+
+	if r.eof {
+		return nil, nil
+	}
+
+	n, err := io.ReadFull(r.reader, r.chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.eof = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	if r.spool == nil {
+		if r.spool, err = os.CreateTemp("", "ermes-offload-*"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := r.spool.WriteAt(r.chunk[:n], r.size); err != nil {
+		return nil, err
+	}
+
+	r.size += int64(n)
+	return r.chunk[:n], nil
+}
+
+// Returns true if the stream has been read and acknowledged up to its end.
+func (r *chunkReader) done(offset int64) bool {
+	return r.eof && offset == r.size
+}
+
+// Remove the spooled stream.
+func (r *chunkReader) close() {
+	if r.spool != nil {
+		r.spool.Close()
+		os.Remove(r.spool.Name())
+		r.spool = nil
+	}
+}
+
+// Returns a random id for a transfer.
+func newTransferId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
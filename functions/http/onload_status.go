@@ -0,0 +1,84 @@
+package http_functions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Type name.
+	onloadStatusRequestType = "onload_status"
+)
+
+func (h *Handler) OnloadStatus(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	sessionId := req.URL.Query().Get(onloadedSessionIdQueryParameterName)
+	status, err := h.node.GetSessionOnloadStatus(req.Context(), sessionId)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strconv.Itoa(int(status))))
+}
+
+func (h *Handler) CreateOnloadStatusRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		onloadedSessionIdQueryParameterName: {location.SessionId},
+		"type":                              {onloadStatusRequestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     location.Host,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+}
+
+func (h *Handler) IssueOnloadStatusRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) (api.OnloadStatus, error) {
+	req, err := h.CreateOnloadStatusRequest(ctx, location)
+	if err != nil {
+		return api.OnloadStatusUnknown, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return api.OnloadStatusUnknown, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return api.OnloadStatusUnknown, errors.New("onload status request failed")
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return api.OnloadStatusUnknown, err
+	}
+
+	status, err := strconv.Atoi(string(body))
+	if err != nil {
+		return api.OnloadStatusUnknown, err
+	}
+
+	return api.OnloadStatus(status), nil
+}
@@ -0,0 +1,511 @@
+package http_functions_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	http_functions "github.com/ermes-labs/api-go/functions/http"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+// Start a node whose functions are reachable through a test server, whose host
+// is the address of the server. The middlewares wrap the handler of the server.
+func newServer(t *testing.T, middlewares ...func(http.Handler) http.Handler) (*api.Node, *http_functions.Handler) {
+	server := httptest.NewUnstartedServer(nil)
+	node := infrastructure.Node{AreaName: server.Listener.Addr().String(), Host: server.Listener.Addr().String()}
+	n := api.NewNode(node, memory_commands.NewCommands(node))
+	handler := http_functions.NewHandler(n, "http", "/")
+	server.Config.Handler = http.HandlerFunc(handler.Handle)
+	for _, middleware := range middlewares {
+		server.Config.Handler = middleware(server.Config.Handler)
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return n, handler
+}
+
+// Create a session with some data, return its id.
+func sessionWithData(t *testing.T, n *api.Node, value string) string {
+	ctx := context.Background()
+
+	sessionToken, err := n.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if err := n.Cmd.(*memory_commands.Commands).SetSessionData(ctx, sessionToken.SessionId, 0, "key", []byte(value)); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return sessionToken.SessionId
+}
+
+// Start the offload of a session with some data, return its metadata and data.
+func offloadingSession(t *testing.T, n *api.Node) (string, api.SessionMetadata, []byte) {
+	ctx := context.Background()
+	cmd := n.Cmd.(*memory_commands.Commands)
+	sessionId := sessionWithData(t, n, "value")
+
+	metadata, err := n.GetSessionMetadata(ctx, sessionId)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	reader, _, err := cmd.OffloadSession(ctx, sessionId, api.DefaultOffloadSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	return sessionId, metadata, data
+}
+
+func TestOnloadVerifiesChecksum(t *testing.T) {
+	ctx := context.Background()
+	n1, handler := newServer(t)
+	n2, _ := newServer(t)
+	sessionId, metadata, data := offloadingSession(t, n1)
+	oldLocation := api.NewSessionLocation(n1.Host, sessionId)
+
+	// The body is corrupted after the checksum has been computed.
+	req, err := handler.CreateOnloadRequest(ctx, n2.Host, oldLocation, metadata, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, err := io.ReadAll(req.Body); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(bytes.Replace(data, []byte("value"), []byte("valuf"), 1)))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, res.StatusCode)
+	}
+
+	if status, err := n2.GetSessionOnloadStatus(ctx, sessionId); err != nil || status != api.OnloadStatusAborted {
+		t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusAborted, status, err)
+	}
+
+	// The body without its checksum is rejected as well.
+	req, err = handler.CreateOnloadRequest(ctx, n2.Host, oldLocation, metadata, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, err := io.ReadAll(req.Body); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	req.Body, req.Trailer = io.NopCloser(bytes.NewReader(data)), nil
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, res.StatusCode)
+	}
+
+	// The intact body is onloaded.
+	newLocation, err := handler.IssueOnloadRequest(ctx, n2.Host, oldLocation, metadata, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The onload is not left pending.
+	if status, err := n2.GetSessionOnloadStatus(ctx, newLocation.SessionId); err != nil || status != api.OnloadStatusCommitted {
+		t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusCommitted, status, err)
+	}
+}
+
+// A gzip codec that counts its uses.
+type countingCodec struct {
+	writers atomic.Int64
+	readers atomic.Int64
+}
+
+func (c *countingCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	c.writers.Add(1)
+	return gzip.NewWriter(w), nil
+}
+
+func (c *countingCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	c.readers.Add(1)
+	return gzip.NewReader(r)
+}
+
+func TestOnloadNegotiatesEncoding(t *testing.T) {
+	ctx := context.Background()
+	codec := &countingCodec{}
+	http_functions.RegisterCodec("counting", codec)
+	n1, handler := newServer(t)
+	n2, handler2 := newServer(t)
+	n3, handler3 := newServer(t)
+	handler2.Encodings = []string{"counting"}
+	handler3.Encodings = nil
+	opt := api.NewOffloadSessionOptionsBuilder().Encodings("unregistered", "counting").Build()
+
+	// The first preferred encoding accepted by the target is used.
+	sessionId, metadata, data := offloadingSession(t, n1)
+	target := handler.OffloadTargetWithOptions(n2.Host, opt)
+	location, err := target.PrepareOnload(ctx, metadata, bytes.NewReader(data), api.NewSessionLocation(n1.Host, sessionId))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if codec.writers.Load() != 1 || codec.readers.Load() != 1 {
+		t.Errorf("Expected the stream to be encoded, got %d writers and %d readers", codec.writers.Load(), codec.readers.Load())
+	}
+
+	if err := target.CommitOnload(ctx, location); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	value, err := n2.Cmd.(*memory_commands.Commands).GetSessionData(ctx, location.SessionId, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+
+	// A target that accepts no encoding receives the stream as is.
+	sessionId, metadata, data = offloadingSession(t, n1)
+	target = handler.OffloadTargetWithOptions(n3.Host, opt)
+	if _, err := target.PrepareOnload(ctx, metadata, bytes.NewReader(data), api.NewSessionLocation(n1.Host, sessionId)); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if codec.writers.Load() != 1 {
+		t.Errorf("Expected the stream not to be encoded, got %d writers", codec.writers.Load())
+	}
+}
+
+func TestOnloadEncodingOfBaselineNode(t *testing.T) {
+	ctx := context.Background()
+	// The node does not know the encodings request.
+	rejectOptions := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodOptions {
+				http.Error(w, "Unknown request", http.StatusBadRequest)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+	_, handler := newServer(t)
+	n2, _ := newServer(t, rejectOptions)
+
+	encoding, err := handler.NegotiateOnloadEncoding(ctx, n2.Host, []string{"zstd", "gzip"})
+	if err != nil || encoding != "identity" {
+		t.Errorf("Expected identity, got %q, %v", encoding, err)
+	}
+}
+
+func TestOnloadZstdEncoding(t *testing.T) {
+	ctx := context.Background()
+	n1, handler := newServer(t)
+	n2, _ := newServer(t)
+
+	// The encodings are advertised as accepted encodings.
+	req, err := handler.CreateOnloadEncodingsRequest(ctx, n2.Host)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	res.Body.Close()
+
+	if encodings := res.Header.Get("Accept-Encoding"); encodings != "zstd, gzip" {
+		t.Errorf("Expected zstd, gzip, got %q", encodings)
+	}
+
+	encoding, err := handler.NegotiateOnloadEncoding(ctx, n2.Host, []string{"zstd"})
+	if err != nil || encoding != "zstd" {
+		t.Errorf("Expected zstd, got %q, %v", encoding, err)
+	}
+
+	// The session is onloaded through the zstd stream.
+	sessionId, metadata, data := offloadingSession(t, n1)
+	target := handler.OffloadTargetWithOptions(n2.Host, api.NewOffloadSessionOptionsBuilder().Encodings("zstd").Build())
+	location, err := target.PrepareOnload(ctx, metadata, bytes.NewReader(data), api.NewSessionLocation(n1.Host, sessionId))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if err := target.CommitOnload(ctx, location); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	value, err := n2.Cmd.(*memory_commands.Commands).GetSessionData(ctx, location.SessionId, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+}
+
+func TestChunkedOnloadResumes(t *testing.T) {
+	ctx := context.Background()
+	var failures atomic.Int64
+	// The connection drops after the first bytes of the second chunk.
+	dropSecondChunk := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			if req.Method == http.MethodPost && query.Get("type") == "onload_chunk" && query.Get("offset") != "0" && failures.Add(1) == 1 {
+				req.Body = io.NopCloser(io.LimitReader(req.Body, 2))
+				next.ServeHTTP(httptest.NewRecorder(), req)
+				panic(http.ErrAbortHandler)
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	n1, handler := newServer(t)
+	n2, _ := newServer(t, dropSecondChunk)
+	handler.ChunkSize = 4
+
+	sessionId, metadata, data := offloadingSession(t, n1)
+	target := handler.OffloadTarget(n2.Host)
+	location, err := target.PrepareOnload(ctx, metadata, bytes.NewReader(data), api.NewSessionLocation(n1.Host, sessionId))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if failures.Load() < 2 {
+		t.Errorf("Expected the second chunk to be resent, got %d attempts", failures.Load())
+	}
+
+	if err := target.CommitOnload(ctx, location); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	value, err := n2.Cmd.(*memory_commands.Commands).GetSessionData(ctx, location.SessionId, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+}
+
+func TestChunkedOnloadResumesFromAcknowledgedOffset(t *testing.T) {
+	ctx := context.Background()
+	var conflicts atomic.Int64
+	// The second chunk is rejected once, asking to resume from the start.
+	rejectSecondChunk := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			if req.Method == http.MethodPost && query.Get("type") == "onload_chunk" && query.Get("offset") != "0" && conflicts.Add(1) == 1 {
+				w.Header().Set("X-Transfer-Offset", "0")
+				http.Error(w, "Unexpected offset", http.StatusConflict)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	n1, handler := newServer(t)
+	n2, _ := newServer(t, rejectSecondChunk)
+	handler.ChunkSize = 4
+
+	// The stream is re-read from before the last chunk read.
+	sessionId, metadata, data := offloadingSession(t, n1)
+	target := handler.OffloadTarget(n2.Host)
+	location, err := target.PrepareOnload(ctx, metadata, bytes.NewReader(data), api.NewSessionLocation(n1.Host, sessionId))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if err := target.CommitOnload(ctx, location); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	value, err := n2.Cmd.(*memory_commands.Commands).GetSessionData(ctx, location.SessionId, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+}
+
+func TestStagedTransfersExpire(t *testing.T) {
+	ctx := context.Background()
+	_, handler := newServer(t)
+	n2, handler2 := newServer(t)
+	handler2.TransferTTL = 50 * time.Millisecond
+	handler2.MaxTransfers = 1
+
+	if _, err := handler.IssueOnloadChunkRequest(ctx, n2.Host, "first", 0, []byte("data")); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// A chunk past the acknowledged offset is rejected.
+	if acknowledged, err := handler.IssueOnloadChunkRequest(ctx, n2.Host, "first", 8, []byte("data")); !errors.Is(err, http_functions.ErrUnexpectedTransferOffset) || acknowledged != 4 {
+		t.Errorf("Expected error %v at offset 4, got %v at offset %d", http_functions.ErrUnexpectedTransferOffset, err, acknowledged)
+	}
+
+	// The transfers that exceed the maximum are rejected.
+	if _, err := handler.IssueOnloadChunkRequest(ctx, n2.Host, "second", 0, []byte("data")); err == nil {
+		t.Errorf("Expected an error, got nil")
+	}
+
+	// The idle transfer is discarded once expired, making room for a new one.
+	time.Sleep(100 * time.Millisecond)
+	if _, err := handler.IssueOnloadChunkRequest(ctx, n2.Host, "second", 0, []byte("data")); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, err := handler.IssueOnloadChunkRequest(ctx, n2.Host, "first", 4, []byte("data")); err == nil {
+		t.Errorf("Expected an error, got nil")
+	}
+}
+
+func TestOffloadSessionsInBatch(t *testing.T) {
+	ctx := context.Background()
+	n1, handler := newServer(t)
+	n2, _ := newServer(t)
+	notify := func(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+		return false, nil
+	}
+
+	sessionIds := []string{sessionWithData(t, n1, "first"), "missing", sessionWithData(t, n1, "second")}
+	opt := api.DefaultOffloadSessionOptions()
+	results, err := n1.OffloadSessions(ctx, sessionIds, opt, handler.BatchOffloadTarget(n2.Host, opt), notify)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if len(results) != len(sessionIds) {
+		t.Fatalf("Expected %d results, got %d", len(sessionIds), len(results))
+	}
+
+	if !errors.Is(results[1].Err, api.ErrSessionNotFound) {
+		t.Errorf("Expected error %v, got %v", api.ErrSessionNotFound, results[1].Err)
+	}
+
+	// The other sessions are committed on the target.
+	for i, value := range map[int]string{0: "first", 2: "second"} {
+		if results[i].Err != nil {
+			t.Fatalf("Expected nil, got %v", results[i].Err)
+		}
+
+		if status, err := n2.GetSessionOnloadStatus(ctx, results[i].NewLocation.SessionId); err != nil || status != api.OnloadStatusCommitted {
+			t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusCommitted, status, err)
+		}
+
+		data, err := n2.Cmd.(*memory_commands.Commands).GetSessionData(ctx, results[i].NewLocation.SessionId, "key")
+		if err != nil || string(data) != value {
+			t.Errorf("Expected %s, got %q, %v", value, data, err)
+		}
+	}
+}
+
+func TestOnloadBatchRejectsLargeFrames(t *testing.T) {
+	n, _ := newServer(t)
+
+	// The length prefix of the first frame announces a terabyte.
+	frame := binary.AppendUvarint(nil, 1<<40)
+	res, err := http.Post("http://"+n.Host+"/?type=onload_batch", "application/octet-stream", bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer res.Body.Close()
+
+	var results []struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if len(results) != 1 || results[0].Error == "" {
+		t.Errorf("Expected the frame to be rejected, got %v", results)
+	}
+}
+
+func TestOnloadBatchRequestReleasesStream(t *testing.T) {
+	ctx := context.Background()
+	_, handler := newServer(t)
+	onloads := []api.BatchOnload{{Reader: bytes.NewReader([]byte("value"))}}
+
+	// The stream is written by a go routine, that must stop once the body of a
+	// request that is never sent is closed.
+	goroutines := runtime.NumGoroutine()
+	req, err := handler.CreateOnloadBatchRequest(ctx, "unreachable", onloads, "gzip")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	req.Body.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d go routines, got %d", goroutines, runtime.NumGoroutine())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOffloadSessionsInBatchAbortsAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	// The connection drops once the batch has been prepared.
+	dropBatchResponse := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost && req.URL.Query().Get("type") == "onload_batch" {
+				next.ServeHTTP(httptest.NewRecorder(), req)
+				panic(http.ErrAbortHandler)
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	n1, handler := newServer(t)
+	n2, _ := newServer(t, dropBatchResponse)
+
+	sessionIds := []string{sessionWithData(t, n1, "first"), sessionWithData(t, n1, "second")}
+	opt := api.DefaultOffloadSessionOptions()
+	results, err := n1.OffloadSessions(ctx, sessionIds, opt, handler.BatchOffloadTarget(n2.Host, opt), nil)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The onloads prepared by the target are aborted, and the sessions stay on
+	// the source.
+	for i, sessionId := range sessionIds {
+		if results[i].Err == nil {
+			t.Errorf("Expected an error, got nil")
+		}
+
+		if status, err := n2.GetSessionOnloadStatus(ctx, sessionId); err != nil || status != api.OnloadStatusAborted {
+			t.Errorf("Expected status %v, got %v, %v", api.OnloadStatusAborted, status, err)
+		}
+
+		if _, err := n1.GetSessionMetadata(ctx, sessionId); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	}
+}
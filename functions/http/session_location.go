@@ -0,0 +1,98 @@
+package http_functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+var (
+	// Type name.
+	sessionLocationRequestType = "session_location"
+)
+
+// Resolve a hop of the forwarding of a session: responds with the location the
+// session has been offloaded to, 204 if the session lives on this node or 404
+// if it is not found.
+func (h *Handler) SessionLocation(
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	sessionId := req.URL.Query().Get(onloadedSessionIdQueryParameterName)
+	location, err := h.node.ResolveSessionLocation(req.Context(), sessionId)
+
+	if errors.Is(err, api.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if location == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(location)
+}
+
+func (h *Handler) CreateSessionLocationRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) (*http.Request, error) {
+	queryParams := url.Values{
+		onloadedSessionIdQueryParameterName: {location.SessionId},
+		"type":                              {sessionLocationRequestType},
+	}
+
+	url := url.URL{
+		Scheme:   h.Scheme,
+		Host:     location.Host,
+		Path:     h.Path,
+		RawQuery: queryParams.Encode(),
+	}
+
+	return http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+}
+
+// Issue a session location request, it resolves a hop of the forwarding of a
+// session on a remote node, see api.SessionLocationResolver.
+func (h *Handler) IssueSessionLocationRequest(
+	ctx context.Context,
+	location api.SessionLocation,
+) (*api.SessionLocation, error) {
+	req, err := h.CreateSessionLocationRequest(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var next api.SessionLocation
+		if err := json.NewDecoder(res.Body).Decode(&next); err != nil {
+			return nil, err
+		}
+
+		return &next, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, api.ErrSessionNotFound
+	default:
+		return nil, errors.New("session location request failed")
+	}
+}
@@ -5,15 +5,26 @@ import (
 
 	"github.com/ermes-labs/api-go/api"
 	http_functions "github.com/ermes-labs/api-go/functions/http"
+	"github.com/ermes-labs/api-go/infrastructure"
 )
 
-var bestOffloadTargetsOptions = api.DefaultBestOffloadTargetsOptions()
+var (
+	bestOffloadTargetsOptions = api.DefaultBestOffloadTargetsOptions()
+	offloadPlanOptions        = api.DefaultOffloadSessionOptions()
+)
 
-func Begin_offload(
-	node api.Node,
+// Choose the sessions to offload and their targets, the targets are chosen by
+// the lookup node of the sessions, reached through the handler.
+func bestOffloadTargets(
+	node *api.Node,
 	ctx context.Context,
-) (*api.SessionLocation, error) {
+	handler *http_functions.Handler,
+) (map[string]api.SessionInfoForOffloadDecision, infrastructure.Node, [][2]string, error) {
 	sessions, err := node.BestSessionsToOffload(ctx, bestOffloadTargetsOptions)
+	if err != nil {
+		return nil, infrastructure.Node{}, nil, err
+	}
+
 	// Extract sessions ids
 	sessionsIds := make([]string, 0, len(sessions))
 	for sessionId := range sessions {
@@ -22,38 +33,79 @@ func Begin_offload(
 
 	lookupNode, err := node.FindLookupNode(ctx, sessionsIds)
 	if err != nil {
-		return nil, err
+		return nil, infrastructure.Node{}, nil, err
 	}
 
 	var sessionsToNodesMap [][2]string
 	if lookupNode.Host == node.Host {
 		sessionsToNodesMap, err = node.BestOffloadTargetNodes(ctx, node.Host, sessions, bestOffloadTargetsOptions)
 	} else {
-		var handler http_functions.Handler
 		// Create the request.
 		sessionsToNodesMap, err = handler.IssueBestOffloadTargetsRequest(ctx, lookupNode.Host, sessions)
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, infrastructure.Node{}, nil, err
 	}
 
-	// For each session-node couple, try to offload the session.
-	for _, target := range sessionsToNodesMap {
-		sessionId := target[0]
-		host := target[1]
+	return sessions, lookupNode, sessionsToNodesMap, nil
+}
+
+// Offload the sessions chosen by the node, each one to the first of its targets
+// that accepts it, and returns the outcome of each offload. The offloads are
+// the ones planned by Plan_offload, executed as by Execute_offload_plan.
+func Begin_offload(
+	node api.Node,
+	ctx context.Context,
+	handler *http_functions.Handler,
+) ([]api.OffloadResult, error) {
+	plan, err := Plan_offload(node, ctx, handler)
+	if err != nil {
+		return nil, err
+	}
 
-		var handler http_functions.Handler
-		newSessionId, err := handler.IssueOffloadRequest(ctx, host, sessionId)
+	return Execute_offload_plan(node, ctx, *plan, handler)
+}
 
-		if err != nil {
-			continue
-		}
+// The plan mode of Begin_offload: returns the offloads Begin_offload would
+// execute, without moving any session. The plan can be executed once approved
+// with Execute_offload_plan. The lookup node is reached through the handler.
+func Plan_offload(
+	node api.Node,
+	ctx context.Context,
+	handler *http_functions.Handler,
+) (*api.OffloadPlan, error) {
+	sessions, lookupNode, sessionsToNodesMap, err := bestOffloadTargets(&node, ctx, handler)
+	if err != nil {
+		return nil, err
+	}
 
-		location := api.NewSessionLocation(node.Host, newSessionId)
-		// musrchal in the body
-		return &location, nil
+	plan, err := node.PlanOffload(ctx, sessions, lookupNode, sessionsToNodesMap)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	return &plan, nil
+}
+
+// Execute a plan returned by Plan_offload, returns the outcome of each planned
+// offload. The targets are reached through the handler, that sets the scheme,
+// path and transfer options of the requests.
+func Execute_offload_plan(
+	node api.Node,
+	ctx context.Context,
+	plan api.OffloadPlan,
+	handler *http_functions.Handler,
+) ([]api.OffloadResult, error) {
+	return node.ExecuteOffloadPlan(
+		ctx,
+		plan,
+		offloadPlanOptions,
+		func(host string) api.OffloadTarget {
+			return handler.OffloadTargetWithOptions(host, offloadPlanOptions)
+		},
+		func(ctx context.Context, oldLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+			return handler.IssueConfirmOffloadRequest(ctx, oldLocation, newLocation)
+		},
+	)
 }
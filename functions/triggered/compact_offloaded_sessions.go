@@ -0,0 +1,22 @@
+package triggered_functions
+
+import (
+	"context"
+
+	"github.com/ermes-labs/api-go/api"
+	http_functions "github.com/ermes-labs/api-go/functions/http"
+)
+
+var compactOffloadedSessionsOptions = api.DefaultCompactOffloadedSessionsOptions()
+
+// Compact the tombstones of the sessions offloaded by the node, so that each
+// one points directly to the current location of its session, and collect the
+// tombstones that lead to no session. The nodes of the sessions are reached
+// through the handler, that sets the scheme and path of the requests.
+func Compact_offloaded_sessions(
+	node api.Node,
+	ctx context.Context,
+	handler *http_functions.Handler,
+) ([]api.TombstoneCompaction, error) {
+	return node.CompactOffloadedSessions(ctx, compactOffloadedSessionsOptions, handler.IssueSessionLocationRequest)
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/ermes-labs/api-go/api"
@@ -28,8 +29,14 @@ func CreateHandler(
 //     (Note that the error should be returned before writing anything to
 //     the responseWriter).
 //     1.2. The callback returns nil and the response is returned.
-//  2. The session has been offloaded and the callback is not run.
+//  2. The session has been offloaded and the callback is not run, the request
+//     is redirected or, in proxy mode, proxied to the node of the session. A
+//     request that already passed through this node, or through the maximum
+//     number of nodes, is rejected instead, see MaxForwardingHops.
 //  3. There is an error and the callback is not run.
+//
+// The request passed to the callback carries the fencing token of the
+// acquisition, see FencingToken.
 func Handle(
 	n *api.Node,
 	w http.ResponseWriter,
@@ -39,7 +46,13 @@ func Handle(
 	// Try to get the session token from the request.
 	sessionTokenBytes := opt.getSessionTokenBytes(req)
 	// If there is a session token and it belongs to a dummy client that ws not
-	sessionToken, err := api.UnmarshallSessionToken(sessionTokenBytes)
+	sessionToken, err := decodeSessionToken(opt, sessionTokenBytes)
+
+	// If the token has not been issued by a trusted node, reject it.
+	if errors.Is(err, api.ErrInvalidSessionTokenSignature) || errors.Is(err, api.ErrSessionTokenDecryption) {
+		opt.invalidSessionTokenErrorResponse(w, err)
+		return
+	}
 
 	// If there is an error, return an error response.
 	if err != nil {
@@ -52,10 +65,8 @@ func Handle(
 	// correct node.
 	if sessionToken != nil {
 		if redirect, destination := dummyClientNeedsRedirect(n, req.Context(), sessionToken); redirect {
-			// Set the session sessionToken in the response.
-			opt.setSessionTokenBytes(w, sessionTokenBytes)
-			// Create the redirect response.
-			opt.redirectResponse(w, req, destination.Host)
+			// Redirect or proxy the request to the node of the session.
+			forward(w, req, opt, n.Host, destination.Host, sessionTokenBytes)
 			// Return.
 			return
 		}
@@ -67,8 +78,8 @@ func Handle(
 		if opt.redirectNewRequest(req, n) {
 			// Get the host to redirect the request to.
 			host := opt.redirectTarget(req, n)
-			// Create the redirect response.
-			opt.redirectResponse(w, req, host)
+			// Redirect or proxy the request to the host.
+			forward(w, req, opt, n.Host, host, nil)
 			// Return.
 			return
 		}
@@ -83,19 +94,19 @@ func Handle(
 			// Create the options.
 			api.CreateAndAcquireSessionOptions{
 				CreateSessionOptions:  opt.getCreateSessionOptions(req),
-				AcquireSessionOptions: opt.getAcquireSessionOptions(req),
+				AcquireSessionOptions: opt.acquireSessionOptions(req),
 			},
 			// Wrap the handler callback.
-			func(sessionToken api.SessionToken) error {
-				sessionTokenBytes, err = api.MarshallSessionToken(sessionToken)
-				// It should not happen, but if there is an error, panic.
+			func(sessionToken api.SessionToken, fencingToken uint64) error {
+				sessionTokenBytes, err := encodeSessionToken(opt, sessionToken)
+				// The signer or the cipher may fail, do not run the handler.
 				if err != nil {
-					panic(err)
+					return err
 				}
 				// Set the session sessionToken in the response.
-				opt.setSessionTokenBytes(w, sessionTokenBytes)
+				opt.setSessionTokenBytes(w, req, sessionTokenBytes)
 				// Run the handler callback.
-				return handler(w, req, sessionToken)
+				return handler(w, withFencingToken(req, fencingToken), sessionToken)
 			})
 	} else {
 		var newToken *api.SessionToken = nil
@@ -106,21 +117,35 @@ func Handle(
 			// Pass the session token.
 			*sessionToken,
 			// Create the options.
-			opt.getAcquireSessionOptions(req),
+			opt.acquireSessionOptions(req),
 			// Wrap the handler callback.
-			func() error {
-				return handler(w, req, *sessionToken)
+			func(fencingToken uint64) error {
+				return handler(w, withFencingToken(req, fencingToken), *sessionToken)
 			})
 
-		// If the session has been offloaded, redirect the request.
+		// If the session has been offloaded, redirect or proxy the request.
 		if err == nil && newToken != nil {
-			// Set the session token in the response.
-			opt.setSessionTokenBytes(w, sessionTokenBytes)
-			// Create the redirect response.
-			opt.redirectResponse(w, req, sessionToken.Host)
+			// Issue the token of the new location.
+			sessionTokenBytes, err = encodeSessionToken(opt, *newToken)
+		}
+		if err == nil && newToken != nil {
+			// Redirect or proxy the request to the new location.
+			forward(w, req, opt, n.Host, newToken.Host, sessionTokenBytes)
 		}
 	}
 
+	// If the session is acquired in a conflicting mode, return a conflict.
+	if errors.Is(err, api.ErrSessionIsAcquired) {
+		opt.sessionIsAcquiredErrorResponse(w, err)
+		return
+	}
+
+	// If the session is still offloading, ask the client to retry.
+	if errors.Is(err, api.ErrSessionIsOffloading) {
+		opt.sessionIsOffloadingErrorResponse(w, err)
+		return
+	}
+
 	// If there is an error, return an error response.
 	if err != nil {
 		// Create the internal server error response.
@@ -130,6 +155,46 @@ func Handle(
 	}
 }
 
+// The key of the fencing token in the context of the requests.
+type fencingTokenKey struct{}
+
+// FencingToken returns the fencing token of the acquisition of the session, to
+// fence the writes to the session. It is set in the request passed to the
+// callback of Handle, false is returned for the other requests.
+func FencingToken(req *http.Request) (uint64, bool) {
+	fencingToken, ok := req.Context().Value(fencingTokenKey{}).(uint64)
+	return fencingToken, ok
+}
+
+// Return a shallow copy of the request that carries the fencing token.
+func withFencingToken(req *http.Request, fencingToken uint64) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), fencingTokenKey{}, fencingToken))
+}
+
+// Decode the session token received with the request with the codec of its
+// version, decrypting and verifying it if the options require so. Returns nil if there is no session token.
+func decodeSessionToken(opt HandlerOptions, sessionTokenBytes []byte) (*api.SessionToken, error) {
+	if len(sessionTokenBytes) != 0 && opt.tokenCipher != nil {
+		var err error
+		if sessionTokenBytes, err = opt.tokenCipher.Open(sessionTokenBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	return opt.sessionTokenCodecs.Unmarshall(sessionTokenBytes, opt.tokenVerifier)
+}
+
+// Encode the session token to send with the response with the default codec,
+// signing and encrypting it if the options require so.
+func encodeSessionToken(opt HandlerOptions, sessionToken api.SessionToken) ([]byte, error) {
+	sessionTokenBytes, err := opt.sessionTokenCodecs.Marshall(sessionToken, opt.tokenSigner)
+	if err != nil || opt.tokenCipher == nil {
+		return sessionTokenBytes, err
+	}
+
+	return opt.tokenCipher.Seal(sessionTokenBytes)
+}
+
 // Return if the session token belongs to a dummy client that was not able to
 // make the request to the correct node, and the sessionLocation of the correct node.
 func dummyClientNeedsRedirect(n *api.Node, ctx context.Context, sessionToken *api.SessionToken) (bool, api.SessionLocation) {
@@ -1,7 +1,10 @@
 package http
 
 import (
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/ermes-labs/api-go/api"
 )
@@ -9,14 +12,32 @@ import (
 // Options for the handler.
 type HandlerOptions struct {
 	getAcquireSessionOptions           func(req *http.Request) api.AcquireSessionOptions
+	getAcquisitionMode                 func(req *http.Request) api.AcquisitionMode
 	getCreateSessionOptions            func(req *http.Request) api.CreateSessionOptions
 	getSessionTokenBytes               func(req *http.Request) []byte
 	redirectNewRequest                 func(req *http.Request, node *api.Node) bool
 	redirectTarget                     func(req *http.Request, node *api.Node) string
-	setSessionTokenBytes               func(w http.ResponseWriter, sessionTokenBytes []byte)
+	setSessionTokenBytes               func(w http.ResponseWriter, req *http.Request, sessionTokenBytes []byte)
+	setRequestSessionTokenBytes        func(req *http.Request, sessionTokenBytes []byte)
 	redirectResponse                   func(w http.ResponseWriter, req *http.Request, host string)
+	redirectStatusCode                 int
+	redirectScheme                     string
+	redirectPort                       string
+	redirectPorts                      map[string]string
 	malformedSessionTokenErrorResponse func(w http.ResponseWriter, err error)
+	invalidSessionTokenErrorResponse   func(w http.ResponseWriter, err error)
 	internalServerErrorResponse        func(w http.ResponseWriter, err error)
+	sessionIsAcquiredErrorResponse     func(w http.ResponseWriter, err error)
+	sessionIsOffloadingErrorResponse   func(w http.ResponseWriter, err error)
+	proxyErrorResponse                 func(w http.ResponseWriter, err error)
+	forwardingLoopErrorResponse        func(w http.ResponseWriter, err error)
+	maxForwardingHops                  int
+	proxy                              bool
+	proxyTransport                     http.RoundTripper
+	tokenSigner                        api.TokenSigner
+	tokenVerifier                      api.TokenVerifier
+	tokenCipher                        api.TokenCipher
+	sessionTokenCodecs                 *api.SessionTokenCodecRegistry
 }
 
 // Builder for HandlerOptions.
@@ -45,6 +66,20 @@ func (builder *HandlerOptionsBuilder) AcquireSessionOptions(acquireSessionOption
 	return builder
 }
 
+// Set the function that returns the mode of the acquisition of the session of
+// a request, that overrides the mode of the AcquireSessionOptions. If nil, the
+// mode of the AcquireSessionOptions is used.
+func (builder *HandlerOptionsBuilder) AcquisitionModeFunc(getAcquisitionMode func(req *http.Request) api.AcquisitionMode) *HandlerOptionsBuilder {
+	builder.options.getAcquisitionMode = getAcquisitionMode
+	return builder
+}
+
+// Acquire the sessions in read mode for the safe methods and in write mode for
+// the others, see MethodAcquisitionMode.
+func (builder *HandlerOptionsBuilder) AcquisitionModeByMethod() *HandlerOptionsBuilder {
+	return builder.AcquisitionModeFunc(MethodAcquisitionMode)
+}
+
 // Set the CreateSessionOptions function.
 func (builder *HandlerOptionsBuilder) GetCreateSessionOptionsFunc(CreateSessionOptions func(req *http.Request) api.CreateSessionOptions) *HandlerOptionsBuilder {
 	builder.options.getCreateSessionOptions = CreateSessionOptions
@@ -79,37 +114,193 @@ func (builder *HandlerOptionsBuilder) RedirectTarget(redirectTarget func(req *ht
 
 // Set the setSessionTokenBytes function.
 func (builder *HandlerOptionsBuilder) SetSessionTokenBytes(setSessionTokenBytes func(w http.ResponseWriter, sessionTokenBytes []byte)) *HandlerOptionsBuilder {
-	builder.options.setSessionTokenBytes = setSessionTokenBytes
+	builder.options.setSessionTokenBytes = func(w http.ResponseWriter, _ *http.Request, sessionTokenBytes []byte) {
+		setSessionTokenBytes(w, sessionTokenBytes)
+	}
 	return builder
 }
 
-// Set the redirectResponse function.
+// Set the redirectResponse function. By default, the client is redirected to
+// the URL of the request on the given host, see RedirectStatusCode,
+// RedirectScheme, RedirectPort and RedirectPorts.
 func (builder *HandlerOptionsBuilder) RedirectResponse(redirectResponse func(w http.ResponseWriter, req *http.Request, host string)) *HandlerOptionsBuilder {
 	builder.options.redirectResponse = redirectResponse
 	return builder
 }
 
+// Set the status code of the default redirect response, by default
+// http.StatusTemporaryRedirect. Use http.StatusTemporaryRedirect or
+// http.StatusPermanentRedirect to keep the method and the body of the request,
+// http.StatusFound or http.StatusSeeOther to redirect with a GET.
+func (builder *HandlerOptionsBuilder) RedirectStatusCode(redirectStatusCode int) *HandlerOptionsBuilder {
+	builder.options.redirectStatusCode = redirectStatusCode
+	return builder
+}
+
+// Set the scheme of the URLs the requests are redirected or proxied to. If
+// empty, the scheme of the request, see RequestScheme.
+func (builder *HandlerOptionsBuilder) RedirectScheme(redirectScheme string) *HandlerOptionsBuilder {
+	builder.options.redirectScheme = redirectScheme
+	return builder
+}
+
+// Set the port of the URLs the requests are redirected or proxied to, replacing
+// the port of the host of the node, if any. Useful when the nodes are reached
+// by the clients on a port different from the one of their host.
+func (builder *HandlerOptionsBuilder) RedirectPort(redirectPort string) *HandlerOptionsBuilder {
+	builder.options.redirectPort = redirectPort
+	return builder
+}
+
+// Set the port of the URLs the requests are redirected or proxied to for each
+// host of the nodes, overriding RedirectPort. The map is copied.
+func (builder *HandlerOptionsBuilder) RedirectPorts(redirectPorts map[string]string) *HandlerOptionsBuilder {
+	builder.options.redirectPorts = make(map[string]string, len(redirectPorts))
+	for host, port := range redirectPorts {
+		builder.options.redirectPorts[host] = port
+	}
+	return builder
+}
+
 // Set the malformedSessionTokenErrorResponse function.
 func (builder *HandlerOptionsBuilder) MalformedSessionTokenErrorResponse(malformedSessionTokenErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
 	builder.options.malformedSessionTokenErrorResponse = malformedSessionTokenErrorResponse
 	return builder
 }
 
+// Set the invalidSessionTokenErrorResponse function, used when the session
+// token cannot be decrypted or its signature cannot be verified.
+func (builder *HandlerOptionsBuilder) InvalidSessionTokenErrorResponse(invalidSessionTokenErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
+	builder.options.invalidSessionTokenErrorResponse = invalidSessionTokenErrorResponse
+	return builder
+}
+
 // Set the internalServerErrorResponse function.
 func (builder *HandlerOptionsBuilder) InternalServerErrorResponse(internalServerErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
 	builder.options.internalServerErrorResponse = internalServerErrorResponse
 	return builder
 }
 
+// Set the sessionIsAcquiredErrorResponse function, used when the session is
+// acquired in a mode that conflicts with the mode of the request.
+func (builder *HandlerOptionsBuilder) SessionIsAcquiredErrorResponse(sessionIsAcquiredErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
+	builder.options.sessionIsAcquiredErrorResponse = sessionIsAcquiredErrorResponse
+	return builder
+}
+
+// Set the sessionIsOffloadingErrorResponse function, used when the session is
+// offloading and cannot be acquired, see also
+// api.AcquireSessionOptionsBuilder.WaitWhileOffloading.
+func (builder *HandlerOptionsBuilder) SessionIsOffloadingErrorResponse(sessionIsOffloadingErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
+	builder.options.sessionIsOffloadingErrorResponse = sessionIsOffloadingErrorResponse
+	return builder
+}
+
+// Set the proxyErrorResponse function, used when the request cannot be proxied
+// to the node of the session.
+func (builder *HandlerOptionsBuilder) ProxyErrorResponse(proxyErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
+	builder.options.proxyErrorResponse = proxyErrorResponse
+	return builder
+}
+
+// Set the forwardingLoopErrorResponse function, used when a request would be
+// forwarded again by a node that already forwarded it, or beyond the maximum
+// number of hops, see MaxForwardingHops.
+func (builder *HandlerOptionsBuilder) ForwardingLoopErrorResponse(forwardingLoopErrorResponse func(w http.ResponseWriter, err error)) *HandlerOptionsBuilder {
+	builder.options.forwardingLoopErrorResponse = forwardingLoopErrorResponse
+	return builder
+}
+
+// Set the maximum number of times a request is redirected or proxied between
+// the nodes, by default api.DefaultMaxForwardingHops, 0 if unbounded. The
+// nodes that forwarded a request are listed in the ForwardedByHeaderName
+// header of the proxied requests and in the ForwardedByQueryParameterName
+// query parameter of the redirect URL, so a request is never forwarded twice
+// by the same node.
+func (builder *HandlerOptionsBuilder) MaxForwardingHops(maxForwardingHops int) *HandlerOptionsBuilder {
+	builder.options.maxForwardingHops = maxForwardingHops
+	return builder
+}
+
+// Set the proxy mode. If enabled, the requests that must be served by another
+// node (a session that has been offloaded, a token issued for another host, or
+// a new request redirected by redirectNewRequest) are proxied to that node and
+// its response is relayed to the client, with the updated session token,
+// instead of redirecting the client. This works with any method and with
+// clients that do not follow redirects or cannot reach the other nodes.
+func (builder *HandlerOptionsBuilder) Proxy(proxy bool) *HandlerOptionsBuilder {
+	builder.options.proxy = proxy
+	return builder
+}
+
+// Set the transport used to proxy the requests. If nil, http.DefaultTransport.
+func (builder *HandlerOptionsBuilder) ProxyTransport(proxyTransport http.RoundTripper) *HandlerOptionsBuilder {
+	builder.options.proxyTransport = proxyTransport
+	return builder
+}
+
+// Set the setRequestSessionTokenBytes function, used to set the session token
+// in the requests proxied to other nodes.
+func (builder *HandlerOptionsBuilder) SetRequestSessionTokenBytes(setRequestSessionTokenBytes func(req *http.Request, sessionTokenBytes []byte)) *HandlerOptionsBuilder {
+	builder.options.setRequestSessionTokenBytes = setRequestSessionTokenBytes
+	return builder
+}
+
 // Set the getSessionTokenBytes and setSessionTokenBytes functions to use the
 // given header name to get and set the session token.
 func (builder *HandlerOptionsBuilder) SessionTokenHeaderName(header string) *HandlerOptionsBuilder {
-	builder.options.getSessionTokenBytes = func(req *http.Request) []byte {
-		return GetSessionTokenBytesFromHeader(req, header)
-	}
-	builder.options.setSessionTokenBytes = func(w http.ResponseWriter, sessionTokenBytes []byte) {
-		SetSessionTokenBytesToHeader(w, sessionTokenBytes, header)
-	}
+	return builder.SessionTokenTransport(NewHeaderTransport(header))
+}
+
+// Set the getSessionTokenBytes, setSessionTokenBytes and
+// setRequestSessionTokenBytes functions to use the given transport to get and set the session token.
+func (builder *HandlerOptionsBuilder) SessionTokenTransport(transport SessionTokenTransport) *HandlerOptionsBuilder {
+	builder.options.getSessionTokenBytes = transport.GetSessionTokenBytes
+	builder.options.setSessionTokenBytes = transport.SetSessionTokenBytes
+	builder.options.setRequestSessionTokenBytes = transport.SetRequestSessionTokenBytes
+	return builder
+}
+
+// Set the getSessionTokenBytes and setSessionTokenBytes functions to try the
+// given transports in order, see ChainTransport.
+func (builder *HandlerOptionsBuilder) SessionTokenTransports(transports ...SessionTokenTransport) *HandlerOptionsBuilder {
+	return builder.SessionTokenTransport(NewChainTransport(transports...))
+}
+
+// Set the signer of the session tokens issued by the handler. If nil, the
+// tokens are not signed.
+func (builder *HandlerOptionsBuilder) TokenSigner(tokenSigner api.TokenSigner) *HandlerOptionsBuilder {
+	builder.options.tokenSigner = tokenSigner
+	return builder
+}
+
+// Set the verifier of the session tokens received by the handler. If not nil,
+// unsigned tokens and tokens whose signature cannot be verified are rejected.
+func (builder *HandlerOptionsBuilder) TokenVerifier(tokenVerifier api.TokenVerifier) *HandlerOptionsBuilder {
+	builder.options.tokenVerifier = tokenVerifier
+	return builder
+}
+
+// Set the cipher of the session tokens. If not nil, the tokens issued by the
+// handler are encrypted, so that clients only see an opaque string, and the
+// tokens received are decrypted. Tokens that cannot be decrypted are rejected.
+func (builder *HandlerOptionsBuilder) TokenCipher(tokenCipher api.TokenCipher) *HandlerOptionsBuilder {
+	builder.options.tokenCipher = tokenCipher
+	return builder
+}
+
+// Set the registry of the codecs used to encode and decode the session tokens.
+// By default, api.DefaultSessionTokenCodecs.
+func (builder *HandlerOptionsBuilder) SessionTokenCodecs(sessionTokenCodecs *api.SessionTokenCodecRegistry) *HandlerOptionsBuilder {
+	builder.options.sessionTokenCodecs = sessionTokenCodecs
+	return builder
+}
+
+// Set both the signer and the verifier of the session tokens to the given
+// keyring.
+func (builder *HandlerOptionsBuilder) HMACKeyring(keyring *api.HMACKeyring) *HandlerOptionsBuilder {
+	builder.options.tokenSigner = keyring
+	builder.options.tokenVerifier = keyring
 	return builder
 }
 
@@ -146,34 +337,145 @@ func DefaultHandlerOptions() HandlerOptions {
 
 			return parent.Host
 		},
-		setSessionTokenBytes: func(w http.ResponseWriter, sessionTokenBytes []byte) {
+		setSessionTokenBytes: func(w http.ResponseWriter, _ *http.Request, sessionTokenBytes []byte) {
 			SetSessionTokenBytesToHeader(w, sessionTokenBytes, DefaultTokenHeaderName)
 		},
-		redirectResponse: func(w http.ResponseWriter, req *http.Request, host string) {
-			// Redirect the request to the given host.
-			http.Redirect(w, req, host, http.StatusFound)
+		setRequestSessionTokenBytes: func(req *http.Request, sessionTokenBytes []byte) {
+			req.Header.Set(DefaultTokenHeaderName, string(sessionTokenBytes))
 		},
+		// By default, redirect the request to the same URL on the given host,
+		// keeping its method and body.
+		redirectResponse:   nil,
+		redirectStatusCode: http.StatusTemporaryRedirect,
 		malformedSessionTokenErrorResponse: func(w http.ResponseWriter, err error) {
 			// Return a bad request response with the error message.
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		},
+		proxyErrorResponse: func(w http.ResponseWriter, err error) {
+			// Return a bad gateway response with the error message.
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+		forwardingLoopErrorResponse: func(w http.ResponseWriter, err error) {
+			// Return a loop detected response with the error message.
+			http.Error(w, err.Error(), http.StatusLoopDetected)
+		},
+		maxForwardingHops:  api.DefaultMaxForwardingHops,
+		sessionTokenCodecs: api.DefaultSessionTokenCodecs,
+		invalidSessionTokenErrorResponse: func(w http.ResponseWriter, err error) {
+			// Return an unauthorized response with the error message.
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		},
 		internalServerErrorResponse: func(w http.ResponseWriter, err error) {
 			// Return an internal server error response with the error message.
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		},
+		sessionIsAcquiredErrorResponse: func(w http.ResponseWriter, err error) {
+			// Return a conflict response with the error message.
+			http.Error(w, err.Error(), http.StatusConflict)
+		},
+		sessionIsOffloadingErrorResponse: func(w http.ResponseWriter, err error) {
+			// The offload is expected to complete shortly, ask to retry.
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		},
 	}
 }
 
+// MethodAcquisitionMode returns the read mode for the requests with a safe
+// method (GET, HEAD, OPTIONS and TRACE), that do not modify the session, and the
+// write mode for the others.
+func MethodAcquisitionMode(req *http.Request) api.AcquisitionMode {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return api.AcquisitionModeRead
+	default:
+		return api.AcquisitionModeWrite
+	}
+}
+
+// Returns the options to acquire the session of the request, in the mode
+// returned by the acquisition mode function, if any.
+func (opt HandlerOptions) acquireSessionOptions(req *http.Request) api.AcquireSessionOptions {
+	acquireSessionOptions := opt.getAcquireSessionOptions(req)
+	if opt.getAcquisitionMode != nil {
+		acquireSessionOptions = acquireSessionOptions.WithMode(opt.getAcquisitionMode(req))
+	}
+
+	return acquireSessionOptions
+}
+
 // DefaultTokenHeaderName is the default name of the header that contains the
 // session token.
 const DefaultTokenHeaderName = "X-Ermes-Token"
 
+// ForwardedByHeaderName is the name of the header that lists the hosts of the
+// nodes that redirected or proxied a request, in order.
+const ForwardedByHeaderName = "X-Ermes-Forwarded-By"
+
+// ForwardedByQueryParameterName is the name of the query parameter of the
+// redirect URL that lists the hosts of the nodes that redirected a request, in
+// order.
+const ForwardedByQueryParameterName = "ermes_forwarded_by"
+
 // GetSessionTokenBytesFromHeader returns the session token bytes from the
 // header of the request.
 func GetSessionTokenBytesFromHeader(req *http.Request, headerName string) []byte {
 	return []byte(req.Header.Get(headerName))
 }
 
+// RedirectURL returns the absolute URL of the request on the given host, with
+// the path and the query of the request and the scheme returned by
+// RequestScheme.
+func RedirectURL(req *http.Request, host string) string {
+	target := *req.URL
+	target.Host = host
+	target.Scheme = RequestScheme(req)
+
+	return target.String()
+}
+
+// RequestScheme returns the scheme the client used to make the request: the
+// first value of the X-Forwarded-Proto header set by a proxy in front of the
+// node, if any, otherwise https if the request was received over TLS, http if
+// not.
+func RequestScheme(req *http.Request) string {
+	if proto, _, _ := strings.Cut(req.Header.Get("X-Forwarded-Proto"), ","); proto != "" {
+		return strings.ToLower(strings.TrimSpace(proto))
+	}
+
+	if req.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Returns the absolute URL of the request on the given host, with the scheme
+// and the port of the options.
+func (opt HandlerOptions) targetURL(req *http.Request, host string) *url.URL {
+	target := *req.URL
+	target.Scheme = opt.redirectScheme
+	if target.Scheme == "" {
+		target.Scheme = RequestScheme(req)
+	}
+
+	target.Host = host
+	port, ok := opt.redirectPorts[host]
+	if !ok {
+		port = opt.redirectPort
+	}
+
+	if port != "" {
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		target.Host = net.JoinHostPort(strings.Trim(hostname, "[]"), port)
+	}
+
+	return &target
+}
+
 // SetSessionTokenBytesToHeader sets the session token bytes to the header of
 // the response.
 func SetSessionTokenBytesToHeader(w http.ResponseWriter, sessionTokenBytes []byte, headerName string) {
@@ -1 +1,534 @@
 package http_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ermes-labs/api-go/api"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	ermes_http "github.com/ermes-labs/api-go/http"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
+
+func newNode(host string) *api.Node {
+	node := infrastructure.Node{AreaName: host, Host: host}
+	return api.NewNode(node, memory_commands.NewCommands(node))
+}
+
+func TestHandleRejectsForgedToken(t *testing.T) {
+	n := newNode("n1")
+	keyring, err := api.NewHMACKeyring("k1", map[string][]byte{"k1": []byte("secret")})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	opt := ermes_http.NewHandlerOptionsBuilder().HMACKeyring(keyring).Build()
+	handler := ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		return nil
+	})
+
+	// A new session gets a signed token, accepted by the next request.
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	tokenBytes := res.Header().Get(ermes_http.DefaultTokenHeaderName)
+	if res.Code != http.StatusOK || tokenBytes == "" {
+		t.Fatalf("Expected status %d and a token, got %d and %q", http.StatusOK, res.Code, tokenBytes)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, tokenBytes)
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	// An unsigned token is rejected.
+	forged, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n2", "s1")), nil)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, string(forged))
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, res.Code)
+	}
+}
+
+func TestHandleEncryptedToken(t *testing.T) {
+	n := newNode("n1")
+	keyring, err := api.NewAEADKeyring("v1", map[string][]byte{"v1": make([]byte, 32)})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	opt := ermes_http.NewHandlerOptionsBuilder().TokenCipher(keyring).Build()
+	var sessionIds []string
+	handler := ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		sessionIds = append(sessionIds, sessionToken.SessionId)
+		return nil
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	tokenBytes := res.Header().Get(ermes_http.DefaultTokenHeaderName)
+	if res.Code != http.StatusOK || strings.Contains(tokenBytes, "n1") || strings.Contains(tokenBytes, sessionIds[0]) {
+		t.Fatalf("Expected status %d and an opaque token, got %d and %q", http.StatusOK, res.Code, tokenBytes)
+	}
+
+	// The token is decrypted transparently.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, tokenBytes)
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusOK || len(sessionIds) != 2 || sessionIds[1] != sessionIds[0] {
+		t.Errorf("Expected status %d and session %s, got %d and %v", http.StatusOK, sessionIds[0], res.Code, sessionIds)
+	}
+
+	// A plain token is rejected.
+	plain, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n1", sessionIds[0])), nil)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, string(plain))
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, res.Code)
+	}
+}
+
+func TestHandleFencingToken(t *testing.T) {
+	n := newNode("n1")
+	var fencingTokens []uint64
+	var sessionId string
+	handler := ermes_http.CreateHandler(n, ermes_http.DefaultHandlerOptions(), func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		fencingToken, ok := ermes_http.FencingToken(req)
+		if !ok {
+			t.Errorf("Expected a fencing token")
+		}
+
+		fencingTokens = append(fencingTokens, fencingToken)
+		sessionId = sessionToken.SessionId
+		return n.SetSessionMetadata(req.Context(), sessionId, api.NewSessionMetadataOptionsBuilder().FencingToken(fencingToken).Build())
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, res.Header().Get(ermes_http.DefaultTokenHeaderName))
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	if len(fencingTokens) != 2 || fencingTokens[1] <= fencingTokens[0] {
+		t.Fatalf("Expected increasing fencing tokens, got %v", fencingTokens)
+	}
+
+	// Once the request is handled, the fencing token is stale.
+	err := n.SetSessionMetadata(context.Background(), sessionId, api.NewSessionMetadataOptionsBuilder().FencingToken(fencingTokens[1]).Build())
+	if !errors.Is(err, api.ErrStaleFencingToken) {
+		t.Errorf("Expected error %v, got %v", api.ErrStaleFencingToken, err)
+	}
+
+	if _, ok := ermes_http.FencingToken(req); ok {
+		t.Errorf("Expected no fencing token outside the handler")
+	}
+}
+
+func TestHandleAcquisitionModeByMethod(t *testing.T) {
+	n := newNode("n1")
+	opt := ermes_http.NewHandlerOptionsBuilder().AcquisitionModeByMethod().Build()
+	var tokenBytes string
+	var nested func(method string) int
+	handler := ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		if nested != nil && req.Header.Get("X-Nested") == "" {
+			// Concurrent requests while the session is acquired by this one.
+			if code := nested(http.MethodGet); code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if code := nested(http.MethodPost); code != http.StatusConflict {
+				t.Errorf("Expected status %d, got %d", http.StatusConflict, code)
+			}
+		}
+
+		return nil
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodPost, "/", nil))
+	tokenBytes = res.Header().Get(ermes_http.DefaultTokenHeaderName)
+
+	newRequest := func(method string) *http.Request {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set(ermes_http.DefaultTokenHeaderName, tokenBytes)
+		return req
+	}
+	nested = func(method string) int {
+		req := newRequest(method)
+		req.Header.Set("X-Nested", "1")
+		res := httptest.NewRecorder()
+		handler(res, req)
+		return res.Code
+	}
+
+	// The readers share the session, the writers do not.
+	res = httptest.NewRecorder()
+	handler(res, newRequest(http.MethodGet))
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, res.Code)
+	}
+}
+
+func TestHandleWaitsWhileOffloading(t *testing.T) {
+	ctx := context.Background()
+	n := newNode("n1")
+	handler := ermes_http.CreateHandler(n, ermes_http.DefaultHandlerOptions(), func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		return nil
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	tokenBytes := res.Header().Get(ermes_http.DefaultTokenHeaderName)
+	sessionToken, err := api.UnmarshallSessionToken([]byte(tokenBytes), nil)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	if _, _, err := n.Cmd.OffloadSession(ctx, sessionToken.SessionId, api.DefaultOffloadSessionOptions()); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// By default, the client is asked to retry.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, tokenBytes)
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusServiceUnavailable || res.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected status %d with Retry-After, got %d", http.StatusServiceUnavailable, res.Code)
+	}
+
+	// Waiting, the client is redirected once the offload completes.
+	opt := ermes_http.NewHandlerOptionsBuilder().
+		AcquireSessionOptions(api.NewAcquireSessionOptionsBuilder().WaitWhileOffloading(10 * time.Millisecond).Build()).
+		Build()
+	handler = ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		return nil
+	})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		n.Cmd.ConfirmSessionOffload(ctx, sessionToken.SessionId, api.NewSessionLocation("n2", sessionToken.SessionId), api.DefaultOffloadSessionOptions(), nil)
+	}()
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, tokenBytes)
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusTemporaryRedirect || res.Header().Get("Location") != "http://n2/?ermes_forwarded_by=n1" {
+		t.Errorf("Expected a redirect to http://n2/?ermes_forwarded_by=n1, got %d and %q", res.Code, res.Header().Get("Location"))
+	}
+}
+
+func TestHandleCookieTransport(t *testing.T) {
+	n := newNode("a.edge.ermes")
+	transport := ermes_http.NewCookieTransport(ermes_http.DefaultTokenCookieName, "edge.ermes")
+	opt := ermes_http.NewHandlerOptionsBuilder().SessionTokenTransport(transport).Build()
+	handler := ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		return nil
+	})
+
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != ermes_http.DefaultTokenCookieName || cookies[0].Domain != "edge.ermes" || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("Expected a shared, secure and http-only cookie, got %v", cookies)
+	}
+
+	// A token for another node is redirected, the cookie is kept.
+	tokenBytes, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("b.edge.ermes", "s1")), nil)
+	req := httptest.NewRequest(http.MethodGet, "/path", nil)
+	req.AddCookie(&http.Cookie{Name: ermes_http.DefaultTokenCookieName, Value: string(tokenBytes)})
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusTemporaryRedirect || res.Header().Get("Location") != "http://b.edge.ermes/path?ermes_forwarded_by=a.edge.ermes" {
+		t.Errorf("Expected a redirect to http://b.edge.ermes/path?ermes_forwarded_by=a.edge.ermes, got %d and %q", res.Code, res.Header().Get("Location"))
+	}
+}
+
+func TestHandleQueryTransport(t *testing.T) {
+	n := newNode("n1")
+	opt := ermes_http.NewHandlerOptionsBuilder().
+		SessionTokenTransports(
+			ermes_http.NewHeaderTransport(ermes_http.DefaultTokenHeaderName),
+			ermes_http.NewQueryTransport(ermes_http.DefaultTokenQueryParameterName),
+		).
+		Build()
+	var links []string
+	handler := ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		links = append(links, req.URL.Query().Get(ermes_http.DefaultTokenQueryParameterName))
+		return nil
+	})
+
+	// The handler can build links that carry the new token.
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(links) != 1 || links[0] == "" || links[0] != res.Header().Get(ermes_http.DefaultTokenHeaderName) {
+		t.Fatalf("Expected the token in the query and in the header, got %v", links)
+	}
+
+	// The token of a plain link is read from the query.
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/?"+ermes_http.DefaultTokenQueryParameterName+"="+links[0], nil))
+	if res.Code != http.StatusOK || len(links) != 2 || links[1] != links[0] {
+		t.Errorf("Expected status %d and the same token, got %d and %v", http.StatusOK, res.Code, links)
+	}
+
+	// A token for another node is redirected with the token in the query.
+	tokenBytes, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n2", "s1")), nil)
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/path?"+ermes_http.DefaultTokenQueryParameterName+"="+string(tokenBytes), nil))
+	location := "http://n2/path?" + ermes_http.DefaultTokenQueryParameterName + "=" + string(tokenBytes) + "&ermes_forwarded_by=n1"
+	if res.Code != http.StatusTemporaryRedirect || res.Header().Get("Location") != location {
+		t.Errorf("Expected a redirect to %s, got %d and %q", location, res.Code, res.Header().Get("Location"))
+	}
+
+	// Only the token parameter of the query changes, on a copy of the URL.
+	req := httptest.NewRequest(http.MethodGet, "/path?b=%7e&a=1+2&"+ermes_http.DefaultTokenQueryParameterName+"=old&c", nil)
+	originalURL := req.URL
+	ermes_http.NewQueryTransport(ermes_http.DefaultTokenQueryParameterName).SetRequestSessionTokenBytes(req, []byte("new token"))
+	if expected := "b=%7e&a=1+2&" + ermes_http.DefaultTokenQueryParameterName + "=new+token&c"; req.URL.RawQuery != expected {
+		t.Errorf("Expected the query %s, got %s", expected, req.URL.RawQuery)
+	}
+
+	if originalURL.RawQuery != "b=%7e&a=1+2&"+ermes_http.DefaultTokenQueryParameterName+"=old&c" {
+		t.Errorf("Expected the original URL to be untouched, got %s", originalURL.RawQuery)
+	}
+
+	// With the query transport alone, the new token reaches the client in the
+	// response.
+	opt = ermes_http.NewHandlerOptionsBuilder().
+		SessionTokenTransport(ermes_http.NewQueryTransport(ermes_http.DefaultTokenQueryParameterName)).
+		Build()
+	handler = ermes_http.CreateHandler(n, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		links = append(links, req.URL.Query().Get(ermes_http.DefaultTokenQueryParameterName))
+		return nil
+	})
+	res = httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	if tokenBytes := res.Header().Get(ermes_http.DefaultTokenHeaderName); tokenBytes == "" || tokenBytes != links[len(links)-1] {
+		t.Errorf("Expected the token in the response, got %q", tokenBytes)
+	}
+}
+
+func TestHandleProxy(t *testing.T) {
+	ctx := context.Background()
+	n1 := newNode("n1")
+
+	// The second node is reachable through a test server, its host is the
+	// address of the server.
+	server := httptest.NewUnstartedServer(nil)
+	n2 := newNode(server.Listener.Addr().String())
+	server.Config.Handler = http.HandlerFunc(ermes_http.CreateHandler(n2, ermes_http.DefaultHandlerOptions(), func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Session-Id", sessionToken.SessionId)
+		// The handler reissues the token of the session.
+		tokenBytes, err := api.MarshallSessionToken(sessionToken, nil)
+		if err != nil {
+			return err
+		}
+		w.Header().Set(ermes_http.DefaultTokenHeaderName, string(tokenBytes))
+		w.WriteHeader(http.StatusCreated)
+		_, err = w.Write(body)
+		return err
+	}))
+	server.Start()
+	defer server.Close()
+
+	opt := ermes_http.NewHandlerOptionsBuilder().Proxy(true).Build()
+	handler := ermes_http.CreateHandler(n1, opt, func(w http.ResponseWriter, req *http.Request, sessionToken api.SessionToken) error {
+		t.Errorf("Unexpected acquisition of a session on n1")
+		return nil
+	})
+
+	// A session created on n1 and offloaded to n2.
+	sessionToken, err := n1.CreateSession(ctx, api.DefaultCreateSessionOptions())
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	_, err = n1.OffloadSession(
+		ctx,
+		sessionToken.SessionId,
+		api.DefaultOffloadSessionOptions(),
+		api.LocalOffloadTarget{Node: n2},
+		func(ctx context.Context, lastVisitedLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
+			return false, nil
+		})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	// The request with the old token is proxied to n2 with its body, the
+	// response is relayed with the token of the new location.
+	tokenBytes, _ := api.MarshallSessionToken(sessionToken, nil)
+	req := httptest.NewRequest(http.MethodPost, "/path", strings.NewReader("body"))
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, string(tokenBytes))
+	res := httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusCreated || res.Body.String() != "body" || res.Header().Get("X-Session-Id") != sessionToken.SessionId {
+		t.Fatalf("Expected status %d and the echoed body, got %d and %q", http.StatusCreated, res.Code, res.Body.String())
+	}
+
+	newToken, err := api.UnmarshallSessionToken([]byte(res.Header().Get(ermes_http.DefaultTokenHeaderName)), nil)
+	if err != nil || newToken == nil || newToken.Host != n2.Host {
+		t.Errorf("Expected a token for %s, got %v, %v", n2.Host, newToken, err)
+	}
+
+	// The token reissued by n2 replaces the one set by n1.
+	if values := res.Header().Values(ermes_http.DefaultTokenHeaderName); len(values) != 1 {
+		t.Errorf("Expected a single token, got %q", values)
+	}
+
+	// A token for an unreachable node is a bad gateway.
+	tokenBytes, _ = api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("127.0.0.1:1", "s1")), nil)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(ermes_http.DefaultTokenHeaderName, string(tokenBytes))
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, res.Code)
+	}
+}
+
+func TestHandleRedirect(t *testing.T) {
+	n := newNode("n1")
+	tokenBytes, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n2:8080", "s1")), nil)
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/path?q=1", strings.NewReader("body"))
+		req.Header.Set(ermes_http.DefaultTokenHeaderName, string(tokenBytes))
+		return req
+	}
+
+	// By default, the redirect keeps the method, the path and the query, and
+	// uses the scheme of the client.
+	handler := ermes_http.CreateHandler(n, ermes_http.DefaultHandlerOptions(), nil)
+	req := newRequest()
+	req.Header.Set("X-Forwarded-Proto", "https")
+	res := httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusTemporaryRedirect || res.Header().Get("Location") != "https://n2:8080/path?q=1&ermes_forwarded_by=n1" {
+		t.Errorf("Expected a redirect to https://n2:8080/path?q=1&ermes_forwarded_by=n1, got %d and %q", res.Code, res.Header().Get("Location"))
+	}
+
+	// The status code, the scheme and the ports can be configured.
+	opt := ermes_http.NewHandlerOptionsBuilder().
+		RedirectStatusCode(http.StatusPermanentRedirect).
+		RedirectScheme("https").
+		RedirectPort("443").
+		RedirectPorts(map[string]string{"n3": "8443"}).
+		Build()
+	handler = ermes_http.CreateHandler(n, opt, nil)
+	res = httptest.NewRecorder()
+	handler(res, newRequest())
+	if res.Code != http.StatusPermanentRedirect || res.Header().Get("Location") != "https://n2:443/path?q=1&ermes_forwarded_by=n1" {
+		t.Errorf("Expected a redirect to https://n2:443/path?q=1&ermes_forwarded_by=n1, got %d and %q", res.Code, res.Header().Get("Location"))
+	}
+
+	tokenBytes, _ = api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n3", "s1")), nil)
+	res = httptest.NewRecorder()
+	handler(res, newRequest())
+	if res.Code != http.StatusPermanentRedirect || res.Header().Get("Location") != "https://n3:8443/path?q=1&ermes_forwarded_by=n1" {
+		t.Errorf("Expected a redirect to https://n3:8443/path?q=1&ermes_forwarded_by=n1, got %d and %q", res.Code, res.Header().Get("Location"))
+	}
+}
+
+func TestHandleForwardingHops(t *testing.T) {
+	n := newNode("n1")
+	tokenBytes, _ := api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation("n2", "s1")), nil)
+	newRequest := func(forwardedBy string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(ermes_http.DefaultTokenHeaderName, string(tokenBytes))
+		if forwardedBy != "" {
+			req.Header.Set(ermes_http.ForwardedByHeaderName, forwardedBy)
+		}
+		return req
+	}
+
+	// The redirect lists the node for the next hop.
+	handler := ermes_http.CreateHandler(n, ermes_http.DefaultHandlerOptions(), nil)
+	res := httptest.NewRecorder()
+	handler(res, newRequest("n0"))
+	if res.Code != http.StatusTemporaryRedirect || res.Header().Get(ermes_http.ForwardedByHeaderName) != "n0, n1" {
+		t.Errorf("Expected a redirect forwarded by n0, n1, got %d and %q", res.Code, res.Header().Get(ermes_http.ForwardedByHeaderName))
+	}
+
+	// The redirect URL lists the hosts, replacing those of the request.
+	req := newRequest("")
+	req.URL.RawQuery = ermes_http.ForwardedByQueryParameterName + "=n0"
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if location := res.Header().Get("Location"); location != "http://n2/?ermes_forwarded_by=n0%2C+n1" {
+		t.Errorf("Expected a redirect to http://n2/?ermes_forwarded_by=n0%%2C+n1, got %q", location)
+	}
+
+	// A request already forwarded by the node is a loop, even if the client
+	// did not copy the header of the redirect.
+	res = httptest.NewRecorder()
+	handler(res, newRequest("n2, n1"))
+	if res.Code != http.StatusLoopDetected {
+		t.Errorf("Expected status %d, got %d", http.StatusLoopDetected, res.Code)
+	}
+
+	req = newRequest("")
+	req.URL.RawQuery = ermes_http.ForwardedByQueryParameterName + "=n2%2C+n1"
+	res = httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusLoopDetected {
+		t.Errorf("Expected status %d, got %d", http.StatusLoopDetected, res.Code)
+	}
+
+	// A request forwarded the maximum number of times is rejected, unless the
+	// hops are unbounded.
+	hosts := make([]string, api.DefaultMaxForwardingHops)
+	for i := range hosts {
+		hosts[i] = "h" + strconv.Itoa(i)
+	}
+
+	res = httptest.NewRecorder()
+	handler(res, newRequest(strings.Join(hosts, ", ")))
+	if res.Code != http.StatusLoopDetected {
+		t.Errorf("Expected status %d, got %d", http.StatusLoopDetected, res.Code)
+	}
+
+	handler = ermes_http.CreateHandler(n, ermes_http.NewHandlerOptionsBuilder().MaxForwardingHops(0).Build(), nil)
+	res = httptest.NewRecorder()
+	handler(res, newRequest(strings.Join(hosts, ", ")))
+	if res.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected status %d, got %d", http.StatusTemporaryRedirect, res.Code)
+	}
+
+	// The proxied request lists the node.
+	var forwardedBy string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwardedBy = req.Header.Get(ermes_http.ForwardedByHeaderName)
+	}))
+	defer server.Close()
+
+	tokenBytes, _ = api.MarshallSessionToken(api.NewSessionToken(api.NewSessionLocation(server.Listener.Addr().String(), "s1")), nil)
+	handler = ermes_http.CreateHandler(n, ermes_http.NewHandlerOptionsBuilder().Proxy(true).Build(), nil)
+	res = httptest.NewRecorder()
+	handler(res, newRequest(""))
+	if res.Code != http.StatusOK || forwardedBy != "n1" {
+		t.Errorf("Expected status %d and a request forwarded by n1, got %d and %q", http.StatusOK, res.Code, forwardedBy)
+	}
+}
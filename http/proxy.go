@@ -0,0 +1,148 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ermes-labs/api-go/api"
+)
+
+// Forward the request from the node to the given host, either redirecting the
+// client or, in proxy mode, proxying the request. If sessionTokenBytes is not
+// nil, it is the token of the session on the host, set in the response and,
+// when proxying, in the forwarded request.
+func forward(w http.ResponseWriter, req *http.Request, opt HandlerOptions, from string, host string, sessionTokenBytes []byte) {
+	// Stop the requests that loop between the nodes or pass through too many.
+	forwardedBy, err := opt.forwardedBy(req, from)
+	if err != nil {
+		opt.forwardingLoopErrorResponse(w, err)
+		return
+	}
+
+	if sessionTokenBytes != nil {
+		// Set the session token in the response.
+		opt.setSessionTokenBytes(w, req, sessionTokenBytes)
+	}
+
+	if opt.proxy {
+		proxyResponse(w, req, opt, host, sessionTokenBytes, forwardedBy)
+		return
+	}
+
+	// The redirect URL carries the hosts to the redirected request, since
+	// most clients do not copy the headers of a redirect response. The header
+	// is set as well for the clients that do.
+	w.Header().Set(ForwardedByHeaderName, forwardedBy)
+	req = withForwardedBy(req, forwardedBy)
+
+	if opt.redirectResponse != nil {
+		// Create the custom redirect response.
+		opt.redirectResponse(w, req, host)
+		return
+	}
+
+	// Redirect the request to the same URL on the given host.
+	http.Redirect(w, req, opt.targetURL(req, host).String(), opt.redirectStatusCode)
+}
+
+// Returns a shallow copy of the request whose URL lists the given hosts in the
+// ForwardedByQueryParameterName query parameter, after the query of the
+// request.
+func withForwardedBy(req *http.Request, forwardedBy string) *http.Request {
+	redirected := *req
+	target := *req.URL
+	removeForwardedBy(&target)
+	if target.RawQuery != "" {
+		target.RawQuery += "&"
+	}
+	target.RawQuery += url.Values{ForwardedByQueryParameterName: {forwardedBy}}.Encode()
+	redirected.URL = &target
+
+	return &redirected
+}
+
+// Remove the ForwardedByQueryParameterName query parameter from the URL, the
+// rest of the query is left untouched if the parameter is missing.
+func removeForwardedBy(target *url.URL) {
+	if query := target.Query(); query.Has(ForwardedByQueryParameterName) {
+		query.Del(ForwardedByQueryParameterName)
+		target.RawQuery = query.Encode()
+	}
+}
+
+// Proxy the request to the same URL on the given host and relay the response.
+// The body of the request and of the response are streamed, and the response
+// is flushed as soon as the host writes it, so that streaming responses (e.g.
+// server-sent events) are relayed as they are produced.
+func proxyResponse(w http.ResponseWriter, req *http.Request, opt HandlerOptions, host string, sessionTokenBytes []byte, forwardedBy string) {
+	target := opt.targetURL(req, host)
+	// The proxied request carries the hosts in the header only.
+	removeForwardedBy(target)
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(proxyReq *httputil.ProxyRequest) {
+			// Keep the path and the query of the (possibly rewritten) request.
+			proxyReq.Out.URL = target
+			proxyReq.Out.Host = target.Host
+			proxyReq.SetXForwarded()
+			proxyReq.Out.Header.Set(ForwardedByHeaderName, forwardedBy)
+			// Replace the session token with the one of the session on the host.
+			if sessionTokenBytes != nil {
+				opt.setRequestSessionTokenBytes(proxyReq.Out, sessionTokenBytes)
+			}
+		},
+		// The response relays the headers of the host, that replace those
+		// set locally (e.g. the session token) instead of adding a second
+		// value. The cookies are added, the later ones take precedence.
+		ModifyResponse: func(res *http.Response) error {
+			for name := range res.Header {
+				if name != "Set-Cookie" {
+					w.Header().Del(name)
+				}
+			}
+			return nil
+		},
+		Transport:     opt.proxyTransport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			opt.proxyErrorResponse(w, err)
+		},
+	}
+
+	proxy.ServeHTTP(w, req)
+}
+
+// Returns the hosts of the nodes that forwarded the request, followed by the
+// given host. The hosts are read from the ForwardedByQueryParameterName query
+// parameter of a redirected request, or else from the ForwardedByHeaderName
+// header.
+// errors:
+// - ErrSessionForwardingLoop: If the host already forwarded the request.
+// - ErrTooManyForwardingHops: If the request has been forwarded the maximum
+// number of times.
+func (opt HandlerOptions) forwardedBy(req *http.Request, host string) (string, error) {
+	forwarders := req.URL.Query().Get(ForwardedByQueryParameterName)
+	if forwarders == "" {
+		forwarders = req.Header.Get(ForwardedByHeaderName)
+	}
+
+	var hosts []string
+	for _, forwarder := range strings.Split(forwarders, ",") {
+		if forwarder = strings.TrimSpace(forwarder); forwarder != "" {
+			hosts = append(hosts, forwarder)
+		}
+	}
+
+	if slices.Contains(hosts, host) {
+		return "", api.ErrSessionForwardingLoop
+	}
+
+	if opt.maxForwardingHops > 0 && len(hosts) >= opt.maxForwardingHops {
+		return "", api.ErrTooManyForwardingHops
+	}
+
+	return strings.Join(append(hosts, host), ", "), nil
+}
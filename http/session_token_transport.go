@@ -0,0 +1,251 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultTokenCookieName is the default name of the cookie that contains the
+// session token.
+const DefaultTokenCookieName = "ermes_token"
+
+// DefaultTokenQueryParameterName is the default name of the query parameter
+// that contains the session token.
+const DefaultTokenQueryParameterName = "ermes_token"
+
+// SessionTokenTransport carries the session token between the clients and the
+// nodes.
+type SessionTokenTransport interface {
+	// Get the session token bytes from the request, empty if there is none.
+	GetSessionTokenBytes(req *http.Request) []byte
+	// Set the session token bytes in the response to the request.
+	SetSessionTokenBytes(w http.ResponseWriter, req *http.Request, sessionTokenBytes []byte)
+	// Set the session token bytes in a request forwarded to another node.
+	SetRequestSessionTokenBytes(req *http.Request, sessionTokenBytes []byte)
+}
+
+// HeaderTransport carries the session token in a header of the requests and of
+// the responses.
+type HeaderTransport struct {
+	// The name of the header.
+	Name string
+}
+
+// Assert that HeaderTransport implements SessionTokenTransport.
+var _ SessionTokenTransport = HeaderTransport{}
+
+// NewHeaderTransport creates a new HeaderTransport that uses the given header.
+func NewHeaderTransport(name string) HeaderTransport {
+	return HeaderTransport{Name: name}
+}
+
+// Get the session token bytes from the header of the request.
+func (t HeaderTransport) GetSessionTokenBytes(req *http.Request) []byte {
+	return GetSessionTokenBytesFromHeader(req, t.Name)
+}
+
+// Set the session token bytes to the header of the response.
+func (t HeaderTransport) SetSessionTokenBytes(w http.ResponseWriter, _ *http.Request, sessionTokenBytes []byte) {
+	SetSessionTokenBytesToHeader(w, sessionTokenBytes, t.Name)
+}
+
+// Set the session token bytes to the header of the forwarded request.
+func (t HeaderTransport) SetRequestSessionTokenBytes(req *http.Request, sessionTokenBytes []byte) {
+	req.Header.Set(t.Name, string(sessionTokenBytes))
+}
+
+// CookieTransport carries the session token in a cookie, so that browsers send
+// it with navigations. To keep the session when a node redirects the client to
+// another node, set Domain to a parent domain shared by the hosts of the nodes
+// (e.g. "edge.example.com" for "a.edge.example.com" and "b.edge.example.com").
+type CookieTransport struct {
+	// The name of the cookie.
+	Name string
+	// The domain of the cookie, empty for a host-only cookie.
+	Domain string
+	// The path of the cookie, "/" if empty.
+	Path string
+	// The max age of the cookie in seconds, 0 for a session cookie.
+	MaxAge int
+	// The SameSite attribute of the cookie.
+	SameSite http.SameSite
+	// The Secure attribute of the cookie.
+	Secure bool
+	// The HttpOnly attribute of the cookie.
+	HttpOnly bool
+}
+
+// Assert that CookieTransport implements SessionTokenTransport.
+var _ SessionTokenTransport = CookieTransport{}
+
+// NewCookieTransport creates a new CookieTransport that uses the given cookie
+// and parent domain. The cookie is HttpOnly, Secure and SameSite=Lax, so that
+// it is sent with top-level navigations but is not readable by scripts.
+func NewCookieTransport(name string, domain string) CookieTransport {
+	return CookieTransport{
+		Name:     name,
+		Domain:   domain,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+		HttpOnly: true,
+	}
+}
+
+// Get the session token bytes from the cookie of the request.
+func (t CookieTransport) GetSessionTokenBytes(req *http.Request) []byte {
+	cookie, err := req.Cookie(t.Name)
+	if err != nil {
+		return nil
+	}
+
+	return []byte(cookie.Value)
+}
+
+// Set the cookie with the session token bytes in the response.
+func (t CookieTransport) SetSessionTokenBytes(w http.ResponseWriter, _ *http.Request, sessionTokenBytes []byte) {
+	path := t.Path
+	if path == "" {
+		path = "/"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     t.Name,
+		Value:    string(sessionTokenBytes),
+		Domain:   t.Domain,
+		Path:     path,
+		MaxAge:   t.MaxAge,
+		SameSite: t.SameSite,
+		Secure:   t.Secure,
+		HttpOnly: t.HttpOnly,
+	})
+}
+
+// Replace the cookie with the session token bytes in the forwarded request.
+func (t CookieTransport) SetRequestSessionTokenBytes(req *http.Request, sessionTokenBytes []byte) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+
+	for _, cookie := range cookies {
+		if cookie.Name != t.Name {
+			req.AddCookie(cookie)
+		}
+	}
+
+	req.AddCookie(&http.Cookie{Name: t.Name, Value: string(sessionTokenBytes)})
+}
+
+// QueryTransport carries the session token in a query parameter, so that it
+// survives plain links. The token issued to the client is set in a header of
+// the response, and in the query of the request URL, so that the redirects
+// built from it carry the token, and the handler can read it to build links.
+type QueryTransport struct {
+	// The name of the query parameter.
+	Name string
+	// The name of the header of the responses that carries the token,
+	// DefaultTokenHeaderName if empty.
+	HeaderName string
+}
+
+// Assert that QueryTransport implements SessionTokenTransport.
+var _ SessionTokenTransport = QueryTransport{}
+
+// NewQueryTransport creates a new QueryTransport that uses the given query
+// parameter.
+func NewQueryTransport(name string) QueryTransport {
+	return QueryTransport{Name: name}
+}
+
+// Get the session token bytes from the query of the request.
+func (t QueryTransport) GetSessionTokenBytes(req *http.Request) []byte {
+	return []byte(req.URL.Query().Get(t.Name))
+}
+
+// Set the session token bytes in the header of the response and in the query
+// of the request URL. The URL is replaced by a copy, in which only the token
+// parameter changes.
+func (t QueryTransport) SetSessionTokenBytes(w http.ResponseWriter, req *http.Request, sessionTokenBytes []byte) {
+	if w != nil {
+		headerName := t.HeaderName
+		if headerName == "" {
+			headerName = DefaultTokenHeaderName
+		}
+
+		SetSessionTokenBytesToHeader(w, sessionTokenBytes, headerName)
+	}
+
+	target := *req.URL
+	target.RawQuery = setQueryParameter(target.RawQuery, t.Name, string(sessionTokenBytes))
+	req.URL = &target
+}
+
+// Set a parameter in a raw query, replacing its first value and removing the
+// others. The other parameters are left untouched, in their original encoding.
+func setQueryParameter(rawQuery string, name string, value string) string {
+	parameter := url.QueryEscape(name) + "=" + url.QueryEscape(value)
+	parts := []string{}
+	set := false
+	for _, part := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err != nil || unescaped != name {
+			if part != "" {
+				parts = append(parts, part)
+			}
+			continue
+		}
+
+		if !set {
+			parts = append(parts, parameter)
+			set = true
+		}
+	}
+
+	if !set {
+		parts = append(parts, parameter)
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// Set the session token bytes in the query of the forwarded request.
+func (t QueryTransport) SetRequestSessionTokenBytes(req *http.Request, sessionTokenBytes []byte) {
+	t.SetSessionTokenBytes(nil, req, sessionTokenBytes)
+}
+
+// ChainTransport tries several transports in order: the session token is read
+// from the first transport that carries one, and it is set with all of them.
+type ChainTransport []SessionTokenTransport
+
+// Assert that ChainTransport implements SessionTokenTransport.
+var _ SessionTokenTransport = ChainTransport{}
+
+// NewChainTransport creates a new ChainTransport of the given transports.
+func NewChainTransport(transports ...SessionTokenTransport) ChainTransport {
+	return ChainTransport(transports)
+}
+
+// Get the session token bytes from the first transport that carries one.
+func (t ChainTransport) GetSessionTokenBytes(req *http.Request) []byte {
+	for _, transport := range t {
+		if sessionTokenBytes := transport.GetSessionTokenBytes(req); len(sessionTokenBytes) != 0 {
+			return sessionTokenBytes
+		}
+	}
+
+	return nil
+}
+
+// Set the session token bytes with all the transports.
+func (t ChainTransport) SetSessionTokenBytes(w http.ResponseWriter, req *http.Request, sessionTokenBytes []byte) {
+	for _, transport := range t {
+		transport.SetSessionTokenBytes(w, req, sessionTokenBytes)
+	}
+}
+
+// Set the session token bytes in the forwarded request with all the transports.
+func (t ChainTransport) SetRequestSessionTokenBytes(req *http.Request, sessionTokenBytes []byte) {
+	for _, transport := range t {
+		transport.SetRequestSessionTokenBytes(req, sessionTokenBytes)
+	}
+}
@@ -3,8 +3,12 @@ package infrastructure
 import (
 	"encoding/json"
 	"errors"
+	"math"
 )
 
+// The mean radius of the earth in kilometers.
+const earthRadiusKm = 6371.0
+
 type GeoCoordinates struct {
 	Longitude float64 `json:"longitude"`
 	Latitude  float64 `json:"latitude"`
@@ -16,6 +20,21 @@ func (g *GeoCoordinates) String() string {
 	return string(data)
 }
 
+// DistanceTo returns the great-circle distance in kilometers between the
+// GeoCoordinates and the other GeoCoordinates.
+func (g *GeoCoordinates) DistanceTo(other GeoCoordinates) float64 {
+	lat1 := g.Latitude * math.Pi / 180
+	lat2 := other.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (other.Longitude - g.Longitude) * math.Pi / 180
+
+	// Haversine formula.
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
 // NewGeoCoordinates creates a new GeoCoordinates.
 func NewGeoCoordinates(longitude float64, latitude float64) (*GeoCoordinates, error) {
 	coordinates := &GeoCoordinates{
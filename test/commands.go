@@ -1,7 +1,16 @@
 package env_test
 
-import "github.com/ermes-labs/api-go/api"
+import (
+	"github.com/ermes-labs/api-go/api"
+	memory_commands "github.com/ermes-labs/api-go/commands/memory"
+	"github.com/ermes-labs/api-go/infrastructure"
+)
 
 type DefaultCommands struct {
 	api.Commands
 }
+
+// Create the default commands of a node, backed by the in-memory commands.
+func NewDefaultCommands(node infrastructure.Node) DefaultCommands {
+	return DefaultCommands{memory_commands.NewCommands(node)}
+}
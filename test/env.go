@@ -2,13 +2,20 @@ package env_test
 
 import (
 	"context"
-	"io"
 
 	"github.com/ermes-labs/api-go/api"
+	"github.com/ermes-labs/api-go/infrastructure"
 )
 
-var n1 api.Node
-var n2 api.Node
+var n1 = newNode(infrastructure.Node{AreaName: "n1", Host: "n1.ermes"})
+var n2 = newNode(infrastructure.Node{AreaName: "n2", Host: "n2.ermes"})
+
+// The nodes by host.
+var nodes = map[string]*api.Node{n1.Host: n1, n2.Host: n2}
+
+func newNode(node infrastructure.Node) *api.Node {
+	return api.NewNode(node, NewDefaultCommands(node))
+}
 
 func init() {
 	sessionToken, err := n1.CreateSession(
@@ -23,7 +30,7 @@ func init() {
 		context.Background(),
 		sessionToken,
 		api.NewAcquireSessionOptionsBuilder().Build(),
-		func() error {
+		func(fencingToken uint64) error {
 			// Do something...
 			return nil
 		})
@@ -40,13 +47,11 @@ func init() {
 
 	location, err := n1.OffloadSession(
 		context.Background(),
-		"session1",
+		sessionToken.SessionId,
 		api.NewOffloadSessionOptionsBuilder().Build(),
-		func(ctx context.Context, sm api.SessionMetadata, r io.Reader) (api.SessionLocation, error) {
-			return n2.OnloadSession(ctx, sm, r, api.OnloadSessionOptions{})
-		},
+		api.LocalOffloadTarget{Node: n2},
 		func(ctx context.Context, oldLocation api.SessionLocation, newLocation api.SessionLocation) (bool, error) {
-			var node *api.Node // = find the node from oldLocation.Host
+			node := nodes[oldLocation.Host]
 			return node.UpdateOffloadedSessionLocation(ctx, oldLocation.SessionId, newLocation)
 		})
 